@@ -3,9 +3,9 @@ package git4go
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"os"
-	"path/filepath"
 	"sort"
 	"strings"
 	"sync"
@@ -14,7 +14,9 @@ import (
 
 const (
 	GitPackedRefsFile        = "packed-refs"
+	GitPackedRefsHeader      = "# pack-refs with: peeled fully-peeled sorted \n"
 	GitSymbolReference       = "ref: "
+	GitRefFileMode           = 0666
 	PackPeelingNone     byte = 0
 	PackPeelingStandard byte = 1
 	PackPeelingFull     byte = 2
@@ -60,6 +62,8 @@ func (c *PackRefSortedCache) Upsert(key string) *PackRef {
 	return c.upsert(key)
 }
 
+// upsert inserts the item at the sorted position to search the items by
+// prefix.
 func (c *PackRefSortedCache) upsert(key string) *PackRef {
 	item, ok := c.cacheMap[key]
 	if ok {
@@ -69,7 +73,12 @@ func (c *PackRefSortedCache) upsert(key string) *PackRef {
 		name: key,
 	}
 	c.cacheMap[key] = item
-	c.items = append(c.items, item)
+	pos := sort.Search(len(c.items), func(i int) bool {
+		return c.items[i].name >= key
+	})
+	c.items = append(c.items, nil)
+	copy(c.items[pos+1:], c.items[pos:])
+	c.items[pos] = item
 	return item
 }
 
@@ -101,6 +110,18 @@ func (c *PackRefSortedCache) entries() []*PackRef {
 	return c.items
 }
 
+// entriesWithPrefix returns the items whose names start with the prefix.
+func (c *PackRefSortedCache) entriesWithPrefix(prefix string) []*PackRef {
+	start := sort.Search(len(c.items), func(i int) bool {
+		return c.items[i].name >= prefix
+	})
+	end := start
+	for end < len(c.items) && strings.HasPrefix(c.items[end].name, prefix) {
+		end++
+	}
+	return c.items[start:end]
+}
+
 func (c *PackRefSortedCache) remove(key string) {
 	delete(c.cacheMap, key)
 	for i, ref := range c.items {
@@ -120,6 +141,8 @@ func (c *PackRefSortedCache) reloadIfChanged(lock bool) error {
 	stat, err := os.Stat(c.path)
 	if err != nil {
 		c.notExist = true
+		c.clear(false)
+		c.stamp = time.Unix(0, 0)
 		return nil
 	}
 	c.notExist = false
@@ -135,11 +158,12 @@ func (c *PackRefSortedCache) reloadIfChanged(lock bool) error {
 		return err
 	}
 
+	c.clear(false)
 	scan := 0
 	eof := len(buffer)
 
 	c.peelingMode = PackPeelingNone
-	if buffer[scan] == '#' {
+	if eof > 0 && buffer[scan] == '#' {
 		traitsHeader := []byte("# pack-refs with: ")
 		if bytes.Equal(buffer[:len(traitsHeader)], traitsHeader) {
 			scan += len(traitsHeader)
@@ -198,7 +222,7 @@ func (c *PackRefSortedCache) reloadIfChanged(lock bool) error {
 				if eol == 0 {
 					return errors.New("Corrupted packed references file")
 				}
-				scan += GitOidHexSize + 1
+				scan = eol + 1
 			}
 			ref.peel = peel
 			ref.flag |= PackRefHasPeel
@@ -214,8 +238,7 @@ type RefDb struct {
 	ignoreCase        bool
 	precomposeUnicode bool
 	repo              *Repository
-	path              string
-	cache             *PackRefSortedCache
+	backend           RefDbBackend
 }
 
 func (r *Repository) NewRefDb() *RefDb {
@@ -236,29 +259,35 @@ func (r *Repository) NewRefDb() *RefDb {
 		precomposeUnicode: precomposeUnicode,
 		repo:              r,
 	}
-
-	if r.namespace != "" {
-		buffer := bytes.NewBufferString(r.pathRepository)
-		for _, namespace := range strings.Split(r.namespace, "/") {
-			buffer.WriteString("refs/namespaces/")
-			buffer.WriteString(namespace)
-			buffer.WriteByte('/')
-		}
-		buffer.WriteString("refs")
-		r.refDb.path = buffer.String()
+	if isReftableRepository(config) {
+		r.refDb.backend = NewRefDbBackendReftable(r)
 	} else {
-		r.refDb.path = r.pathRepository
-	}
-	r.refDb.cache = &PackRefSortedCache{
-		cacheMap: make(map[string]*PackRef),
-		path:     filepath.Join(r.refDb.path, GitPackedRefsFile),
-		stamp:    time.Unix(0, 0),
+		r.refDb.backend = NewRefDbBackendFiles(r)
 	}
-	r.refDb.cache.reloadIfChanged(true)
 
 	return r.refDb
 }
 
+// SetBackend replaces the storage of references and reflogs.
+func (r *RefDb) SetBackend(backend RefDbBackend) {
+	r.backend = backend
+}
+
+func (r *RefDb) Backend() RefDbBackend {
+	return r.backend
+}
+
+// isReftableRepository returns true if the repository stores references
+// in reftable ("extensions.refStorage = reftable").
+func isReftableRepository(config *Config) bool {
+	for _, name := range []string{"extensions.refstorage", "extensions.refStorage"} {
+		if value, err := config.LookupString(name); err == nil {
+			return strings.ToLower(strings.TrimSpace(value)) == "reftable"
+		}
+	}
+	return false
+}
+
 func searchEndLine(buffer []byte, start int) int {
 	eof := len(buffer)
 	for i := start; i < eof; i++ {
@@ -270,64 +299,149 @@ func searchEndLine(buffer []byte, start int) int {
 }
 
 func (r *RefDb) Lookup(name string) (*Reference, error) {
-	refFile, err := ioutil.ReadFile(filepath.Join(r.path, name))
-	if err == nil {
-		refString := string(refFile)
-		if strings.HasPrefix(refString, GitSymbolReference) {
-			ref := &Reference{
-				refType:        ReferenceSymbolic,
-				targetSymbolic: strings.TrimSpace(refString[len(GitSymbolReference):]),
-				repo:           r.repo,
-			}
-			return ref, nil
-		} else {
-			oid, err := NewOid(strings.TrimSpace(refString))
-			if err != nil {
-				return nil, err
-			}
-			ref := &Reference{
-				refType:   ReferenceOid,
-				targetOid: oid,
-				repo:      r.repo,
-				name:      name,
-			}
-			return ref, nil
-		}
-	} else {
-		item := r.cache.Lookup(name)
-		if item == nil {
-			return nil, errors.New("not found")
-		}
-		ref := &Reference{
-			refType:   ReferenceOid,
-			targetOid: item.oid,
-			repo:      r.repo,
-			name:      name,
+	ref, err := r.backend.Lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	return r.own(ref), nil
+}
+
+// forEach calls the callback for the references which match the pattern.
+// An empty pattern matches everything.
+func (r *RefDb) forEach(pattern string, callback ForEachReferenceCallback) error {
+	return r.backend.ForEach(pattern, func(ref *Reference) error {
+		return callback(r.own(ref))
+	})
+}
+
+// own sets the repository to the reference returned by the backend.
+func (r *RefDb) own(ref *Reference) *Reference {
+	if ref != nil && ref.repo != r.repo {
+		ref = &Reference{
+			refType:        ref.refType,
+			repo:           r.repo,
+			targetSymbolic: ref.targetSymbolic,
+			targetOid:      ref.targetOid,
+			name:           ref.name,
 		}
-		return ref, nil
 	}
+	return ref
 }
 
+// GetPackedReferences returns the references in packed-refs. It returns an
+// empty list if the backend isn't the files backend.
 func (r *RefDb) GetPackedReferences() ([]*Reference, error) {
-	r.cache.lock.Lock()
-	defer r.cache.lock.Unlock()
+	files, ok := r.backend.(*RefDbBackendFiles)
+	if !ok {
+		return []*Reference{}, nil
+	}
+	return files.packedReferences("")
+}
 
-	err := r.cache.reloadIfChanged(false)
+// PackAll packs all loose direct references into packed-refs like
+// "git pack-refs --all". Annotated tags are peeled. If prune is true, the
+// loose reference files are removed after they are packed. For reftable,
+// all tables are compacted into one table.
+func (r *RefDb) PackAll(prune bool) error {
+	return r.backend.PackAll(prune)
+}
+
+// write stores the reference and returns the previous value. If oldId or
+// oldTarget is given, the current value should match it (a zero oldId
+// means the reference should not exist yet).
+func (r *RefDb) write(ref *Reference, force bool, oldId *Oid, oldTarget string) (*Reference, error) {
+	if err := r.checkNameAvailable(ref.name, ""); err != nil {
+		return nil, err
+	}
+	previous, err := r.backend.Write(ref, force, oldId, oldTarget)
 	if err != nil {
 		return nil, err
 	}
-	if r.cache.notExist {
-		return []*Reference{}, nil
+	return r.own(previous), nil
+}
+
+func (r *RefDb) delete(name string, oldId *Oid, oldTarget string) error {
+	return r.backend.Delete(name, oldId, oldTarget)
+}
+
+// rename moves the reference to the new name and returns the renamed one.
+func (r *RefDb) rename(ref *Reference, newName string, force bool) (*Reference, error) {
+	if err := r.checkNameAvailable(newName, ref.name); err != nil {
+		return nil, err
+	}
+	renamed, err := r.backend.Rename(ref, newName, force)
+	if err != nil {
+		return nil, err
 	}
-	var result []*Reference
-	for _, item := range r.cache.items {
-		ref := &Reference{
-			refType:   ReferenceOid,
-			targetOid: item.oid,
-			repo:      r.repo,
-			name:      item.name,
+	return r.own(renamed), nil
+}
+
+// checkNameAvailable returns an error if the name conflicts with existing
+// reference like "refs/heads/a" and "refs/heads/a/b". The reference named
+// ignoreName is not checked. Only the parent names and the children of the
+// name are looked up. The files backend reads the directory of the name and
+// the range of packed-refs for the children.
+func (r *RefDb) checkNameAvailable(name, ignoreName string) error {
+	conflict := func(existing string) error {
+		msg := fmt.Sprintf("The reference '%s' conflicts with the existing reference '%s'", name, existing)
+		return MakeGitError(msg, ErrExists)
+	}
+	for i := 0; i < len(name); i++ {
+		if name[i] != '/' {
+			continue
+		}
+		parent := name[:i]
+		if parent == ignoreName {
+			continue
 		}
-		result = append(result, ref)
+		_, err := r.backend.Lookup(parent)
+		if err == nil {
+			return conflict(parent)
+		} else if !IsErrorCode(err, ErrNotFound) {
+			return err
+		}
+	}
+	return r.backend.ForEach(name+"/*", func(existing *Reference) error {
+		if existing.name == ignoreName {
+			return nil
+		}
+		return conflict(existing.name)
+	})
+}
+
+// peelPackRef sets the peeled value if the reference points to an
+// annotated tag.
+func peelPackRef(repo *Repository, item *PackRef) {
+	if item.flag&(PackRefHasPeel|PackRefCannotPeel) != 0 {
+		return
+	}
+	object, err := repo.Lookup(item.oid)
+	if err != nil || object.Type() != ObjectTag {
+		item.flag |= PackRefCannotPeel
+		return
+	}
+	peeled, err := object.Peel(ObjectAny)
+	if err != nil {
+		item.flag |= PackRefCannotPeel
+		return
+	}
+	item.peel = peeled.Id()
+	item.flag |= PackRefHasPeel
+}
+
+func checkReferenceValue(name string, current *Reference, oldId *Oid, oldTarget string) error {
+	matched := true
+	if oldId != nil {
+		if oldId.IsZero() {
+			matched = current == nil
+		} else {
+			matched = current != nil && current.refType == ReferenceOid && current.targetOid.Equal(oldId)
+		}
+	} else if oldTarget != "" {
+		matched = current != nil && current.refType == ReferenceSymbolic && current.targetSymbolic == oldTarget
 	}
-	return result, nil
+	if !matched {
+		return MakeGitError(fmt.Sprintf("Old reference value does not match for '%s'", name), ErrModified)
+	}
+	return nil
 }
@@ -0,0 +1,39 @@
+package git4go
+
+import (
+	"fmt"
+	"strings"
+)
+
+// internal functions
+
+// branchUpstreamName returns the name of the reference that the given
+// local branch tracks, by reading "branch.<name>.remote" and
+// "branch.<name>.merge" in the config.
+func branchUpstreamName(repo *Repository, refName string) (string, error) {
+	if !strings.HasPrefix(refName, GitRefsHeadsDir) {
+		return "", MakeGitError(fmt.Sprintf("Reference '%s' is not a local branch", refName), ErrInvalidSpec)
+	}
+	shortName := refName[len(GitRefsHeadsDir):]
+	config := repo.Config()
+	remoteName, _ := config.LookupString(fmt.Sprintf("branch.%s.remote", shortName))
+	mergeName, _ := config.LookupString(fmt.Sprintf("branch.%s.merge", shortName))
+	if remoteName == "" || mergeName == "" {
+		return "", MakeGitError(fmt.Sprintf("Branch '%s' does not have an upstream", shortName), ErrNotFound)
+	}
+	if remoteName == "." {
+		return mergeName, nil
+	}
+	fetch, err := config.LookupString(fmt.Sprintf("remote.%s.fetch", remoteName))
+	if err != nil || fetch == "" {
+		return "", MakeGitError(fmt.Sprintf("Remote '%s' does not have a fetch refspec", remoteName), ErrNotFound)
+	}
+	spec, err := parseRefspec(fetch)
+	if err != nil {
+		return "", err
+	}
+	if !spec.srcMatches(mergeName) {
+		return "", MakeGitError(fmt.Sprintf("Could not find matching refspec for '%s'", mergeName), ErrNotFound)
+	}
+	return spec.transform(mergeName)
+}
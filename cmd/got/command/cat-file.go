@@ -26,20 +26,12 @@ func CmdCatFile(c *cli.Context) {
 			fmt.Fprintln(os.Stderr, err)
 			os.Exit(1)
 		}
-		oid, err := git4go.NewOid(c.Args()[1])
+		target, err := repo.RevparseSingle(c.Args()[1])
 		if err != nil {
-			ref, err := repo.DwimReference(c.Args()[1])
-			if err != nil {
-				fmt.Fprintln(os.Stderr, err)
-				os.Exit(1)
-			}
-			resolved, err := ref.Resolve()
-			if err != nil {
-				fmt.Fprintln(os.Stderr, err)
-				os.Exit(1)
-			}
-			oid = resolved.Target()
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
 		}
+		oid := target.Id()
 		obj, err := odb.Read(oid)
 		if err != nil {
 			fmt.Fprintln(os.Stderr, err)
@@ -3,6 +3,10 @@ package git4go
 import (
 	"bytes"
 	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/text/encoding/htmlindex"
 )
 
 func (r *Repository) LookupCommit(oid *Oid) (*Commit, error) {
@@ -23,14 +27,19 @@ func (r *Repository) LookupPrefixCommit(oid *Oid, length int) (*Commit, error) {
 
 type Commit struct {
 	gitObject
-	message   string
-	summary   string
-	treeId    *Oid
-	author    *Signature
-	committer *Signature
-	Parents   []*Oid
+	message         string
+	summary         string
+	messageEncoding string
+	rawHeader       string
+	treeId          *Oid
+	author          *Signature
+	committer       *Signature
+	Parents         []*Oid
 }
 
+// the header field of the signature of commits
+const commitSignatureField = "gpgsig"
+
 func (t *Commit) Type() ObjectType {
 	return ObjectCommit
 }
@@ -47,6 +56,66 @@ func (c *Commit) Summary() string {
 	return c.summary
 }
 
+// MessageEncoding returns the encoding of the message in the "encoding"
+// header. It is empty if the header doesn't exist, which means UTF-8.
+// Message returns the message converted into UTF-8.
+func (c *Commit) MessageEncoding() string {
+	return c.messageEncoding
+}
+
+// RawHeader returns the header of the commit object including the extra
+// headers like "gpgsig" and "mergetag".
+func (c *Commit) RawHeader() string {
+	return c.rawHeader
+}
+
+// HeaderField returns the value of the header field like "gpgsig". The
+// value of multi-line field doesn't have the leading spaces of the
+// continuation lines. It fails with ErrNotFound if the field doesn't exist.
+func (c *Commit) HeaderField(name string) (string, error) {
+	return headerField(c.rawHeader, name)
+}
+
+// ExtractSignature returns the "gpgsig" field and the signed data, which
+// is the commit object without the field. It fails with ErrNotFound if the
+// commit is not signed.
+func (c *Commit) ExtractSignature() (signature, signedData string, err error) {
+	odb, err := c.repo.Odb()
+	if err != nil {
+		return "", "", err
+	}
+	obj, err := odb.Read(c.oid)
+	if err != nil {
+		return "", "", err
+	}
+	if obj.Type != ObjectCommit {
+		return "", "", MakeGitError("the requested type does not match the type in ODB", ErrInvalidSpec)
+	}
+	end := bytes.Index(obj.Data, []byte("\n\n"))
+	if end == -1 {
+		end = len(obj.Data)
+	} else {
+		end++
+	}
+	signature, err = headerField(string(obj.Data[:end]), commitSignatureField)
+	if err != nil {
+		return "", "", err
+	}
+	var signed bytes.Buffer
+	inSignature := false
+	for _, line := range bytes.SplitAfter(obj.Data[:end], []byte("\n")) {
+		if inSignature && bytes.HasPrefix(line, []byte(" ")) {
+			continue
+		}
+		inSignature = bytes.HasPrefix(line, []byte(commitSignatureField+" "))
+		if !inSignature {
+			signed.Write(line)
+		}
+	}
+	signed.Write(obj.Data[end:])
+	return signature, signed.String(), nil
+}
+
 func (c Commit) Tree() (*Tree, error) {
 	return c.repo.LookupTree(c.treeId)
 }
@@ -64,6 +133,9 @@ func (c Commit) Committer() *Signature {
 }
 
 func (c *Commit) Parent(n int) *Commit {
+	if n < 0 || n >= len(c.Parents) {
+		return nil
+	}
 	parent, _ := c.repo.LookupCommit(c.Parents[n])
 	return parent
 }
@@ -98,6 +170,156 @@ func (c *Commit) Amend(refname string, author, committer *Signature, message str
 	return nil, nil
 }
 
+// CreateCommit writes a new commit and returns its id. If refname is not
+// empty, the reference is updated to point to the commit. A symbolic
+// reference like HEAD updates the branch which it points to, even if the
+// branch doesn't exist yet. The update fails with ErrModified unless the
+// reference points to the first parent, or doesn't exist if the commit has
+// no parents. messageEncoding is written into the commit unless it is
+// empty.
+func (r *Repository) CreateCommit(refname string, author, committer *Signature, messageEncoding, message string, tree *Tree, parents ...*Commit) (*Oid, error) {
+	return r.createCommit(refname, author, committer, messageEncoding, message, tree, nil, parents)
+}
+
+// CreateSignedCommit is the same as CreateCommit, but the commit is signed
+// by the signer like "git commit -S".
+func (r *Repository) CreateSignedCommit(refname string, author, committer *Signature, messageEncoding, message string, tree *Tree, signer Signer, parents ...*Commit) (*Oid, error) {
+	if signer == nil {
+		return nil, errors.New("Repository.CreateSignedCommit(): signer is required")
+	}
+	return r.createCommit(refname, author, committer, messageEncoding, message, tree, signer, parents)
+}
+
+func (r *Repository) createCommit(refname string, author, committer *Signature, messageEncoding, message string, tree *Tree, signer Signer, parents []*Commit) (*Oid, error) {
+	buffer, err := r.CreateCommitBuffer(author, committer, messageEncoding, message, tree, parents...)
+	if err != nil {
+		return nil, err
+	}
+	oid, err := r.writeCommit(buffer, signer)
+	if err != nil {
+		return nil, err
+	}
+	if refname == "" {
+		return oid, nil
+	}
+	name, err := r.commitTargetReference(refname)
+	if err != nil {
+		return nil, err
+	}
+	currentId := new(Oid)
+	if len(parents) > 0 {
+		currentId = parents[0].Id()
+	}
+	_, err = r.CreateReferenceMatching(name, oid, true, currentId, committer, commitReflogMessage(message, len(parents)))
+	if err != nil {
+		return nil, err
+	}
+	return oid, nil
+}
+
+// writeCommit writes the commit. It is signed if the signer is not nil.
+func (r *Repository) writeCommit(buffer []byte, signer Signer) (*Oid, error) {
+	if signer != nil {
+		signature, err := signer.Sign(buffer)
+		if err != nil {
+			return nil, err
+		}
+		return r.CreateCommitWithSignature(buffer, signature, "")
+	}
+	odb, err := r.Odb()
+	if err != nil {
+		return nil, err
+	}
+	return odb.Write(buffer, ObjectCommit)
+}
+
+// CreateCommitWithSignature writes the commit made by CreateCommitBuffer
+// with the signature in the header field. The field is "gpgsig" if it is
+// empty. No references are updated.
+func (r *Repository) CreateCommitWithSignature(content []byte, signature, field string) (*Oid, error) {
+	if field == "" {
+		field = commitSignatureField
+	}
+	if _, err := newCommit(r, nil, content); err != nil {
+		return nil, err
+	}
+	end := bytes.Index(content, []byte("\n\n"))
+	if end == -1 {
+		return nil, errors.New("Repository.CreateCommitWithSignature(): the commit has no message")
+	}
+	var buffer bytes.Buffer
+	buffer.Write(content[:end+1])
+	fmt.Fprintf(&buffer, "%s %s\n", field, strings.Replace(strings.TrimRight(signature, "\n"), "\n", "\n ", -1))
+	buffer.Write(content[end+1:])
+	odb, err := r.Odb()
+	if err != nil {
+		return nil, err
+	}
+	return odb.Write(buffer.Bytes(), ObjectCommit)
+}
+
+// CreateCommitBuffer returns the content of the commit object without
+// writing it.
+func (r *Repository) CreateCommitBuffer(author, committer *Signature, messageEncoding, message string, tree *Tree, parents ...*Commit) ([]byte, error) {
+	if author == nil || committer == nil {
+		return nil, errors.New("Repository.CreateCommit(): author and committer are required")
+	}
+	if tree == nil {
+		return nil, errors.New("Repository.CreateCommit(): tree is required")
+	}
+	var buffer bytes.Buffer
+	fmt.Fprintf(&buffer, "tree %s\n", tree.Id())
+	for _, parent := range parents {
+		if parent == nil {
+			return nil, errors.New("Repository.CreateCommit(): parent should not be nil")
+		}
+		fmt.Fprintf(&buffer, "parent %s\n", parent.Id())
+	}
+	fmt.Fprintf(&buffer, "author %s\n", formatSignature(author))
+	fmt.Fprintf(&buffer, "committer %s\n", formatSignature(committer))
+	if messageEncoding != "" {
+		fmt.Fprintf(&buffer, "encoding %s\n", messageEncoding)
+	}
+	buffer.WriteByte('\n')
+	buffer.WriteString(message)
+	return buffer.Bytes(), nil
+}
+
+// commitTargetReference follows the symbolic references and returns the
+// name of the direct reference to update.
+func (r *Repository) commitTargetReference(refname string) (string, error) {
+	name, err := referenceNormalizeForWrite(r, refname)
+	if err != nil {
+		return "", err
+	}
+	refDb := r.NewRefDb()
+	for nesting := 0; nesting <= MaxNestingLevel; nesting++ {
+		ref, err := refDb.Lookup(name)
+		if IsErrorCode(err, ErrNotFound) {
+			return name, nil
+		} else if err != nil {
+			return "", err
+		}
+		if ref.refType == ReferenceOid {
+			return name, nil
+		}
+		name = ref.targetSymbolic
+	}
+	return "", fmt.Errorf("Cannot resolve reference (>%d levels deep)", MaxNestingLevel)
+}
+
+// commitReflogMessage returns the reflog message like "git commit".
+func commitReflogMessage(message string, parentCount int) string {
+	summary := messageSummary(message)
+	switch parentCount {
+	case 0:
+		return "commit (initial): " + summary
+	case 1:
+		return "commit: " + summary
+	}
+	return "commit (merge): " + summary
+}
+
 func newCommit(repo *Repository, oid *Oid, contents []byte) (*Commit, error) {
 	offset := 0
 	var tree *Oid
@@ -119,32 +341,87 @@ func newCommit(repo *Repository, oid *Oid, contents []byte) (*Commit, error) {
 	if err != nil {
 		return nil, err
 	}
-	for offset < len(contents) {
-		if contents[offset-1] == '\n' && contents[offset] == '\n' {
+	// extra headers continue until the blank line
+	var messageEncoding string
+	for offset < len(contents) && contents[offset] != '\n' {
+		eol := bytes.IndexByte(contents[offset:], '\n')
+		if eol == -1 {
+			offset = len(contents)
 			break
 		}
-		eol := offset
-		for eol < len(contents) && contents[eol] != '\n' {
-			eol++
-		}
-		if bytes.Compare([]byte("encoding "), contents[offset:offset+len("encoding ")]) == 0 {
-			offset += len("encoding ")
-			// messageEncoding := contents[offset+len("encoding "):eol]
+		eol += offset
+		if bytes.HasPrefix(contents[offset:eol], []byte("encoding ")) {
+			messageEncoding = string(contents[offset+len("encoding ") : eol])
 		}
-		if eol < len(contents) && contents[eol] == '\n' {
-			eol++
-		}
-		offset = eol
+		offset = eol + 1
+	}
+	rawHeader := string(contents[:offset])
+	if offset < len(contents) {
+		offset++
 	}
-	// rawHeader := contents[:offset]
+	message := decodeMessage(contents[offset:], messageEncoding)
 	return &Commit{
-		message:   string(contents[offset:]),
-		treeId:    tree,
-		author:    author,
-		committer: committer,
+		message:         message,
+		summary:         messageSummary(message),
+		messageEncoding: messageEncoding,
+		rawHeader:       rawHeader,
+		treeId:          tree,
+		Parents:         parents,
+		author:          author,
+		committer:       committer,
 		gitObject: gitObject{
 			repo: repo,
 			oid:  oid,
 		},
 	}, nil
 }
+
+// decodeMessage converts the message into UTF-8. The message is kept as it
+// is if the encoding is not supported.
+func decodeMessage(message []byte, messageEncoding string) string {
+	if messageEncoding == "" {
+		return string(message)
+	}
+	encoding, err := htmlindex.Get(messageEncoding)
+	if err != nil {
+		return string(message)
+	}
+	decoded, err := encoding.NewDecoder().Bytes(message)
+	if err != nil {
+		return string(message)
+	}
+	return string(decoded)
+}
+
+// messageSummary returns the first paragraph of the message in one line.
+func messageSummary(message string) string {
+	message = strings.TrimLeft(message, " \t\r\n")
+	if i := strings.Index(message, "\n\n"); i != -1 {
+		message = message[:i]
+	}
+	lines := strings.Split(message, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+	return strings.TrimSpace(strings.Join(lines, " "))
+}
+
+// headerField returns the value of the header field. The continuation
+// lines, which start with a space, are joined with newlines.
+func headerField(header, name string) (string, error) {
+	var values []string
+	for _, line := range strings.Split(header, "\n") {
+		if values != nil {
+			if !strings.HasPrefix(line, " ") {
+				break
+			}
+			values = append(values, line[1:])
+		} else if strings.HasPrefix(line, name+" ") {
+			values = append(values, line[len(name)+1:])
+		}
+	}
+	if values == nil {
+		return "", MakeGitError(fmt.Sprintf("no such field '%s'", name), ErrNotFound)
+	}
+	return strings.Join(values, "\n"), nil
+}
@@ -3,6 +3,7 @@ package git4go
 import (
 	"./testutil"
 	"testing"
+	"time"
 )
 
 /*
@@ -49,3 +50,159 @@ func Test_LookupCommit(t *testing.T) {
 		}
 	}
 }
+
+func Test_CreateCommit(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/empty_standard_repo")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/empty_standard_repo")
+	sig := &Signature{
+		Name:  "Shawn O. Pearce",
+		Email: "spearce@spearce.org",
+		When:  time.Unix(1225475778, 0).In(time.FixedZone("", -7*60*60)),
+	}
+	tree := writeTestTree(repo, "file")
+	first, err := repo.CreateCommit("HEAD", sig, sig, "", "initial\n\nbody\n", tree)
+	if err != nil {
+		t.Error("err should be nil:", err)
+		return
+	}
+	master, _ := repo.LookupReference("refs/heads/master")
+	if master == nil || !master.Target().Equal(first) {
+		t.Error("branch of HEAD should be created:", master)
+	}
+	reflog, _ := repo.ReadReflog("HEAD")
+	if reflog == nil || reflog.EntryCount() != 1 || reflog.EntryByIndex(0).Message != "commit (initial): initial" {
+		t.Error("reflog is wrong:", reflog)
+	}
+
+	parent, _ := repo.LookupCommit(first)
+	second, err := repo.CreateCommit("HEAD", sig, sig, "", "second\n", tree, parent)
+	if err != nil {
+		t.Error("err should be nil:", err)
+	}
+	reflog, _ = repo.ReadReflog("refs/heads/master")
+	if reflog == nil || reflog.EntryCount() != 2 || reflog.EntryByIndex(0).Message != "commit: second" {
+		t.Error("reflog is wrong:", reflog)
+	}
+	commit, _ := repo.LookupCommit(second)
+	if commit == nil || commit.ParentCount() != 1 || !commit.ParentId(0).Equal(first) || !commit.TreeId().Equal(tree.Id()) {
+		t.Error("commit is wrong:", commit)
+	}
+
+	// the branch was moved from the first parent
+	if _, err := repo.CreateCommit("refs/heads/master", sig, sig, "", "third\n", tree, parent); !IsErrorCode(err, ErrModified) {
+		t.Error("reference should not be updated:", err)
+	}
+	if _, err := repo.CreateCommit("refs/heads/master", sig, sig, "", "third\n", tree); !IsErrorCode(err, ErrModified) {
+		t.Error("existing reference should not be updated by a root commit:", err)
+	}
+	master, _ = repo.LookupReference("refs/heads/master")
+	if !master.Target().Equal(second) {
+		t.Error("branch should not be changed:", master.Target())
+	}
+}
+
+func Test_CreateCommitBuffer(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	oid, _ := NewOid("111d5ccf0bb010c4e8d7af3eedfa12ef4c5e265b")
+	original, _ := repo.LookupCommit(oid)
+	tree, _ := original.Tree()
+	parent := original.Parent(0)
+	author := &Signature{
+		Name:  "Shawn O. Pearce",
+		Email: "spearce@spearce.org",
+		When:  time.Unix(1225475778, 0).In(time.FixedZone("", -7*60*60)),
+	}
+	committer := &Signature{
+		Name:  "Shawn O. Pearce",
+		Email: "spearce@spearce.org",
+		When:  time.Unix(1225476305, 0).In(time.FixedZone("", -7*60*60)),
+	}
+	message := "Add a git_sobj_close to release the git_sobj data\n\nSigned-off-by: Shawn O. Pearce <spearce@spearce.org>\n"
+	buffer, err := repo.CreateCommitBuffer(author, committer, "", message, tree, parent)
+	if err != nil {
+		t.Error("err should be nil:", err)
+	}
+	odb, _ := repo.Odb()
+	if hashed, _ := odb.Hash(buffer, ObjectCommit); !hashed.Equal(oid) {
+		t.Error("commit should be same as the original:", string(buffer))
+	}
+
+	buffer, _ = repo.CreateCommitBuffer(author, committer, "ISO-8859-1", message, tree)
+	expected := "tree 50330c02bd4fd95c9db1fcf2f97f4218e42b7226\n" +
+		"author Shawn O. Pearce <spearce@spearce.org> 1225475778 -0700\n" +
+		"committer Shawn O. Pearce <spearce@spearce.org> 1225476305 -0700\n" +
+		"encoding ISO-8859-1\n" +
+		"\n" + message
+	if string(buffer) != expected {
+		t.Error("buffer is wrong:", string(buffer))
+	}
+}
+
+func Test_Commit_ExtraHeaders(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	header := "tree 50330c02bd4fd95c9db1fcf2f97f4218e42b7226\n" +
+		"parent b51eb250ed0cbda59d3108d04569fab9413909fd\n" +
+		"author Shawn O. Pearce <spearce@spearce.org> 1225475778 -0700\n" +
+		"committer Shawn O. Pearce <spearce@spearce.org> 1225476305 -0700\n" +
+		"encoding ISO-8859-1\n"
+	signature := "-----BEGIN PGP SIGNATURE-----\n" +
+		"\n" +
+		"iQEcBAABAgAGBQJVAAAAAAoJEB\n" +
+		"-----END PGP SIGNATURE-----"
+	gpgsig := "gpgsig -----BEGIN PGP SIGNATURE-----\n" +
+		" \n" +
+		" iQEcBAABAgAGBQJVAAAAAAoJEB\n" +
+		" -----END PGP SIGNATURE-----\n"
+	mergetag := "mergetag object b51eb250ed0cbda59d3108d04569fab9413909fd\n" +
+		" type commit\n"
+	message := "Caf\xe9 ol\xe9\n\nbody\n"
+	odb, _ := repo.Odb()
+	oid, _ := odb.Write([]byte(header+gpgsig+mergetag+"\n"+message), ObjectCommit)
+	commit, err := repo.LookupCommit(oid)
+	if err != nil {
+		t.Error("err should be nil:", err)
+		return
+	}
+	if commit.MessageEncoding() != "ISO-8859-1" {
+		t.Error("encoding is wrong:", commit.MessageEncoding())
+	}
+	if commit.Message() != "Café olé\n\nbody\n" || commit.Summary() != "Café olé" {
+		t.Error("message should be decoded:", commit.Message(), commit.Summary())
+	}
+	if commit.RawHeader() != header+gpgsig+mergetag {
+		t.Error("raw header is wrong:", commit.RawHeader())
+	}
+	if value, err := commit.HeaderField("gpgsig"); err != nil || value != signature {
+		t.Error("gpgsig is wrong:", value, err)
+	}
+	if value, err := commit.HeaderField("mergetag"); err != nil || value != "object b51eb250ed0cbda59d3108d04569fab9413909fd\ntype commit" {
+		t.Error("mergetag is wrong:", value, err)
+	}
+	if _, err := commit.HeaderField("merge"); !IsErrorCode(err, ErrNotFound) {
+		t.Error("prefix of the field should not match:", err)
+	}
+
+	sig, signed, err := commit.ExtractSignature()
+	if err != nil || sig != signature {
+		t.Error("signature is wrong:", sig, err)
+	}
+	if signed != header+mergetag+"\n"+message {
+		t.Error("signed data is wrong:", signed)
+	}
+	oid, _ = NewOid("111d5ccf0bb010c4e8d7af3eedfa12ef4c5e265b")
+	commit, _ = repo.LookupCommit(oid)
+	if _, _, err := commit.ExtractSignature(); !IsErrorCode(err, ErrNotFound) {
+		t.Error("commit should not be signed:", err)
+	}
+	if commit.MessageEncoding() != "" || commit.Summary() != "Add a git_sobj_close to release the git_sobj data" {
+		t.Error("commit is wrong:", commit.MessageEncoding(), commit.Summary())
+	}
+}
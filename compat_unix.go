@@ -5,6 +5,7 @@ package git4go
 import (
 	"os"
 	"path/filepath"
+	"syscall"
 )
 
 func guessSystemFile() []string {
@@ -50,3 +51,13 @@ var defaultStringConfig map[string]string = map[string]string{
 	"core.autocrlf": "false",
 	"core.eol":      "crlf",
 }
+
+// statIds returns device, inode, uid and gid which are stored in index
+// entries.
+func statIds(stat os.FileInfo) (dev, ino, uid, gid uint32) {
+	sys, ok := stat.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, 0, 0
+	}
+	return uint32(sys.Dev), uint32(sys.Ino), sys.Uid, sys.Gid
+}
@@ -2,6 +2,10 @@
 
 package git4go
 
+import (
+	"os"
+)
+
 func guessSystemFile() []string {
 	return []string{}
 }
@@ -35,3 +39,9 @@ var defaultStringConfig map[string]string = map[string]string{
 	"core.autocrlf": "false",
 	"core.eol":      "crlf",
 }
+
+// statIds returns zeros because Windows doesn't have them like git for
+// Windows.
+func statIds(stat os.FileInfo) (dev, ino, uid, gid uint32) {
+	return 0, 0, 0, 0
+}
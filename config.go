@@ -105,9 +105,9 @@ func (c *Config) AddFile(path string, level ConfigLevel, force bool) error {
 }
 
 func (c *Config) LookupInt32(name string) (int32, error) {
-	keys := strings.SplitN(name, ".", 2)
+	section, key := splitConfigName(name)
 	for _, file := range c.files {
-		value, err := file.file.Int(keys[0], keys[1])
+		value, err := file.file.Int(section, key)
 		if err == nil {
 			return int32(value), nil
 		}
@@ -116,9 +116,9 @@ func (c *Config) LookupInt32(name string) (int32, error) {
 }
 
 func (c *Config) LookupInt64(name string) (int64, error) {
-	keys := strings.SplitN(name, ".", 2)
+	section, key := splitConfigName(name)
 	for _, file := range c.files {
-		value, err := file.file.Int64(keys[0], keys[1])
+		value, err := file.file.Int64(section, key)
 		if err == nil {
 			return value, nil
 		}
@@ -127,9 +127,9 @@ func (c *Config) LookupInt64(name string) (int64, error) {
 }
 
 func (c *Config) LookupString(name string) (string, error) {
-	keys := strings.SplitN(name, ".", 2)
+	section, key := splitConfigName(name)
 	for _, file := range c.files {
-		value, err := file.file.GetValue(keys[0], keys[1])
+		value, err := file.file.GetValue(section, key)
 		if err == nil {
 			return value, nil
 		}
@@ -150,9 +150,9 @@ func (c *Config) LookupStringWithDefaultValue(name string) (string, error) {
 }
 
 func (c *Config) LookupBool(name string) (bool, error) {
-	keys := strings.SplitN(name, ".", 2)
+	section, key := splitConfigName(name)
 	for _, file := range c.files {
-		value, err := file.file.Bool(keys[0], keys[1])
+		value, err := file.file.Bool(section, key)
 		if err == nil {
 			return value, nil
 		}
@@ -175,8 +175,8 @@ func (c *Config) LookupBooleanWithDefaultValue(name string) (bool, error) {
 func (c *Config) SetString(name, value string) (err error) {
 	if len(c.files) > 0 && c.files[0].level == ConfigLevelLocal {
 		file := c.files[0].file
-		keys := strings.SplitN(name, ".", 2)
-		file.SetValue(keys[0], keys[1], value)
+		section, key := splitConfigName(name)
+		file.SetValue(section, key, value)
 		path, err := ConfigFindGlobal()
 		if err != nil {
 			return err
@@ -202,6 +202,20 @@ func (c *Config) SetBool(name string, value bool) (err error) {
 	}
 }
 
+// splitConfigName converts "section.subsection.key" style name into
+// the section name and the key name of the config file.
+func splitConfigName(name string) (string, string) {
+	first := strings.Index(name, ".")
+	last := strings.LastIndex(name, ".")
+	if first == -1 {
+		return name, ""
+	}
+	if first == last {
+		return name[:first], name[last+1:]
+	}
+	return fmt.Sprintf("%s \"%s\"", name[:first], name[first+1:last]), name[last+1:]
+}
+
 func ConfigFindGlobal() (string, error) {
 	return findInDirList(ConfigFileNameGlobal, "global")
 }
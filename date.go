@@ -0,0 +1,219 @@
+package git4go
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// approxidate parses the date format that git accepts in "<ref>@{<date>}",
+// "--since" and so on. It accepts strict formats like ISO 8601 or RFC 2822
+// and approximate English expressions like "yesterday" or "3 weeks ago".
+// Dates without time zone are interpreted in now's location.
+func approxidate(date string, now time.Time) (time.Time, error) {
+	date = strings.TrimSpace(date)
+	if date == "" {
+		return time.Time{}, errors.New("Date is empty")
+	}
+	if result, ok := parseStrictDate(date, now.Location()); ok {
+		return result, nil
+	}
+	return parseApproximateDate(date, now)
+}
+
+var strictDateLayoutsWithZone = []string{
+	time.RFC3339,
+	"2006-1-2T15:04:05-0700",
+	"2006-1-2 15:04:05 -0700",
+	"2006-1-2 15:04:05 MST",
+	"2006-1-2 15:04 -0700",
+	"2006/1/2 15:04:05 -0700",
+	"2006.1.2 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"2 Jan 2006 15:04:05 -0700",
+	"Mon Jan 2 15:04:05 2006 -0700",
+}
+
+var strictDateLayouts = []string{
+	"2006-1-2T15:04:05",
+	"2006-1-2 15:04:05",
+	"2006-1-2 15:04",
+	"2006-1-2",
+	"2006/1/2 15:04:05",
+	"2006/1/2 15:04",
+	"2006/1/2",
+	"2006.1.2 15:04:05",
+	"2006.1.2 15:04",
+	"2006.1.2",
+	"Mon, 2 Jan 2006 15:04:05",
+	"2 Jan 2006 15:04:05",
+	"2 Jan 2006",
+	"Jan 2 2006",
+	"Mon Jan 2 15:04:05 2006",
+}
+
+var epochRegexp = regexp.MustCompile(`^@([0-9]+)$`)
+
+func parseStrictDate(date string, location *time.Location) (time.Time, bool) {
+	if match := epochRegexp.FindStringSubmatch(date); match != nil {
+		epoch, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return time.Time{}, false
+		}
+		return time.Unix(epoch, 0), true
+	}
+	for _, layout := range strictDateLayoutsWithZone {
+		if result, err := time.Parse(layout, date); err == nil {
+			return result, true
+		}
+	}
+	for _, layout := range strictDateLayouts {
+		if result, err := time.ParseInLocation(layout, date, location); err == nil {
+			return result, true
+		}
+	}
+	return time.Time{}, false
+}
+
+var numberNames = map[string]int{
+	"a": 1, "an": 1, "last": 1,
+	"zero": 0, "one": 1, "two": 2, "three": 3, "four": 4, "five": 5,
+	"six": 6, "seven": 7, "eight": 8, "nine": 9, "ten": 10,
+}
+
+var weekdayNames = map[string]time.Weekday{
+	"sunday": time.Sunday, "monday": time.Monday, "tuesday": time.Tuesday,
+	"wednesday": time.Wednesday, "thursday": time.Thursday, "friday": time.Friday,
+	"saturday": time.Saturday,
+}
+
+var approxidateTokenRegexp = regexp.MustCompile(`[0-9:]+|[a-z]+`)
+
+var clockRegexp = regexp.MustCompile(`^([0-9]{1,2}):([0-9]{2})(?::([0-9]{2}))?$`)
+
+// parseApproximateDate handles the relative expressions. Like git, unknown
+// words are ignored, but at least one word should be recognized.
+func parseApproximateDate(date string, now time.Time) (time.Time, error) {
+	tokens := approxidateTokenRegexp.FindAllString(strings.ToLower(date), -1)
+	result := now
+	number := -1
+	hour := -1
+	touched := false
+	setClock := func(h, m, s int) {
+		result = time.Date(result.Year(), result.Month(), result.Day(), h, m, s, 0, result.Location())
+		hour = h
+		touched = true
+	}
+	for _, token := range tokens {
+		if n, err := strconv.Atoi(token); err == nil {
+			number = n
+			continue
+		}
+		if n, ok := numberNames[token]; ok {
+			number = n
+			continue
+		}
+		if match := clockRegexp.FindStringSubmatch(token); match != nil {
+			h, _ := strconv.Atoi(match[1])
+			m, _ := strconv.Atoi(match[2])
+			s, _ := strconv.Atoi(match[3])
+			setClock(h, m, s)
+			continue
+		}
+		count := number
+		if number < 0 {
+			count = 1
+		}
+		switch token {
+		case "now", "today":
+			touched = true
+		case "yesterday":
+			result = result.AddDate(0, 0, -1)
+			touched = true
+		case "midnight":
+			setClock(0, 0, 0)
+		case "noon":
+			if now.Hour() < 12 {
+				result = result.AddDate(0, 0, -1)
+			}
+			setClock(12, 0, 0)
+		case "tea":
+			if now.Hour() < 17 {
+				result = result.AddDate(0, 0, -1)
+			}
+			setClock(17, 0, 0)
+		case "am", "pm":
+			if number > 12 {
+				return time.Time{}, errors.New(fmt.Sprintf("Invalid hour in date '%s'", date))
+			} else if number >= 0 {
+				setClock(number, 0, 0)
+			} else if hour < 0 {
+				hour = result.Hour()
+			}
+			if token == "pm" && hour < 12 {
+				result = result.Add(12 * time.Hour)
+			} else if token == "am" && hour == 12 {
+				result = result.Add(-12 * time.Hour)
+			}
+			touched = true
+		default:
+			if unit := approxidateUnit(token); unit != "" {
+				switch unit {
+				case "second":
+					result = result.Add(-time.Duration(count) * time.Second)
+				case "minute":
+					result = result.Add(-time.Duration(count) * time.Minute)
+				case "hour":
+					result = result.Add(-time.Duration(count) * time.Hour)
+				case "day":
+					result = result.AddDate(0, 0, -count)
+				case "week":
+					result = result.AddDate(0, 0, -7*count)
+				case "month":
+					result = result.AddDate(0, -count, 0)
+				case "year":
+					result = result.AddDate(-count, 0, 0)
+				}
+				touched = true
+			} else if weekday, ok := weekdayNames[token]; ok {
+				diff := int(result.Weekday() - weekday)
+				if diff <= 0 {
+					diff += 7
+				}
+				result = result.AddDate(0, 0, -diff-7*(count-1))
+				touched = true
+			}
+		}
+		number = -1
+	}
+	if !touched {
+		return time.Time{}, errors.New(fmt.Sprintf("Unknown date format '%s'", date))
+	}
+	return result, nil
+}
+
+// approxidateUnit returns the unit name for the token like "days", "day",
+// "weeks" or "mins".
+func approxidateUnit(token string) string {
+	switch token {
+	case "s", "sec", "secs", "second", "seconds":
+		return "second"
+	case "min", "mins", "minute", "minutes":
+		return "minute"
+	case "h", "hour", "hours":
+		return "hour"
+	case "d", "day", "days":
+		return "day"
+	case "w", "week", "weeks":
+		return "week"
+	case "month", "months":
+		return "month"
+	case "y", "year", "years":
+		return "year"
+	}
+	return ""
+}
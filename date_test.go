@@ -0,0 +1,66 @@
+package git4go
+
+import (
+	"testing"
+	"time"
+)
+
+func checkApproxidate(date, expected string, now time.Time, t *testing.T) {
+	result, err := approxidate(date, now)
+	if expected == "" {
+		if err == nil {
+			t.Error("err should not be nil:", date, result)
+		}
+		return
+	}
+	if err != nil {
+		t.Error("err should be nil:", date, err)
+		return
+	}
+	expectedTime, _ := time.Parse("2006-01-02 15:04:05 -0700", expected)
+	if !result.Equal(expectedTime) {
+		t.Error("date is wrong:", date, "expected:", expectedTime, "actual:", result)
+	}
+}
+
+func Test_Approxidate_Strict(t *testing.T) {
+	now := time.Date(2026, 1, 14, 15, 30, 0, 0, time.UTC)
+
+	checkApproxidate("2026-01-01 10:00:00 +0900", "2026-01-01 10:00:00 +0900", now, t)
+	checkApproxidate("2026-01-01 10:00 +0900", "2026-01-01 10:00:00 +0900", now, t)
+	checkApproxidate("2026-1-1 10:00:00 -0800", "2026-01-01 10:00:00 -0800", now, t)
+	checkApproxidate("2026-01-01T10:00:00Z", "2026-01-01 10:00:00 +0000", now, t)
+	checkApproxidate("2026-01-01T10:00:00+09:00", "2026-01-01 10:00:00 +0900", now, t)
+	checkApproxidate("Thu, 1 Jan 2026 10:00:00 +0000", "2026-01-01 10:00:00 +0000", now, t)
+	checkApproxidate("@1767261600", "2026-01-01 10:00:00 +0000", now, t)
+
+	// without time zone, it is local time of now
+	checkApproxidate("2026-01-01 10:00", "2026-01-01 10:00:00 +0000", now, t)
+	checkApproxidate("2026-01-01", "2026-01-01 00:00:00 +0000", now, t)
+	checkApproxidate("2026/01/01 10:00:00", "2026-01-01 10:00:00 +0000", now, t)
+}
+
+func Test_Approxidate_Relative(t *testing.T) {
+	// Wednesday
+	now := time.Date(2026, 1, 14, 15, 30, 0, 0, time.UTC)
+
+	checkApproxidate("now", "2026-01-14 15:30:00 +0000", now, t)
+	checkApproxidate("yesterday", "2026-01-13 15:30:00 +0000", now, t)
+	checkApproxidate("yesterday 10:00", "2026-01-13 10:00:00 +0000", now, t)
+	checkApproxidate("3 weeks ago", "2025-12-24 15:30:00 +0000", now, t)
+	checkApproxidate("3.weeks.ago", "2025-12-24 15:30:00 +0000", now, t)
+	checkApproxidate("2 days 3 hours ago", "2026-01-12 12:30:00 +0000", now, t)
+	checkApproxidate("one month ago", "2025-12-14 15:30:00 +0000", now, t)
+	checkApproxidate("a year ago", "2025-01-14 15:30:00 +0000", now, t)
+	checkApproxidate("10 minutes ago", "2026-01-14 15:20:00 +0000", now, t)
+	checkApproxidate("noon", "2026-01-14 12:00:00 +0000", now, t)
+	checkApproxidate("midnight", "2026-01-14 00:00:00 +0000", now, t)
+	checkApproxidate("tea", "2026-01-13 17:00:00 +0000", now, t)
+	checkApproxidate("yesterday 5pm", "2026-01-13 17:00:00 +0000", now, t)
+	checkApproxidate("last friday", "2026-01-09 15:30:00 +0000", now, t)
+	checkApproxidate("wednesday", "2026-01-07 15:30:00 +0000", now, t)
+
+	checkApproxidate("", "", now, t)
+	checkApproxidate("not a date", "", now, t)
+	checkApproxidate("13pm", "", now, t)
+}
@@ -0,0 +1,216 @@
+package git4go
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+// ewahBitmap is the compressed bitmap format of git (EWAH). The buffer
+// consists of "running length words" followed by literal words:
+//
+//	bit 0      : the bit of the running words
+//	bit 1-32   : the number of the running words
+//	bit 33-63  : the number of the following literal words
+//
+// Bits are set only in ascending order like git's ewah_set().
+type ewahBitmap struct {
+	buffer  []uint64
+	rlw     int
+	bitSize int
+}
+
+const (
+	ewahBitsInWord          = 64
+	ewahRunningBits         = 32
+	ewahLiteralBits         = 64 - 1 - ewahRunningBits
+	ewahLargestRunningCount = (uint64(1) << ewahRunningBits) - 1
+	ewahLargestLiteralCount = (uint64(1) << ewahLiteralBits) - 1
+)
+
+func newEwahBitmap() *ewahBitmap {
+	return &ewahBitmap{
+		buffer: []uint64{0},
+	}
+}
+
+func rlwRunBit(word uint64) uint64 {
+	return word & 1
+}
+
+func rlwRunningLength(word uint64) uint64 {
+	return (word >> 1) & ewahLargestRunningCount
+}
+
+func rlwLiteralWords(word uint64) uint64 {
+	return word >> (1 + ewahRunningBits)
+}
+
+func (b *ewahBitmap) setRunBit(bit uint64) {
+	b.buffer[b.rlw] = b.buffer[b.rlw]&^1 | bit
+}
+
+func (b *ewahBitmap) setRunningLength(length uint64) {
+	b.buffer[b.rlw] = b.buffer[b.rlw]&^(ewahLargestRunningCount<<1) | length<<1
+}
+
+func (b *ewahBitmap) setLiteralWords(count uint64) {
+	b.buffer[b.rlw] = b.buffer[b.rlw]&^(ewahLargestLiteralCount<<(1+ewahRunningBits)) | count<<(1+ewahRunningBits)
+}
+
+func (b *ewahBitmap) pushRlw() {
+	b.buffer = append(b.buffer, 0)
+	b.rlw = len(b.buffer) - 1
+}
+
+func (b *ewahBitmap) addLiteral(word uint64) {
+	count := rlwLiteralWords(b.buffer[b.rlw])
+	if count >= ewahLargestLiteralCount {
+		b.pushRlw()
+		count = 0
+	}
+	b.setLiteralWords(count + 1)
+	b.buffer = append(b.buffer, word)
+}
+
+func (b *ewahBitmap) addEmptyWord(bit uint64) {
+	rlw := b.buffer[b.rlw]
+	noLiteral := rlwLiteralWords(rlw) == 0
+	length := rlwRunningLength(rlw)
+	if noLiteral && length == 0 {
+		b.setRunBit(bit)
+	}
+	if noLiteral && rlwRunBit(b.buffer[b.rlw]) == bit && length < ewahLargestRunningCount {
+		b.setRunningLength(length + 1)
+		return
+	}
+	b.pushRlw()
+	b.setRunBit(bit)
+	b.setRunningLength(1)
+}
+
+func (b *ewahBitmap) addEmptyWords(bit, count uint64) {
+	rlw := b.buffer[b.rlw]
+	if rlwRunBit(rlw) != bit && rlwRunningLength(rlw)+rlwLiteralWords(rlw) == 0 {
+		b.setRunBit(bit)
+	} else if rlwLiteralWords(rlw) != 0 || rlwRunBit(rlw) != bit {
+		b.pushRlw()
+		b.setRunBit(bit)
+	}
+	length := rlwRunningLength(b.buffer[b.rlw])
+	canAdd := ewahLargestRunningCount - length
+	if count < canAdd {
+		canAdd = count
+	}
+	b.setRunningLength(length + canAdd)
+	count -= canAdd
+	for count > 0 {
+		b.pushRlw()
+		b.setRunBit(bit)
+		if count > ewahLargestRunningCount {
+			b.setRunningLength(ewahLargestRunningCount)
+			count -= ewahLargestRunningCount
+		} else {
+			b.setRunningLength(count)
+			count = 0
+		}
+	}
+}
+
+// set sets the i-th bit. i should be larger than the bits set before.
+func (b *ewahBitmap) set(i int) {
+	dist := (i+ewahBitsInWord)/ewahBitsInWord - (b.bitSize+ewahBitsInWord-1)/ewahBitsInWord
+	b.bitSize = i + 1
+	bit := uint64(1) << uint(i%ewahBitsInWord)
+	if dist > 0 {
+		if dist > 1 {
+			b.addEmptyWords(0, uint64(dist-1))
+		}
+		b.addLiteral(bit)
+		return
+	}
+	if rlwLiteralWords(b.buffer[b.rlw]) == 0 {
+		b.setRunningLength(rlwRunningLength(b.buffer[b.rlw]) - 1)
+		b.addLiteral(bit)
+		return
+	}
+	last := len(b.buffer) - 1
+	b.buffer[last] |= bit
+	// a literal word which is filled by 1s becomes a running word
+	if b.buffer[last] == ^uint64(0) {
+		b.buffer = b.buffer[:last]
+		b.setLiteralWords(rlwLiteralWords(b.buffer[b.rlw]) - 1)
+		b.addEmptyWord(1)
+	}
+}
+
+// eachBit calls the callback with the positions of the set bits in
+// ascending order.
+func (b *ewahBitmap) eachBit(callback func(int) error) error {
+	pos := 0
+	for ptr := 0; ptr < len(b.buffer); {
+		word := b.buffer[ptr]
+		length := int(rlwRunningLength(word)) * ewahBitsInWord
+		if rlwRunBit(word) != 0 {
+			for k := 0; k < length; k++ {
+				if err := callback(pos); err != nil {
+					return err
+				}
+				pos++
+			}
+		} else {
+			pos += length
+		}
+		ptr++
+		for k := uint64(0); k < rlwLiteralWords(word); k++ {
+			if ptr >= len(b.buffer) {
+				return errors.New("EWAH bitmap is truncated")
+			}
+			for c := uint(0); c < ewahBitsInWord; c++ {
+				if b.buffer[ptr]&(uint64(1)<<c) != 0 {
+					if err := callback(pos); err != nil {
+						return err
+					}
+				}
+				pos++
+			}
+			ptr++
+		}
+	}
+	return nil
+}
+
+// write serializes the bitmap in the format of git's
+// ewah_serialize_to().
+func (b *ewahBitmap) write(buffer *bytes.Buffer) {
+	binary.Write(buffer, binary.BigEndian, []uint32{uint32(b.bitSize), uint32(len(b.buffer))})
+	binary.Write(buffer, binary.BigEndian, b.buffer)
+	binary.Write(buffer, binary.BigEndian, uint32(b.rlw))
+}
+
+// readEwahBitmap returns the bitmap and the offset next to it.
+func readEwahBitmap(buffer []byte, offset int) (*ewahBitmap, int, error) {
+	if offset+8 > len(buffer) {
+		return nil, 0, errors.New("EWAH bitmap is truncated")
+	}
+	bitSize := int(ntohlFromBytes(buffer, offset))
+	wordCount := int(ntohlFromBytes(buffer, offset+4))
+	offset += 8
+	if offset+wordCount*8+4 > len(buffer) {
+		return nil, 0, errors.New("EWAH bitmap is truncated")
+	}
+	words := make([]uint64, wordCount)
+	for i := range words {
+		words[i] = binary.BigEndian.Uint64(buffer[offset : offset+8])
+		offset += 8
+	}
+	rlw := int(ntohlFromBytes(buffer, offset))
+	if rlw >= wordCount && wordCount != 0 {
+		return nil, 0, errors.New("EWAH bitmap has invalid running length word position")
+	}
+	return &ewahBitmap{
+		buffer:  words,
+		rlw:     rlw,
+		bitSize: bitSize,
+	}, offset + 4, nil
+}
@@ -0,0 +1,72 @@
+package git4go
+
+import (
+	"bytes"
+	"encoding/hex"
+	"reflect"
+	"testing"
+)
+
+func Test_Ewah_SetAndEachBit(t *testing.T) {
+	testcases := [][]int{
+		{},
+		{4},
+		{0, 1, 2, 3, 5, 6, 7},
+		{3, 200, 1000},
+		// 64 bits in a word become a running word
+		append(bitRange(0, 64), 70),
+		append(bitRange(10, 300), 5000),
+	}
+	for _, bits := range testcases {
+		bitmap := newEwahBitmap()
+		for _, bit := range bits {
+			bitmap.set(bit)
+		}
+		var buffer bytes.Buffer
+		bitmap.write(&buffer)
+		read, offset, err := readEwahBitmap(buffer.Bytes(), 0)
+		if err != nil || offset != buffer.Len() {
+			t.Error("err should be nil:", err, offset, buffer.Len())
+			continue
+		}
+		var result []int
+		read.eachBit(func(pos int) error {
+			result = append(result, pos)
+			return nil
+		})
+		if len(result) != len(bits) || (len(bits) > 0 && !reflect.DeepEqual(result, bits)) {
+			t.Error("bits should be same:", bits, result)
+		}
+	}
+}
+
+func Test_Ewah_Write(t *testing.T) {
+	// bitmaps in the link extension written by git
+	testcases := []struct {
+		bits     []int
+		expected string
+	}{
+		{[]int{}, "00000000" + "00000001" + "0000000000000000" + "00000000"},
+		{[]int{4}, "00000005" + "00000002" + "0000000200000000" + "0000000000000010" + "00000000"},
+		{[]int{0, 1, 2, 3, 5, 6, 7}, "00000008" + "00000002" + "0000000200000000" + "00000000000000ef" + "00000000"},
+	}
+	for _, c := range testcases {
+		bitmap := newEwahBitmap()
+		for _, bit := range c.bits {
+			bitmap.set(bit)
+		}
+		var buffer bytes.Buffer
+		bitmap.write(&buffer)
+		if hex.EncodeToString(buffer.Bytes()) != c.expected {
+			t.Error("bitmap is wrong:", c.bits, hex.EncodeToString(buffer.Bytes()))
+		}
+	}
+}
+
+func bitRange(start, end int) []int {
+	var result []int
+	for i := start; i < end; i++ {
+		result = append(result, i)
+	}
+	return result
+}
@@ -29,7 +29,7 @@ func fnMatchX(pattern, str string, patternOffset, strOffset int, flags FnMatchFl
 	initialStrOffset := strOffset
 	for {
 		if patternOffset == len(pattern) {
-			if (flags&FNMLeadingDir != 0) && str[0] == '/' {
+			if (flags&FNMLeadingDir != 0) && strOffset < len(str) && str[strOffset] == '/' {
 				return true, nil
 			}
 			return strOffset == len(str), nil
@@ -50,23 +50,38 @@ func fnMatchX(pattern, str string, patternOffset, strOffset int, flags FnMatchFl
 			}
 			strOffset++
 		case '*':
-			if patternOffset < len(pattern) {
-				c = pattern[patternOffset]
-				if c == '*' {
-					flags &= FNMPathName
-					for c == '*' && patternOffset < len(pattern) {
-						patternOffset++
-						c = pattern[patternOffset]
+			starStart := patternOffset - 1
+			if patternOffset < len(pattern) && pattern[patternOffset] == '*' {
+				for patternOffset < len(pattern) && pattern[patternOffset] == '*' {
+					patternOffset++
+				}
+				// like git, '**' matches across directories only with PATHNAME
+				// and if it is a whole path component. Otherwise it is '*'
+				if flags&FNMPathName != 0 && (starStart == 0 || pattern[starStart-1] == '/') {
+					// star-star at the end matches by default
+					if patternOffset == len(pattern) {
+						return true, nil
 					}
-					if c == '/' {
+					if pattern[patternOffset] == '/' {
 						patternOffset++
+						for {
+							r, err := fnMatchX(pattern, str, patternOffset, strOffset, flags, recurs)
+							if err != nil || r {
+								return r, err
+							}
+							i := strings.IndexByte(str[strOffset:], '/')
+							if i == -1 {
+								return false, nil
+							}
+							strOffset += i + 1
+						}
 					}
 				}
 			}
-			s := str[strOffset]
-			if s == '.' && (flags&FNMPeriod != 0) && ((initialStrOffset == strOffset) || ((flags&FNMPathName != 0) && (strOffset != 0) && (str[strOffset-1] == '/'))) {
+			if strOffset < len(str) && str[strOffset] == '.' && (flags&FNMPeriod != 0) && ((initialStrOffset == strOffset) || ((flags&FNMPathName != 0) && (strOffset != 0) && (str[strOffset-1] == '/'))) {
 				return false, nil
 			}
+			// optimize for pattern with * at end or before /
 			if patternOffset == len(pattern) {
 				if flags&FNMPathName != 0 {
 					return ((flags&FNMLeadingDir != 0) || strings.IndexByte(str[strOffset:], '/') == -1), nil
@@ -89,7 +104,7 @@ func fnMatchX(pattern, str string, patternOffset, strOffset int, flags FnMatchFl
 				if err != nil {
 					return false, err
 				}
-				if err == nil && r {
+				if r {
 					return true, nil
 				}
 				if test == '/' && (flags&FNMPathName != 0) {
@@ -106,12 +121,12 @@ func fnMatchX(pattern, str string, patternOffset, strOffset int, flags FnMatchFl
 			if s == '/' && (flags&FNMPathName != 0) {
 				return false, nil
 			}
-			if s == '.' && (flags&FNMPeriod != 0) && (initialStrOffset == strOffset) || ((flags&FNMPathName != 0) && (strOffset != 0) && (str[strOffset-1] == '/')) {
+			if s == '.' && (flags&FNMPeriod != 0) && ((initialStrOffset == strOffset) || ((flags&FNMPathName != 0) && (strOffset != 0) && (str[strOffset-1] == '/'))) {
 				return false, nil
 			}
 			switch rangeMatch(pattern, s, &patternOffset, flags) {
 			case RangeMatch:
-				break
+				strOffset++
 			case RangeNoMatch:
 				return false, nil
 			case RangeError:
@@ -167,27 +182,31 @@ func toLower(c byte) byte {
 
 func rangeMatch(pattern string, test byte, originalPatternOffset *int, flags FnMatchFlag) RangeMatchResult {
 	patternOffset := *originalPatternOffset
-	c := pattern[patternOffset]
-
-	negate := (c == '!' || c == '^')
-	if negate {
-		patternOffset++
+	// returns 0 as EOS
+	next := func() byte {
 		if patternOffset == len(pattern) {
-			return RangeError
+			return 0
 		}
-		c = pattern[patternOffset]
+		c := pattern[patternOffset]
+		patternOffset++
+		return c
+	}
+	negate := patternOffset < len(pattern) && (pattern[patternOffset] == '!' || pattern[patternOffset] == '^')
+	if negate {
+		patternOffset++
 	}
 	if flags&FNMCaseFold != 0 {
 		test = toLower(test)
 	}
+	// a right bracket at first represents itself
 	ok := false
+	c := next()
 	for {
 		if c == '\\' && (flags&FNMNoEscape == 0) {
-			patternOffset++
-			if patternOffset == len(pattern) {
-				return RangeError
-			}
-			c = pattern[patternOffset]
+			c = next()
+		}
+		if c == 0 {
+			return RangeError
 		}
 		if c == '/' && (flags&FNMPathName != 0) {
 			return RangeNoMatch
@@ -195,42 +214,28 @@ func rangeMatch(pattern string, test byte, originalPatternOffset *int, flags FnM
 		if flags&FNMCaseFold != 0 {
 			c = toLower(c)
 		}
-		if c == '-' {
-			if patternOffset == len(pattern)-2 {
+		if patternOffset+1 < len(pattern) && pattern[patternOffset] == '-' && pattern[patternOffset+1] != ']' {
+			patternOffset++
+			c2 := next()
+			if c2 == '\\' && (flags&FNMNoEscape == 0) {
+				c2 = next()
+			}
+			if c2 == 0 {
 				return RangeError
 			}
-			c2 := pattern[patternOffset+1]
-			if c2 != ']' {
-				patternOffset += 2
-				if c2 == '\\' && (flags&FNMNoEscape == 0) {
-					patternOffset++
-					if patternOffset == len(pattern) {
-						return RangeError
-					}
-					c2 = pattern[patternOffset]
-				}
-				if flags&FNMCaseFold != 0 {
-					c2 = toLower(c2)
-				}
-				if c <= test && test <= c2 {
-					ok = true
-				}
-			} else if c == test {
+			if flags&FNMCaseFold != 0 {
+				c2 = toLower(c2)
+			}
+			if c <= test && test <= c2 {
 				ok = true
 			}
-
 		} else if c == test {
 			ok = true
 		}
-
-		c = pattern[patternOffset]
-		patternOffset++
+		c = next()
 		if c == ']' {
 			break
 		}
-		if patternOffset == len(pattern) {
-			return RangeError
-		}
 	}
 	*originalPatternOffset = patternOffset
 	if ok == negate {
@@ -72,8 +72,32 @@ func TestFnMatch_Star(t *testing.T) {
 	if fnMatch("refs/*/awesome", "refs/heads/feature/awesome", FNMPathName) {
 		t.Error("match error")
 	}
-	// todo: bug?
-	//if !fnMatch("refs/**/awesome", "refs/heads/feature/awesome", FNMPathName) {
-	//	t.Error("match error")
-	//}
+	if !fnMatch("refs/**/awesome", "refs/heads/feature/awesome", FNMPathName) {
+		t.Error("match error")
+	}
+	if !fnMatch("refs/**/awesome", "refs/awesome", FNMPathName) {
+		t.Error("match error")
+	}
+	if !fnMatch("refs/**", "refs/heads/master", FNMPathName) {
+		t.Error("match error")
+	}
+	if !fnMatch("master*", "master", FNMPathName) {
+		t.Error("match error")
+	}
+	if !fnMatch("refs/heads/[lm]aster", "refs/heads/master", FNMPathName) {
+		t.Error("match error")
+	}
+	// '**' which is not a whole path component is same as '*'
+	if !fnMatch("foo**bar", "fooXbar", 0) {
+		t.Error("match error")
+	}
+	if !fnMatch("refs/heads/**x", "refs/heads/ax", 0) {
+		t.Error("match error")
+	}
+	if fnMatch("x**/y", "x/a/y", FNMPathName) {
+		t.Error("match error")
+	}
+	if !fnMatch("**/master", "refs/heads/master", FNMPathName) {
+		t.Error("match error")
+	}
 }
@@ -5,8 +5,22 @@ type ErrorCode int
 const (
 	// Requested object could not be found
 	ErrNotFound ErrorCode = -3
+	// Object exists preventing operation
+	ErrExists ErrorCode = -4
+	// A user-configured callback refused to act
+	ErrUser ErrorCode = -7
 	// Operation not allowed on bare repository
 	ErrBareRepository ErrorCode = -8
+	// Merge in progress prevented operation
+	ErrUnmerged ErrorCode = -10
+	// The given revision spec or reference name is not valid
+	ErrInvalidSpec ErrorCode = -12
+	// Conflicts prevented operation
+	ErrConflict ErrorCode = -13
+	// Lock file prevented operation
+	ErrLocked ErrorCode = -14
+	// Reference value does not match expected
+	ErrModified ErrorCode = -15
 	// The operation is not valid for a directory
 	ErrDirectory ErrorCode = -23
 	// Signals end of iteration with iterator
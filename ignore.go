@@ -0,0 +1,225 @@
+package git4go
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	GitIgnoreFile      = ".gitignore"
+	GitInfoExcludeFile = "info/exclude"
+)
+
+// ignoreRule is a pattern in ignore files.
+type ignoreRule struct {
+	pattern string
+	// base is the directory which has the .gitignore file ("" or "dir/")
+	base     string
+	negate   bool
+	dirOnly  bool
+	anchored bool
+}
+
+// ignores decides whether the paths in the working directory are ignored
+// like git. Rules in .gitignore files are loaded when they are needed.
+type ignores struct {
+	workDir    string
+	ignoreCase bool
+	// info/exclude and core.excludesFile in the order of precedence
+	globalRules [][]*ignoreRule
+	dirRules    map[string][]*ignoreRule
+	dirIgnored  map[string]bool
+}
+
+func newIgnores(repo *Repository, ignoreCase bool) *ignores {
+	result := &ignores{
+		workDir:    repo.Workdir(),
+		ignoreCase: ignoreCase,
+		dirRules:   make(map[string][]*ignoreRule),
+		dirIgnored: make(map[string]bool),
+	}
+	result.globalRules = append(result.globalRules, readIgnoreFile(filepath.Join(repo.Path(), GitInfoExcludeFile), ""))
+	if excludesFile := findExcludesFile(repo.Config()); excludesFile != "" {
+		result.globalRules = append(result.globalRules, readIgnoreFile(excludesFile, ""))
+	}
+	return result
+}
+
+// IsPathIgnored checks the ignore rules of the repository for the path
+// relative to the working directory. It returns true also if one of the
+// parent directories is ignored.
+func (r *Repository) IsPathIgnored(path string) (bool, error) {
+	if r.IsBare() {
+		return false, MakeGitError("Cannot check ignore rules in bare repository", ErrBareRepository)
+	}
+	ignoreCase, _ := r.Config().LookupBooleanWithDefaultValue("core.ignorecase")
+	isDir := strings.HasSuffix(path, "/")
+	path = strings.Trim(filepath.ToSlash(path), "/")
+	if !isDir {
+		stat, err := os.Stat(filepath.Join(r.Workdir(), path))
+		isDir = err == nil && stat.IsDir()
+	}
+	return newIgnores(r, ignoreCase).isIgnored(path, isDir), nil
+}
+
+// isIgnored returns true if the path or one of the parent directories is
+// ignored. The path is relative to the working directory and separated by
+// slashes.
+func (i *ignores) isIgnored(path string, isDir bool) bool {
+	if dir := parentDir(path); dir != "" && i.isDirIgnored(dir) {
+		return true
+	}
+	return i.match(path, isDir)
+}
+
+// isDirIgnored caches the results because all files in the directory
+// share them.
+func (i *ignores) isDirIgnored(dir string) bool {
+	ignored, ok := i.dirIgnored[dir]
+	if !ok {
+		ignored = i.isIgnored(dir, true)
+		i.dirIgnored[dir] = ignored
+	}
+	return ignored
+}
+
+// match checks the rules of the deepest .gitignore first. In each file,
+// the last matching rule decides the result.
+func (i *ignores) match(path string, isDir bool) bool {
+	dir := parentDir(path)
+	for {
+		if matched, ignored := matchIgnoreRules(i.rulesIn(dir), path, isDir, i.ignoreCase); matched {
+			return ignored
+		}
+		if dir == "" {
+			break
+		}
+		dir = parentDir(dir)
+	}
+	for _, rules := range i.globalRules {
+		if matched, ignored := matchIgnoreRules(rules, path, isDir, i.ignoreCase); matched {
+			return ignored
+		}
+	}
+	return false
+}
+
+func (i *ignores) rulesIn(dir string) []*ignoreRule {
+	rules, ok := i.dirRules[dir]
+	if !ok {
+		base := ""
+		if dir != "" {
+			base = dir + "/"
+		}
+		rules = readIgnoreFile(filepath.Join(i.workDir, dir, GitIgnoreFile), base)
+		i.dirRules[dir] = rules
+	}
+	return rules
+}
+
+func matchIgnoreRules(rules []*ignoreRule, path string, isDir, ignoreCase bool) (matched, ignored bool) {
+	for j := len(rules) - 1; j >= 0; j-- {
+		if rules[j].match(path, isDir, ignoreCase) {
+			return true, !rules[j].negate
+		}
+	}
+	return false, false
+}
+
+func (r *ignoreRule) match(path string, isDir, ignoreCase bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	if !strings.HasPrefix(path, r.base) {
+		return false
+	}
+	path = path[len(r.base):]
+	flags := FNMPathName
+	if ignoreCase {
+		flags |= FNMCaseFold
+	}
+	if !r.anchored {
+		path = path[strings.LastIndex(path, "/")+1:]
+	}
+	return fnMatch(r.pattern, path, flags)
+}
+
+// readIgnoreFile returns nil if the file doesn't exist.
+func readIgnoreFile(filePath, base string) []*ignoreRule {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+	var rules []*ignoreRule
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if rule := parseIgnoreRule(scanner.Text(), base); rule != nil {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+func parseIgnoreRule(line, base string) *ignoreRule {
+	line = strings.TrimSuffix(line, "\r")
+	if line == "" || line[0] == '#' {
+		return nil
+	}
+	// trailing spaces are ignored unless they are quoted with backslash
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+		line = line[:len(line)-1]
+	}
+	if line == "" {
+		return nil
+	}
+	rule := &ignoreRule{base: base}
+	if line[0] == '!' {
+		rule.negate = true
+		line = line[1:]
+	} else if line[0] == '\\' && len(line) > 1 && (line[1] == '!' || line[1] == '#') {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if strings.HasPrefix(line, "/") {
+		rule.anchored = true
+		line = line[1:]
+	} else if strings.Contains(line, "/") {
+		rule.anchored = true
+	}
+	if line == "" {
+		return nil
+	}
+	rule.pattern = line
+	return rule
+}
+
+// findExcludesFile returns core.excludesFile or $XDG_CONFIG_HOME/git/ignore.
+func findExcludesFile(config *Config) string {
+	for _, name := range []string{"core.excludesFile", "core.excludesfile"} {
+		if value, err := config.LookupString(name); err == nil && value != "" {
+			if strings.HasPrefix(value, "~/") {
+				value = filepath.Join(os.Getenv("HOME"), value[2:])
+			}
+			return value
+		}
+	}
+	if path, err := findInDirList("ignore", "global/xdg"); err == nil {
+		return path
+	}
+	return ""
+}
+
+func parentDir(filePath string) string {
+	dir := path.Dir(filePath)
+	if dir == "." || dir == "/" {
+		return ""
+	}
+	return dir
+}
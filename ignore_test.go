@@ -0,0 +1,45 @@
+package git4go
+
+import (
+	"./testutil"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func Test_IsPathIgnored(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/status")
+	defer testutil.CleanupWorkspace()
+
+	ioutil.WriteFile("test_resources/status/.gitignore", []byte("# comment\n*.log\n!keep.log\n/build/\ndocs/**/*.tmp\n\\#hash\n"), 0644)
+	os.MkdirAll("test_resources/status/build", 0777)
+	os.MkdirAll("test_resources/status/subdir/build", 0777)
+	ioutil.WriteFile("test_resources/status/subdir/.gitignore", []byte("*.txt\n!keep.log\nkeep.log\n"), 0644)
+
+	repo, _ := OpenRepository("test_resources/status")
+	cases := []struct {
+		path    string
+		ignored bool
+	}{
+		{"ignored_file", true},
+		{"current_file", false},
+		{"debug.log", true},
+		{"keep.log", false},
+		{"a/b/debug.log", true},
+		{"build", true},
+		{"build/out.o", true},
+		{"subdir/build", false},
+		{"docs/x.tmp", true},
+		{"docs/a/b/x.tmp", true},
+		{"#hash", true},
+		{"subdir/a.txt", true},
+		{"subdir.txt", false},
+		{"subdir/keep.log", true},
+	}
+	for _, c := range cases {
+		ignored, err := repo.IsPathIgnored(c.path)
+		if err != nil || ignored != c.ignored {
+			t.Error("ignore result is wrong:", c.path, ignored, err)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package git4go
 
 import (
 	"bytes"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -39,6 +40,7 @@ const (
 	IndexEntryStageMask  IndexEntryFlag = 0x3000
 	IndexEntryStageShift int            = 12
 	IndexEntryExtended   uint16         = 0x4000
+	IndexEntryValid      IndexEntryFlag = 0x8000
 
 	IndexEntryIntentToAdd     IndexEntryExtendedFlag = 1 << 13
 	IndexEntrySkipWorkTree    IndexEntryExtendedFlag = 1 << 14
@@ -60,6 +62,9 @@ const (
 
 	IndexVersionNumber    = 2
 	IndexVersionNumberExt = 3
+	IndexVersionNumberLb  = 2
+	IndexVersionNumberUb  = 4
+	IndexVersionNumberV4  = 4
 
 	IndexHeaderSig uint32 = 0x44495243
 
@@ -82,11 +87,16 @@ const (
 var IndexExtTreeCacheSig []byte = []byte("TREE")
 var IndexExtUnmergedSig []byte = []byte("REUC")
 var IndexExtConflictNameSig []byte = []byte("NAME")
+var IndexExtLinkSig []byte = []byte("link")
+var IndexExtUntrackedSig []byte = []byte("UNTR")
+var IndexExtEndOfIndexSig []byte = []byte("EOIE")
+var IndexExtOffsetTableSig []byte = []byte("IEOT")
 
 type Index struct {
 	repo             *Repository
 	filePath         string
-	stamp            int64
+	stamp            time.Time
+	version          uint32
 	Entries          []*IndexEntry
 	entriesSorted    bool
 	lock             sync.Mutex
@@ -96,16 +106,27 @@ type Index struct {
 	ignoreCase       bool
 	distrustFilemode bool
 	noSymlinks       bool
+	trustCtime       bool
+	checkStat        bool
 
-	tree       *TreeCache
-	names      []*IndexNameEntry
-	reuc       []*IndexReucEntry
-	reucSorted bool
+	tree      *TreeCache
+	split     *splitIndex
+	untracked *untrackedCache
+
+	// parallel loading
+	threads           int
+	recordEndOfIndex  bool
+	recordOffsetTable bool
+	names             []*IndexNameEntry
+	reuc              []*IndexReucEntry
+	reucSorted        bool
 }
 
 type IndexEntry struct {
 	Ctime         time.Time
 	Mtime         time.Time
+	Dev           uint32
+	Ino           uint32
 	Mode          Filemode
 	Uid           uint32
 	Gid           uint32
@@ -130,20 +151,55 @@ type IndexReucEntry struct {
 
 func (r *Repository) Index() (*Index, error) {
 	if r.index == nil {
-		index, err := OpenIndex(filepath.Join(r.pathRepository, GitIndexFile))
-		if err != nil {
+		index := newIndex(filepath.Join(r.pathRepository, GitIndexFile))
+		index.applyThreadsConfig(r.Config())
+		index.applyStatConfig(r.Config())
+		if err := index.Read(true); err != nil {
 			return nil, err
 		}
 		index.repo = r
 		r.index = index
-		err = index.SetCaps(IndexCapFromOwner)
+		err := index.SetCaps(IndexCapFromOwner)
 		if err != nil {
 			return nil, err
 		}
+		if !index.onDisk {
+			index.version = defaultIndexVersion(r.Config())
+		}
+		if split, ok := lookupConfigBool(r.Config(), "core.splitIndex", "core.splitindex"); ok {
+			index.SetSplitIndex(split)
+		}
+		if untracked, ok := lookupConfigBool(r.Config(), "core.untrackedCache", "core.untrackedcache"); ok {
+			index.SetUntrackedCache(untracked)
+		}
 	}
 	return r.index, nil
 }
 
+// defaultIndexVersion returns the version for a new index file.
+// index.version is preferred to feature.manyFiles like git.
+func defaultIndexVersion(config *Config) uint32 {
+	if version, err := config.LookupInt32("index.version"); err == nil &&
+		version >= IndexVersionNumberLb && version <= IndexVersionNumberUb {
+		return uint32(version)
+	}
+	if manyFiles, _ := lookupConfigBool(config, "feature.manyFiles", "feature.manyfiles"); manyFiles {
+		return IndexVersionNumberV4
+	}
+	return IndexVersionNumber
+}
+
+// lookupConfigBool returns the first value found in the names. ok is false
+// if none of them is set.
+func lookupConfigBool(config *Config, names ...string) (value, ok bool) {
+	for _, name := range names {
+		if value, err := config.LookupBool(name); err == nil {
+			return value, true
+		}
+	}
+	return false, false
+}
+
 func (r *Repository) SetIndex(index *Index) {
 	if r.index != nil {
 		r.index.repo = nil
@@ -161,13 +217,7 @@ func NewIndex() (*Index, error) {
 // OpenIndex creates a new index at the given path. If the file does
 // not exist it will be created when Write() is called.
 func OpenIndex(path string) (*Index, error) {
-	index := &Index{
-		filePath: path,
-		Entries:  make([]*IndexEntry, 0, 32),
-		names:    make([]*IndexNameEntry, 0, 8),
-		reuc:     make([]*IndexReucEntry, 0, 8),
-		deleted:  make([]*IndexEntry, 0, 8),
-	}
+	index := newIndex(path)
 	if path != "" {
 		err := index.Read(true)
 		if err != nil {
@@ -178,6 +228,19 @@ func OpenIndex(path string) (*Index, error) {
 	return index, nil
 }
 
+func newIndex(path string) *Index {
+	return &Index{
+		filePath:   path,
+		version:    IndexVersionNumber,
+		trustCtime: true,
+		checkStat:  true,
+		Entries:    make([]*IndexEntry, 0, 32),
+		names:      make([]*IndexNameEntry, 0, 8),
+		reuc:       make([]*IndexReucEntry, 0, 8),
+		deleted:    make([]*IndexEntry, 0, 8),
+	}
+}
+
 // Path returns the index' path on disk or an empty string if it
 // exists only in memory.
 func (v *Index) Path() string {
@@ -197,8 +260,8 @@ func (v *Index) Read(force bool) error {
 		return nil
 	}
 	v.onDisk = true
-	stamp := stat.ModTime().Unix()
-	if v.stamp >= stamp && !force {
+	stamp := stat.ModTime()
+	if !stamp.After(v.stamp) && !force {
 		return nil
 	}
 	buffer, err := ioutil.ReadFile(v.filePath)
@@ -209,6 +272,38 @@ func (v *Index) Read(force bool) error {
 	if err != nil {
 		return err
 	}
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	splitEnabled := v.split != nil
+	untrackedEnabled := v.untracked != nil
+	v.split = nil
+	v.untracked = nil
+	if err := v.parse(buffer); err != nil {
+		return err
+	}
+	if v.split != nil {
+		if err := v.mergeSharedIndex(filepath.Dir(v.filePath)); err != nil {
+			return err
+		}
+	} else if splitEnabled {
+		v.split = &splitIndex{}
+	}
+	if v.untracked == nil && untrackedEnabled {
+		v.untracked = newUntrackedCache()
+	}
+	v.entriesSorted = !v.ignoreCase
+	if !v.entriesSorted {
+		v.sortEntriesIfNeeded(v.ignoreCase, false)
+		v.entriesSorted = true
+	}
+	v.stamp = stamp
+	return nil
+}
+
+// parse reads the entries and the extensions of the index file. Entries
+// are kept in the order on disk.
+func (v *Index) parse(buffer []byte) error {
 	// check size and read checksum(sha1)
 	if len(buffer) < IndexHeaderSize+IndexFooterSize {
 		return errors.New("Index.Read(): insufficient buffer space")
@@ -225,27 +320,29 @@ func (v *Index) Read(force bool) error {
 		return errors.New("Index.Read(): incorrect header signature")
 	}
 	version := ntohlFromBytes(buffer, 4)
-	if version != IndexVersionNumber && version != IndexVersionNumberExt {
+	if version < IndexVersionNumberLb || version > IndexVersionNumberUb {
 		return errors.New("Index.Read(): incorrect header version")
 	}
 	entryCount := int(ntohlFromBytes(buffer, 8))
+	if entryCount > (len(buffer)-IndexHeaderSize-IndexFooterSize)/IndexMinimumEntrySize {
+		return errors.New("Index.Read(): too many entries for the buffer size")
+	}
+	v.version = version
 	// start reading entries
-	v.lock.Lock()
-	defer v.lock.Unlock()
-
-	bound := len(buffer) - IndexFooterSize
-	offset := IndexHeaderSize
-	var i int
-	for i = 0; i < entryCount && offset < bound; i++ {
-		var entry *IndexEntry
-		offset, entry = readEntry(buffer, offset)
-		if entry != nil {
-			v.Entries = append(v.Entries, entry)
+	var offset int
+	var err error
+	if blocks, extensionOffset := v.offsetTableToLoad(buffer, entryCount); blocks != nil {
+		offset, err = v.parseEntriesParallel(buffer, version, entryCount, blocks, extensionOffset)
+		if err == nil && offset != extensionOffset {
+			err = errors.New("Index.Read(): offset table doesn't match the entries")
 		}
+	} else {
+		offset, err = v.parseEntries(buffer, version, entryCount)
 	}
-	if i != entryCount {
-		return errors.New("Index.Read(): header entries changed while parsing")
+	if err != nil {
+		return err
 	}
+	bound := len(buffer) - IndexFooterSize
 	for offset < bound {
 		size := readExtension(v, buffer, offset)
 		if size == 0 {
@@ -256,12 +353,6 @@ func (v *Index) Read(force bool) error {
 	if offset != bound {
 		return errors.New("buffer size does not match index footer size")
 	}
-	v.entriesSorted = !v.ignoreCase
-	if !v.entriesSorted {
-		v.sortEntriesIfNeeded(v.ignoreCase, false)
-		v.entriesSorted = true
-	}
-	v.stamp = stamp
 	return nil
 }
 
@@ -274,7 +365,8 @@ func (v *Index) Clear() error {
 	v.names = make([]*IndexNameEntry, 0, 8)
 	v.reuc = make([]*IndexReucEntry, 0, 8)
 	v.deleted = make([]*IndexEntry, 0, 8)
-	v.stamp = 0
+	v.untracked.invalidateAll()
+	v.stamp = time.Time{}
 	return nil
 }
 
@@ -284,44 +376,99 @@ func (v *Index) Add(entry *IndexEntry) error {
 	if !validFilemode(entry.Mode) {
 		return errors.New("invalid filemode")
 	}
-	v.Entries = append(v.Entries, entry)
-	v.entriesSorted = false
-	v.tree.invalidatePath(entry.Path)
+	if entry.Id == nil {
+		return errors.New("Index.Add(): entry should have an object id")
+	}
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	copied := *entry
+	v.insertEntry(&copied)
 	return nil
 }
 
 func (v *Index) AddByPath(path string) error {
 	// begin: index_entry_init
 	if v.Owner() == nil {
-		errors.New("Could not initialize index entry. Index is not backed up by an existing repository.")
+		return errors.New("Could not initialize index entry. Index is not backed up by an existing repository.")
 	}
 	entry, err := indexEntryCreate(v.repo, path)
 	if err != nil {
 		return err
 	}
-	oid, _, err := createBlobCreateFromPaths(v.Owner(), "", path, 0, true)
+	oid, stat, err := createBlobCreateFromPaths(v.Owner(), "", path, 0, true)
 	if err != nil {
 		return err
 	}
 	entry.Id = oid
 
 	// end: index_entry_init
-	v.Entries = append(v.Entries, entry)
-	v.entriesSorted = false
-	if err := conflictToReuc(v, path); err != nil {
-		return err
+	v.lock.Lock()
+	var existing *IndexEntry
+	if pos := v.sortAndFindInEntries(path, 0, false); pos != -1 {
+		existing = v.Entries[pos]
 	}
-	v.tree.invalidatePath(path)
-	return nil
+	indexEntryInitFromStat(entry, stat, v.mergeMode(existing, stat.Mode()))
+	v.insertEntry(entry)
+	v.lock.Unlock()
+
+	return conflictToReuc(v, path)
+}
+
+// insertEntry replaces the entry which has the same path and stage, or
+// inserts the entry at the sorted position. The entries are kept sorted
+// not to sort them again at every insertion.
+func (v *Index) insertEntry(entry *IndexEntry) {
+	pathLength := IndexEntryFlag(len(entry.Path))
+	if pathLength > IndexEntryNameMask {
+		pathLength = IndexEntryNameMask
+	}
+	entry.flags = entry.flags&^uint16(IndexEntryNameMask) | uint16(pathLength)
+	pos := v.sortAndFindInEntries(entry.Path, entry.Stage(), false)
+	if pos != -1 {
+		v.Entries[pos] = entry
+	} else {
+		pos = sort.Search(len(v.Entries), func(i int) bool {
+			return !indexEntryLess(v.Entries[i], entry, v.ignoreCase)
+		})
+		v.Entries = append(v.Entries, nil)
+		copy(v.Entries[pos+1:], v.Entries[pos:])
+		v.Entries[pos] = entry
+	}
+	v.tree.invalidatePath(entry.Path)
+	v.untracked.invalidatePath(entry.Path)
+}
+
+// mergeMode decides the file mode of the new entry. It keeps the mode in
+// the index if the file system doesn't support executable bits or
+// symbolic links.
+func (v *Index) mergeMode(existing *IndexEntry, mode os.FileMode) Filemode {
+	if v.noSymlinks && existing != nil && existing.Mode == FilemodeLink && mode&os.ModeSymlink == 0 {
+		return FilemodeLink
+	}
+	if v.distrustFilemode && mode.IsRegular() {
+		if existing != nil && (existing.Mode == FilemodeBlob || existing.Mode == FilemodeBlobExecutable) {
+			return existing.Mode
+		}
+		return FilemodeBlob
+	}
+	switch {
+	case mode&os.ModeSymlink != 0:
+		return FilemodeLink
+	case mode.IsDir():
+		return FilemodeCommit
+	case mode&0100 != 0:
+		return FilemodeBlobExecutable
+	}
+	return FilemodeBlob
 }
 
 func indexEntryInitFromStat(entry *IndexEntry, stat os.FileInfo, mode Filemode) {
 	extStat := extstat.New(stat)
 	entry.Mtime = stat.ModTime()
 	entry.Ctime = extStat.ChangeTime
-	//entry.Dev = stat.Dev
-	//entry.Uid = stat.Uid
-	//entry.Gid = stat.Gid
+	entry.Dev, entry.Ino, entry.Uid, entry.Gid = statIds(stat)
+	entry.Mode = mode
 	entry.Size = uint32(stat.Size())
 }
 
@@ -346,35 +493,14 @@ func (v *Index) AddFromBuffer(sourceEntry *IndexEntry, buffer []byte) error {
 
 }
 
+// conflictToReuc resolves the conflict of the path. It does nothing if
+// the path doesn't have conflicts.
 func conflictToReuc(v *Index, path string) error {
-	conflict, err := v.GetConflict(path)
-	if err != nil {
-		return err
-	}
-	var ancestorMode Filemode
-	var ancestorOid *Oid
-	if conflict.Ancestor != nil {
-		ancestorMode = conflict.Ancestor.Mode
-		ancestorOid = conflict.Ancestor.Id
-	}
-	var ourMode Filemode
-	var ourOid *Oid
-	if conflict.Our != nil {
-		ourMode = conflict.Our.Mode
-		ourOid = conflict.Our.Id
-	}
-	var theirMode Filemode
-	var theirOid *Oid
-	if conflict.Their != nil {
-		theirMode = conflict.Their.Mode
-		theirOid = conflict.Their.Id
-	}
-	err = reucAdd(v, path, ancestorMode, ourMode, theirMode, ancestorOid, ourOid, theirOid)
-	if err != nil {
-		return err
+	err := v.RemoveConflict(path)
+	if IsErrorCode(err, ErrNotFound) {
+		return nil
 	}
-	return v.RemoveConflict(path)
-
+	return err
 }
 
 func reucAdd(v *Index, path string, ancestorMode, ourMode, theirMode Filemode, ancestorOid, ourOid, theirOid *Oid) error {
@@ -394,16 +520,22 @@ func reucAdd(v *Index, path string, ancestorMode, ourMode, theirMode Filemode, a
 }
 
 func reucFind(v *Index, reucPath string) int {
+	name := func(i int) string {
+		return v.reuc[i].path
+	}
 	if v.ignoreCase {
 		reucPath = strings.ToLower(reucPath)
-		return sort.Search(len(v.reuc), func(i int) bool {
-			return strings.ToLower(v.reuc[i].path) == reucPath
-		})
-	} else {
-		return sort.Search(len(v.reuc), func(i int) bool {
-			return v.reuc[i].path == reucPath
-		})
+		name = func(i int) string {
+			return strings.ToLower(v.reuc[i].path)
+		}
 	}
+	pos := sort.Search(len(v.reuc), func(i int) bool {
+		return name(i) >= reucPath
+	})
+	if pos < len(v.reuc) && name(pos) == reucPath {
+		return pos
+	}
+	return -1
 }
 
 func (v *Index) SetCaps(caps IndexCapFlag) error {
@@ -446,31 +578,268 @@ func (v *Index) Caps() IndexCapFlag {
 	return flag
 }
 
-// todo
+// AddAll adds or updates the files in the working directory which match
+// the pathspecs like "git add --all". Entries of deleted files are removed
+// too. Untracked files are skipped if they are ignored unless
+// IndexAddForce is set. The callback is called before each path is
+// changed and can return IndexSkipFile or IndexAbort.
 func (v *Index) AddAll(pathSpecs []string, flags IndexAddOpts, callback IndexMatchedPathCallback) error {
-	return errors.New("not implemented")
+	repo, err := v.workdirOwner()
+	if err != nil {
+		return err
+	}
+	spec, err := newPathspec(pathSpecs, flags&IndexAddDisablePathspecMatch != 0)
+	if err != nil {
+		return err
+	}
+	force := flags&IndexAddForce != 0
+	ignores := newIgnores(repo, v.ignoreCase)
+	tracked := newIndexPaths(v.entriesSnapshot(), v.ignoreCase)
+	if flags&IndexAddCheckPathspec != 0 && !force {
+		if err := checkIgnoredPathspec(repo, spec, ignores, tracked); err != nil {
+			return err
+		}
+	}
+	workDir := repo.Workdir()
+	err = filepath.Walk(workDir, func(fullPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fullPath == workDir {
+			return nil
+		}
+		relPath, err := filepath.Rel(workDir, fullPath)
+		if err != nil {
+			return err
+		}
+		path := filepath.ToSlash(relPath)
+		if info.IsDir() {
+			// nested repositories are not added
+			if info.Name() == GitDirName {
+				return filepath.SkipDir
+			}
+			if _, err := os.Lstat(filepath.Join(fullPath, GitDirName)); err == nil {
+				return filepath.SkipDir
+			}
+			if !spec.matchDirectory(path, v.ignoreCase) {
+				return filepath.SkipDir
+			}
+			if !force && !tracked.hasDir(path) && ignores.isIgnored(path, true) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() && info.Mode()&os.ModeSymlink == 0 {
+			return nil
+		}
+		matched, matchedSpec := spec.match(path, v.ignoreCase)
+		if !matched {
+			return nil
+		}
+		entry, isTracked := tracked.lookup(path)
+		if !isTracked && !force && ignores.isIgnored(path, false) {
+			return nil
+		}
+		if entry != nil && v.isUnchanged(entry, fullPath, info) {
+			return nil
+		}
+		if apply, err := notifyMatchedPath(callback, path, matchedSpec); !apply {
+			return err
+		}
+		return v.AddByPath(path)
+	})
+	if err != nil {
+		return err
+	}
+	return v.updateTracked(repo, spec, callback, true)
 }
 
-// todo
+// UpdateAll updates the entries which match the pathspecs to the files in
+// the working directory like "git add --update". Entries of deleted files
+// are removed. Untracked files are not added.
 func (v *Index) UpdateAll(pathSpecs []string, callback IndexMatchedPathCallback) error {
-	return errors.New("not implemented")
+	repo, err := v.workdirOwner()
+	if err != nil {
+		return err
+	}
+	spec, err := newPathspec(pathSpecs, false)
+	if err != nil {
+		return err
+	}
+	return v.updateTracked(repo, spec, callback, false)
 }
 
-// todo
+// RemoveAll removes all entries which match the pathspecs including
+// conflicts like "git rm --cached". The working directory is not changed.
 func (v *Index) RemoveAll(pathSpecs []string, callback IndexMatchedPathCallback) error {
-	return errors.New("not implemented")
+	spec, err := newPathspec(pathSpecs, false)
+	if err != nil {
+		return err
+	}
+	previous := ""
+	for _, entry := range v.entriesSnapshot() {
+		if entry.Path == previous {
+			continue
+		}
+		previous = entry.Path
+		matched, matchedSpec := spec.match(entry.Path, v.ignoreCase)
+		if !matched {
+			continue
+		}
+		if apply, err := notifyMatchedPath(callback, entry.Path, matchedSpec); !apply {
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if err := v.RemoveByPath(entry.Path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateTracked removes the entries of deleted files. It also updates the
+// modified files unless removeOnly is true. Files of skip worktree entries
+// are not checked because they are not expected in the working directory.
+func (v *Index) updateTracked(repo *Repository, spec *pathspec, callback IndexMatchedPathCallback, removeOnly bool) error {
+	previous := ""
+	for _, entry := range v.entriesSnapshot() {
+		if entry.Path == previous || entry.Mode == FilemodeCommit || entry.IsSkipWorktree() {
+			continue
+		}
+		previous = entry.Path
+		matched, matchedSpec := spec.match(entry.Path, v.ignoreCase)
+		if !matched {
+			continue
+		}
+		fullPath := filepath.Join(repo.Workdir(), entry.Path)
+		info, err := os.Lstat(fullPath)
+		deleted := err != nil || info.IsDir()
+		if !deleted && (removeOnly || (entry.Stage() == 0 && v.isUnchanged(entry, fullPath, info))) {
+			continue
+		}
+		if apply, err := notifyMatchedPath(callback, entry.Path, matchedSpec); !apply {
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if deleted {
+			err = v.RemoveByPath(entry.Path)
+		} else {
+			err = v.AddByPath(entry.Path)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (v *Index) workdirOwner() (*Repository, error) {
+	if v.repo == nil {
+		return nil, errors.New("Could not add paths to index. Index is not backed up by an existing repository.")
+	}
+	if v.repo.IsBare() {
+		return nil, MakeGitError("Could not add paths to index. Repository should not be bare", ErrBareRepository)
+	}
+	return v.repo, nil
+}
+
+// entriesSnapshot returns the sorted copy of the entries to modify the
+// index while iterating.
+func (v *Index) entriesSnapshot() []*IndexEntry {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	v.sortEntriesIfNeeded(v.ignoreCase, false)
+	return append([]*IndexEntry{}, v.Entries...)
+}
+
+// notifyMatchedPath calls the callback and returns whether the path
+// should be changed.
+func notifyMatchedPath(callback IndexMatchedPathCallback, path, matchedSpec string) (bool, error) {
+	if callback == nil {
+		return true, nil
+	}
+	result := callback(path, matchedSpec)
+	if result < 0 {
+		return false, MakeGitError(fmt.Sprintf("Index operation was aborted by callback at '%s'", path), ErrUser)
+	}
+	return result == IndexApplyFile, nil
+}
+
+// checkIgnoredPathspec returns an error if one of the literal pathspecs is
+// an untracked ignored file like git add does.
+func checkIgnoredPathspec(repo *Repository, spec *pathspec, ignores *ignores, tracked *indexPaths) error {
+	for _, item := range spec.items {
+		if item.exclude || item.pattern == "" || (!item.literal && strings.ContainsAny(item.pattern, "*?[\\")) {
+			continue
+		}
+		info, err := os.Lstat(filepath.Join(repo.Workdir(), item.pattern))
+		if err != nil {
+			continue
+		}
+		if _, isTracked := tracked.lookup(item.pattern); isTracked || tracked.hasDir(item.pattern) {
+			continue
+		}
+		if ignores.isIgnored(item.pattern, info.IsDir()) {
+			return MakeGitError(fmt.Sprintf("pathspec '%s' is ignored by one of the .gitignore files", item.original), ErrInvalidSpec)
+		}
+	}
+	return nil
+}
+
+// indexPaths is a snapshot of the paths in the index.
+type indexPaths struct {
+	// stage 0 entries. conflicted paths have nil.
+	entries    map[string]*IndexEntry
+	dirs       map[string]bool
+	ignoreCase bool
+}
+
+func newIndexPaths(entries []*IndexEntry, ignoreCase bool) *indexPaths {
+	result := &indexPaths{
+		entries:    make(map[string]*IndexEntry),
+		dirs:       make(map[string]bool),
+		ignoreCase: ignoreCase,
+	}
+	for _, entry := range entries {
+		key := result.key(entry.Path)
+		if entry.Stage() == 0 {
+			result.entries[key] = entry
+		} else if _, ok := result.entries[key]; !ok {
+			result.entries[key] = nil
+		}
+		for dir := parentDir(key); dir != "" && !result.dirs[dir]; dir = parentDir(dir) {
+			result.dirs[dir] = true
+		}
+	}
+	return result
+}
+
+func (p *indexPaths) key(path string) string {
+	if p.ignoreCase {
+		return strings.ToLower(path)
+	}
+	return path
+}
+
+func (p *indexPaths) lookup(path string) (*IndexEntry, bool) {
+	entry, ok := p.entries[p.key(path)]
+	return entry, ok
+}
+
+func (p *indexPaths) hasDir(path string) bool {
+	return p.dirs[p.key(path)]
 }
 
 func (v *Index) RemoveByPath(path string) error {
 	err := v.Remove(path, 0)
-	if err != nil && IsErrorCode(err, ErrNotFound) {
-		return err
-	}
-	err = conflictToReuc(v, path)
-	if err != nil && IsErrorCode(err, ErrNotFound) {
+	if err != nil && !IsErrorCode(err, ErrNotFound) {
 		return err
 	}
-	return nil
+	return conflictToReuc(v, path)
 }
 
 func (v *Index) Remove(path string, stage IndexStage) error {
@@ -484,9 +853,36 @@ func (v *Index) Remove(path string, stage IndexStage) error {
 	return v.removeEntry(pos)
 }
 
-// todo
+// WriteTreeTo writes the trees of the index into the repository and
+// returns the id of the root tree. The index must not have conflicts.
+// If the repository is the owner of the index, valid nodes of the tree
+// cache are reused and the tree cache is updated with the written trees.
 func (v *Index) WriteTreeTo(repo *Repository) (*Oid, error) {
-	return nil, nil
+	if repo == nil {
+		return nil, errors.New("Failed to write tree. Index is not backed up by an existing repository.")
+	}
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	var entries indexEntriesCaseSensitive = append([]*IndexEntry{}, v.Entries...)
+	sort.Sort(entries)
+	for _, entry := range entries {
+		if entry.Stage() != 0 {
+			return nil, MakeGitError("Cannot create a tree from a not fully merged index", ErrUnmerged)
+		}
+	}
+	var cache *TreeCache
+	if repo == v.repo {
+		cache = v.tree
+	}
+	cache, err := writeTreeCache(repo, entries, "", "", cache)
+	if err != nil {
+		return nil, err
+	}
+	if repo == v.repo {
+		v.tree = cache
+	}
+	return cache.oid, nil
 }
 
 // ReadTree replaces the contents of the index with those of the given
@@ -539,17 +935,93 @@ func (v *Index) ReadTree(tree *Tree) error {
 		var entries indexEntriesCaseSensitive = newEntries
 		sort.Sort(entries)
 	}
+	cache, err := createTreeCacheFromTree(tree)
+	if err != nil {
+		return err
+	}
 	v.Entries = newEntries
+	v.entriesSorted = true
+	v.tree = cache
+	v.untracked.invalidateAll()
 	return nil
 }
 
-// todo
+// Version returns the on-disk format version of the index.
+func (v *Index) Version() uint {
+	return uint(v.version)
+}
+
+// SetVersion chooses the on-disk format version used by Write. Version 4
+// compresses the paths with the previous entries. Versions 2 and 3 are
+// chosen by the existence of extended flags like git.
+func (v *Index) SetVersion(version uint) error {
+	if version < IndexVersionNumberLb || version > IndexVersionNumberUb {
+		return MakeGitError("Invalid index version", ErrInvalidSpec)
+	}
+	v.version = uint32(version)
+	return nil
+}
+
+// WriteTree writes the trees of the index into the owner repository.
 func (v *Index) WriteTree() (*Oid, error) {
 	return v.WriteTreeTo(v.repo)
 }
 
-// todo
+// Write writes the index to its file via "index.lock". Version 4 is kept
+// if it was read from the file or chosen by SetVersion. Otherwise version
+// 3 is used only if some entries need extended flags. A split index writes
+// a new shared index too when it has too many changes. Racily clean
+// entries which are modified are smudged before writing.
 func (v *Index) Write() error {
+	if v.filePath == "" {
+		return errors.New("Failed to write index: The index is in-memory only")
+	}
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	// take index.lock at first not to smudge the entries against the index
+	// which another process is writing
+	lock, err := newLockFile(v.filePath, GitIndexFileMode)
+	if err != nil {
+		return err
+	}
+	v.smudgeRacilyCleanEntries()
+	rollback := lock.Rollback
+	if v.split != nil {
+		baseId, base := v.split.baseId, v.split.base
+		sharedPath, err := v.updateSharedIndex()
+		if err != nil {
+			lock.Rollback()
+			return err
+		}
+		if sharedPath != "" {
+			// nobody refers the new shared index if the index isn't written
+			rollback = func() {
+				lock.Rollback()
+				os.Remove(sharedPath)
+				v.split.baseId, v.split.base = baseId, base
+			}
+		}
+	}
+	buffer, err := v.serialize()
+	if err != nil {
+		rollback()
+		return err
+	}
+	if _, err := lock.Write(buffer); err != nil {
+		rollback()
+		return err
+	}
+	if err := lock.Commit(); err != nil {
+		rollback()
+		return err
+	}
+	stat, err := os.Stat(v.filePath)
+	if err != nil {
+		return err
+	}
+	v.stamp = stat.ModTime()
+	v.onDisk = true
 	return nil
 }
 
@@ -569,6 +1041,18 @@ func (v *Index) EntryByIndex(index int) (*IndexEntry, error) {
 	return nil, errors.New("out of index")
 }
 
+// EntryByPath returns the entry that has the given path and stage.
+func (v *Index) EntryByPath(path string, stage IndexStage) (*IndexEntry, error) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	pos := v.sortAndFindInEntries(path, stage, false)
+	if pos == -1 {
+		return nil, MakeGitError(fmt.Sprintf("Index does not contain %s at stage %d", path, stage), ErrNotFound)
+	}
+	return v.Entries[pos], nil
+}
+
 func (v *Index) Find(path string) int {
 	v.lock.Lock()
 	defer v.lock.Unlock()
@@ -596,7 +1080,7 @@ func (v *Index) Find(path string) int {
 			}
 		}
 	} else {
-		pos = bsearch.Search(len(v.reuc), func(i int) bsearch.CompareResult {
+		pos = bsearch.Search(len(v.Entries), func(i int) bsearch.CompareResult {
 			pathInList := v.Entries[i].Path
 			if path > pathInList {
 				return bsearch.Smaller
@@ -633,7 +1117,7 @@ func (v *Index) findInEntries(entries []*IndexEntry, path string, stage IndexSta
 			}
 		})
 	} else {
-		return bsearch.Search(len(v.reuc), func(i int) bsearch.CompareResult {
+		return bsearch.Search(len(entries), func(i int) bsearch.CompareResult {
 			pathInList := entries[i].Path
 			if path > pathInList {
 				return bsearch.Smaller
@@ -654,18 +1138,77 @@ func (v *Index) sortAndFindInEntries(path string, stage IndexStage, needLock boo
 func (v *Index) HasConflicts() bool {
 	for _, entry := range v.Entries {
 		if entry.Stage() != 0 {
-			return false
+			return true
 		}
 	}
-	return true
+	return false
 }
 
-// FIXME: this might return an error
+// CleanupConflicts removes all conflict entries. Unlike RemoveConflict,
+// resolve undo information is not recorded.
 func (v *Index) CleanupConflicts() {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	entries := make([]*IndexEntry, 0, len(v.Entries))
+	for _, entry := range v.Entries {
+		if entry.Stage() == 0 {
+			entries = append(entries, entry)
+		} else {
+			v.tree.invalidatePath(entry.Path)
+			v.untracked.invalidatePath(entry.Path)
+			if v.readers > 0 {
+				v.deleted = append(v.deleted, entry)
+			}
+		}
+	}
+	v.Entries = entries
 }
 
-// todo: not implemented yet
+// AddConflict adds the entries as stage 1 (ancestor), 2 (our) and 3
+// (their) of the path, making copies of them. Nil means the side doesn't
+// have the file. The stage 0 entry of the path is removed.
 func (v *Index) AddConflict(ancestor *IndexEntry, our *IndexEntry, their *IndexEntry) error {
+	entries := []*IndexEntry{ancestor, our, their}
+	path := ""
+	for _, entry := range entries {
+		if entry == nil {
+			continue
+		}
+		if path == "" {
+			path = entry.Path
+		} else if entry.Path != path {
+			return errors.New("Index.AddConflict(): entries should have the same path")
+		}
+		if !validFilemode(entry.Mode) || entry.Mode == FilemodeTree {
+			return errors.New("invalid filemode")
+		}
+		if entry.Id == nil {
+			return errors.New("Index.AddConflict(): entry should have an object id")
+		}
+	}
+	if path == "" {
+		return errors.New("Index.AddConflict(): at least one entry is required")
+	}
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if pos := v.sortAndFindInEntries(path, 0, false); pos != -1 {
+		v.removeEntry(pos)
+	}
+	for i, entry := range entries {
+		stage := IndexStage(i + 1)
+		if entry == nil {
+			// the side might exist already
+			if pos := v.sortAndFindInEntries(path, stage, false); pos != -1 {
+				v.removeEntry(pos)
+			}
+			continue
+		}
+		copied := *entry
+		copied.SetStage(stage)
+		v.insertEntry(&copied)
+	}
 	return nil
 }
 
@@ -687,9 +1230,34 @@ func (v *Index) GetConflict(path string) (IndexConflict, error) {
 	return conflict, nil
 }
 
-// todo: not implemented yet
+// RemoveConflict removes all conflict entries (stage 1-3) of the path and
+// records them as resolve undo information (REUC extension) like git
+// does when the conflict is resolved.
 func (v *Index) RemoveConflict(path string) error {
-	return nil
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	var modes [3]Filemode
+	var oids [3]*Oid
+	found := false
+	for stage := StageAncestor; stage <= StageTheirs; stage++ {
+		pos := v.sortAndFindInEntries(path, stage, false)
+		if pos == -1 {
+			continue
+		}
+		entry := v.Entries[pos]
+		modes[stage-1] = entry.Mode
+		oids[stage-1] = entry.Id
+		path = entry.Path
+		found = true
+		if err := v.removeEntry(pos); err != nil {
+			return err
+		}
+	}
+	if !found {
+		return MakeGitError(fmt.Sprintf("Index does not contain conflicts of %s", path), ErrNotFound)
+	}
+	return reucAdd(v, path, modes[0], modes[1], modes[2], oids[0], oids[1], oids[2])
 }
 
 func (v IndexEntry) Stage() IndexStage {
@@ -704,6 +1272,55 @@ func (v IndexEntry) IsConflict() bool {
 	return v.Stage() != 0
 }
 
+// IsAssumeValid returns true if the file is assumed to be unchanged
+// without checking the working directory ("git update-index
+// --assume-unchanged"). Deleted files are still detected.
+func (v IndexEntry) IsAssumeValid() bool {
+	return v.flags&uint16(IndexEntryValid) != 0
+}
+
+// SetAssumeValid sets or clears the assume valid flag. Add the entry to
+// update the index.
+func (v *IndexEntry) SetAssumeValid(enable bool) {
+	if enable {
+		v.flags |= uint16(IndexEntryValid)
+	} else {
+		v.flags &^= uint16(IndexEntryValid)
+	}
+}
+
+// IsSkipWorktree returns true if the file is out of the sparse checkout.
+// The file in the working directory is neither compared nor removed.
+func (v IndexEntry) IsSkipWorktree() bool {
+	return v.flagsExtended&uint16(IndexEntrySkipWorkTree) != 0
+}
+
+// SetSkipWorktree sets or clears the skip worktree flag. Add the entry to
+// update the index.
+func (v *IndexEntry) SetSkipWorktree(enable bool) {
+	v.setExtendedFlag(IndexEntrySkipWorkTree, enable)
+}
+
+// IsIntentToAdd returns true if the entry was added by "git add -N". The
+// entry is never up to date and it is not written into trees.
+func (v IndexEntry) IsIntentToAdd() bool {
+	return v.flagsExtended&uint16(IndexEntryIntentToAdd) != 0
+}
+
+// SetIntentToAdd sets or clears the intent to add flag. Add the entry to
+// update the index.
+func (v *IndexEntry) SetIntentToAdd(enable bool) {
+	v.setExtendedFlag(IndexEntryIntentToAdd, enable)
+}
+
+func (v *IndexEntry) setExtendedFlag(flag IndexEntryExtendedFlag, enable bool) {
+	if enable {
+		v.flagsExtended |= uint16(flag)
+	} else {
+		v.flagsExtended &^= uint16(flag)
+	}
+}
+
 type IndexConflictIterator struct {
 	index  *Index
 	cursor int
@@ -779,6 +1396,14 @@ func (a indexEntriesCaseSensitive) Less(i, j int) bool {
 	}
 }
 
+// indexEntryLess compares the entries in the order of the sorted entries.
+func indexEntryLess(e1, e2 *IndexEntry, ignoreCase bool) bool {
+	if ignoreCase {
+		return indexEntriesCaseInSensitive{e1, e2}.Less(0, 1)
+	}
+	return indexEntriesCaseSensitive{e1, e2}.Less(0, 1)
+}
+
 type indexEntriesCaseInSensitive []*IndexEntry
 
 func (a indexEntriesCaseInSensitive) Len() int {
@@ -841,6 +1466,7 @@ func (v *Index) sortEntriesIfNeeded(ignoreCase, lock bool) {
 		var entries indexEntriesCaseSensitive = v.Entries
 		sort.Sort(entries)
 	}
+	v.entriesSorted = true
 }
 
 func (v *Index) sortReuc(ignoreCase bool) {
@@ -872,7 +1498,7 @@ func (a indexNameEntries) Less(i, j int) bool {
 	return e1.ours < e2.ours
 }
 
-func readEntry(buffer []byte, offset int) (int, *IndexEntry) {
+func readEntry(buffer []byte, offset int, version uint32, previousPath string) (int, *IndexEntry) {
 	bound := len(buffer) - IndexFooterSize
 	if offset+IndexMinimumEntrySize > bound {
 		return offset, nil
@@ -880,8 +1506,8 @@ func readEntry(buffer []byte, offset int) (int, *IndexEntry) {
 	entry := &IndexEntry{
 		Ctime: time.Unix(int64(ntohlFromBytes(buffer, offset)), int64(ntohlFromBytes(buffer, offset+4))),
 		Mtime: time.Unix(int64(ntohlFromBytes(buffer, offset+8)), int64(ntohlFromBytes(buffer, offset+12))),
-		//Dev: ntohlFromBytes(buffer, offset+16),
-		//Ino: ntohlFromBytes(buffer, offset+20),
+		Dev:   ntohlFromBytes(buffer, offset+16),
+		Ino:   ntohlFromBytes(buffer, offset+20),
 		Mode:  Filemode(ntohlFromBytes(buffer, offset+24)),
 		Uid:   ntohlFromBytes(buffer, offset+28),
 		Gid:   ntohlFromBytes(buffer, offset+32),
@@ -891,11 +1517,37 @@ func readEntry(buffer []byte, offset int) (int, *IndexEntry) {
 	}
 	var pathStart int
 	if entry.flags&IndexEntryExtended != 0 {
+		if version < IndexVersionNumberExt || offset+64 > bound {
+			return offset, nil
+		}
 		entry.flagsExtended = ntohsFromBytes(buffer, offset+62)
 		pathStart = offset + 64
 	} else {
 		pathStart = offset + 62
 	}
+	if version >= IndexVersionNumberV4 {
+		// the path is compressed: the number of bytes to remove from the
+		// previous path and the NUL terminated suffix. No padding follows.
+		// the first entry of a block of the offset table ignores it
+		strip, suffixStart, ok := getVarint(buffer[:bound], pathStart)
+		if !ok {
+			return offset, nil
+		}
+		prefix := ""
+		if previousPath != "" {
+			if strip > uint64(len(previousPath)) {
+				return offset, nil
+			}
+			prefix = previousPath[:len(previousPath)-int(strip)]
+		}
+		suffixEnd := bytes.IndexByte(buffer[suffixStart:bound], 0)
+		if suffixEnd == -1 {
+			return offset, nil
+		}
+		suffixEnd += suffixStart
+		entry.Path = prefix + string(buffer[suffixStart:suffixEnd])
+		return suffixEnd + 1, entry
+	}
 	pathLength := int(entry.flags & uint16(IndexEntryNameMask))
 	if pathLength == int(IndexEntryNameMask) {
 		pathEnd := pathStart
@@ -905,12 +1557,16 @@ func readEntry(buffer []byte, offset int) (int, *IndexEntry) {
 				found = true
 				break
 			}
+			pathEnd++
 		}
 		if !found {
 			return offset, nil
 		}
 		pathLength = pathEnd - pathStart
 	}
+	if pathStart+pathLength > bound {
+		return offset, nil
+	}
 	entry.Path = string(buffer[pathStart : pathStart+pathLength])
 	offset = ((pathStart + pathLength + 8 - offset) & ^7) + offset
 	return offset, entry
@@ -1007,16 +1663,207 @@ func readExtension(index *Index, buffer []byte, offset int) int {
 			if err != nil {
 				return 0
 			}
+		} else if bytes.Equal(sig, IndexExtUntrackedSig) {
+			// the broken cache is dropped like git
+			index.untracked, _ = readUntrackedCache(buffer[offset+8 : offset+totalSize])
+		}
+	} else if bytes.Equal(buffer[offset:offset+4], IndexExtLinkSig) {
+		split, err := readLink(buffer[offset+8 : offset+totalSize])
+		if err != nil {
+			return 0
 		}
+		index.split = split
 	} else {
 		return 0
 	}
 	return totalSize
 }
 
+// serialize returns the content of the index file. Entries are always
+// sorted case sensitively on disk. A split index has only the differences
+// from the shared index.
+func (v *Index) serialize() ([]byte, error) {
+	entries, err := v.sortedEntries()
+	if err != nil {
+		return nil, err
+	}
+	var link []byte
+	if v.split != nil && v.split.baseId != nil {
+		entries, link = v.split.delta(entries)
+	}
+	version := v.versionFor(entries)
+	buffer, blocks := serializeEntries(entries, version, v.offsetTableBlocks(len(entries)))
+	extensionOffset := buffer.Len()
+	if len(blocks) > 1 {
+		writeExtension(buffer, IndexExtOffsetTableSig, writeOffsetTable(blocks))
+	}
+	if link != nil {
+		writeExtension(buffer, IndexExtLinkSig, link)
+	}
+	if v.tree != nil {
+		var tree bytes.Buffer
+		v.tree.write(&tree)
+		writeExtension(buffer, IndexExtTreeCacheSig, tree.Bytes())
+	}
+	if len(v.names) > 0 {
+		writeExtension(buffer, IndexExtConflictNameSig, writeConflictNames(v.names))
+	}
+	if len(v.reuc) > 0 {
+		writeExtension(buffer, IndexExtUnmergedSig, writeReuc(v.reuc))
+	}
+	if v.untracked != nil {
+		writeExtension(buffer, IndexExtUntrackedSig, v.untracked.write())
+	}
+	if v.recordEndOfIndex {
+		writeExtension(buffer, IndexExtEndOfIndexSig, endOfIndexEntries(buffer.Bytes(), extensionOffset))
+	}
+	buffer.Write(calcHash(buffer.Bytes())[:])
+	v.version = version
+	return buffer.Bytes(), nil
+}
+
+func (v *Index) sortedEntries() ([]*IndexEntry, error) {
+	var entries indexEntriesCaseSensitive = append([]*IndexEntry{}, v.Entries...)
+	sort.Sort(entries)
+	for _, entry := range entries {
+		if entry.Id == nil {
+			return nil, fmt.Errorf("Failed to write index: entry '%s' doesn't have an object id", entry.Path)
+		}
+	}
+	return entries, nil
+}
+
+// versionFor demotes version 3 to version 2 when the latter suffices like
+// git. version 4 is kept because it is chosen for the path compression.
+func (v *Index) versionFor(entries []*IndexEntry) uint32 {
+	if v.version == IndexVersionNumberV4 {
+		return IndexVersionNumberV4
+	}
+	for _, entry := range entries {
+		if entry.flagsExtended&uint16(IndexEntryExtendedFlags) != 0 {
+			return IndexVersionNumberExt
+		}
+	}
+	return IndexVersionNumber
+}
+
+// serializeEntries returns the header and the entries of the index file.
+// The entries are divided into the blocks for the offset table if
+// blockCount is more than 1.
+func serializeEntries(entries []*IndexEntry, version uint32, blockCount int) (*bytes.Buffer, []indexEntryBlock) {
+	var buffer bytes.Buffer
+	binary.Write(&buffer, binary.BigEndian, []uint32{IndexHeaderSig, version, uint32(len(entries))})
+	var blocks []indexEntryBlock
+	blockSize := len(entries) + 1
+	if blockCount > 1 {
+		blockSize = (len(entries) + blockCount - 1) / blockCount
+	}
+	previousPath := ""
+	for i, entry := range entries {
+		if i%blockSize == 0 {
+			if blockCount > 1 {
+				blocks = append(blocks, indexEntryBlock{offset: buffer.Len()})
+			}
+			// nothing is common with the previous path like git
+			if previousPath != "" {
+				previousPath = "\x00" + previousPath[1:]
+			}
+		}
+		if blocks != nil {
+			blocks[len(blocks)-1].count++
+		}
+		writeEntry(&buffer, entry, version, previousPath)
+		previousPath = entry.Path
+	}
+	return &buffer, blocks
+}
+
+func writeEntry(buffer *bytes.Buffer, entry *IndexEntry, version uint32, previousPath string) {
+	start := buffer.Len()
+	ctimeSec, ctimeNsec := indexTime(entry.Ctime)
+	mtimeSec, mtimeNsec := indexTime(entry.Mtime)
+	binary.Write(buffer, binary.BigEndian, []uint32{
+		ctimeSec, ctimeNsec, mtimeSec, mtimeNsec,
+		entry.Dev, entry.Ino, uint32(entry.Mode), entry.Uid, entry.Gid, entry.Size,
+	})
+	buffer.Write(entry.Id[:])
+
+	pathLength := IndexEntryFlag(len(entry.Path))
+	if pathLength > IndexEntryNameMask {
+		pathLength = IndexEntryNameMask
+	}
+	flags := entry.flags&^(IndexEntryExtended|uint16(IndexEntryNameMask)) | uint16(pathLength)
+	flagsExtended := entry.flagsExtended & uint16(IndexEntryExtendedFlags)
+	if flagsExtended != 0 {
+		binary.Write(buffer, binary.BigEndian, []uint16{flags | IndexEntryExtended, flagsExtended})
+	} else {
+		binary.Write(buffer, binary.BigEndian, flags)
+	}
+	if version >= IndexVersionNumberV4 {
+		// strip count from the previous path and the rest of the path
+		common := 0
+		for common < len(previousPath) && common < len(entry.Path) && previousPath[common] == entry.Path[common] {
+			common++
+		}
+		buffer.Write(putVarint(nil, uint64(len(previousPath)-common)))
+		buffer.WriteString(entry.Path[common:])
+		buffer.WriteByte(0)
+		return
+	}
+	buffer.WriteString(entry.Path)
+	// 1-8 NULs to make the entry size a multiple of 8
+	length := buffer.Len() - start
+	buffer.Write(make([]byte, ((length+8)&^7)-length))
+}
+
+func indexTime(t time.Time) (uint32, uint32) {
+	if t.IsZero() {
+		return 0, 0
+	}
+	return uint32(t.Unix()), uint32(t.Nanosecond())
+}
+
+func writeReuc(reuc []*IndexReucEntry) []byte {
+	var entries reucEntriesCaseSensitive = append([]*IndexReucEntry{}, reuc...)
+	sort.Sort(entries)
+	var buffer bytes.Buffer
+	for _, entry := range entries {
+		buffer.WriteString(entry.path)
+		buffer.WriteByte(0)
+		for _, mode := range entry.mode {
+			fmt.Fprintf(&buffer, "%o", mode)
+			buffer.WriteByte(0)
+		}
+		for i, oid := range entry.oid {
+			if entry.mode[i] != 0 {
+				buffer.Write(oid[:])
+			}
+		}
+	}
+	return buffer.Bytes()
+}
+
+func writeConflictNames(names []*IndexNameEntry) []byte {
+	var buffer bytes.Buffer
+	for _, name := range names {
+		for _, path := range []string{name.ancestor, name.ours, name.theirs} {
+			buffer.WriteString(path)
+			buffer.WriteByte(0)
+		}
+	}
+	return buffer.Bytes()
+}
+
+func writeExtension(buffer *bytes.Buffer, signature, data []byte) {
+	buffer.Write(signature)
+	binary.Write(buffer, binary.BigEndian, uint32(len(data)))
+	buffer.Write(data)
+}
+
 func (v *Index) removeEntry(pos int) error {
 	entry := v.Entries[pos]
 	v.tree.invalidatePath(entry.Path)
+	v.untracked.invalidatePath(entry.Path)
 	v.Entries = append(v.Entries[:pos], v.Entries[pos+1:]...)
 	if v.readers > 0 {
 		v.deleted = append(v.deleted, entry)
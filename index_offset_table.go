@@ -0,0 +1,301 @@
+package git4go
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+)
+
+const (
+	IndexOffsetTableVersion = 1
+
+	// the minimum number of entries which is worth a goroutine like
+	// git's THREAD_COST
+	indexThreadCost = 10000
+
+	// "EOIE" extension: the signature, the size, the offset of the
+	// extensions and the hash of the extension headers
+	indexEndOfIndexSize = 8 + 4 + GitOidRawSize
+)
+
+// indexEntryBlock is an entry of "IEOT" extension. Version 4 indexes
+// don't compress the path of the first entry of each block, so blocks
+// can be read independently.
+type indexEntryBlock struct {
+	offset int
+	count  int
+}
+
+// SetThreads sets the number of goroutines used to read the entries like
+// index.threads. 0 decides it from the number of entries and CPUs, and 1
+// disables parallel loading.
+func (v *Index) SetThreads(threads int) error {
+	if threads < 0 {
+		return MakeGitError(fmt.Sprintf("Invalid number of index threads: %d", threads), ErrInvalidSpec)
+	}
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	v.threads = threads
+	return nil
+}
+
+// SetRecordEndOfIndexEntries enables writing "EOIE" extension which
+// tells the offset of the extensions to readers.
+func (v *Index) SetRecordEndOfIndexEntries(enable bool) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	v.recordEndOfIndex = enable
+}
+
+// SetRecordOffsetTable enables writing "IEOT" extension which has the
+// offsets of blocks of entries to read them in parallel. It is used only
+// with "EOIE" extension.
+func (v *Index) SetRecordOffsetTable(enable bool) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	v.recordOffsetTable = enable
+}
+
+// applyThreadsConfig reads index.threads, index.recordEndOfIndexEntries
+// and index.recordOffsetTable. Both extensions are written by default
+// if the threads are configured explicitly like git.
+func (v *Index) applyThreadsConfig(config *Config) {
+	threadsConfigured := true
+	if threads, err := config.LookupInt32("index.threads"); err == nil && threads >= 0 {
+		v.threads = int(threads)
+	} else if enable, err := config.LookupBool("index.threads"); err == nil {
+		if enable {
+			v.threads = 0
+		} else {
+			v.threads = 1
+		}
+	} else {
+		threadsConfigured = false
+	}
+	defaultRecord := threadsConfigured && v.threads != 1
+	v.recordEndOfIndex = defaultRecord
+	if record, ok := lookupConfigBool(config, "index.recordEndOfIndexEntries", "index.recordendofindexentries"); ok {
+		v.recordEndOfIndex = record
+	}
+	v.recordOffsetTable = defaultRecord
+	if record, ok := lookupConfigBool(config, "index.recordOffsetTable", "index.recordoffsettable"); ok {
+		v.recordOffsetTable = record
+	}
+}
+
+// readerThreads returns the number of goroutines to read the entries.
+func (v *Index) readerThreads(entryCount int) int {
+	threads := v.threads
+	if threads == 0 {
+		threads = entryCount / indexThreadCost
+		if cpus := runtime.NumCPU(); threads > cpus {
+			threads = cpus
+		}
+	}
+	return threads
+}
+
+// offsetTableBlocks returns the number of blocks in "IEOT" extension. The
+// table is not written if it is less than 2, or without "EOIE" extension
+// like git because readers find the table through it.
+func (v *Index) offsetTableBlocks(entryCount int) int {
+	if !v.recordOffsetTable || !v.recordEndOfIndex {
+		return 0
+	}
+	blocks := v.threads
+	if blocks == 0 {
+		blocks = entryCount / indexThreadCost
+		if cpus := runtime.NumCPU(); blocks > cpus-1 {
+			blocks = cpus - 1
+		}
+	}
+	if blocks > entryCount {
+		blocks = entryCount
+	}
+	return blocks
+}
+
+// readEndOfIndexEntries returns the offset of the extensions stored in
+// "EOIE" extension. It returns 0 if the index doesn't have it or the hash
+// of the extension headers doesn't match.
+func readEndOfIndexEntries(buffer []byte) int {
+	start := len(buffer) - IndexFooterSize - indexEndOfIndexSize
+	if start < IndexHeaderSize ||
+		!bytes.Equal(buffer[start:start+4], IndexExtEndOfIndexSig) ||
+		ntohlFromBytes(buffer, start+4) != indexEndOfIndexSize-8 {
+		return 0
+	}
+	offset := int(ntohlFromBytes(buffer, start+8))
+	if offset < IndexHeaderSize || offset > start {
+		return 0
+	}
+	h := sha1.New()
+	pos := offset
+	for pos < start {
+		if pos+8 > start {
+			return 0
+		}
+		h.Write(buffer[pos : pos+8])
+		pos += 8 + int(ntohlFromBytes(buffer, pos+4))
+	}
+	if pos != start || !bytes.Equal(h.Sum(nil), buffer[start+12:start+indexEndOfIndexSize]) {
+		return 0
+	}
+	return offset
+}
+
+// endOfIndexEntries returns the content of "EOIE" extension. The buffer
+// should have the extensions from the offset.
+func endOfIndexEntries(buffer []byte, offset int) []byte {
+	h := sha1.New()
+	for pos := offset; pos < len(buffer); pos += 8 + int(ntohlFromBytes(buffer, pos+4)) {
+		h.Write(buffer[pos : pos+8])
+	}
+	var result bytes.Buffer
+	binary.Write(&result, binary.BigEndian, uint32(offset))
+	result.Write(h.Sum(nil))
+	return result.Bytes()
+}
+
+// readOffsetTable returns the blocks in "IEOT" extension at the offset.
+// It returns nil if the extension doesn't exist or is broken.
+func readOffsetTable(buffer []byte, offset int) []indexEntryBlock {
+	if offset+12 > len(buffer)-IndexFooterSize || !bytes.Equal(buffer[offset:offset+4], IndexExtOffsetTableSig) {
+		return nil
+	}
+	size := int(ntohlFromBytes(buffer, offset+4))
+	if size < 4 || (size-4)%8 != 0 || offset+8+size > len(buffer)-IndexFooterSize ||
+		ntohlFromBytes(buffer, offset+8) != IndexOffsetTableVersion {
+		return nil
+	}
+	blocks := make([]indexEntryBlock, (size-4)/8)
+	for i := range blocks {
+		pos := offset + 12 + i*8
+		blocks[i].offset = int(ntohlFromBytes(buffer, pos))
+		blocks[i].count = int(ntohlFromBytes(buffer, pos+4))
+	}
+	return blocks
+}
+
+func writeOffsetTable(blocks []indexEntryBlock) []byte {
+	var buffer bytes.Buffer
+	binary.Write(&buffer, binary.BigEndian, uint32(IndexOffsetTableVersion))
+	for _, block := range blocks {
+		binary.Write(&buffer, binary.BigEndian, []uint32{uint32(block.offset), uint32(block.count)})
+	}
+	return buffer.Bytes()
+}
+
+// offsetTableToLoad returns the blocks of entries and the offset of the
+// extensions if the entries should be read in parallel.
+func (v *Index) offsetTableToLoad(buffer []byte, entryCount int) ([]indexEntryBlock, int) {
+	if v.readerThreads(entryCount) <= 1 {
+		return nil, 0
+	}
+	offset := readEndOfIndexEntries(buffer)
+	if offset == 0 {
+		return nil, 0
+	}
+	blocks := readOffsetTable(buffer, offset)
+	if len(blocks) <= 1 {
+		return nil, 0
+	}
+	return blocks, offset
+}
+
+// parseEntries reads the entries from the head and returns the offset of
+// the extensions.
+func (v *Index) parseEntries(buffer []byte, version uint32, entryCount int) (int, error) {
+	bound := len(buffer) - IndexFooterSize
+	offset := IndexHeaderSize
+	var i int
+	previousPath := ""
+	for i = 0; i < entryCount && offset < bound; i++ {
+		var entry *IndexEntry
+		offset, entry = readEntry(buffer, offset, version, previousPath)
+		if entry == nil {
+			return 0, errors.New("Index.Read(): failed to read index entry")
+		}
+		v.Entries = append(v.Entries, entry)
+		previousPath = entry.Path
+	}
+	if i != entryCount {
+		return 0, errors.New("Index.Read(): header entries changed while parsing")
+	}
+	return offset, nil
+}
+
+// parseEntriesParallel reads the blocks of entries in goroutines. Each
+// goroutine reads the consecutive blocks. The blocks are checked to be in
+// the entries before the extensions not to allocate too many entries.
+func (v *Index) parseEntriesParallel(buffer []byte, version uint32, entryCount int, blocks []indexEntryBlock, extensionOffset int) (int, error) {
+	total := 0
+	for _, block := range blocks {
+		if block.offset < IndexHeaderSize || block.offset >= extensionOffset ||
+			block.count > (extensionOffset-block.offset)/IndexMinimumEntrySize {
+			return 0, errors.New("Index.Read(): offset table doesn't match the entries")
+		}
+		total += block.count
+	}
+	if total != entryCount || blocks[0].offset != IndexHeaderSize {
+		return 0, errors.New("Index.Read(): offset table doesn't match the entries")
+	}
+	threads := v.readerThreads(entryCount)
+	if threads > len(blocks) {
+		threads = len(blocks)
+	}
+	perThread := (len(blocks) + threads - 1) / threads
+	entries := make([][]*IndexEntry, len(blocks))
+	ends := make([]int, len(blocks))
+	succeeded := make([]bool, len(blocks))
+	var wg sync.WaitGroup
+	for start := 0; start < len(blocks); start += perThread {
+		end := start + perThread
+		if end > len(blocks) {
+			end = len(blocks)
+		}
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+			for i := start; i < end; i++ {
+				entries[i], ends[i], succeeded[i] = readEntryBlock(buffer, version, blocks[i])
+				if !succeeded[i] {
+					return
+				}
+			}
+		}(start, end)
+	}
+	wg.Wait()
+
+	v.Entries = make([]*IndexEntry, 0, entryCount)
+	for i := range blocks {
+		if !succeeded[i] || i+1 < len(blocks) && ends[i] != blocks[i+1].offset {
+			return 0, errors.New("Index.Read(): failed to read index entry")
+		}
+		v.Entries = append(v.Entries, entries[i]...)
+	}
+	return ends[len(ends)-1], nil
+}
+
+// readEntryBlock returns the entries in the block and the offset next to
+// them. ok is false if one of the entries is broken.
+func readEntryBlock(buffer []byte, version uint32, block indexEntryBlock) (entries []*IndexEntry, offset int, ok bool) {
+	entries = make([]*IndexEntry, block.count)
+	offset = block.offset
+	previousPath := ""
+	for i := range entries {
+		offset, entries[i] = readEntry(buffer, offset, version, previousPath)
+		if entries[i] == nil {
+			return nil, 0, false
+		}
+		previousPath = entries[i].Path
+	}
+	return entries, offset, true
+}
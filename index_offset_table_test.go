@@ -0,0 +1,177 @@
+package git4go
+
+import (
+	"./testutil"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_IndexOffsetTable_WriteAndRead(t *testing.T) {
+	testutil.PrepareEmptyWorkDir("test-index")
+	defer testutil.CleanupEmptyWorkDir()
+
+	original, _ := OpenIndex("test_resources/big.index")
+	content, _ := ioutil.ReadFile("test_resources/big.index")
+	for _, version := range []uint{2, 4} {
+		path := filepath.Join("test-index", fmt.Sprintf("big-v%d.index", version))
+		ioutil.WriteFile(path, content, 0666)
+		index, _ := OpenIndex(path)
+		index.SetVersion(version)
+		index.SetThreads(4)
+		index.SetRecordEndOfIndexEntries(true)
+		index.SetRecordOffsetTable(true)
+		if err := index.Write(); err != nil {
+			t.Error("err should be nil:", err)
+			continue
+		}
+
+		// git writes the same blocks with index.threads=4
+		buffer, _ := ioutil.ReadFile(path)
+		blocks, _ := index.offsetTableToLoad(buffer, len(original.Entries))
+		if len(blocks) != 4 || blocks[0].count != 879 || blocks[3].count != 877 {
+			t.Error("offset table is wrong:", version, blocks)
+		}
+
+		if err := index.Read(true); err != nil {
+			t.Error("err should be nil:", err)
+			continue
+		}
+		if len(index.Entries) != len(original.Entries) {
+			t.Error("entries should be read:", version, len(index.Entries))
+			continue
+		}
+		for i, entry := range index.Entries {
+			if entry.Path != original.Entries[i].Path || !sameIndexEntry(entry, original.Entries[i]) {
+				t.Error("entry should be same:", version, i, entry.Path, original.Entries[i].Path)
+				break
+			}
+		}
+	}
+}
+
+func Test_IndexOffsetTable_WithoutEndOfIndex(t *testing.T) {
+	testutil.PrepareEmptyWorkDir("test-index")
+	defer testutil.CleanupEmptyWorkDir()
+
+	content, _ := ioutil.ReadFile("test_resources/big.index")
+	path := filepath.Join("test-index", "big.index")
+	ioutil.WriteFile(path, content, 0666)
+	index, _ := OpenIndex(path)
+	index.SetThreads(4)
+	index.SetRecordEndOfIndexEntries(false)
+	index.SetRecordOffsetTable(true)
+	if err := index.Write(); err != nil {
+		t.Error("err should be nil:", err)
+	}
+	buffer, _ := ioutil.ReadFile(path)
+	if bytes.Contains(buffer, IndexExtOffsetTableSig) || bytes.Contains(buffer, IndexExtEndOfIndexSig) {
+		t.Error("offset table should not be written without EOIE extension")
+	}
+}
+
+func Test_IndexOffsetTable_BrokenEndOfIndex(t *testing.T) {
+	testutil.PrepareEmptyWorkDir("test-index")
+	defer testutil.CleanupEmptyWorkDir()
+
+	content, _ := ioutil.ReadFile("test_resources/big.index")
+	path := filepath.Join("test-index", "big.index")
+	ioutil.WriteFile(path, content, 0666)
+	index, _ := OpenIndex(path)
+	index.SetThreads(4)
+	index.SetRecordEndOfIndexEntries(true)
+	index.SetRecordOffsetTable(true)
+	index.Write()
+
+	// break the hash of the extension headers and fix the checksum
+	buffer, _ := ioutil.ReadFile(path)
+	eoie := len(buffer) - IndexFooterSize - indexEndOfIndexSize
+	buffer[eoie+12] ^= 0xff
+	copy(buffer[len(buffer)-IndexFooterSize:], calcHash(buffer[:len(buffer)-IndexFooterSize])[:])
+	if readEndOfIndexEntries(buffer) != 0 {
+		t.Error("broken extension should be ignored")
+	}
+	ioutil.WriteFile(path, buffer, 0666)
+	if err := index.Read(true); err != nil {
+		t.Error("err should be nil:", err)
+	}
+	if len(index.Entries) != 3514 {
+		t.Error("entries should be read sequentially:", len(index.Entries))
+	}
+}
+
+func Test_IndexOffsetTable_BrokenBlocks(t *testing.T) {
+	testutil.PrepareEmptyWorkDir("test-index")
+	defer testutil.CleanupEmptyWorkDir()
+
+	content, _ := ioutil.ReadFile("test_resources/big.index")
+	path := filepath.Join("test-index", "big.index")
+	ioutil.WriteFile(path, content, 0666)
+	index, _ := OpenIndex(path)
+	index.SetThreads(4)
+	index.SetRecordEndOfIndexEntries(true)
+	index.SetRecordOffsetTable(true)
+	index.Write()
+	written, _ := ioutil.ReadFile(path)
+	table := readEndOfIndexEntries(written) + 12
+
+	cases := []struct {
+		pos   int
+		value uint32
+	}{
+		{8, 0xffffffff},          // entry count in the header
+		{table + 12, 0x7fffffff}, // count of the second block
+		{table + 8, 0xfffffff0},  // offset of the second block
+		{table + 8, IndexHeaderSize - 1},
+	}
+	for _, c := range cases {
+		buffer := append([]byte{}, written...)
+		binary.BigEndian.PutUint32(buffer[c.pos:], c.value)
+		copy(buffer[len(buffer)-IndexFooterSize:], calcHash(buffer[:len(buffer)-IndexFooterSize])[:])
+		ioutil.WriteFile(path, buffer, 0666)
+		if err := index.Read(true); err == nil {
+			t.Error("broken index should not be read:", c.pos, c.value)
+		}
+	}
+}
+
+func Test_IndexThreads_FromConfig(t *testing.T) {
+	testutil.PrepareEmptyWorkDir("test_resources/init_repo")
+	defer testutil.CleanupEmptyWorkDir()
+
+	testcases := []struct {
+		config            string
+		threads           int
+		recordEndOfIndex  bool
+		recordOffsetTable bool
+	}{
+		{"", 0, false, false},
+		{"[index]\n\tthreads = 4\n", 4, true, true},
+		{"[index]\n\tthreads = true\n\trecordOffsetTable = false\n", 0, true, false},
+		{"[index]\n\tthreads = false\n\trecordEndOfIndexEntries = true\n", 1, true, false},
+	}
+	for i, c := range testcases {
+		path := filepath.Join("test_resources/init_repo", fmt.Sprintf("work%d", i))
+		repo, err := InitRepository(path, false)
+		if err != nil {
+			t.Error("err should be nil:", err)
+			continue
+		}
+		file, _ := os.OpenFile(filepath.Join(repo.Path(), "config"), os.O_APPEND|os.O_WRONLY, 0644)
+		file.WriteString(c.config)
+		file.Close()
+		repo, _ = OpenRepository(path)
+		index, err := repo.Index()
+		if err != nil {
+			t.Error("err should be nil:", err)
+			continue
+		}
+		if index.threads != c.threads || index.recordEndOfIndex != c.recordEndOfIndex || index.recordOffsetTable != c.recordOffsetTable {
+			t.Error("config is wrong:", index.threads, index.recordEndOfIndex, index.recordOffsetTable, c.config)
+		}
+	}
+}
@@ -0,0 +1,387 @@
+package git4go
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+)
+
+type IndexReadTreeFlag uint
+
+const (
+	IndexReadTreeDefault IndexReadTreeFlag = 0
+	// resolves the removals in the three-way merge like "--aggressive"
+	IndexReadTreeAggressive IndexReadTreeFlag = 1
+	// doesn't check the working directory like "-i"
+	IndexReadTreeIndexOnly IndexReadTreeFlag = 2
+)
+
+// dfConflictEntry stands for a side which has a directory at the path of a
+// file, or a file at the parent directory of it, like git's
+// df_conflict_entry.
+var dfConflictEntry = &IndexEntry{}
+
+// ReadTreeMerge merges the trees into the index like "git read-tree -m".
+//
+// One tree replaces the index, keeping the stat data of the unchanged
+// entries. Two trees (the current and the new head) move the index to the
+// new tree, keeping the local changes which don't conflict with it. Three
+// or more trees are the merge bases, ours and theirs. Trivial merges are
+// resolved and the others are left as conflicts (stage 1, 2 and 3).
+//
+// A nil tree is regarded as an empty tree. The index should not have
+// conflicts. It fails with ErrConflict and the index is left unchanged if
+// a local change would be lost, including the files in the working
+// directory which are not up to date unless IndexReadTreeIndexOnly is
+// given.
+func (v *Index) ReadTreeMerge(trees []*Tree, flags IndexReadTreeFlag) error {
+	if len(trees) == 0 {
+		return errors.New("Index.ReadTreeMerge(): at least one tree is required")
+	}
+	sides := make([]*readTreeSide, len(trees))
+	for i, tree := range trees {
+		side, err := newReadTreeSide(tree)
+		if err != nil {
+			return err
+		}
+		sides[i] = side
+	}
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	paths := make(map[string]bool)
+	current := make(map[string]*IndexEntry)
+	for _, entry := range v.Entries {
+		if entry.Stage() != 0 {
+			return MakeGitError("You need to resolve your current index first", ErrUnmerged)
+		}
+		current[entry.Path] = entry
+		paths[entry.Path] = true
+	}
+	for _, side := range sides {
+		for path := range side.files {
+			paths[path] = true
+		}
+	}
+
+	merger := &treeMerger{
+		index:           v,
+		flags:           flags,
+		initialCheckout: len(v.Entries) == 0 && v.stamp.IsZero(),
+	}
+	sortedPaths := make([]string, 0, len(paths))
+	for path := range paths {
+		sortedPaths = append(sortedPaths, path)
+	}
+	sort.Strings(sortedPaths)
+	stages := make([]*IndexEntry, len(sides))
+	var changedPaths []string
+	for _, path := range sortedPaths {
+		for i, side := range sides {
+			stages[i] = side.lookup(path)
+		}
+		entry := current[path]
+		var err error
+		switch len(sides) {
+		case 1:
+			err = merger.oneWay(entry, stages[0])
+		case 2:
+			err = merger.twoWay(entry, stages[0], stages[1])
+		default:
+			err = merger.threeWay(entry, stages)
+		}
+		if err != nil {
+			return err
+		}
+		if merger.changed {
+			changedPaths = append(changedPaths, path)
+			merger.changed = false
+		}
+	}
+	for _, path := range changedPaths {
+		v.tree.invalidatePath(path)
+		v.untracked.invalidatePath(path)
+	}
+
+	if v.ignoreCase {
+		sort.Sort(indexEntriesCaseInSensitive(merger.result))
+	} else {
+		sort.Sort(indexEntriesCaseSensitive(merger.result))
+	}
+	// the cache of the single tree is valid like "git read-tree -m <tree>"
+	if len(trees) == 1 && trees[0] != nil {
+		cache, err := createTreeCacheFromTree(trees[0])
+		if err != nil {
+			return err
+		}
+		v.tree = cache
+	}
+	v.Entries = merger.result
+	v.entriesSorted = true
+	return nil
+}
+
+// readTreeSide has the files and directories of a tree to merge.
+type readTreeSide struct {
+	files map[string]*IndexEntry
+	dirs  map[string]bool
+}
+
+func newReadTreeSide(tree *Tree) (*readTreeSide, error) {
+	side := &readTreeSide{
+		files: make(map[string]*IndexEntry),
+		dirs:  make(map[string]bool),
+	}
+	if tree == nil {
+		return side, nil
+	}
+	err := tree.Walk(func(root string, treeEntry *TreeEntry) int {
+		// index paths are separated by "/" on all platforms
+		name := path.Join(root, treeEntry.Name)
+		if treeEntry.Type == ObjectTree {
+			side.dirs[name] = true
+		} else {
+			side.files[name] = &IndexEntry{
+				Path: name,
+				Mode: treeEntry.Filemode,
+				Id:   treeEntry.Id,
+			}
+		}
+		return 0
+	})
+	if err != nil {
+		return nil, err
+	}
+	return side, nil
+}
+
+// lookup returns the file at the path. It returns dfConflictEntry if the
+// path or one of its parents has the other type.
+func (s *readTreeSide) lookup(name string) *IndexEntry {
+	if entry, ok := s.files[name]; ok {
+		return entry
+	}
+	if s.dirs[name] {
+		return dfConflictEntry
+	}
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		if _, ok := s.files[dir]; ok {
+			return dfConflictEntry
+		}
+	}
+	return nil
+}
+
+// treeMerger builds the result of ReadTreeMerge path by path. It follows
+// oneway_merge(), twoway_merge() and threeway_merge() of git.
+type treeMerger struct {
+	index           *Index
+	flags           IndexReadTreeFlag
+	initialCheckout bool
+	result          []*IndexEntry
+	changed         bool
+}
+
+// sameEntry returns true if both are missing or both have the same mode
+// and object.
+func sameEntry(a, b *IndexEntry) bool {
+	if a == nil || b == nil || a == dfConflictEntry || b == dfConflictEntry {
+		return a == b
+	}
+	return a.Mode == b.Mode && a.Id.Equal(b.Id)
+}
+
+func (m *treeMerger) oneWay(current, tree *IndexEntry) error {
+	if tree == nil || tree == dfConflictEntry {
+		return m.deleted(current)
+	}
+	return m.merged(tree, current)
+}
+
+func (m *treeMerger) twoWay(current, oldTree, newTree *IndexEntry) error {
+	if oldTree == dfConflictEntry {
+		oldTree = nil
+	}
+	if newTree == dfConflictEntry {
+		newTree = nil
+	}
+	if current != nil {
+		switch {
+		case oldTree == nil && newTree == nil,
+			oldTree == nil && sameEntry(current, newTree),
+			oldTree != nil && sameEntry(oldTree, newTree),
+			oldTree != nil && newTree != nil && sameEntry(current, newTree):
+			m.keep(current)
+			return nil
+		case oldTree != nil && newTree == nil && sameEntry(current, oldTree):
+			return m.deleted(current)
+		case oldTree != nil && newTree != nil && sameEntry(current, oldTree):
+			return m.merged(newTree, current)
+		}
+		return m.reject(current.Path)
+	}
+	if newTree == nil {
+		return nil
+	}
+	if oldTree != nil && !m.initialCheckout {
+		// the removal is staged
+		if sameEntry(oldTree, newTree) {
+			return nil
+		}
+		return m.reject(oldTree.Path)
+	}
+	return m.merged(newTree, nil)
+}
+
+// threeWay merges the path. The numbers in the comments are the cases of
+// "git read-tree" documentation.
+func (m *treeMerger) threeWay(current *IndexEntry, stages []*IndexEntry) error {
+	bases := stages[:len(stages)-2]
+	head := stages[len(stages)-2]
+	remote := stages[len(stages)-1]
+	dfConflictHead := head == dfConflictEntry
+	if dfConflictHead {
+		head = nil
+	}
+	dfConflictRemote := remote == dfConflictEntry
+	if dfConflictRemote {
+		remote = nil
+	}
+	anyBaseMissing := false
+	for _, base := range bases {
+		if base == nil || base == dfConflictEntry {
+			anyBaseMissing = true
+		}
+	}
+	headMatch := false
+	remoteMatch := false
+	if !sameEntry(head, remote) {
+		for _, base := range bases {
+			headMatch = headMatch || sameEntry(base, head)
+			remoteMatch = remoteMatch || sameEntry(base, remote)
+		}
+	}
+
+	// #14, #14ALT, #2ALT: the index may have the result
+	if remote != nil && !dfConflictHead && headMatch && !remoteMatch {
+		if current != nil && !sameEntry(current, remote) && !sameEntry(current, head) {
+			return m.reject(current.Path)
+		}
+		return m.merged(remote, current)
+	}
+	if current != nil && !sameEntry(current, head) {
+		return m.reject(current.Path)
+	}
+	if head != nil {
+		// #5ALT, #15
+		if sameEntry(head, remote) {
+			return m.merged(head, current)
+		}
+		// #13, #3ALT
+		if !dfConflictRemote && remoteMatch && !headMatch {
+			return m.merged(head, current)
+		}
+	}
+	// #1
+	if head == nil && remote == nil && anyBaseMissing {
+		return nil
+	}
+	// removed in both, or removed in one and unchanged in the other
+	if m.flags&IndexReadTreeAggressive != 0 {
+		if head == nil && remote == nil ||
+			head == nil && remoteMatch ||
+			remote == nil && headMatch {
+			return m.deleted(current)
+		}
+	}
+
+	// the conflict should not overwrite the local change
+	if current != nil {
+		if err := m.verifyUpToDate(current); err != nil {
+			return err
+		}
+	}
+	if !headMatch || !remoteMatch {
+		for _, base := range bases {
+			if base != nil && base != dfConflictEntry {
+				m.add(base, StageAncestor)
+				break
+			}
+		}
+	}
+	if head != nil {
+		m.add(head, StageOurs)
+	}
+	if remote != nil {
+		m.add(remote, StageTheirs)
+	}
+	return nil
+}
+
+// merged adds the entry of the tree as the result. The entry in the index
+// is kept if it is same.
+func (m *treeMerger) merged(entry, current *IndexEntry) error {
+	if current != nil {
+		if sameEntry(current, entry) {
+			m.keep(current)
+			return nil
+		}
+		if err := m.verifyUpToDate(current); err != nil {
+			return err
+		}
+	}
+	m.add(entry, 0)
+	return nil
+}
+
+// deleted removes the entry in the index.
+func (m *treeMerger) deleted(current *IndexEntry) error {
+	if current == nil {
+		return nil
+	}
+	m.changed = true
+	return m.verifyUpToDate(current)
+}
+
+func (m *treeMerger) keep(current *IndexEntry) {
+	m.result = append(m.result, current)
+}
+
+func (m *treeMerger) add(entry *IndexEntry, stage IndexStage) {
+	copied := &IndexEntry{
+		Path: entry.Path,
+		Mode: entry.Mode,
+		Id:   entry.Id,
+	}
+	pathLength := IndexEntryFlag(len(entry.Path))
+	if pathLength > IndexEntryNameMask {
+		pathLength = IndexEntryNameMask
+	}
+	copied.flags = uint16(pathLength)
+	copied.SetStage(stage)
+	m.result = append(m.result, copied)
+	m.changed = true
+}
+
+func (m *treeMerger) reject(path string) error {
+	return MakeGitError(fmt.Sprintf("Entry '%s' would be overwritten by merge. Cannot merge.", path), ErrConflict)
+}
+
+// verifyUpToDate checks that the file in the working directory matches
+// the entry which is going to be replaced or removed. Missing files are
+// regarded as up to date like git.
+func (m *treeMerger) verifyUpToDate(current *IndexEntry) error {
+	v := m.index
+	if m.flags&IndexReadTreeIndexOnly != 0 || v.repo == nil || v.repo.IsBare() {
+		return nil
+	}
+	fullPath := filepath.Join(v.repo.Workdir(), current.Path)
+	info, err := os.Lstat(fullPath)
+	if os.IsNotExist(err) || err == nil && v.isUnchanged(current, fullPath, info) {
+		return nil
+	}
+	return MakeGitError(fmt.Sprintf("Entry '%s' not uptodate. Cannot merge.", current.Path), ErrConflict)
+}
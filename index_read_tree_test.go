@@ -0,0 +1,191 @@
+package git4go
+
+import (
+	"./testutil"
+	"io/ioutil"
+	"testing"
+)
+
+// writeTestTree writes the tree of the files. The contents are same as
+// the paths.
+func writeTestTree(repo *Repository, paths ...string) *Tree {
+	index, _ := NewIndex()
+	for _, path := range paths {
+		oid, _ := repo.CreateBlobFromBuffer([]byte(path + "\n"))
+		index.Add(&IndexEntry{Path: path, Mode: FilemodeBlob, Id: oid})
+	}
+	oid, _ := index.WriteTreeTo(repo)
+	tree, _ := repo.LookupTree(oid)
+	return tree
+}
+
+// indexStages returns the stages of the path. 0 means the path doesn't
+// exist.
+func indexStages(index *Index, path string) []IndexStage {
+	var stages []IndexStage
+	for _, entry := range index.Entries {
+		if entry.Path == path {
+			stages = append(stages, entry.Stage())
+		}
+	}
+	return stages
+}
+
+func sameStages(a, b []IndexStage) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func Test_IndexReadTreeMerge_ThreeWay(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/empty_standard_repo")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/empty_standard_repo")
+	base := writeTestTree(repo, "same", "changed_ours", "removed_ours", "removed_theirs", "removed_both", "modified_removed", "df")
+	ours := writeTestTree(repo, "same", "changed_ours", "removed_theirs", "modified_removed", "df/file", "added_ours")
+	theirs := writeTestTree(repo, "same", "changed_ours", "removed_ours", "df")
+	// modify the contents of ours
+	oid, _ := repo.CreateBlobFromBuffer([]byte("modified\n"))
+	builder, _ := repo.TreeBuilder()
+	for _, entry := range ours.Entries {
+		builder.Insert(entry.Name, entry.Id, entry.Filemode)
+	}
+	builder.Insert("changed_ours", oid, FilemodeBlob)
+	builder.Insert("modified_removed", oid, FilemodeBlob)
+	oid, _ = builder.Write()
+	ours, _ = repo.LookupTree(oid)
+
+	testcases := []struct {
+		flags    IndexReadTreeFlag
+		expected map[string][]IndexStage
+	}{
+		{IndexReadTreeDefault, map[string][]IndexStage{
+			"same":             {0},
+			"changed_ours":     {0},
+			"removed_ours":     {1, 3},
+			"removed_theirs":   {1, 2},
+			"removed_both":     {1},
+			"modified_removed": {1, 2},
+			"df":               {1, 3},
+			"df/file":          {2},
+			"added_ours":       {0},
+		}},
+		{IndexReadTreeAggressive, map[string][]IndexStage{
+			"same":             {0},
+			"changed_ours":     {0},
+			"removed_ours":     nil,
+			"removed_theirs":   nil,
+			"removed_both":     nil,
+			"modified_removed": {1, 2},
+			"df":               nil,
+			"df/file":          {2},
+			"added_ours":       {0},
+		}},
+	}
+	for _, c := range testcases {
+		index, _ := NewIndex()
+		index.ReadTree(ours)
+		if err := index.ReadTreeMerge([]*Tree{base, ours, theirs}, c.flags); err != nil {
+			t.Error("err should be nil:", err)
+			continue
+		}
+		for path, expected := range c.expected {
+			if stages := indexStages(index, path); !sameStages(stages, expected) {
+				t.Error("stages are wrong:", c.flags, path, stages, expected)
+			}
+		}
+		if len(index.Entries) == 0 || index.Entries[0].Path != "added_ours" {
+			t.Error("entries should be sorted:", index.Entries)
+		}
+	}
+
+	// conflicts can be iterated
+	index, _ := NewIndex()
+	index.ReadTree(ours)
+	index.ReadTreeMerge([]*Tree{base, ours, theirs}, IndexReadTreeDefault)
+	iter, _ := index.ConflictIterator()
+	conflict, err := iter.Next()
+	if err != nil || conflict.Ancestor == nil || conflict.Our != nil || conflict.Their == nil || conflict.Their.Path != "df" {
+		t.Error("conflict is wrong:", conflict, err)
+	}
+	if _, err := index.WriteTreeTo(repo); !IsErrorCode(err, ErrUnmerged) {
+		t.Error("conflicted index should not be written:", err)
+	}
+	if err := index.ReadTreeMerge([]*Tree{base, ours, theirs}, IndexReadTreeDefault); !IsErrorCode(err, ErrUnmerged) {
+		t.Error("conflicted index should not be merged:", err)
+	}
+}
+
+func Test_IndexReadTreeMerge_TwoWay(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/empty_standard_repo")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/empty_standard_repo")
+	head := writeTestTree(repo, "kept", "removed", "staged", "dir/file")
+	next := writeTestTree(repo, "kept", "staged", "dir/file", "added")
+	index, _ := NewIndex()
+	index.ReadTree(head)
+	// not an initial checkout
+	index.stamp = index.stamp.Add(1)
+	oid, _ := repo.CreateBlobFromBuffer([]byte("local\n"))
+	index.Add(&IndexEntry{Path: "staged", Mode: FilemodeBlob, Id: oid})
+	index.Add(&IndexEntry{Path: "local", Mode: FilemodeBlob, Id: oid})
+	index.RemoveByPath("dir/file")
+
+	if err := index.ReadTreeMerge([]*Tree{head, next}, IndexReadTreeDefault); err != nil {
+		t.Error("err should be nil:", err)
+	}
+	paths := ""
+	for _, entry := range index.Entries {
+		paths += entry.Path + " "
+	}
+	if paths != "added kept local staged " {
+		t.Error("local changes should be kept:", paths)
+	}
+	if entry, _ := index.EntryByPath("staged", 0); entry == nil || !entry.Id.Equal(oid) {
+		t.Error("staged change should be kept:", entry)
+	}
+
+	// the local change conflicts with the next tree
+	other := writeTestTree(repo, "kept", "local")
+	entries := len(index.Entries)
+	if err := index.ReadTreeMerge([]*Tree{next, other}, IndexReadTreeDefault); !IsErrorCode(err, ErrConflict) {
+		t.Error("local change should not be overwritten:", err)
+	}
+	if len(index.Entries) != entries {
+		t.Error("index should not be changed:", len(index.Entries))
+	}
+}
+
+func Test_IndexReadTreeMerge_NotUpToDate(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/empty_standard_repo")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/empty_standard_repo")
+	ioutil.WriteFile("test_resources/empty_standard_repo/file", []byte("file\n"), 0644)
+	index, _ := repo.Index()
+	index.AddByPath("file")
+	tree := writeTestTree(repo, "other")
+
+	ioutil.WriteFile("test_resources/empty_standard_repo/file", []byte("modified\n"), 0644)
+	if err := index.ReadTreeMerge([]*Tree{tree}, IndexReadTreeDefault); !IsErrorCode(err, ErrConflict) {
+		t.Error("modified file should not be lost:", err)
+	}
+	if err := index.ReadTreeMerge([]*Tree{tree}, IndexReadTreeIndexOnly); err != nil {
+		t.Error("err should be nil:", err)
+	}
+	if index.EntryCount() != 1 || index.Entries[0].Path != "other" {
+		t.Error("index should be replaced:", index.Entries)
+	}
+	oid, err := index.WriteTree()
+	if err != nil || !oid.Equal(tree.Id()) {
+		t.Error("tree id is wrong:", oid, err)
+	}
+}
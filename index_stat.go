@@ -0,0 +1,249 @@
+package git4go
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// differences between an entry and a file like git's ie_match_stat()
+const (
+	// timestamps, owner or inode is changed. The content may be same.
+	entryStatChanged = 1 << iota
+	// the size or the content is changed
+	entryDataChanged
+	// the file type or the executable bit is changed
+	entryModeChanged
+)
+
+// the id of the blob without content
+var emptyBlobId, _ = NewOid("e69de29bb2d1d6434b8b29ae775ad8c2e48c5391")
+
+// applyStatConfig reads core.trustctime and core.checkStat. ctime is not
+// compared if it is not trusted, and only mtime in seconds and the size
+// are compared if core.checkStat is "minimal".
+func (v *Index) applyStatConfig(config *Config) {
+	v.trustCtime = true
+	if trustCtime, ok := lookupConfigBool(config, "core.trustctime", "core.trustCtime"); ok {
+		v.trustCtime = trustCtime
+	}
+	v.checkStat = true
+	for _, name := range []string{"core.checkStat", "core.checkstat"} {
+		if value, err := config.LookupString(name); err == nil {
+			v.checkStat = value != "minimal"
+			break
+		}
+	}
+}
+
+// EntryMatchesWorkdir returns true if the file in the working directory
+// has the same content and mode as the entry. The file is hashed only if
+// the stat data doesn't match or the entry is racily clean, i.e. the file
+// was modified at the same time as or after the index file was written.
+func (v *Index) EntryMatchesWorkdir(entry *IndexEntry) (bool, error) {
+	if v.repo == nil {
+		return false, errors.New("Could not compare the entry. Index is not backed up by an existing repository.")
+	}
+	if v.repo.IsBare() {
+		return false, MakeGitError("Could not compare the entry in bare repository", ErrBareRepository)
+	}
+	fullPath := filepath.Join(v.repo.Workdir(), entry.Path)
+	info, err := os.Lstat(fullPath)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	return v.isUnchanged(entry, fullPath, info), nil
+}
+
+// isUnchanged compares the entry with the file like git's ce_modified().
+// The content is hashed only if the stat data doesn't tell the result.
+func (v *Index) isUnchanged(entry *IndexEntry, fullPath string, info os.FileInfo) bool {
+	changed := v.matchStat(entry, fullPath, info)
+	if changed == 0 {
+		return true
+	}
+	if changed&entryModeChanged != 0 {
+		return false
+	}
+	// a smudged entry has size 0 and it needs the content to compare
+	if changed&entryDataChanged != 0 && (entry.Mode == FilemodeCommit || entry.Size != 0) {
+		return false
+	}
+	return v.matchContent(entry, fullPath, info) == 0
+}
+
+// matchStat returns the differences between the entry and the file. Skip
+// worktree and assume valid entries are always unchanged and intent to add
+// entries are always changed. Racily clean entries are hashed.
+func (v *Index) matchStat(entry *IndexEntry, fullPath string, info os.FileInfo) int {
+	if entry.IsSkipWorktree() || entry.IsAssumeValid() {
+		return 0
+	}
+	if entry.IsIntentToAdd() {
+		return entryDataChanged | entryModeChanged
+	}
+	changed := v.matchStatBasic(entry, fullPath, info)
+	if changed == 0 && v.isRacy(entry) {
+		changed = v.matchContent(entry, fullPath, info)
+	}
+	return changed
+}
+
+// matchStatBasic compares the mode and the stat data. The executable bit
+// and symbolic links are not compared if the file system doesn't support
+// them (IndexCapNoFilemode and IndexCapNoSimlinks).
+func (v *Index) matchStatBasic(entry *IndexEntry, fullPath string, info os.FileInfo) int {
+	changed := 0
+	mode := info.Mode()
+	switch entry.Mode {
+	case FilemodeBlob, FilemodeBlobExecutable:
+		if !mode.IsRegular() {
+			changed |= entryModeChanged
+		} else if !v.distrustFilemode && (uint32(entry.Mode)^uint32(mode.Perm()))&0100 != 0 {
+			changed |= entryModeChanged
+		}
+	case FilemodeLink:
+		if mode&os.ModeSymlink == 0 && (!v.noSymlinks || !mode.IsRegular()) {
+			changed |= entryModeChanged
+		}
+	case FilemodeCommit:
+		// most of stat data is meaningless for submodules
+		if !mode.IsDir() {
+			return entryModeChanged
+		}
+		if !gitlinkMatches(entry, fullPath) {
+			return entryDataChanged
+		}
+		return 0
+	}
+	changed |= v.matchStatData(newEntryStatData(entry), newStatData(info))
+	// racily smudged entry
+	if entry.Size == 0 && !entry.Id.Equal(emptyBlobId) {
+		changed |= entryDataChanged
+	}
+	return changed
+}
+
+// matchStatData compares the stat data like git's match_stat_data(). The
+// device is not compared like git because it is not stable on network file
+// systems.
+func (v *Index) matchStatData(entry, file statData) int {
+	changed := 0
+	if entry.mtimeSec != file.mtimeSec {
+		changed |= entryStatChanged
+	}
+	if v.checkStat {
+		if entry.mtimeNsec != file.mtimeNsec {
+			changed |= entryStatChanged
+		}
+		if v.trustCtime && (entry.ctimeSec != file.ctimeSec || entry.ctimeNsec != file.ctimeNsec) {
+			changed |= entryStatChanged
+		}
+		if entry.uid != file.uid || entry.gid != file.gid || entry.ino != file.ino {
+			changed |= entryStatChanged
+		}
+	}
+	if entry.size != file.size {
+		changed |= entryDataChanged
+	}
+	return changed
+}
+
+func newEntryStatData(entry *IndexEntry) statData {
+	var result statData
+	result.ctimeSec, result.ctimeNsec = indexTime(entry.Ctime)
+	result.mtimeSec, result.mtimeNsec = indexTime(entry.Mtime)
+	result.dev, result.ino, result.uid, result.gid = entry.Dev, entry.Ino, entry.Uid, entry.Gid
+	result.size = entry.Size
+	return result
+}
+
+// isRacy returns true if the entry was modified at the same time as or
+// after the index file was written. The file might be modified again
+// without changing the stat data after it was added.
+func (v *Index) isRacy(entry *IndexEntry) bool {
+	if entry.Mode == FilemodeCommit {
+		return false
+	}
+	return v.isRacyStat(indexTime(entry.Mtime))
+}
+
+func (v *Index) isRacyStat(mtimeSec, mtimeNsec uint32) bool {
+	if v.stamp.IsZero() {
+		return false
+	}
+	stampSec, stampNsec := indexTime(v.stamp)
+	return stampSec < mtimeSec || stampSec == mtimeSec && stampNsec <= mtimeNsec
+}
+
+// matchContent hashes the file and compares it with the entry like git's
+// ce_modified_check_fs().
+func (v *Index) matchContent(entry *IndexEntry, fullPath string, info os.FileInfo) int {
+	mode := info.Mode()
+	var content []byte
+	var err error
+	switch {
+	case mode.IsRegular():
+		content, err = ioutil.ReadFile(fullPath)
+	case mode&os.ModeSymlink != 0:
+		var target string
+		target, err = os.Readlink(fullPath)
+		content = []byte(target)
+	case mode.IsDir() && entry.Mode == FilemodeCommit:
+		if gitlinkMatches(entry, fullPath) {
+			return 0
+		}
+		return entryDataChanged
+	default:
+		return entryModeChanged
+	}
+	if err != nil {
+		return entryDataChanged
+	}
+	if oid, err := hash(content, ObjectBlob); err != nil || !oid.Equal(entry.Id) {
+		return entryDataChanged
+	}
+	return 0
+}
+
+// gitlinkMatches compares HEAD of the submodule with the entry. A
+// directory which is not a repository is regarded as unchanged like git.
+func gitlinkMatches(entry *IndexEntry, fullPath string) bool {
+	repo, err := OpenRepository(fullPath)
+	if err != nil {
+		return true
+	}
+	head, err := referenceLookupResolved(repo, GitHeadFile, -1)
+	if err != nil {
+		return true
+	}
+	return head.Target().Equal(entry.Id)
+}
+
+// smudgeRacilyCleanEntries sets the size of the racily clean entries which
+// are actually modified to 0 like git. Otherwise they would be regarded as
+// unchanged after the new index file hides their racy timestamps.
+func (v *Index) smudgeRacilyCleanEntries() {
+	if v.repo == nil || v.repo.IsBare() {
+		return
+	}
+	for _, entry := range v.Entries {
+		if !v.isRacy(entry) {
+			continue
+		}
+		fullPath := filepath.Join(v.repo.Workdir(), entry.Path)
+		info, err := os.Lstat(fullPath)
+		if err != nil || v.matchStatBasic(entry, fullPath, info) != 0 {
+			continue
+		}
+		if v.matchContent(entry, fullPath, info) != 0 {
+			entry.Size = 0
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package git4go
+
+import (
+	"./testutil"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_IndexEntryMatchesWorkdir(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/status")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/status")
+	index, _ := repo.Index()
+	index.AddByPath("new_file")
+	entry, _ := index.EntryByPath("new_file", 0)
+	if matched, err := index.EntryMatchesWorkdir(entry); !matched || err != nil {
+		t.Error("added file should match:", matched, err)
+	}
+
+	os.Chmod("test_resources/status/new_file", 0755)
+	if matched, _ := index.EntryMatchesWorkdir(entry); matched {
+		t.Error("executable bit should be compared")
+	}
+	index.SetCaps(IndexCapNoFilemode)
+	if matched, _ := index.EntryMatchesWorkdir(entry); !matched {
+		t.Error("executable bit should be ignored with IndexCapNoFilemode")
+	}
+
+	ioutil.WriteFile("test_resources/status/new_file", []byte("modified\n"), 0644)
+	if matched, _ := index.EntryMatchesWorkdir(entry); matched {
+		t.Error("modified file should not match")
+	}
+	os.Remove("test_resources/status/new_file")
+	if matched, err := index.EntryMatchesWorkdir(entry); matched || err != nil {
+		t.Error("deleted file should not match:", matched, err)
+	}
+}
+
+// the content is changed without changing the size and the timestamps
+func changeContentKeepingStat(path string) {
+	info, _ := os.Stat(path)
+	content, _ := ioutil.ReadFile(path)
+	ioutil.WriteFile(path, bytes.ToUpper(content), 0644)
+	os.Chtimes(path, info.ModTime(), info.ModTime())
+}
+
+func Test_IndexEntryMatchesWorkdir_Racy(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/status")
+	defer testutil.CleanupWorkspace()
+
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+	os.Chtimes("test_resources/status/new_file", past, past)
+	os.Chtimes("test_resources/status/modified_file", future, future)
+	os.Chtimes("test_resources/status/current_file", future, future)
+	repo, _ := OpenRepository("test_resources/status")
+	index, _ := repo.Index()
+	index.trustCtime = false
+	for _, path := range []string{"new_file", "modified_file", "current_file"} {
+		index.AddByPath(path)
+	}
+	if err := index.Write(); err != nil {
+		t.Error("err should be nil:", err)
+	}
+	changeContentKeepingStat("test_resources/status/new_file")
+	changeContentKeepingStat("test_resources/status/modified_file")
+
+	// the stat data is trusted if the file is older than the index file
+	entry, _ := index.EntryByPath("new_file", 0)
+	if matched, _ := index.EntryMatchesWorkdir(entry); !matched {
+		t.Error("entry should be regarded as unchanged by the stat data")
+	}
+	// racily clean entries are hashed
+	entry, _ = index.EntryByPath("modified_file", 0)
+	if matched, _ := index.EntryMatchesWorkdir(entry); matched {
+		t.Error("racily clean entry should be hashed")
+	}
+
+	if err := index.Write(); err != nil {
+		t.Error("err should be nil:", err)
+	}
+	written, _ := OpenIndex(index.Path())
+	if entry, _ := written.EntryByPath("modified_file", 0); entry == nil || entry.Size != 0 {
+		t.Error("modified racy entry should be smudged:", entry)
+	}
+	if entry, _ := written.EntryByPath("current_file", 0); entry == nil || entry.Size == 0 {
+		t.Error("unchanged racy entry should not be smudged:", entry)
+	}
+	entry, _ = index.EntryByPath("modified_file", 0)
+	if matched, _ := index.EntryMatchesWorkdir(entry); matched {
+		t.Error("smudged entry should not match")
+	}
+}
@@ -2,6 +2,12 @@ package git4go
 
 import (
 	"./testutil"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -112,3 +118,622 @@ func Test_IndexConflictIterator(t *testing.T) {
 		t.Error("it should be nil")
 	}
 }
+
+func Test_IndexWrite_RoundTrip(t *testing.T) {
+	testutil.PrepareEmptyWorkDir("test-index")
+	defer testutil.CleanupEmptyWorkDir()
+
+	for _, name := range []string{"gitgit.index", "big.index"} {
+		original, _ := ioutil.ReadFile(filepath.Join("test_resources", name))
+		path := filepath.Join("test-index", name)
+		ioutil.WriteFile(path, original, 0666)
+		index, err := OpenIndex(path)
+		if err != nil {
+			t.Error("err should be nil:", name, err)
+			continue
+		}
+		if err := index.Write(); err != nil {
+			t.Error("err should be nil:", name, err)
+			continue
+		}
+		written, _ := ioutil.ReadFile(path)
+		if !bytes.Equal(original, written) {
+			t.Error("written index should be same as the original:", name, len(original), len(written))
+		}
+		if _, err := os.Stat(path + ".lock"); !os.IsNotExist(err) {
+			t.Error("lock file should be removed:", name)
+		}
+	}
+}
+
+func Test_IndexWrite_AddAndRemove(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/mergedrepo")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/mergedrepo")
+	index, _ := repo.Index()
+	ioutil.WriteFile("test_resources/mergedrepo/new.txt", []byte("new file\n"), 0644)
+	if err := index.AddByPath("new.txt"); err != nil {
+		t.Error("err should be nil:", err)
+	}
+	if err := index.AddByPath("one.txt"); err != nil {
+		t.Error("err should be nil:", err)
+	}
+	if err := index.Remove("two.txt", 0); err != nil {
+		t.Error("err should be nil:", err)
+	}
+	if err := index.Write(); err != nil {
+		t.Error("err should be nil:", err)
+	}
+
+	written, err := OpenIndex(index.Path())
+	if err != nil {
+		t.Error("err should be nil:", err)
+		return
+	}
+	if written.EntryCount() != 8 {
+		t.Error("entry count should be 8, but", written.EntryCount())
+	}
+	entry, err := written.EntryByPath("new.txt", 0)
+	if err != nil {
+		t.Error("new entry should be written:", err)
+		return
+	}
+	if entry.Mode != FilemodeBlob || entry.Size != 9 || entry.Id.String() != "fa49b077972391ad58037050f2a75f74e3671e92" {
+		t.Error("new entry is wrong:", entry.Mode, entry.Size, entry.Id)
+	}
+	if _, err := repo.LookupBlob(entry.Id); err != nil {
+		t.Error("blob should be written:", err)
+	}
+	if _, err := written.EntryByPath("two.txt", 0); !IsErrorCode(err, ErrNotFound) {
+		t.Error("removed entry should not be written:", err)
+	}
+	if _, err := written.GetConflict("conflicts-one.txt"); err != nil {
+		t.Error("conflicts should be kept:", err)
+	}
+}
+
+func Test_IndexWrite_Locked(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/mergedrepo")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/mergedrepo")
+	index, _ := repo.Index()
+	lockPath := index.Path() + ".lock"
+	ioutil.WriteFile(lockPath, []byte{}, 0666)
+	if err := index.Write(); !IsErrorCode(err, ErrLocked) {
+		t.Error("locked index should not be written:", err)
+	}
+	os.Remove(lockPath)
+
+	memory, _ := NewIndex()
+	if err := memory.Write(); err == nil {
+		t.Error("in-memory index should not be written")
+	}
+}
+
+func Test_IndexWrite_Version4(t *testing.T) {
+	testutil.PrepareEmptyWorkDir("test-index")
+	defer testutil.CleanupEmptyWorkDir()
+
+	// gitgit-v4.index was converted from gitgit.index by
+	// "git update-index --index-version 4"
+	v2, _ := OpenIndex("test_resources/gitgit.index")
+	v4, err := OpenIndex("test_resources/gitgit-v4.index")
+	if err != nil {
+		t.Error("err should be nil:", err)
+		return
+	}
+	if v4.Version() != 4 || len(v4.Entries) != len(v2.Entries) {
+		t.Error("index should be read:", v4.Version(), len(v4.Entries))
+		return
+	}
+	for i, entry := range v4.Entries {
+		if entry.Path != v2.Entries[i].Path || !entry.Id.Equal(v2.Entries[i].Id) {
+			t.Error("entry should be same:", i, entry.Path, v2.Entries[i].Path)
+		}
+	}
+
+	original, _ := ioutil.ReadFile("test_resources/gitgit.index")
+	path := filepath.Join("test-index", "gitgit.index")
+	ioutil.WriteFile(path, original, 0666)
+	index, _ := OpenIndex(path)
+	if err := index.SetVersion(4); err != nil {
+		t.Error("err should be nil:", err)
+	}
+	if err := index.Write(); err != nil {
+		t.Error("err should be nil:", err)
+	}
+	expected, _ := ioutil.ReadFile("test_resources/gitgit-v4.index")
+	written, _ := ioutil.ReadFile(path)
+	if !bytes.Equal(expected, written) {
+		t.Error("written index should be same as git's one:", len(expected), len(written))
+	}
+
+	if err := index.SetVersion(5); !IsErrorCode(err, ErrInvalidSpec) {
+		t.Error("unsupported version should be rejected:", err)
+	}
+}
+
+func Test_IndexVersion_FromConfig(t *testing.T) {
+	testutil.PrepareEmptyWorkDir("test_resources/init_repo")
+	defer testutil.CleanupEmptyWorkDir()
+
+	testcases := []struct {
+		config   string
+		expected uint
+	}{
+		{"", 2},
+		{"[feature]\n\tmanyFiles = true\n", 4},
+		{"[feature]\n\tmanyFiles = true\n[index]\n\tversion = 3\n", 3},
+	}
+	for i, c := range testcases {
+		path := filepath.Join("test_resources/init_repo", fmt.Sprintf("work%d", i))
+		repo, err := InitRepository(path, false)
+		if err != nil {
+			t.Error("err should be nil:", err)
+			continue
+		}
+		file, _ := os.OpenFile(filepath.Join(repo.Path(), "config"), os.O_APPEND|os.O_WRONLY, 0644)
+		file.WriteString(c.config)
+		file.Close()
+		repo, _ = OpenRepository(path)
+		index, err := repo.Index()
+		if err != nil {
+			t.Error("err should be nil:", err)
+			continue
+		}
+		if index.Version() != c.expected {
+			t.Error("version should be", c.expected, "but", index.Version(), c.config)
+		}
+	}
+}
+
+func indexEntryPaths(index *Index) string {
+	var paths []string
+	for _, entry := range index.Entries {
+		paths = append(paths, entry.Path)
+	}
+	return strings.Join(paths, " ")
+}
+
+func Test_IndexAdd_Sorted(t *testing.T) {
+	index, _ := NewIndex()
+	id, _ := NewOid("0a539630525aca2e7bc84975958f92f10a64c9b6")
+	for _, path := range []string{"b", "a/c", "c", "a", "a.txt", "b"} {
+		if err := index.Add(&IndexEntry{Path: path, Mode: FilemodeBlob, Id: id}); err != nil {
+			t.Error("err should be nil:", err)
+		}
+	}
+	// entries are inserted at the sorted position without sorting again
+	if paths := indexEntryPaths(index); paths != "a a.txt a/c b c" {
+		t.Error("entries should be sorted:", paths)
+	}
+}
+
+func Test_IndexAddAll(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/status")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/status")
+	index, _ := repo.Index()
+	if err := index.AddAll(nil, IndexAddDefault, nil); err != nil {
+		t.Error("err should be nil:", err)
+	}
+	expected := "current_file modified_file new_file staged_changes staged_changes_modified_file " +
+		"staged_delete_modified_file staged_new_file staged_new_file_modified_file subdir.txt " +
+		"subdir/current_file subdir/modified_file subdir/new_file 这"
+	if paths := indexEntryPaths(index); paths != expected {
+		t.Error("entries are wrong:", paths)
+	}
+	entry, _ := index.EntryByPath("modified_file", 0)
+	if entry == nil || entry.Id.String() != "0a539630525aca2e7bc84975958f92f10a64c9b6" {
+		t.Error("modified file should be updated:", entry)
+	}
+	entry, _ = index.EntryByPath("subdir/new_file", 0)
+	if entry == nil || entry.Id.String() != "80a86a6931b91bc01c2dbf5ca55bdd24ad1ef466" {
+		t.Error("new file should be added:", entry)
+	}
+}
+
+func Test_IndexAddAll_PathspecAndIgnore(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/status")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/status")
+	index, _ := repo.Index()
+	ioutil.WriteFile("test_resources/status/.gitignore", []byte("*.log\n/build/\n!keep.log\n"), 0644)
+	os.MkdirAll("test_resources/status/build", 0777)
+	ioutil.WriteFile("test_resources/status/build/out.txt", []byte("out\n"), 0644)
+	ioutil.WriteFile("test_resources/status/subdir/debug.log", []byte("debug\n"), 0644)
+	ioutil.WriteFile("test_resources/status/subdir/keep.log", []byte("keep\n"), 0644)
+
+	var called []string
+	err := index.AddAll([]string{"subdir", ":(exclude)*/new_file"}, IndexAddDefault, func(path, spec string) IndexMatchResult {
+		called = append(called, path+"|"+spec)
+		return IndexApplyFile
+	})
+	if err != nil {
+		t.Error("err should be nil:", err)
+	}
+	if strings.Join(called, " ") != "subdir/keep.log|subdir subdir/modified_file|subdir subdir/deleted_file|subdir" {
+		t.Error("callback is called with wrong paths:", called)
+	}
+	if _, err := index.EntryByPath("subdir/deleted_file", 0); !IsErrorCode(err, ErrNotFound) {
+		t.Error("deleted file should be removed")
+	}
+	if _, err := index.EntryByPath("new_file", 0); !IsErrorCode(err, ErrNotFound) {
+		t.Error("unmatched file should not be added")
+	}
+
+	err = index.AddAll([]string{"build/*", "*.log", ":(icase)SUBDIR/NEW_FILE"}, IndexAddForce, func(path, spec string) IndexMatchResult {
+		if path == "subdir/new_file" {
+			return IndexSkipFile
+		}
+		return IndexApplyFile
+	})
+	if err != nil {
+		t.Error("err should be nil:", err)
+	}
+	for _, path := range []string{"build/out.txt", "subdir/debug.log"} {
+		if _, err := index.EntryByPath(path, 0); err != nil {
+			t.Error("ignored file should be added with force:", path)
+		}
+	}
+	if _, err := index.EntryByPath("subdir/new_file", 0); !IsErrorCode(err, ErrNotFound) {
+		t.Error("skipped file should not be added")
+	}
+
+	err = index.AddAll([]string{"new_file"}, IndexAddDefault, func(path, spec string) IndexMatchResult {
+		return IndexAbort
+	})
+	if !IsErrorCode(err, ErrUser) {
+		t.Error("abort should be an error:", err)
+	}
+	err = index.AddAll([]string{"ignored_file"}, IndexAddCheckPathspec, nil)
+	if !IsErrorCode(err, ErrInvalidSpec) {
+		t.Error("ignored pathspec should be an error:", err)
+	}
+	if _, err := index.EntryByPath("ignored_file", 0); !IsErrorCode(err, ErrNotFound) {
+		t.Error("ignored file should not be added")
+	}
+}
+
+func Test_IndexUpdateAll(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/status")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/status")
+	index, _ := repo.Index()
+	if err := index.UpdateAll(nil, nil); err != nil {
+		t.Error("err should be nil:", err)
+	}
+	expected := "current_file modified_file staged_changes staged_changes_modified_file staged_new_file " +
+		"staged_new_file_modified_file subdir.txt subdir/current_file subdir/modified_file"
+	if paths := indexEntryPaths(index); paths != expected {
+		t.Error("entries are wrong:", paths)
+	}
+	entry, _ := index.EntryByPath("subdir/modified_file", 0)
+	if entry == nil || entry.Id.String() != "57274b75eeb5f36fd55527806d567b2240a20c57" {
+		t.Error("modified file should be updated:", entry)
+	}
+}
+
+func Test_IndexUpdateAll_EntryFlags(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/status")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/status")
+	index, _ := repo.Index()
+	deleted, _ := index.EntryByPath("file_deleted", 0)
+	deleted.SetSkipWorktree(true)
+	index.Add(deleted)
+	modified, _ := index.EntryByPath("modified_file", 0)
+	modifiedId := modified.Id
+	modified.SetAssumeValid(true)
+	index.Add(modified)
+	emptyBlob, _ := NewOid("e69de29bb2d1d6434b8b29ae775ad8c2e48c5391")
+	added := &IndexEntry{Path: "new_file", Mode: FilemodeBlob, Id: emptyBlob}
+	added.SetIntentToAdd(true)
+	index.Add(added)
+
+	if err := index.UpdateAll(nil, nil); err != nil {
+		t.Error("err should be nil:", err)
+	}
+	if entry, _ := index.EntryByPath("file_deleted", 0); entry == nil || !entry.IsSkipWorktree() {
+		t.Error("skip worktree entry should not be removed:", entry)
+	}
+	if entry, _ := index.EntryByPath("modified_file", 0); entry == nil || !entry.Id.Equal(modifiedId) || !entry.IsAssumeValid() {
+		t.Error("assume valid entry should not be updated:", entry)
+	}
+	if entry, _ := index.EntryByPath("new_file", 0); entry == nil || entry.IsIntentToAdd() || entry.Id.Equal(emptyBlob) {
+		t.Error("intent to add entry should be added:", entry)
+	}
+}
+
+func Test_IndexEntryFlags_Write(t *testing.T) {
+	testutil.PrepareEmptyWorkDir("test-index")
+	defer testutil.CleanupEmptyWorkDir()
+
+	index, _ := OpenIndex("test-index/index")
+	emptyBlob, _ := NewOid("e69de29bb2d1d6434b8b29ae775ad8c2e48c5391")
+	for _, path := range []string{"assume_valid", "intent_to_add", "skip_worktree"} {
+		entry := &IndexEntry{Path: path, Mode: FilemodeBlob, Id: emptyBlob}
+		entry.SetAssumeValid(path == "assume_valid")
+		entry.SetIntentToAdd(path == "intent_to_add")
+		entry.SetSkipWorktree(path == "skip_worktree")
+		index.Add(entry)
+	}
+	if err := index.Write(); err != nil {
+		t.Error("err should be nil:", err)
+	}
+	written, err := OpenIndex("test-index/index")
+	if err != nil {
+		t.Error("err should be nil:", err)
+		return
+	}
+	if written.Version() != 3 {
+		t.Error("extended flags need version 3:", written.Version())
+	}
+	for _, entry := range written.Entries {
+		if entry.IsAssumeValid() != (entry.Path == "assume_valid") ||
+			entry.IsIntentToAdd() != (entry.Path == "intent_to_add") ||
+			entry.IsSkipWorktree() != (entry.Path == "skip_worktree") {
+			t.Error("flags are wrong:", entry.Path, entry.IsAssumeValid(), entry.IsIntentToAdd(), entry.IsSkipWorktree())
+		}
+	}
+}
+
+func Test_IndexRemoveAll(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/status")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/status")
+	index, _ := repo.Index()
+	err := index.RemoveAll([]string{"subdir/*", "staged_*", ":!staged_new_*"}, func(path, spec string) IndexMatchResult {
+		if path == "subdir/current_file" {
+			return IndexSkipFile
+		}
+		return IndexApplyFile
+	})
+	if err != nil {
+		t.Error("err should be nil:", err)
+	}
+	expected := "current_file file_deleted modified_file staged_new_file staged_new_file_deleted_file " +
+		"staged_new_file_modified_file subdir.txt subdir/current_file"
+	if paths := indexEntryPaths(index); paths != expected {
+		t.Error("entries are wrong:", paths)
+	}
+	if _, err := os.Stat("test_resources/status/subdir/modified_file"); err != nil {
+		t.Error("files should not be removed:", err)
+	}
+}
+
+func Test_IndexAddConflict(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/mergedrepo")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/mergedrepo")
+	index, _ := repo.Index()
+	ancestorId, _ := NewOid("1f85ca51b8e0aac893a621b61a9c2661d6aa6d81")
+	ourId, _ := NewOid("6aea5f295304c36144ad6e9247a291b7f8112399")
+	theirId, _ := NewOid("516bd85f78061e09ccc714561d7b504672cb52da")
+	ancestor := &IndexEntry{Path: "one.txt", Mode: FilemodeBlob, Id: ancestorId}
+	our := &IndexEntry{Path: "one.txt", Mode: FilemodeBlobExecutable, Id: ourId}
+	their := &IndexEntry{Path: "one.txt", Mode: FilemodeBlob, Id: theirId}
+
+	if err := index.AddConflict(ancestor, our, their); err != nil {
+		t.Error("err should be nil:", err)
+	}
+	if ancestor.Stage() != 0 {
+		t.Error("given entries should not be modified")
+	}
+	if _, err := index.EntryByPath("one.txt", 0); !IsErrorCode(err, ErrNotFound) {
+		t.Error("stage 0 entry should be removed")
+	}
+	if err := index.Write(); err != nil {
+		t.Error("err should be nil:", err)
+	}
+	written, _ := OpenIndex(index.Path())
+	conflict, err := written.GetConflict("one.txt")
+	if err != nil {
+		t.Error("err should be nil:", err)
+		return
+	}
+	checkConflict(conflict.Ancestor, "one.txt", ancestorId.String(), t)
+	checkConflict(conflict.Our, "one.txt", ourId.String(), t)
+	checkConflict(conflict.Their, "one.txt", theirId.String(), t)
+	if conflict.Our.Mode != FilemodeBlobExecutable {
+		t.Error("mode is wrong:", conflict.Our.Mode)
+	}
+
+	// deleted by them
+	if err := index.AddConflict(ancestor, our, nil); err != nil {
+		t.Error("err should be nil:", err)
+	}
+	conflict, _ = index.GetConflict("one.txt")
+	if conflict.Their != nil || conflict.Our == nil {
+		t.Error("their entry should be removed:", conflict)
+	}
+	if err := index.AddConflict(nil, nil, nil); err == nil {
+		t.Error("empty conflict should be an error")
+	}
+	other := &IndexEntry{Path: "two.txt", Mode: FilemodeBlob, Id: theirId}
+	if err := index.AddConflict(ancestor, our, other); err == nil {
+		t.Error("different paths should be an error")
+	}
+}
+
+func Test_IndexRemoveConflict(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/mergedrepo")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/mergedrepo")
+	index, _ := repo.Index()
+	if !index.HasConflicts() {
+		t.Error("index should have conflicts")
+	}
+	if err := index.RemoveConflict("conflicts-one.txt"); err != nil {
+		t.Error("err should be nil:", err)
+	}
+	if _, err := index.GetConflict("conflicts-one.txt"); !IsErrorCode(err, ErrNotFound) {
+		t.Error("conflict should be removed:", err)
+	}
+	if err := index.RemoveConflict("one.txt"); !IsErrorCode(err, ErrNotFound) {
+		t.Error("path without conflicts should be an error:", err)
+	}
+	if err := index.Write(); err != nil {
+		t.Error("err should be nil:", err)
+	}
+
+	written, _ := OpenIndex(index.Path())
+	if written.EntryCount() != 5 {
+		t.Error("entry count should be 5, but", written.EntryCount())
+	}
+	pos := reucFind(written, "conflicts-one.txt")
+	if pos == -1 {
+		t.Error("resolve undo entry should be recorded")
+		return
+	}
+	reuc := written.reuc[pos]
+	if reuc.mode[0] != FilemodeBlob || reuc.mode[1] != FilemodeBlob || reuc.mode[2] != FilemodeBlob {
+		t.Error("modes are wrong:", reuc.mode)
+	}
+	if reuc.oid[0].String() != "1f85ca51b8e0aac893a621b61a9c2661d6aa6d81" ||
+		reuc.oid[1].String() != "6aea5f295304c36144ad6e9247a291b7f8112399" ||
+		reuc.oid[2].String() != "516bd85f78061e09ccc714561d7b504672cb52da" {
+		t.Error("ids are wrong:", reuc.oid)
+	}
+}
+
+func Test_IndexCleanupConflicts(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/mergedrepo")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/mergedrepo")
+	index, _ := repo.Index()
+	reucCount := len(index.reuc)
+	index.CleanupConflicts()
+	if index.HasConflicts() {
+		t.Error("index should not have conflicts")
+	}
+	if index.EntryCount() != 2 {
+		t.Error("entry count should be 2, but", index.EntryCount())
+	}
+	if len(index.reuc) != reucCount {
+		t.Error("resolve undo entries should not be recorded")
+	}
+}
+
+func Test_IndexReadTreeCache_Corrupted(t *testing.T) {
+	valid := []byte("\x00-1 1\nsubdir\x00-1 0\n")
+	if tree, err := readTreeCache(valid, 0, len(valid)); err != nil || tree.get("subdir") == nil {
+		t.Error("err should be nil:", err)
+	}
+	for _, broken := range []string{
+		"\x00-1 2147483647\n",
+		"\x00-1 2\nsubdir\x00-1 0\n",
+		"\x000 1\n",
+	} {
+		if _, err := readTreeCache([]byte(broken), 0, len(broken)); err == nil {
+			t.Errorf("broken extension should be detected: %q", broken)
+		}
+	}
+}
+
+func Test_IndexWriteTree(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/status")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/status")
+	index, _ := repo.Index()
+	index.AddAll(nil, IndexAddDefault, nil)
+	oid, err := index.WriteTree()
+	if err != nil || oid.String() != "b1e22a8976c450201ab372e22c7e2a25dc54db08" {
+		t.Error("tree id is wrong:", oid, err)
+	}
+	if index.tree == nil || index.tree.entryCount != 13 || index.tree.get("subdir").entryCount != 3 {
+		t.Error("tree cache should be updated")
+	}
+	if err := index.Write(); err != nil {
+		t.Error("err should be nil:", err)
+	}
+	written, _ := OpenIndex(index.Path())
+	if written.tree == nil || !written.tree.oid.Equal(oid) || written.tree.get("subdir") == nil {
+		t.Error("tree cache should be written")
+	}
+
+	ioutil.WriteFile("test_resources/status/subdir/current_file", []byte("changed\n"), 0644)
+	index.AddByPath("subdir/current_file")
+	if index.tree.entryCount != -1 || index.tree.get("subdir").entryCount != -1 {
+		t.Error("tree cache should be invalidated")
+	}
+	oid, err = index.WriteTree()
+	if err != nil || oid.String() != "78052ec6124177b7febbe693d5736dc5f67572c7" {
+		t.Error("tree id is wrong:", oid, err)
+	}
+}
+
+func Test_IndexWriteTree_IntentToAdd(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/status")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/status")
+	index, _ := repo.Index()
+	index.AddAll(nil, IndexAddDefault, nil)
+	emptyBlob, _ := NewOid("e69de29bb2d1d6434b8b29ae775ad8c2e48c5391")
+	for _, path := range []string{"subdir/intent_to_add", "newdir/intent_to_add", "zzz"} {
+		entry := &IndexEntry{Path: path, Mode: FilemodeBlob, Id: emptyBlob}
+		entry.SetIntentToAdd(true)
+		index.Add(entry)
+	}
+	// same as Test_IndexWriteTree
+	oid, err := index.WriteTree()
+	if err != nil || oid.String() != "b1e22a8976c450201ab372e22c7e2a25dc54db08" {
+		t.Error("intent to add entries should not be written:", oid, err)
+	}
+	if index.tree.entryCount != -1 || index.tree.get("subdir").entryCount != -1 || index.tree.get("newdir").entryCount != -1 {
+		t.Error("trees which have intent to add entries should be invalid")
+	}
+	if index.tree.get("subdir").oid == nil || !index.tree.get("newdir").oid.Equal(emptyTreeId) {
+		t.Error("tree ids should be kept in memory")
+	}
+}
+
+func Test_IndexWriteTree_ReuseCache(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/status")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/status")
+	index, _ := repo.Index()
+	head, _ := repo.RevparseSingle("HEAD^{tree}")
+	tree := head.(*Tree)
+	if err := index.ReadTree(tree); err != nil {
+		t.Error("err should be nil:", err)
+	}
+	oid, err := index.WriteTree()
+	if err != nil || !oid.Equal(tree.Id()) {
+		t.Error("tree id should be same as the read tree:", oid, err)
+	}
+
+	// valid subtrees are not written again
+	fake, _ := NewOid("4b825dc642cb6eb9a060af5ab1ef8e5e6a1d2f4b")
+	index.tree.get("subdir").oid = fake
+	index.RemoveByPath("current_file")
+	oid, _ = index.WriteTree()
+	written, _ := repo.LookupTree(oid)
+	if entry := written.EntryByName("subdir"); entry == nil || !entry.Id.Equal(fake) {
+		t.Error("subtree should be reused from the cache:", entry)
+	}
+}
+
+func Test_IndexWriteTree_Unmerged(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/mergedrepo")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/mergedrepo")
+	index, _ := repo.Index()
+	if _, err := index.WriteTree(); !IsErrorCode(err, ErrUnmerged) {
+		t.Error("conflicted index should not be written:", err)
+	}
+}
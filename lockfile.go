@@ -0,0 +1,61 @@
+package git4go
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const GitLockFileSuffix = ".lock"
+
+// lockFile is git's "<path>.lock" protocol. The new content is written
+// into the lock file and it replaces the original file atomically by
+// Commit(). While the lock file exists, other writers fail.
+type lockFile struct {
+	path     string
+	lockPath string
+	file     *os.File
+}
+
+func newLockFile(path string, mode os.FileMode) (*lockFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), os.FileMode(GitObjectDirMode)); err != nil {
+		return nil, err
+	}
+	lockPath := path + GitLockFileSuffix
+	file, err := os.OpenFile(lockPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if os.IsExist(err) {
+		msg := fmt.Sprintf("Failed to lock file '%s' for writing: lock file exists", path)
+		return nil, MakeGitError(msg, ErrLocked)
+	} else if err != nil {
+		return nil, err
+	}
+	return &lockFile{
+		path:     path,
+		lockPath: lockPath,
+		file:     file,
+	}, nil
+}
+
+func (l *lockFile) Write(data []byte) (int, error) {
+	return l.file.Write(data)
+}
+
+// Commit replaces the original file with the written content.
+func (l *lockFile) Commit() error {
+	err := l.file.Close()
+	if err != nil {
+		os.Remove(l.lockPath)
+		return err
+	}
+	err = os.Rename(l.lockPath, l.path)
+	if err != nil {
+		os.Remove(l.lockPath)
+	}
+	return err
+}
+
+// Rollback discards the written content and releases the lock.
+func (l *lockFile) Rollback() {
+	l.file.Close()
+	os.Remove(l.lockPath)
+}
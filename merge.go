@@ -0,0 +1,88 @@
+package git4go
+
+import (
+	"fmt"
+)
+
+// Repository methods related to merge
+
+func (r *Repository) MergeBase(one, two *Oid) (*Oid, error) {
+	bases, err := r.MergeBases(one, two)
+	if err != nil {
+		return nil, err
+	}
+	return bases[0], nil
+}
+
+func (r *Repository) MergeBases(one, two *Oid) ([]*Oid, error) {
+	walk, err := r.Walk()
+	if err != nil {
+		return nil, err
+	}
+	result, err := walk.mergeBases(walk.commitLookup(one), walk.commitLookup(two))
+	if err != nil {
+		return nil, err
+	}
+	if len(result) == 0 {
+		return nil, MakeGitError(fmt.Sprintf("No merge base found between %s and %s", one, two), ErrNotFound)
+	}
+	oids := make([]*Oid, len(result))
+	for i, commit := range result {
+		oids[i] = commit.oid
+	}
+	return oids, nil
+}
+
+// internal functions
+
+// mergeBases paints the history down from the both commits and collects
+// the commits that are reachable from the both but not from other results.
+// The result is sorted by commit time (newer first).
+func (v *RevWalk) mergeBases(one, two *commitListNode) (commitListNodes, error) {
+	if one == two {
+		return commitListNodes{one}, nil
+	}
+	var queue commitListNodes
+	if err := v.commitListParse(one); err != nil {
+		return nil, err
+	}
+	one.flags |= Parent1
+	queue = queue.insertByTime(one)
+	if err := v.commitListParse(two); err != nil {
+		return nil, err
+	}
+	two.flags |= Parent2
+	queue = queue.insertByTime(two)
+
+	var result commitListNodes
+	for queue.interesting() {
+		commit := queue[0]
+		queue = queue[1:]
+		flags := commit.flags & (Parent1 | Parent2 | Stale)
+		if flags == (Parent1 | Parent2) {
+			if commit.flags&Result == 0 {
+				commit.flags |= Result
+				result = append(result, commit)
+			}
+			// parents of the merge base are not interesting anymore
+			flags |= Stale
+		}
+		for _, parent := range commit.parents {
+			if parent.flags&flags == flags {
+				continue
+			}
+			if err := v.commitListParse(parent); err != nil {
+				return nil, err
+			}
+			parent.flags |= flags
+			queue = queue.insertByTime(parent)
+		}
+	}
+	var filtered commitListNodes
+	for _, commit := range result {
+		if commit.flags&Stale == 0 {
+			filtered = filtered.insertByTime(commit)
+		}
+	}
+	return filtered, nil
+}
@@ -0,0 +1,31 @@
+package git4go
+
+import (
+	"./testutil"
+	"testing"
+)
+
+func Test_MergeBase(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+
+	one, _ := NewOid("9fd738e8f7967c078dceed8190330fc8648ee56a")
+	two, _ := NewOid("c47800c7266a2be04c571c04d5a6614691ea99bd")
+	base, err := repo.MergeBase(one, two)
+	if err != nil {
+		t.Error("err should be nil:", err)
+	} else if base.String() != "5b5b025afb0b4c913b4c338a42934a3863bf3644" {
+		t.Error("merge base is wrong:", base)
+	}
+
+	// one is an ancestor of the other
+	head, _ := NewOid("a65fedf39aefe402d3bb6e24df4d4f5fe4547750")
+	base, err = repo.MergeBase(head, one)
+	if err != nil {
+		t.Error("err should be nil:", err)
+	} else if !base.Equal(one) {
+		t.Error("merge base is wrong:", base)
+	}
+}
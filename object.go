@@ -100,7 +100,7 @@ func checkTypeCombination(sourceType, targetType ObjectType) bool {
 
 func peelError(oid *Oid, targetType ObjectType) error {
 	msg := fmt.Sprintf("The git_object of id '%s' can not be successfully peeled into a %s.", oid, targetType)
-	return errors.New(msg)
+	return MakeGitError(msg, ErrInvalidSpec)
 }
 
 func dereferenceObject(object Object) Object {
@@ -121,7 +121,7 @@ func peel(source Object, targetType ObjectType) (Object, error) {
 	}
 	sourceType := source.Type()
 	if !checkTypeCombination(sourceType, targetType) {
-		peelError(source.Id(), targetType)
+		return nil, peelError(source.Id(), targetType)
 	}
 	if source.Type() == targetType {
 		return source, nil
@@ -179,14 +179,39 @@ func (o *OdbBackendLoose) Write(data []byte, objType ObjectType) (*Oid, error) {
 	}
 	dirName, fileName := oid.PathFormat()
 	dirPath := filepath.Join(o.objectsDir, dirName)
-	os.MkdirAll(dirPath, os.FileMode(GitObjectDirMode))
-	file, err := os.OpenFile(filepath.Join(dirPath, fileName), os.O_WRONLY, os.FileMode(GitObjectFileMode))
-	defer file.Close()
+	path := filepath.Join(dirPath, fileName)
+	if _, err := os.Stat(path); err == nil {
+		return oid, nil
+	}
+	if err := os.MkdirAll(dirPath, os.FileMode(o.dirMode)); err != nil {
+		return nil, err
+	}
+	// write to a temporary file and rename it to avoid broken objects
+	file, err := ioutil.TempFile(dirPath, "tmp_object_")
+	if err != nil {
+		return nil, err
+	}
+	tempPath := file.Name()
 	writer := zlib.NewWriter(file)
 	fmt.Fprintf(writer, "%s %d\x00", objType.String(), len(data))
 	writer.Write(data)
-	defer writer.Close()
-
+	err = writer.Close()
+	if err == nil && o.doFileSync {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tempPath, os.FileMode(o.fileMode))
+	}
+	if err == nil {
+		err = os.Rename(tempPath, path)
+	}
+	if err != nil {
+		os.Remove(tempPath)
+		return nil, err
+	}
 	return oid, nil
 }
 
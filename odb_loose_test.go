@@ -195,7 +195,7 @@ func Test_LooseWrite(t *testing.T) {
 			t.Error("id is wrong: ", oid.String())
 		}
 		_, err = os.Stat(filepath.Join("test-objects", "67", "b808feb36201507a77f85e6d898f0a2836e4a5"))
-		if !os.IsNotExist(err) {
+		if os.IsNotExist(err) {
 			t.Error("file is missing")
 		}
 	}
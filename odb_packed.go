@@ -202,6 +202,7 @@ func (o *OdbBackendPacked) findEntryByPrefixInternal(shortOid *Oid, length int)
 			if foundEntry != nil && !foundEntry.Sha1.Equal(entry.Sha1) {
 				return nil, false, errors.New("found multiple pack entries for: " + shortOid.String())
 			}
+			foundEntry = entry
 			o.lastFound = pack
 		}
 	}
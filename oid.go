@@ -36,11 +36,14 @@ func NewOidFromPrefix(s string) (*Oid, error) {
 	if len(s) > GitOidHexSize {
 		return nil, errors.New("string is too long for oid")
 	}
+	length := len(s)
+	if length%2 == 1 {
+		s += "0"
+	}
 	slice, err := hex.DecodeString(s)
 	if err != nil {
 		return nil, err
 	}
-	length := len(s)
 
 	shortOid := new(Oid)
 	copy(shortOid[:], slice[:(length+1)/2])
@@ -103,7 +106,7 @@ func (oid *Oid) IsZero() bool {
 func (oid *Oid) NCmp(oid2 *Oid, n uint) int {
 	result := bytes.Compare(oid[:n/2], oid2[:n/2])
 	if result == 0 && n%2 == 1 {
-		if (oid[n/2+1]^oid2[n/2+1])&0xf0 != 0 {
+		if (oid[n/2]^oid2[n/2])&0xf0 != 0 {
 			return 1
 		}
 		return 0
@@ -0,0 +1,176 @@
+package git4go
+
+import (
+	"fmt"
+	"strings"
+)
+
+// pathspec matches paths like the pathspec arguments of git commands.
+// It supports wildcards and the magic words top, exclude, icase, literal
+// and glob in the long form (":(exclude,icase)pattern") and in the short
+// form (":!pattern", ":^pattern" and ":/pattern").
+type pathspec struct {
+	items       []*pathspecItem
+	hasPositive bool
+}
+
+type pathspecItem struct {
+	original string
+	pattern  string
+	exclude  bool
+	icase    bool
+	literal  bool
+	glob     bool
+}
+
+// newPathspec parses the pathspecs. If literal is true, all patterns are
+// treated as literal paths like ":(literal)".
+func newPathspec(specs []string, literal bool) (*pathspec, error) {
+	result := &pathspec{}
+	for _, spec := range specs {
+		item, err := parsePathspecItem(spec)
+		if err != nil {
+			return nil, err
+		}
+		if literal {
+			item.literal = true
+		}
+		if !item.exclude {
+			result.hasPositive = true
+		}
+		result.items = append(result.items, item)
+	}
+	return result, nil
+}
+
+func parsePathspecItem(spec string) (*pathspecItem, error) {
+	item := &pathspecItem{original: spec}
+	pattern := spec
+	if strings.HasPrefix(pattern, ":(") {
+		end := strings.IndexByte(pattern, ')')
+		if end == -1 {
+			return nil, MakeGitError(fmt.Sprintf("Missing ')' at the end of pathspec magic in '%s'", spec), ErrInvalidSpec)
+		}
+		for _, magic := range strings.Split(pattern[2:end], ",") {
+			switch strings.TrimSpace(magic) {
+			case "", "top":
+			case "exclude":
+				item.exclude = true
+			case "icase":
+				item.icase = true
+			case "literal":
+				item.literal = true
+			case "glob":
+				item.glob = true
+			default:
+				return nil, MakeGitError(fmt.Sprintf("Invalid pathspec magic '%s' in '%s'", magic, spec), ErrInvalidSpec)
+			}
+		}
+		pattern = pattern[end+1:]
+	} else if strings.HasPrefix(pattern, ":") {
+		pattern = pattern[1:]
+	shortMagic:
+		for len(pattern) > 0 {
+			switch pattern[0] {
+			case '/':
+			case '!', '^':
+				item.exclude = true
+			case ':':
+				pattern = pattern[1:]
+				break shortMagic
+			default:
+				break shortMagic
+			}
+			pattern = pattern[1:]
+		}
+	}
+	if item.literal && item.glob {
+		return nil, MakeGitError(fmt.Sprintf("'literal' and 'glob' are incompatible in '%s'", spec), ErrInvalidSpec)
+	}
+	// paths are always relative to the top of the working directory
+	for strings.HasPrefix(pattern, "./") {
+		pattern = pattern[2:]
+	}
+	pattern = strings.TrimRight(pattern, "/")
+	if pattern == "." {
+		pattern = ""
+	}
+	item.pattern = pattern
+	return item, nil
+}
+
+// match returns whether the path matches the pathspec and the matched
+// pathspec. All paths match the empty pathspec. An empty string is
+// returned as the matched pathspec in that case.
+func (p *pathspec) match(path string, ignoreCase bool) (bool, string) {
+	matched := ""
+	found := !p.hasPositive
+	for _, item := range p.items {
+		if item.exclude || found {
+			continue
+		}
+		if item.match(path, ignoreCase) {
+			matched = item.original
+			found = true
+		}
+	}
+	if !found {
+		return false, ""
+	}
+	for _, item := range p.items {
+		if item.exclude && item.match(path, ignoreCase) {
+			return false, ""
+		}
+	}
+	return true, matched
+}
+
+// matchDirectory returns false if no path under the directory can match
+// the pathspec. It is used to skip directories while walking.
+func (p *pathspec) matchDirectory(dir string, ignoreCase bool) bool {
+	if !p.hasPositive {
+		return true
+	}
+	for _, item := range p.items {
+		if item.exclude {
+			continue
+		}
+		pattern, path := item.pattern, dir
+		if ignoreCase || item.icase {
+			pattern, path = strings.ToLower(pattern), strings.ToLower(path)
+		}
+		if !item.literal {
+			if wildcard := strings.IndexAny(pattern, "*?[\\"); wildcard != -1 {
+				pattern = pattern[:wildcard]
+			}
+		}
+		// the directory is in the pattern or the pattern is in the directory
+		if strings.HasPrefix(path+"/", pattern) || strings.HasPrefix(pattern, path+"/") || pattern == "" {
+			return true
+		}
+	}
+	return false
+}
+
+func (i *pathspecItem) match(path string, ignoreCase bool) bool {
+	pattern := i.pattern
+	if pattern == "" {
+		return true
+	}
+	var flags FnMatchFlag
+	if ignoreCase || i.icase {
+		pattern = strings.ToLower(pattern)
+		path = strings.ToLower(path)
+		flags |= FNMCaseFold
+	}
+	if path == pattern || strings.HasPrefix(path, pattern+"/") {
+		return true
+	}
+	if i.literal || !strings.ContainsAny(pattern, "*?[\\") {
+		return false
+	}
+	if i.glob {
+		flags |= FNMPathName
+	}
+	return fnMatch(pattern, path, flags)
+}
@@ -0,0 +1,65 @@
+package git4go
+
+import (
+	"testing"
+)
+
+func Test_Pathspec_Match(t *testing.T) {
+	spec, err := newPathspec([]string{"src", "*.md", ":(glob)docs/*.txt", ":!src/vendor", ":(exclude,icase)SRC/GEN"}, false)
+	if err != nil {
+		t.Error("err should be nil:", err)
+		return
+	}
+	cases := []struct {
+		path     string
+		expected bool
+		matched  string
+	}{
+		{"src/main.go", true, "src"},
+		{"src", true, "src"},
+		{"srcfile", false, ""},
+		{"README.md", true, "*.md"},
+		{"doc/api/index.md", true, "*.md"},
+		{"docs/a.txt", true, ":(glob)docs/*.txt"},
+		{"docs/sub/a.txt", false, ""},
+		{"src/vendor/lib.go", false, ""},
+		{"src/gen/out.go", false, ""},
+	}
+	for _, c := range cases {
+		matched, matchedSpec := spec.match(c.path, false)
+		if matched != c.expected || matchedSpec != c.matched {
+			t.Error("match result is wrong:", c.path, matched, matchedSpec)
+		}
+	}
+	spec, _ = newPathspec([]string{"src/app", "docs/*.txt"}, false)
+	if !spec.matchDirectory("docs", false) || !spec.matchDirectory("src", false) || !spec.matchDirectory("src/app/sub", false) {
+		t.Error("directory should match")
+	}
+	if spec.matchDirectory("test", false) || spec.matchDirectory("src/lib", false) {
+		t.Error("directory should not match")
+	}
+}
+
+func Test_Pathspec_Parse(t *testing.T) {
+	spec, _ := newPathspec([]string{":/./", ":^*.o"}, false)
+	if matched, _ := spec.match("a/b.c", false); !matched {
+		t.Error("top pathspec should match all")
+	}
+	if matched, _ := spec.match("a/b.o", false); matched {
+		t.Error("excluded path should not match")
+	}
+	spec, _ = newPathspec([]string{":!*.o"}, false)
+	if matched, _ := spec.match("a/b.c", false); !matched {
+		t.Error("only exclude pathspec should match others")
+	}
+	spec, _ = newPathspec([]string{"*.c"}, true)
+	if matched, _ := spec.match("a.c", false); matched {
+		t.Error("literal pathspec should not match wildcard")
+	}
+	if _, err := newPathspec([]string{":(unknown)a"}, false); !IsErrorCode(err, ErrInvalidSpec) {
+		t.Error("unknown magic should be an error:", err)
+	}
+	if _, err := newPathspec([]string{":(icase"}, false); !IsErrorCode(err, ErrInvalidSpec) {
+		t.Error("unclosed magic should be an error:", err)
+	}
+}
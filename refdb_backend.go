@@ -0,0 +1,79 @@
+package git4go
+
+// RefDbBackend stores references and their reflogs. RefDb uses the files
+// backend (loose references and packed-refs) or the reftable backend by
+// default. Other implementations can be set by RefDb.SetBackend().
+//
+// Names passed to the backend are already normalized. RefDb checks name
+// conflicts like "refs/heads/a" and "refs/heads/a/b" before writing.
+type RefDbBackend interface {
+	// Lookup returns the reference or an ErrNotFound error.
+	Lookup(name string) (*Reference, error)
+	// ForEach calls the callback for the references under "refs/" which
+	// match the glob pattern. An empty pattern matches everything.
+	ForEach(pattern string, callback ForEachReferenceCallback) error
+	// Write stores the reference and returns the previous value. If oldId
+	// or oldTarget is given, the current value should match it (a zero
+	// oldId means the reference should not exist yet).
+	Write(ref *Reference, force bool, oldId *Oid, oldTarget string) (*Reference, error)
+	// Delete removes the reference if the current value matches oldId or
+	// oldTarget.
+	Delete(name string, oldId *Oid, oldTarget string) error
+	// Rename moves the reference to the new name and returns the renamed
+	// one. Reflogs are moved separately by RenameReflog().
+	Rename(ref *Reference, newName string, force bool) (*Reference, error)
+	// PackAll optimizes the storage like "git pack-refs --all".
+	PackAll(prune bool) error
+	// NewTransaction starts a transaction to update several references
+	// atomically.
+	NewTransaction() RefDbTransaction
+
+	HasReflog(name string) bool
+	// ReadReflog returns the entries from the oldest one. It returns an
+	// empty list if the reference doesn't have a reflog.
+	ReadReflog(name string) ([]*ReflogEntry, error)
+	// WriteReflog replaces all entries of the reflog.
+	WriteReflog(name string, entries []*ReflogEntry) error
+	AppendReflog(name string, entry *ReflogEntry) error
+	RenameReflog(oldName, newName string) error
+	DeleteReflog(name string) error
+}
+
+// RefDbTransaction is the backend side of RefTransaction.
+type RefDbTransaction interface {
+	// Lock locks the reference until Commit() or Rollback() is called.
+	// It fails with ErrLocked if somebody else has locked it.
+	Lock(name string) error
+	// Unlock releases the lock of the reference without any change.
+	Unlock(name string)
+	// Commit applies the updates of the locked references at once and
+	// releases all locks even if it fails.
+	Commit(updates []*RefUpdate) error
+	// Rollback releases all locks without any change.
+	Rollback()
+}
+
+type RefUpdate struct {
+	Name      string
+	Reference *Reference // nil means deletion
+}
+
+// NewReference makes a direct reference. It is for RefDbBackend
+// implementations.
+func NewReference(name string, id *Oid) *Reference {
+	return &Reference{
+		refType:   ReferenceOid,
+		targetOid: id,
+		name:      name,
+	}
+}
+
+// NewSymbolicReference makes a symbolic reference. It is for RefDbBackend
+// implementations.
+func NewSymbolicReference(name, target string) *Reference {
+	return &Reference{
+		refType:        ReferenceSymbolic,
+		targetSymbolic: target,
+		name:           name,
+	}
+}
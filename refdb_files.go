@@ -0,0 +1,645 @@
+package git4go
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// RefDbBackendFiles is git's traditional reference storage: loose
+// reference files, packed-refs and reflogs under "logs/".
+type RefDbBackendFiles struct {
+	repo  *Repository
+	path  string
+	cache *PackRefSortedCache
+}
+
+func NewRefDbBackendFiles(repo *Repository) *RefDbBackendFiles {
+	backend := &RefDbBackendFiles{
+		repo: repo,
+	}
+	if repo.namespace != "" {
+		buffer := bytes.NewBufferString(repo.pathRepository)
+		for _, namespace := range strings.Split(repo.namespace, "/") {
+			buffer.WriteString("refs/namespaces/")
+			buffer.WriteString(namespace)
+			buffer.WriteByte('/')
+		}
+		buffer.WriteString("refs")
+		backend.path = buffer.String()
+	} else {
+		backend.path = repo.pathRepository
+	}
+	backend.cache = &PackRefSortedCache{
+		cacheMap: make(map[string]*PackRef),
+		path:     filepath.Join(backend.path, GitPackedRefsFile),
+		stamp:    time.Unix(0, 0),
+	}
+	backend.cache.reloadIfChanged(true)
+	return backend
+}
+
+func (b *RefDbBackendFiles) Lookup(name string) (*Reference, error) {
+	ref, err := b.lookupLoose(name)
+	if ref != nil || err != nil {
+		return ref, err
+	}
+	b.cache.reloadIfChanged(true)
+	item := b.cache.Lookup(name)
+	if item == nil {
+		return nil, MakeGitError(fmt.Sprintf("Reference '%s' not found", name), ErrNotFound)
+	}
+	return &Reference{
+		refType:   ReferenceOid,
+		targetOid: item.oid,
+		repo:      b.repo,
+		name:      name,
+	}, nil
+}
+
+// lookupLoose reads the loose reference file. It returns nil without error
+// if the file can't be read.
+func (b *RefDbBackendFiles) lookupLoose(name string) (*Reference, error) {
+	refFile, err := ioutil.ReadFile(filepath.Join(b.path, name))
+	if err != nil {
+		return nil, nil
+	}
+	refString := string(refFile)
+	if strings.HasPrefix(refString, GitSymbolReference) {
+		return &Reference{
+			refType:        ReferenceSymbolic,
+			targetSymbolic: strings.TrimSpace(refString[len(GitSymbolReference):]),
+			repo:           b.repo,
+			name:           name,
+		}, nil
+	}
+	oid, err := NewOid(strings.TrimSpace(refString))
+	if err != nil {
+		return nil, err
+	}
+	return &Reference{
+		refType:   ReferenceOid,
+		targetOid: oid,
+		repo:      b.repo,
+		name:      name,
+	}, nil
+}
+
+// ForEach visits the loose references at first and then the packed ones
+// which are not overridden. Broken loose references are skipped. Only the
+// directory and the packed references under the literal prefix of the
+// pattern like "refs/heads/" of "refs/heads/*" are read.
+func (b *RefDbBackendFiles) ForEach(pattern string, callback ForEachReferenceCallback) error {
+	prefix := pattern
+	if i := strings.IndexAny(pattern, "*?[\\"); i != -1 {
+		prefix = pattern[:i]
+	}
+	if !strings.HasPrefix(prefix, GitRefsDir) {
+		prefix = GitRefsDir
+	}
+	processed := make(map[string]bool)
+	root := filepath.Join(b.path, prefix[:strings.LastIndex(prefix, "/")+1])
+	// no loose references if the directory doesn't exist or is a reference
+	if info, err := os.Stat(root); err == nil && info.IsDir() {
+		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+			if info.IsDir() || strings.HasSuffix(path, GitLockFileSuffix) {
+				return nil
+			}
+			relPath, err := filepath.Rel(b.path, path)
+			if err != nil {
+				return err
+			}
+			name := filepath.ToSlash(relPath)
+			if pattern != "" && !fnMatch(pattern, name, 0) {
+				return nil
+			}
+			ref, err := b.lookupLoose(name)
+			if err != nil || ref == nil {
+				return nil // ignore error
+			}
+			processed[name] = true
+			return callback(ref)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	refs, err := b.packedReferences(prefix)
+	if err != nil {
+		return err
+	}
+	for _, ref := range refs {
+		if processed[ref.name] || (pattern != "" && !fnMatch(pattern, ref.name, 0)) {
+			continue
+		}
+		if err := callback(ref); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// packedReferences returns the packed references whose names start with
+// the prefix.
+func (b *RefDbBackendFiles) packedReferences(prefix string) ([]*Reference, error) {
+	b.cache.lock.Lock()
+	defer b.cache.lock.Unlock()
+
+	err := b.cache.reloadIfChanged(false)
+	if err != nil {
+		return nil, err
+	}
+	if b.cache.notExist {
+		return []*Reference{}, nil
+	}
+	var result []*Reference
+	for _, item := range b.cache.entriesWithPrefix(prefix) {
+		ref := &Reference{
+			refType:   ReferenceOid,
+			targetOid: item.oid,
+			repo:      b.repo,
+			name:      item.name,
+		}
+		result = append(result, ref)
+	}
+	return result, nil
+}
+
+// PackAll packs all loose direct references into packed-refs like
+// "git pack-refs --all". Annotated tags are peeled. If prune is true, the
+// loose reference files are removed after they are packed.
+func (b *RefDbBackendFiles) PackAll(prune bool) error {
+	packed, err := b.packLooseRefs()
+	if err != nil || !prune {
+		return err
+	}
+	for name, oid := range packed {
+		b.pruneLooseRef(name, oid)
+	}
+	return nil
+}
+
+// packLooseRefs writes the loose references into packed-refs and returns
+// the packed values.
+func (b *RefDbBackendFiles) packLooseRefs() (map[string]*Oid, error) {
+	lock, err := newLockFile(b.cache.path, GitRefFileMode)
+	if err != nil {
+		return nil, err
+	}
+	b.cache.lock.Lock()
+	defer b.cache.lock.Unlock()
+	if err := b.cache.reloadIfChanged(false); err != nil {
+		lock.Rollback()
+		return nil, err
+	}
+	packed := make(map[string]*Oid)
+	rootDir := filepath.Join(b.path, GitRefsDir)
+	err = filepath.Walk(rootDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() || strings.HasSuffix(path, GitLockFileSuffix) {
+			return nil
+		}
+		relPath, err := filepath.Rel(b.path, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(relPath)
+		ref, err := b.lookupLoose(name)
+		if err != nil || ref == nil || ref.refType != ReferenceOid {
+			// broken or symbolic references are kept loose
+			return nil
+		}
+		item := b.cache.upsert(name)
+		if item.oid == nil || !item.oid.Equal(ref.targetOid) {
+			item.oid = ref.targetOid
+			item.peel = nil
+			item.flag = 0
+		}
+		item.flag |= PackRefWasLoose
+		packed[name] = ref.targetOid
+		return nil
+	})
+	if err != nil {
+		lock.Rollback()
+		return nil, err
+	}
+	if err := b.writePackedRefs(lock); err != nil {
+		lock.Rollback()
+		return nil, err
+	}
+	if err := lock.Commit(); err != nil {
+		return nil, err
+	}
+	return packed, nil
+}
+
+// pruneLooseRef removes the packed loose reference if nobody has changed
+// it after it was packed.
+func (b *RefDbBackendFiles) pruneLooseRef(name string, oid *Oid) {
+	loosePath := filepath.Join(b.path, name)
+	lock, err := newLockFile(loosePath, GitRefFileMode)
+	if err != nil {
+		return
+	}
+	defer func() {
+		lock.Rollback()
+		b.removeEmptyDirs(loosePath)
+	}()
+	ref, err := b.lookupLoose(name)
+	if err == nil && ref != nil && ref.refType == ReferenceOid && ref.targetOid.Equal(oid) {
+		os.Remove(loosePath)
+	}
+}
+
+// Write stores the reference as a loose reference.
+func (b *RefDbBackendFiles) Write(ref *Reference, force bool, oldId *Oid, oldTarget string) (*Reference, error) {
+	lock, err := newLockFile(filepath.Join(b.path, ref.name), GitRefFileMode)
+	if err != nil {
+		return nil, err
+	}
+	current, err := b.Lookup(ref.name)
+	if err != nil && !IsErrorCode(err, ErrNotFound) {
+		lock.Rollback()
+		return nil, err
+	}
+	if current != nil && !force {
+		lock.Rollback()
+		return nil, MakeGitError(fmt.Sprintf("Failed to write reference '%s': a reference with that name already exists.", ref.name), ErrExists)
+	}
+	if err := checkReferenceValue(ref.name, current, oldId, oldTarget); err != nil {
+		lock.Rollback()
+		return nil, err
+	}
+	if err := writeLooseReference(lock, ref); err != nil {
+		lock.Rollback()
+		return nil, err
+	}
+	return current, lock.Commit()
+}
+
+func writeLooseReference(lock *lockFile, ref *Reference) error {
+	var err error
+	if ref.refType == ReferenceSymbolic {
+		_, err = lock.Write([]byte(GitSymbolReference + ref.targetSymbolic + "\n"))
+	} else {
+		_, err = lock.Write([]byte(ref.targetOid.String() + "\n"))
+	}
+	return err
+}
+
+// Delete removes the reference from both of the loose reference and the
+// packed-refs file.
+func (b *RefDbBackendFiles) Delete(name string, oldId *Oid, oldTarget string) error {
+	loosePath := filepath.Join(b.path, name)
+	lock, err := newLockFile(loosePath, GitRefFileMode)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		lock.Rollback()
+		b.removeEmptyDirs(loosePath)
+	}()
+	current, err := b.Lookup(name)
+	if err != nil {
+		return err
+	}
+	if err := checkReferenceValue(name, current, oldId, oldTarget); err != nil {
+		return err
+	}
+	// the packed reference is removed at first so that deleting the loose
+	// reference doesn't reveal the stale packed value
+	if b.cache.Lookup(name) != nil {
+		if err := b.removePacked(name); err != nil {
+			return err
+		}
+	}
+	if err := os.Remove(loosePath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// gitRefRenameTemporary is the temporary reference to rename "a" to "a/b"
+// and vice versa, which can't exist at the same time.
+const gitRefRenameTemporary = "RENAMED-REF"
+
+// Rename writes the new reference at first and then deletes the old one
+// like git, so the reference isn't lost if it fails on the way.
+func (b *RefDbBackendFiles) Rename(ref *Reference, newName string, force bool) (*Reference, error) {
+	existing, err := b.Lookup(newName)
+	if err != nil && !IsErrorCode(err, ErrNotFound) {
+		return nil, err
+	}
+	if existing != nil && !force {
+		return nil, MakeGitError(fmt.Sprintf("Failed to rename reference to '%s': a reference with that name already exists.", newName), ErrExists)
+	}
+	var oldId *Oid
+	if ref.refType == ReferenceOid {
+		oldId = ref.targetOid
+	}
+	renamed := copyReference(ref, newName)
+	if !strings.HasPrefix(newName, ref.name+"/") && !strings.HasPrefix(ref.name, newName+"/") {
+		if _, err := b.Write(renamed, force, nil, ""); err != nil {
+			return nil, err
+		}
+		if err := b.Delete(ref.name, oldId, ref.targetSymbolic); err != nil {
+			var restoreErr error
+			if existing != nil {
+				_, restoreErr = b.Write(existing, true, nil, "")
+			} else {
+				restoreErr = b.Delete(newName, nil, "")
+			}
+			return nil, withRestoreError(err, restoreErr)
+		}
+		return renamed, nil
+	}
+	temporary := copyReference(ref, gitRefRenameTemporary)
+	if _, err := b.Write(temporary, true, nil, ""); err != nil {
+		return nil, err
+	}
+	if err := b.Delete(ref.name, oldId, ref.targetSymbolic); err != nil {
+		return nil, withRestoreError(err, b.Delete(gitRefRenameTemporary, nil, ""))
+	}
+	if _, err := b.Write(renamed, false, nil, ""); err != nil {
+		_, restoreErr := b.Write(ref, false, nil, "")
+		if restoreErr == nil {
+			restoreErr = b.Delete(gitRefRenameTemporary, nil, "")
+		}
+		return nil, withRestoreError(err, restoreErr)
+	}
+	if err := b.Delete(gitRefRenameTemporary, nil, ""); err != nil {
+		return nil, err
+	}
+	return renamed, nil
+}
+
+// withRestoreError adds the error of restoring the reference to the original
+// error keeping its error code.
+func withRestoreError(err, restoreErr error) error {
+	if restoreErr == nil {
+		return err
+	}
+	msg := fmt.Sprintf("%s (failed to restore the reference: %s)", err.Error(), restoreErr.Error())
+	if gitError, ok := err.(*GitError); ok {
+		return MakeGitError(msg, gitError.Code)
+	}
+	return errors.New(msg)
+}
+
+func (b *RefDbBackendFiles) removePacked(name string) error {
+	lock, err := b.lockPackedRefsWithout([]string{name})
+	if err != nil || lock == nil {
+		return err
+	}
+	return lock.Commit()
+}
+
+// lockPackedRefsWithout locks packed-refs and writes its new content that
+// doesn't have the given references into the lock file. The caller should
+// commit or rollback the returned lock. If none of the references is
+// packed, it returns nil.
+func (b *RefDbBackendFiles) lockPackedRefsWithout(names []string) (*lockFile, error) {
+	lock, err := newLockFile(b.cache.path, GitRefFileMode)
+	if err != nil {
+		return nil, err
+	}
+	b.cache.lock.Lock()
+	defer b.cache.lock.Unlock()
+	if err := b.cache.reloadIfChanged(false); err != nil {
+		lock.Rollback()
+		return nil, err
+	}
+	removed := false
+	for _, name := range names {
+		if b.cache.cacheMap[name] != nil {
+			b.cache.remove(name)
+			removed = true
+		}
+	}
+	if !removed {
+		lock.Rollback()
+		return nil, nil
+	}
+	if err := b.writePackedRefs(lock); err != nil {
+		lock.Rollback()
+		return nil, err
+	}
+	return lock, nil
+}
+
+// writePackedRefs writes the cached packed references to the locked file.
+// All references are peeled so the file can declare "fully-peeled".
+func (b *RefDbBackendFiles) writePackedRefs(lock *lockFile) error {
+	b.cache.sort()
+	var buffer bytes.Buffer
+	buffer.WriteString(GitPackedRefsHeader)
+	for _, item := range b.cache.entries() {
+		peelPackRef(b.repo, item)
+		buffer.WriteString(fmt.Sprintf("%s %s\n", item.oid, item.name))
+		if item.flag&PackRefHasPeel != 0 {
+			buffer.WriteString(fmt.Sprintf("^%s\n", item.peel))
+		}
+	}
+	_, err := lock.Write(buffer.Bytes())
+	// the cache will be reloaded from the new file
+	b.cache.stamp = time.Unix(0, 0)
+	return err
+}
+
+// removeEmptyDirs removes empty parent directories of the reference file.
+// Top level directories like "refs/heads" are kept.
+func (b *RefDbBackendFiles) removeEmptyDirs(path string) {
+	root := filepath.Join(b.path, GitRefsDir)
+	for dir := filepath.Dir(path); strings.HasPrefix(dir, root+string(filepath.Separator)) && filepath.Dir(dir) != root; dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+}
+
+// transaction
+
+type refDbFilesTransaction struct {
+	backend *RefDbBackendFiles
+	locks   map[string]*lockFile
+}
+
+func (b *RefDbBackendFiles) NewTransaction() RefDbTransaction {
+	return &refDbFilesTransaction{
+		backend: b,
+		locks:   make(map[string]*lockFile),
+	}
+}
+
+func (t *refDbFilesTransaction) Lock(name string) error {
+	lock, err := newLockFile(filepath.Join(t.backend.path, name), GitRefFileMode)
+	if err != nil {
+		return err
+	}
+	t.locks[name] = lock
+	return nil
+}
+
+func (t *refDbFilesTransaction) Unlock(name string) {
+	if lock := t.locks[name]; lock != nil {
+		delete(t.locks, name)
+		lock.Rollback()
+		t.backend.removeEmptyDirs(filepath.Join(t.backend.path, name))
+	}
+}
+
+func (t *refDbFilesTransaction) Commit(updates []*RefUpdate) error {
+	defer t.Rollback()
+	var deleted []string
+	for _, update := range updates {
+		if update.Reference == nil {
+			deleted = append(deleted, update.Name)
+		} else if err := writeLooseReference(t.locks[update.Name], update.Reference); err != nil {
+			return err
+		}
+	}
+	// packed references are removed at first so that deleting loose
+	// references doesn't reveal the stale packed value
+	if len(deleted) > 0 {
+		packedLock, err := t.backend.lockPackedRefsWithout(deleted)
+		if err != nil {
+			return err
+		}
+		if packedLock != nil {
+			if err := packedLock.Commit(); err != nil {
+				return err
+			}
+		}
+	}
+	for _, update := range updates {
+		if update.Reference == nil {
+			err := os.Remove(filepath.Join(t.backend.path, update.Name))
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			continue
+		}
+		lock := t.locks[update.Name]
+		delete(t.locks, update.Name)
+		if err := lock.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *refDbFilesTransaction) Rollback() {
+	for name := range t.locks {
+		t.Unlock(name)
+	}
+}
+
+// reflog
+
+func (b *RefDbBackendFiles) reflogPath(name string) string {
+	return filepath.Join(b.repo.pathRepository, GitReflogDir, name)
+}
+
+func (b *RefDbBackendFiles) HasReflog(name string) bool {
+	_, err := os.Stat(b.reflogPath(name))
+	return err == nil
+}
+
+func (b *RefDbBackendFiles) ReadReflog(name string) ([]*ReflogEntry, error) {
+	buffer, err := ioutil.ReadFile(b.reflogPath(name))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return parseReflog(buffer)
+}
+
+func (b *RefDbBackendFiles) WriteReflog(name string, entries []*ReflogEntry) error {
+	var buffer bytes.Buffer
+	for _, entry := range entries {
+		buffer.WriteString(formatReflogEntry(entry.Old, entry.New, entry.Committer, entry.Message))
+	}
+	lock, err := newLockFile(b.reflogPath(name), GitReflogFileMode)
+	if err != nil {
+		return err
+	}
+	if _, err := lock.Write(buffer.Bytes()); err != nil {
+		lock.Rollback()
+		return err
+	}
+	return lock.Commit()
+}
+
+func (b *RefDbBackendFiles) AppendReflog(name string, entry *ReflogEntry) error {
+	path := b.reflogPath(name)
+	if err := os.MkdirAll(filepath.Dir(path), GitReflogDirMode); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, GitReflogFileMode)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.WriteString(formatReflogEntry(entry.Old, entry.New, entry.Committer, entry.Message))
+	return err
+}
+
+func (b *RefDbBackendFiles) RenameReflog(oldName, newName string) error {
+	oldPath := b.reflogPath(oldName)
+	newPath := b.reflogPath(newName)
+	if _, err := os.Stat(oldPath); os.IsNotExist(err) {
+		return nil
+	}
+	// move via a temporary file to allow renaming "a" to "a/b" and vice versa
+	tempPath := filepath.Join(b.repo.pathRepository, GitReflogDir, gitReflogRenameTemporary)
+	if err := os.Rename(oldPath, tempPath); err != nil {
+		return err
+	}
+	b.removeEmptyReflogDirs(oldPath)
+	if info, err := os.Stat(newPath); err == nil && info.IsDir() {
+		if err := os.Remove(newPath); err != nil {
+			os.Rename(tempPath, oldPath)
+			return MakeGitError(fmt.Sprintf("Cannot rename reflog to '%s': directory is not empty", newName), ErrExists)
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(newPath), GitReflogDirMode); err != nil {
+		return err
+	}
+	return os.Rename(tempPath, newPath)
+}
+
+func (b *RefDbBackendFiles) DeleteReflog(name string) error {
+	path := b.reflogPath(name)
+	err := os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	b.removeEmptyReflogDirs(path)
+	return nil
+}
+
+func (b *RefDbBackendFiles) removeEmptyReflogDirs(path string) {
+	root := filepath.Join(b.repo.pathRepository, GitReflogDir)
+	for dir := filepath.Dir(path); strings.HasPrefix(dir, root+string(filepath.Separator)); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package git4go
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// RefDbBackendMemory keeps references and reflogs in memory. It is useful
+// for hermetic tests or as a base of custom backends. Its content is lost
+// when the process exits.
+type RefDbBackendMemory struct {
+	lock    sync.Mutex
+	refs    map[string]*Reference
+	reflogs map[string][]*ReflogEntry
+	locked  map[string]bool
+}
+
+func NewRefDbBackendMemory() *RefDbBackendMemory {
+	return &RefDbBackendMemory{
+		refs:    make(map[string]*Reference),
+		reflogs: make(map[string][]*ReflogEntry),
+		locked:  make(map[string]bool),
+	}
+}
+
+func (b *RefDbBackendMemory) Lookup(name string) (*Reference, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.lookup(name)
+}
+
+func (b *RefDbBackendMemory) lookup(name string) (*Reference, error) {
+	ref, ok := b.refs[name]
+	if !ok {
+		return nil, MakeGitError(fmt.Sprintf("Reference '%s' not found", name), ErrNotFound)
+	}
+	return ref, nil
+}
+
+// ForEach visits the references in name order. The callback can modify
+// the backend because it is called with a snapshot.
+func (b *RefDbBackendMemory) ForEach(pattern string, callback ForEachReferenceCallback) error {
+	b.lock.Lock()
+	var refs []*Reference
+	for name, ref := range b.refs {
+		if !strings.HasPrefix(name, GitRefsDir) {
+			continue
+		}
+		if pattern != "" && !fnMatch(pattern, name, 0) {
+			continue
+		}
+		refs = append(refs, ref)
+	}
+	b.lock.Unlock()
+	sort.Sort(referencesByName(refs))
+	for _, ref := range refs {
+		if err := callback(ref); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *RefDbBackendMemory) Write(ref *Reference, force bool, oldId *Oid, oldTarget string) (*Reference, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if err := b.checkUnlocked(ref.name); err != nil {
+		return nil, err
+	}
+	current, _ := b.lookup(ref.name)
+	if current != nil && !force {
+		return nil, MakeGitError(fmt.Sprintf("Failed to write reference '%s': a reference with that name already exists.", ref.name), ErrExists)
+	}
+	if err := checkReferenceValue(ref.name, current, oldId, oldTarget); err != nil {
+		return nil, err
+	}
+	b.refs[ref.name] = copyReference(ref, ref.name)
+	return current, nil
+}
+
+func (b *RefDbBackendMemory) Delete(name string, oldId *Oid, oldTarget string) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if err := b.checkUnlocked(name); err != nil {
+		return err
+	}
+	current, err := b.lookup(name)
+	if err != nil {
+		return err
+	}
+	if err := checkReferenceValue(name, current, oldId, oldTarget); err != nil {
+		return err
+	}
+	delete(b.refs, name)
+	return nil
+}
+
+func (b *RefDbBackendMemory) Rename(ref *Reference, newName string, force bool) (*Reference, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if err := b.checkUnlocked(ref.name); err != nil {
+		return nil, err
+	}
+	if err := b.checkUnlocked(newName); err != nil {
+		return nil, err
+	}
+	if existing, _ := b.lookup(newName); existing != nil && !force {
+		return nil, MakeGitError(fmt.Sprintf("Failed to rename reference to '%s': a reference with that name already exists.", newName), ErrExists)
+	}
+	current, err := b.lookup(ref.name)
+	if err != nil {
+		return nil, err
+	}
+	var oldId *Oid
+	if ref.refType == ReferenceOid {
+		oldId = ref.targetOid
+	}
+	if err := checkReferenceValue(ref.name, current, oldId, ref.targetSymbolic); err != nil {
+		return nil, err
+	}
+	renamed := copyReference(current, newName)
+	delete(b.refs, ref.name)
+	b.refs[newName] = renamed
+	return renamed, nil
+}
+
+// PackAll does nothing.
+func (b *RefDbBackendMemory) PackAll(prune bool) error {
+	return nil
+}
+
+func (b *RefDbBackendMemory) checkUnlocked(name string) error {
+	if b.locked[name] {
+		return MakeGitError(fmt.Sprintf("Failed to lock reference '%s': it is locked by a transaction", name), ErrLocked)
+	}
+	return nil
+}
+
+// transaction
+
+type refDbMemoryTransaction struct {
+	backend *RefDbBackendMemory
+	names   map[string]bool
+}
+
+func (b *RefDbBackendMemory) NewTransaction() RefDbTransaction {
+	return &refDbMemoryTransaction{
+		backend: b,
+		names:   make(map[string]bool),
+	}
+}
+
+func (t *refDbMemoryTransaction) Lock(name string) error {
+	t.backend.lock.Lock()
+	defer t.backend.lock.Unlock()
+	if err := t.backend.checkUnlocked(name); err != nil {
+		return err
+	}
+	t.backend.locked[name] = true
+	t.names[name] = true
+	return nil
+}
+
+func (t *refDbMemoryTransaction) Unlock(name string) {
+	t.backend.lock.Lock()
+	defer t.backend.lock.Unlock()
+	if t.names[name] {
+		delete(t.names, name)
+		delete(t.backend.locked, name)
+	}
+}
+
+func (t *refDbMemoryTransaction) Commit(updates []*RefUpdate) error {
+	t.backend.lock.Lock()
+	for _, update := range updates {
+		if update.Reference == nil {
+			delete(t.backend.refs, update.Name)
+		} else {
+			t.backend.refs[update.Name] = copyReference(update.Reference, update.Name)
+		}
+	}
+	t.backend.lock.Unlock()
+	t.Rollback()
+	return nil
+}
+
+func (t *refDbMemoryTransaction) Rollback() {
+	for name := range t.names {
+		t.Unlock(name)
+	}
+}
+
+// reflog
+
+func (b *RefDbBackendMemory) HasReflog(name string) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	_, ok := b.reflogs[name]
+	return ok
+}
+
+func (b *RefDbBackendMemory) ReadReflog(name string) ([]*ReflogEntry, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	// Reflog.Drop() modifies the entries
+	var entries []*ReflogEntry
+	for _, entry := range b.reflogs[name] {
+		copied := *entry
+		entries = append(entries, &copied)
+	}
+	return entries, nil
+}
+
+func (b *RefDbBackendMemory) WriteReflog(name string, entries []*ReflogEntry) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.reflogs[name] = append([]*ReflogEntry{}, entries...)
+	return nil
+}
+
+func (b *RefDbBackendMemory) AppendReflog(name string, entry *ReflogEntry) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.reflogs[name] = append(b.reflogs[name], entry)
+	return nil
+}
+
+func (b *RefDbBackendMemory) RenameReflog(oldName, newName string) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	entries, ok := b.reflogs[oldName]
+	if !ok {
+		return nil
+	}
+	delete(b.reflogs, oldName)
+	b.reflogs[newName] = entries
+	return nil
+}
+
+func (b *RefDbBackendMemory) DeleteReflog(name string) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	delete(b.reflogs, name)
+	return nil
+}
+
+func copyReference(ref *Reference, name string) *Reference {
+	return &Reference{
+		refType:        ref.refType,
+		targetSymbolic: ref.targetSymbolic,
+		targetOid:      ref.targetOid,
+		name:           name,
+	}
+}
+
+type referencesByName []*Reference
+
+func (r referencesByName) Len() int           { return len(r) }
+func (r referencesByName) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+func (r referencesByName) Less(i, j int) bool { return r[i].name < r[j].name }
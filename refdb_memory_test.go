@@ -0,0 +1,149 @@
+package git4go
+
+import (
+	"./testutil"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func openMemoryRefDbRepository() *Repository {
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	repo.NewRefDb().SetBackend(NewRefDbBackendMemory())
+	return repo
+}
+
+func Test_RefDbBackendMemory_References(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo := openMemoryRefDbRepository()
+	master, _ := NewOid("a65fedf39aefe402d3bb6e24df4d4f5fe4547750")
+	br2, _ := NewOid("a4a7dce85cf63874e984719f4fdd239f5145052f")
+
+	if _, err := repo.LookupReference("refs/heads/master"); !IsErrorCode(err, ErrNotFound) {
+		t.Error("memory backend should start empty:", err)
+	}
+	if _, err := repo.CreateReference("refs/heads/master", master, false, nil, "init"); err != nil {
+		t.Error("err should be nil:", err)
+	}
+	if _, err := repo.CreateReference("refs/heads/br2", br2, false, nil, "init"); err != nil {
+		t.Error("err should be nil:", err)
+	}
+	if _, err := repo.CreateSymbolicReference(GitHeadFile, "refs/heads/master", true, nil, ""); err != nil {
+		t.Error("err should be nil:", err)
+	}
+	if _, err := repo.CreateReference("refs/heads/master", br2, false, nil, ""); !IsErrorCode(err, ErrExists) {
+		t.Error("existing reference should not be overwritten:", err)
+	}
+	if _, err := repo.CreateReference("refs/heads/master/child", br2, false, nil, ""); !IsErrorCode(err, ErrExists) {
+		t.Error("conflicting name should be rejected:", err)
+	}
+
+	head, err := repo.Head()
+	if err != nil || !head.Target().Equal(master) || head.Owner() != repo {
+		t.Error("HEAD should be resolved:", head, err)
+	}
+	obj, err := repo.RevparseSingle("br2")
+	if err != nil || !obj.Id().Equal(br2) {
+		t.Error("revparse should use the backend:", err)
+	}
+	var names []string
+	repo.ForEachGlobReferenceName("refs/heads/*", func(name string) error {
+		names = append(names, name)
+		return nil
+	})
+	if len(names) != 2 || names[0] != "refs/heads/br2" || names[1] != "refs/heads/master" {
+		t.Error("names are wrong:", names)
+	}
+
+	ref, _ := repo.LookupReference("refs/heads/master")
+	renamed, err := ref.Rename("refs/heads/main", false, nil, "rename")
+	if err != nil || renamed.Name() != "refs/heads/main" {
+		t.Error("err should be nil:", err)
+	}
+	head, _ = repo.LookupReference(GitHeadFile)
+	if head.SymbolicTarget() != "refs/heads/main" {
+		t.Error("HEAD should follow the renamed branch:", head.SymbolicTarget())
+	}
+	if err := ref.Delete(); !IsErrorCode(err, ErrNotFound) {
+		t.Error("renamed reference should not exist:", err)
+	}
+	if err := renamed.Delete(); err != nil {
+		t.Error("err should be nil:", err)
+	}
+	if _, err := repo.LookupReference("refs/heads/main"); !IsErrorCode(err, ErrNotFound) {
+		t.Error("reference should be deleted:", err)
+	}
+
+	content, _ := ioutil.ReadFile("test_resources/testrepo.git/refs/heads/master")
+	if string(content) != master.String()+"\n" {
+		t.Error("files on disk should not be changed:", string(content))
+	}
+	if _, err := os.Stat("test_resources/testrepo.git/refs/heads/main"); !os.IsNotExist(err) {
+		t.Error("files on disk should not be created")
+	}
+}
+
+func Test_RefDbBackendMemory_ReflogAndTransaction(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo := openMemoryRefDbRepository()
+	master, _ := NewOid("a65fedf39aefe402d3bb6e24df4d4f5fe4547750")
+	br2, _ := NewOid("a4a7dce85cf63874e984719f4fdd239f5145052f")
+
+	repo.CreateReference("refs/heads/master", master, false, nil, "first")
+	repo.CreateReference("refs/heads/master", br2, true, nil, "second")
+	reflog, err := repo.ReadReflog("refs/heads/master")
+	if err != nil || reflog.EntryCount() != 2 {
+		t.Error("reflog should be written:", err)
+		return
+	}
+	if reflog.EntryByIndex(0).Message != "second" || !reflog.EntryByIndex(0).Old.Equal(master) {
+		t.Error("latest entry is wrong:", reflog.EntryByIndex(0))
+	}
+	reflog.Drop(0, true)
+	if err := reflog.Write(); err != nil {
+		t.Error("err should be nil:", err)
+	}
+	reflog, _ = repo.ReadReflog("refs/heads/master")
+	if reflog.EntryCount() != 1 {
+		t.Error("reflog should be rewritten:", reflog.EntryCount())
+	}
+
+	tx := repo.NewRefTransaction()
+	if err := tx.Update("refs/heads/master", master, br2, nil, "tx"); err != nil {
+		t.Error("err should be nil:", err)
+	}
+	if err := tx.Create("refs/heads/topic", br2, nil, "tx"); err != nil {
+		t.Error("err should be nil:", err)
+	}
+	if _, err := repo.CreateReference("refs/heads/topic", master, true, nil, ""); !IsErrorCode(err, ErrLocked) {
+		t.Error("queued reference should be locked:", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Error("err should be nil:", err)
+	}
+	ref, _ := repo.LookupReference("refs/heads/master")
+	if ref == nil || !ref.Target().Equal(master) {
+		t.Error("master should be updated")
+	}
+	ref, _ = repo.LookupReference("refs/heads/topic")
+	if ref == nil || !ref.Target().Equal(br2) {
+		t.Error("topic should be created")
+	}
+
+	tx = repo.NewRefTransaction()
+	tx.Delete("refs/heads/topic", nil)
+	if err := tx.Update("refs/heads/master", br2, br2, nil, ""); !IsErrorCode(err, ErrModified) {
+		t.Error("old value should be checked:", err)
+	}
+	tx.Rollback()
+	if _, err := repo.CreateReference("refs/heads/master", br2, true, nil, ""); err != nil {
+		t.Error("lock should be released:", err)
+	}
+	if _, err := repo.LookupReference("refs/heads/topic"); err != nil {
+		t.Error("rolled back deletion should not be applied:", err)
+	}
+}
@@ -0,0 +1,345 @@
+package git4go
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// RefDbBackendReftable stores references and reflogs in the reftable stack
+// under "reftable/".
+type RefDbBackendReftable struct {
+	repo  *Repository
+	stack *reftableStack
+}
+
+func NewRefDbBackendReftable(repo *Repository) *RefDbBackendReftable {
+	return &RefDbBackendReftable{
+		repo:  repo,
+		stack: newReftableStack(filepath.Join(repo.pathRepository, GitReftableDir)),
+	}
+}
+
+func (b *RefDbBackendReftable) Lookup(name string) (*Reference, error) {
+	record, err := b.stack.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	if record == nil {
+		return nil, MakeGitError(fmt.Sprintf("Reference '%s' not found", name), ErrNotFound)
+	}
+	return record.reference(b.repo), nil
+}
+
+func (b *RefDbBackendReftable) ForEach(pattern string, callback ForEachReferenceCallback) error {
+	records, err := b.stack.refs()
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		if !strings.HasPrefix(record.name, GitRefsDir) {
+			continue
+		}
+		if pattern != "" && !fnMatch(pattern, record.name, 0) {
+			continue
+		}
+		if err := callback(record.reference(b.repo)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *RefDbBackendReftable) Write(ref *Reference, force bool, oldId *Oid, oldTarget string) (*Reference, error) {
+	var previous *Reference
+	err := b.stack.add(func(updateIndex uint64) ([]*reftableRef, []*reftableLog, error) {
+		current, err := b.Lookup(ref.name)
+		if err != nil && !IsErrorCode(err, ErrNotFound) {
+			return nil, nil, err
+		}
+		if current != nil && !force {
+			return nil, nil, MakeGitError(fmt.Sprintf("Failed to write reference '%s': a reference with that name already exists.", ref.name), ErrExists)
+		}
+		if err := checkReferenceValue(ref.name, current, oldId, oldTarget); err != nil {
+			return nil, nil, err
+		}
+		previous = current
+		return []*reftableRef{newReftableRef(b.repo, ref, updateIndex)}, nil, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return previous, nil
+}
+
+func (b *RefDbBackendReftable) Delete(name string, oldId *Oid, oldTarget string) error {
+	return b.stack.add(func(updateIndex uint64) ([]*reftableRef, []*reftableLog, error) {
+		current, err := b.Lookup(name)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := checkReferenceValue(name, current, oldId, oldTarget); err != nil {
+			return nil, nil, err
+		}
+		return []*reftableRef{newReftableDeletion(name, updateIndex)}, nil, nil
+	})
+}
+
+// Rename writes the deletion and the new reference into one table, so the
+// rename is atomic.
+func (b *RefDbBackendReftable) Rename(ref *Reference, newName string, force bool) (*Reference, error) {
+	renamed := &Reference{
+		refType:        ref.refType,
+		repo:           ref.repo,
+		targetSymbolic: ref.targetSymbolic,
+		targetOid:      ref.targetOid,
+		name:           newName,
+	}
+	err := b.stack.add(func(updateIndex uint64) ([]*reftableRef, []*reftableLog, error) {
+		existing, err := b.Lookup(newName)
+		if err != nil && !IsErrorCode(err, ErrNotFound) {
+			return nil, nil, err
+		}
+		if existing != nil && !force {
+			return nil, nil, MakeGitError(fmt.Sprintf("Failed to rename reference to '%s': a reference with that name already exists.", newName), ErrExists)
+		}
+		current, err := b.Lookup(ref.name)
+		if err != nil {
+			return nil, nil, err
+		}
+		var oldId *Oid
+		if ref.refType == ReferenceOid {
+			oldId = ref.targetOid
+		}
+		if err := checkReferenceValue(ref.name, current, oldId, ref.targetSymbolic); err != nil {
+			return nil, nil, err
+		}
+		return []*reftableRef{
+			newReftableDeletion(ref.name, updateIndex),
+			newReftableRef(b.repo, renamed, updateIndex),
+		}, nil, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return renamed, nil
+}
+
+// PackAll compacts all tables into one table.
+func (b *RefDbBackendReftable) PackAll(prune bool) error {
+	return b.stack.compactAll()
+}
+
+// transaction
+
+// refDbReftableTransaction locks the whole stack at the first Lock() and
+// writes all updates into one table.
+type refDbReftableTransaction struct {
+	backend *RefDbBackendReftable
+	lock    *lockFile
+	names   map[string]bool
+}
+
+func (b *RefDbBackendReftable) NewTransaction() RefDbTransaction {
+	return &refDbReftableTransaction{
+		backend: b,
+		names:   make(map[string]bool),
+	}
+}
+
+func (t *refDbReftableTransaction) Lock(name string) error {
+	if t.lock == nil {
+		lock, err := t.backend.stack.lockList()
+		if err != nil {
+			return err
+		}
+		t.lock = lock
+	}
+	t.names[name] = true
+	return nil
+}
+
+// Unlock releases the stack when no reference is locked anymore.
+func (t *refDbReftableTransaction) Unlock(name string) {
+	delete(t.names, name)
+	if len(t.names) == 0 {
+		t.Rollback()
+	}
+}
+
+func (t *refDbReftableTransaction) Commit(updates []*RefUpdate) error {
+	defer t.Rollback()
+	if t.lock == nil {
+		return nil
+	}
+	err := t.backend.stack.addLocked(t.lock, func(updateIndex uint64) ([]*reftableRef, []*reftableLog, error) {
+		var records []*reftableRef
+		for _, update := range updates {
+			if update.Reference == nil {
+				records = append(records, newReftableDeletion(update.Name, updateIndex))
+			} else {
+				records = append(records, newReftableRef(t.backend.repo, update.Reference, updateIndex))
+			}
+		}
+		return records, nil, nil
+	})
+	if err != nil {
+		return err
+	}
+	lock := t.lock
+	t.lock = nil
+	if err := lock.Commit(); err != nil {
+		return err
+	}
+	return t.backend.stack.autoCompact()
+}
+
+func (t *refDbReftableTransaction) Rollback() {
+	t.names = make(map[string]bool)
+	if t.lock != nil {
+		t.lock.Rollback()
+		t.lock = nil
+	}
+}
+
+// reflog
+
+func (b *RefDbBackendReftable) HasReflog(name string) bool {
+	logs, err := b.stack.logs(name)
+	return err == nil && len(logs) > 0
+}
+
+func (b *RefDbBackendReftable) ReadReflog(name string) ([]*ReflogEntry, error) {
+	logs, err := b.stack.logs(name)
+	if err != nil {
+		return nil, err
+	}
+	var entries []*ReflogEntry
+	for _, log := range logs {
+		entries = append(entries, &ReflogEntry{
+			Old:       log.old,
+			New:       log.new,
+			Committer: log.committer,
+			Message:   log.message,
+		})
+	}
+	return entries, nil
+}
+
+func (b *RefDbBackendReftable) WriteReflog(name string, entries []*ReflogEntry) error {
+	return b.rewriteLogs(name, name, append([]*ReflogEntry{}, entries...))
+}
+
+func (b *RefDbBackendReftable) AppendReflog(name string, entry *ReflogEntry) error {
+	return b.stack.add(func(updateIndex uint64) ([]*reftableRef, []*reftableLog, error) {
+		return nil, []*reftableLog{{
+			name:        name,
+			updateIndex: updateIndex,
+			old:         entry.Old,
+			new:         entry.New,
+			committer:   entry.Committer,
+			message:     entry.Message,
+		}}, nil
+	})
+}
+
+func (b *RefDbBackendReftable) RenameReflog(oldName, newName string) error {
+	return b.rewriteLogs(oldName, newName, nil)
+}
+
+func (b *RefDbBackendReftable) DeleteReflog(name string) error {
+	return b.rewriteLogs(name, "", nil)
+}
+
+// rewriteLogs replaces the logs of the reference. The existing logs of
+// oldName are deleted and moved to newName. If entries is not nil, they
+// are written as the new logs of newName instead.
+func (b *RefDbBackendReftable) rewriteLogs(oldName, newName string, entries []*ReflogEntry) error {
+	stack := b.stack
+	return stack.add(func(updateIndex uint64) ([]*reftableRef, []*reftableLog, error) {
+		existing, err := stack.logs(oldName)
+		if err != nil {
+			return nil, nil, err
+		}
+		if newName != "" && newName != oldName {
+			replaced, err := stack.logs(newName)
+			if err != nil {
+				return nil, nil, err
+			}
+			existing = append(existing, replaced...)
+		}
+		if len(existing) == 0 && entries == nil {
+			return nil, nil, nil
+		}
+		// later records override the earlier ones with the same key
+		records := make(map[string]*reftableLog)
+		for _, log := range existing {
+			records[string(log.key())] = &reftableLog{
+				name:        log.name,
+				updateIndex: log.updateIndex,
+				deleted:     true,
+			}
+		}
+		if entries != nil {
+			for i, entry := range entries {
+				log := &reftableLog{
+					name:        newName,
+					updateIndex: updateIndex + uint64(i),
+					old:         entry.Old,
+					new:         entry.New,
+					committer:   entry.Committer,
+					message:     entry.Message,
+				}
+				records[string(log.key())] = log
+			}
+		} else if newName != "" {
+			for _, log := range existing {
+				if log.name != oldName {
+					continue
+				}
+				moved := *log
+				moved.name = newName
+				records[string(moved.key())] = &moved
+			}
+		}
+		var logs []*reftableLog
+		for _, log := range records {
+			logs = append(logs, log)
+		}
+		return nil, logs, nil
+	})
+}
+
+// newReftableRef makes the record of the reference. Annotated tags are
+// stored with their peeled value.
+func newReftableRef(repo *Repository, ref *Reference, updateIndex uint64) *reftableRef {
+	if ref.refType == ReferenceSymbolic {
+		return &reftableRef{
+			name:        ref.name,
+			updateIndex: updateIndex,
+			valueType:   reftableRefSymbolic,
+			target:      ref.targetSymbolic,
+		}
+	}
+	record := &reftableRef{
+		name:        ref.name,
+		updateIndex: updateIndex,
+		valueType:   reftableRefValue,
+		value:       ref.targetOid,
+	}
+	item := &PackRef{oid: ref.targetOid}
+	peelPackRef(repo, item)
+	if item.flag&PackRefHasPeel != 0 {
+		record.valueType = reftableRefPeeled
+		record.peeled = item.peel
+	}
+	return record
+}
+
+func newReftableDeletion(name string, updateIndex uint64) *reftableRef {
+	return &reftableRef{
+		name:        name,
+		updateIndex: updateIndex,
+		valueType:   reftableRefDeletion,
+	}
+}
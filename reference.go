@@ -4,7 +4,6 @@ import (
 	"errors"
 	"fmt"
 	"golang.org/x/text/unicode/norm"
-	"os"
 	"path/filepath"
 	"strings"
 )
@@ -57,153 +56,105 @@ func (r *Repository) DwimReference(name string) (*Reference, error) {
 			return ref, nil
 		}
 	}
-	return nil, errors.New(fmt.Sprintf("Could not use '%s' as valid reference name", name))
+	return nil, MakeGitError(fmt.Sprintf("Could not use '%s' as valid reference name", name), ErrNotFound)
 }
 
-type ForEachReferenceNameCallback func(string) error
+// CreateReference creates a new direct reference. If force is true and
+// a reference already exists with the given name, it'll be replaced.
+func (r *Repository) CreateReference(name string, id *Oid, force bool, sig *Signature, msg string) (*Reference, error) {
+	return r.CreateReferenceMatching(name, id, force, nil, sig, msg)
+}
 
-func (r *Repository) ForEachReferenceName(callback ForEachReferenceNameCallback) error {
-	rootDir := filepath.Join(r.pathRepository, GitRefsDir)
-	processed := make(map[string]bool)
-	offset := len(r.pathRepository)
-	err := filepath.Walk(rootDir, func(path string, info os.FileInfo, err error) error {
-		if info.IsDir() {
-			return nil
-		}
-		path = path[offset:]
-		processed[path] = true
-		return callback(path)
-	})
+// CreateReferenceMatching is the same as CreateReference, but it updates
+// the reference only if its current value is currentId. A zero currentId
+// means the reference should not exist yet.
+func (r *Repository) CreateReferenceMatching(name string, id *Oid, force bool, currentId *Oid, sig *Signature, msg string) (*Reference, error) {
+	normalized, err := referenceNormalizeForWrite(r, name)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	refDb := r.NewRefDb()
-	refs, err := refDb.GetPackedReferences()
+	odb, err := r.Odb()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	for _, ref := range refs {
-		if !processed[ref.name] {
-			err = callback(ref.name)
-			if err != nil {
-				return err
-			}
-		}
+	if !odb.Exists(id) {
+		return nil, MakeGitError(fmt.Sprintf("Target OID %s for the reference doesn't exist on the repository", id), ErrNotFound)
 	}
-	return nil
+	ref := &Reference{
+		refType:   ReferenceOid,
+		repo:      r,
+		targetOid: id.Copy(),
+		name:      normalized,
+	}
+	previous, err := r.NewRefDb().write(ref, force, currentId, "")
+	if err != nil {
+		return nil, err
+	}
+	err = logReferenceUpdate(r, normalized, resolvedTarget(previous), id, sig, msg)
+	if err != nil {
+		return nil, err
+	}
+	return ref, nil
 }
 
-type ForEachReferenceCallback func(*Reference) error
+// CreateSymbolicReference creates a new symbolic reference like HEAD.
+func (r *Repository) CreateSymbolicReference(name, target string, force bool, sig *Signature, msg string) (*Reference, error) {
+	return r.CreateSymbolicReferenceMatching(name, target, force, "", sig, msg)
+}
 
-func (r *Repository) ForEachReference(callback ForEachReferenceCallback) error {
-	rootDir := filepath.Join(r.pathRepository, GitRefsDir)
-	processed := make(map[string]bool)
-	offset := len(rootDir) - 4
-	err := filepath.Walk(rootDir, func(path string, info os.FileInfo, err error) error {
-		if info.IsDir() {
-			return nil
-		}
-		path = path[offset:]
-		ref, err := r.LookupReference(path)
-		if err == nil {
-			processed[path] = true
-			return callback(ref)
-		}
-		return nil // ignore error
-	})
+// CreateSymbolicReferenceMatching is the same as CreateSymbolicReference,
+// but it updates the reference only if its current target is currentValue.
+func (r *Repository) CreateSymbolicReferenceMatching(name, target string, force bool, currentValue string, sig *Signature, msg string) (*Reference, error) {
+	normalized, err := referenceNormalizeForWrite(r, name)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	refDb := r.NewRefDb()
-	refs, err := refDb.GetPackedReferences()
+	normalizedTarget, err := referenceNormalizeForWrite(r, target)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	for _, ref := range refs {
-		if !processed[ref.name] {
-			err = callback(ref)
-			if err != nil {
-				return err
-			}
+	ref := &Reference{
+		refType:        ReferenceSymbolic,
+		repo:           r,
+		targetSymbolic: normalizedTarget,
+		name:           normalized,
+	}
+	previous, err := r.NewRefDb().write(ref, force, nil, currentValue)
+	if err != nil {
+		return nil, err
+	}
+	// the reflog records the commit that the reference points to
+	if newId := resolvedTarget(ref); newId != nil {
+		err = appendReflogForUpdate(r, normalized, resolvedTarget(previous), newId, sig, msg)
+		if err != nil {
+			return nil, err
 		}
 	}
-	return nil
+	return ref, nil
+}
+
+type ForEachReferenceNameCallback func(string) error
+
+func (r *Repository) ForEachReferenceName(callback ForEachReferenceNameCallback) error {
+	return r.NewRefDb().forEach("", func(ref *Reference) error {
+		return callback(ref.name)
+	})
+}
+
+type ForEachReferenceCallback func(*Reference) error
+
+func (r *Repository) ForEachReference(callback ForEachReferenceCallback) error {
+	return r.NewRefDb().forEach("", callback)
 }
 
 func (r *Repository) ForEachGlobReferenceName(pattern string, callback ForEachReferenceNameCallback) error {
-	rootDir := filepath.Join(r.pathRepository, GitRefsDir)
-	processed := make(map[string]bool)
-	offset := len(r.pathRepository)
-	err := filepath.Walk(rootDir, func(path string, info os.FileInfo, err error) error {
-		if info.IsDir() {
-			return nil
-		}
-		path = path[offset:]
-		processed[path] = true
-		if fnMatch(pattern, path, 0) {
-			return callback(path)
-		}
-		return nil
+	return r.NewRefDb().forEach(pattern, func(ref *Reference) error {
+		return callback(ref.name)
 	})
-	if err != nil {
-		return err
-	}
-	refDb := r.NewRefDb()
-	refs, err := refDb.GetPackedReferences()
-	if err != nil {
-		return err
-	}
-	for _, ref := range refs {
-		if !processed[ref.name] {
-			if fnMatch(pattern, ref.name, 0) {
-				err = callback(ref.name)
-				if err != nil {
-					return err
-				}
-			}
-		}
-	}
-	return nil
 }
 
 func (r *Repository) ForEachGlobReference(pattern string, callback ForEachReferenceCallback) error {
-	rootDir := filepath.Join(r.pathRepository, GitRefsDir)
-	processed := make(map[string]bool)
-	offset := len(r.pathRepository)
-	err := filepath.Walk(rootDir, func(path string, info os.FileInfo, err error) error {
-		if info.IsDir() {
-			return nil
-		}
-		path = path[offset:]
-		processed[path] = true
-		if fnMatch(pattern, path, 0) {
-			ref, err := r.LookupReference(path)
-			if err == nil {
-				return callback(ref)
-			}
-			return nil // ignore error
-		}
-		return nil
-	})
-	if err != nil {
-		return err
-	}
-	refDb := r.NewRefDb()
-	refs, err := refDb.GetPackedReferences()
-	if err != nil {
-		return err
-	}
-	for _, ref := range refs {
-		if !processed[ref.name] {
-			if fnMatch(pattern, ref.name, 0) {
-				err = callback(ref)
-				if err != nil {
-					return err
-				}
-			}
-		}
-	}
-	return nil
+	return r.NewRefDb().forEach(pattern, callback)
 }
 
 // Reference type and its methods
@@ -240,15 +191,15 @@ func (r *Reference) Type() ReferenceType {
 }
 
 func (r *Reference) IsBranch() bool {
-	return false
+	return strings.HasPrefix(r.name, GitRefsHeadsDir)
 }
 
 func (r *Reference) IsRemote() bool {
-	return false
+	return strings.HasPrefix(r.name, GitRefsRemotesDir)
 }
 
 func (r *Reference) IsTag() bool {
-	return false
+	return strings.HasPrefix(r.name, GitRefsTagsDir+"/")
 }
 
 func (r *Reference) Resolve() (*Reference, error) {
@@ -259,6 +210,71 @@ func (r *Reference) Resolve() (*Reference, error) {
 	}
 }
 
+// SetTarget updates the direct reference to point to the new id. It fails
+// with ErrModified if the reference was changed after it was read.
+func (r *Reference) SetTarget(target *Oid, sig *Signature, msg string) (*Reference, error) {
+	if r.refType != ReferenceOid {
+		return nil, errors.New("Cannot set OID on symbolic reference")
+	}
+	return r.repo.CreateReferenceMatching(r.name, target, true, r.targetOid, sig, msg)
+}
+
+// SetSymbolicTarget updates the symbolic reference to point to the new
+// reference name.
+func (r *Reference) SetSymbolicTarget(target string, sig *Signature, msg string) (*Reference, error) {
+	if r.refType != ReferenceSymbolic {
+		return nil, errors.New("Cannot set symbolic target on a direct reference")
+	}
+	return r.repo.CreateSymbolicReferenceMatching(r.name, target, true, r.targetSymbolic, sig, msg)
+}
+
+// Rename renames the reference with its reflog. If HEAD points to the
+// reference, HEAD is updated too.
+func (r *Reference) Rename(name string, force bool, sig *Signature, msg string) (*Reference, error) {
+	normalized, err := referenceNormalizeForWrite(r.repo, name)
+	if err != nil {
+		return nil, err
+	}
+	refDb := r.repo.NewRefDb()
+	head, _ := refDb.Lookup(GitHeadFile)
+	renamed, err := refDb.rename(r, normalized, force)
+	if err != nil {
+		return nil, err
+	}
+	err = r.repo.RenameReflog(r.name, normalized)
+	if err != nil {
+		return nil, err
+	}
+	if target := resolvedTarget(renamed); target != nil {
+		err = appendReflogForUpdate(r.repo, normalized, target, target, sig, msg)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if head != nil && head.refType == ReferenceSymbolic && head.targetSymbolic == r.name {
+		head.targetSymbolic = normalized
+		_, err = refDb.write(head, true, nil, r.name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return renamed, nil
+}
+
+// Delete removes the reference and its reflog. It fails with ErrModified
+// if the reference was changed after it was read.
+func (r *Reference) Delete() error {
+	var oldId *Oid
+	if r.refType == ReferenceOid {
+		oldId = r.targetOid
+	}
+	err := r.repo.NewRefDb().delete(r.name, oldId, r.targetSymbolic)
+	if err != nil {
+		return err
+	}
+	return r.repo.DeleteReflog(r.name)
+}
+
 /*type ReferenceIterator struct {
 	repo *Repository
 }
@@ -327,13 +343,67 @@ func referenceLookupResolved(repo *Repository, name string, maxNesting int) (*Re
 	return ref, nil
 }
 
+// referenceNormalizeForWrite validates the name of a reference to be written.
+// One-level names are allowed only for special references like "HEAD" or
+// "FETCH_HEAD".
+func referenceNormalizeForWrite(repo *Repository, name string) (string, error) {
+	normalized, err := referenceNormalize(name, repo.NewRefDb().precomposeUnicode, true)
+	if err != nil {
+		return "", err
+	}
+	if strings.IndexByte(normalized, '/') == -1 {
+		for _, c := range normalized {
+			if !('A' <= c && c <= 'Z') && c != '_' {
+				return "", MakeGitError(fmt.Sprintf("The given reference name '%s' is not valid", name), ErrInvalidSpec)
+			}
+		}
+	}
+	return normalized, nil
+}
+
+// resolvedTarget returns the id which the reference finally points to,
+// or nil if the reference is nil or can't be resolved.
+func resolvedTarget(ref *Reference) *Oid {
+	if ref == nil {
+		return nil
+	}
+	resolved, err := ref.Resolve()
+	if err != nil {
+		return nil
+	}
+	return resolved.targetOid
+}
+
+// logReferenceUpdate writes the reflog of the updated reference. When HEAD
+// points to the reference, the reflog of HEAD is written too like git does.
+func logReferenceUpdate(repo *Repository, name string, oldId, newId *Oid, sig *Signature, msg string) error {
+	err := appendReflogForUpdate(repo, name, oldId, newId, sig, msg)
+	if err != nil || name == GitHeadFile {
+		return err
+	}
+	head, err := repo.NewRefDb().Lookup(GitHeadFile)
+	if err == nil && head.refType == ReferenceSymbolic && head.targetSymbolic == name {
+		return appendReflogForUpdate(repo, GitHeadFile, oldId, newId, sig, msg)
+	}
+	return nil
+}
+
 func referenceNormalize(name string, precomposeUnicode, allowOneLevel bool) (string, error) {
 	invalid := false
 	if len(name) == 0 {
 		invalid = true
-	} else if name[0] == '/' {
+	} else if name[0] == '/' || name == "@" || strings.Contains(name, "@{") || strings.HasSuffix(name, ".lock") {
+		invalid = true
+	} else if strings.ContainsAny(name, " ~^:?*[\\\x7f") || strings.IndexFunc(name, isControlRune) != -1 {
 		invalid = true
 	} else {
+		for _, component := range strings.Split(name, "/") {
+			if strings.HasPrefix(component, ".") || strings.HasSuffix(component, ".lock") {
+				invalid = true
+			}
+		}
+	}
+	if !invalid {
 		name = filepath.Clean(name)
 		lastChar := name[len(name)-1]
 		if lastChar == '.' || lastChar == '/' {
@@ -344,10 +414,14 @@ func referenceNormalize(name string, precomposeUnicode, allowOneLevel bool) (str
 		}
 	}
 	if invalid {
-		return "", errors.New(fmt.Sprintf("The given reference name '%s' is not valid", name))
+		return "", MakeGitError(fmt.Sprintf("The given reference name '%s' is not valid", name), ErrInvalidSpec)
 	}
 	if precomposeUnicode {
 		name = norm.NFC.String(name)
 	}
 	return name, nil
 }
+
+func isControlRune(r rune) bool {
+	return r < 0x20
+}
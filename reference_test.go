@@ -2,6 +2,10 @@ package git4go
 
 import (
 	"./testutil"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
 	"testing"
 )
 
@@ -175,3 +179,375 @@ func Test_ForEachGlobReference(t *testing.T) {
 		t.Error("it should have references in repository:", len(names), names)
 	}
 }
+
+func Test_ForEachGlobReference_Prefix(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	cases := []struct {
+		pattern  string
+		expected string
+	}{
+		{"refs/heads/packed*", "refs/heads/packed refs/heads/packed-test"},
+		{"refs/heads/packed/*", ""},
+		{"refs/heads/master/*", ""},
+		{"refs/heads/br2", "refs/heads/br2"},
+	}
+	for _, c := range cases {
+		var names []string
+		err := repo.ForEachGlobReferenceName(c.pattern, func(name string) error {
+			names = append(names, name)
+			return nil
+		})
+		sort.Strings(names)
+		if err != nil || strings.Join(names, " ") != c.expected {
+			t.Error("references are wrong:", c.pattern, names, err)
+		}
+	}
+}
+
+func Test_CreateReference(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	id, _ := NewOid("c47800c7266a2be04c571c04d5a6614691ea99bd")
+
+	ref, err := repo.CreateReference("refs/heads/new-branch", id, false, nil, "branch: Created from c47800c")
+	if err != nil {
+		t.Error("err should be nil:", err)
+		return
+	}
+	if ref.Name() != "refs/heads/new-branch" || !ref.Target().Equal(id) {
+		t.Error("created reference is wrong:", ref.Name(), ref.Target())
+	}
+	content, _ := ioutil.ReadFile("test_resources/testrepo.git/refs/heads/new-branch")
+	if string(content) != "c47800c7266a2be04c571c04d5a6614691ea99bd\n" {
+		t.Error("loose reference is wrong:", string(content))
+	}
+	reflog, _ := repo.ReadReflog("refs/heads/new-branch")
+	if reflog.EntryCount() != 1 || reflog.EntryByIndex(0).Message != "branch: Created from c47800c" {
+		t.Error("reflog should be written:", reflog.EntryCount())
+	}
+
+	_, err = repo.CreateReference("refs/heads/new-branch", id, false, nil, "")
+	if !IsErrorCode(err, ErrExists) {
+		t.Error("existing reference should not be overwritten:", err)
+	}
+	_, err = repo.CreateReference("refs/heads/packed", id, false, nil, "")
+	if !IsErrorCode(err, ErrExists) {
+		t.Error("packed reference should not be overwritten:", err)
+	}
+	_, err = repo.CreateReference("refs/heads/br2/child", id, false, nil, "")
+	if !IsErrorCode(err, ErrExists) {
+		t.Error("conflicting name should be rejected:", err)
+	}
+	_, err = repo.CreateReference("refs/heads/new", id, false, nil, "")
+	if err != nil {
+		t.Error("name which is a prefix of other name should be accepted:", err)
+	}
+	_, err = repo.CreateReference("refs/heads/in valid", id, false, nil, "")
+	if !IsErrorCode(err, ErrInvalidSpec) {
+		t.Error("invalid name should be rejected:", err)
+	}
+	_, err = repo.CreateReference("lowercase", id, false, nil, "")
+	if !IsErrorCode(err, ErrInvalidSpec) {
+		t.Error("one level name should be rejected:", err)
+	}
+	missing, _ := NewOid("1111111111111111111111111111111111111111")
+	_, err = repo.CreateReference("refs/heads/missing", missing, false, nil, "")
+	if !IsErrorCode(err, ErrNotFound) {
+		t.Error("missing object should be rejected:", err)
+	}
+}
+
+func Test_CreateReferenceMatching(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	id, _ := NewOid("c47800c7266a2be04c571c04d5a6614691ea99bd")
+	wrong, _ := NewOid("a4a7dce85cf63874e984719f4fdd239f5145052f")
+	current, _ := NewOid("a65fedf39aefe402d3bb6e24df4d4f5fe4547750")
+
+	_, err := repo.CreateReferenceMatching("refs/heads/master", id, true, wrong, nil, "")
+	if !IsErrorCode(err, ErrModified) {
+		t.Error("unmatched value should be rejected:", err)
+	}
+	_, err = repo.CreateReferenceMatching("refs/heads/master", id, true, new(Oid), nil, "")
+	if !IsErrorCode(err, ErrModified) {
+		t.Error("zero id means the reference should not exist:", err)
+	}
+	_, err = repo.CreateReferenceMatching("refs/heads/master", id, true, current, nil, "reset: moving to c47800c")
+	if err != nil {
+		t.Error("err should be nil:", err)
+	}
+	_, err = repo.CreateReferenceMatching("refs/heads/brand-new", id, true, new(Oid), nil, "")
+	if err != nil {
+		t.Error("err should be nil:", err)
+	}
+
+	// HEAD points to master, so the reflog of HEAD is written too
+	reflog, _ := repo.ReadReflog(GitHeadFile)
+	entry := reflog.EntryByIndex(0)
+	if entry.Message != "reset: moving to c47800c" || !entry.Old.Equal(current) || !entry.New.Equal(id) {
+		t.Error("HEAD reflog is wrong:", entry.Message, entry.Old, entry.New)
+	}
+}
+
+func Test_ReferenceSetTarget(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	id, _ := NewOid("c47800c7266a2be04c571c04d5a6614691ea99bd")
+	id2, _ := NewOid("a4a7dce85cf63874e984719f4fdd239f5145052f")
+
+	ref, _ := repo.LookupReference("refs/heads/br2")
+	stale, _ := repo.LookupReference("refs/heads/br2")
+	updated, err := ref.SetTarget(id, nil, "reset")
+	if err != nil || !updated.Target().Equal(id) {
+		t.Error("err should be nil:", err)
+	}
+	_, err = stale.SetTarget(id2, nil, "reset")
+	if !IsErrorCode(err, ErrModified) {
+		t.Error("stale reference should not be updated:", err)
+	}
+
+	lock, _ := os.Create("test_resources/testrepo.git/refs/heads/br2.lock")
+	lock.Close()
+	_, err = updated.SetTarget(id2, nil, "reset")
+	if !IsErrorCode(err, ErrLocked) {
+		t.Error("locked reference should not be updated:", err)
+	}
+	os.Remove("test_resources/testrepo.git/refs/heads/br2.lock")
+
+	head, _ := repo.LookupReference(GitHeadFile)
+	_, err = head.SetTarget(id, nil, "")
+	if err == nil {
+		t.Error("symbolic reference should not accept id")
+	}
+}
+
+func Test_SymbolicReference(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	ref, err := repo.CreateSymbolicReference("refs/heads/symbolic", "refs/heads/br2", false, nil, "")
+	if err != nil {
+		t.Error("err should be nil:", err)
+		return
+	}
+	content, _ := ioutil.ReadFile("test_resources/testrepo.git/refs/heads/symbolic")
+	if string(content) != "ref: refs/heads/br2\n" {
+		t.Error("symbolic reference is wrong:", string(content))
+	}
+	_, err = ref.SetSymbolicTarget("refs/heads/master", nil, "")
+	if err != nil {
+		t.Error("err should be nil:", err)
+	}
+	_, err = ref.SetSymbolicTarget("refs/heads/test", nil, "")
+	if !IsErrorCode(err, ErrModified) {
+		t.Error("stale reference should not be updated:", err)
+	}
+	resolved, _ := repo.DwimReference("symbolic")
+	if resolved.Target().String() != "a65fedf39aefe402d3bb6e24df4d4f5fe4547750" {
+		t.Error("symbolic reference should point to master:", resolved.Target())
+	}
+}
+
+func Test_ReferenceDelete(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+
+	// loose and packed
+	ref, _ := repo.LookupReference("refs/heads/packed-test")
+	ioutil.WriteFile("test_resources/testrepo.git/packed-refs.lock", []byte{}, 0666)
+	if err := ref.Delete(); !IsErrorCode(err, ErrLocked) {
+		t.Error("reference should not be deleted while packed-refs is locked:", err)
+	}
+	if current, err := repo.LookupReference("refs/heads/packed-test"); err != nil || !current.Target().Equal(ref.Target()) {
+		t.Error("loose reference should be kept:", err)
+	}
+	os.Remove("test_resources/testrepo.git/packed-refs.lock")
+	if err := ref.Delete(); err != nil {
+		t.Error("err should be nil:", err)
+	}
+	if _, err := repo.LookupReference("refs/heads/packed-test"); !IsErrorCode(err, ErrNotFound) {
+		t.Error("reference should be deleted from packed-refs too:", err)
+	}
+
+	// packed only
+	ref, _ = repo.LookupReference("refs/heads/packed")
+	if err := ref.Delete(); err != nil {
+		t.Error("err should be nil:", err)
+	}
+	packed, _ := ioutil.ReadFile("test_resources/testrepo.git/packed-refs")
+	if strings.Contains(string(packed), "refs/heads/packed") {
+		t.Error("packed-refs still has deleted reference:", string(packed))
+	}
+
+	// with reflog
+	ref, _ = repo.LookupReference("refs/heads/br2")
+	stale, _ := repo.LookupReference("refs/heads/br2")
+	if err := ref.Delete(); err != nil {
+		t.Error("err should be nil:", err)
+	}
+	if _, err := os.Stat("test_resources/testrepo.git/logs/refs/heads/br2"); !os.IsNotExist(err) {
+		t.Error("reflog should be deleted")
+	}
+	if err := stale.Delete(); !IsErrorCode(err, ErrNotFound) {
+		t.Error("deleted reference can't be deleted again:", err)
+	}
+}
+
+func Test_ReferenceRename(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	ref, _ := repo.LookupReference("refs/heads/master")
+
+	if _, err := ref.Rename("refs/heads/br2", false, nil, ""); !IsErrorCode(err, ErrExists) {
+		t.Error("existing name should be rejected:", err)
+	}
+	if _, err := ref.Rename("refs/heads/br2/child", false, nil, ""); !IsErrorCode(err, ErrExists) {
+		t.Error("conflicting name should be rejected:", err)
+	}
+
+	renamed, err := ref.Rename("refs/heads/master/main", false, nil, "Branch: renamed refs/heads/master to refs/heads/master/main")
+	if err != nil {
+		t.Error("err should be nil:", err)
+		return
+	}
+	if renamed.Name() != "refs/heads/master/main" || !renamed.Target().Equal(ref.Target()) {
+		t.Error("renamed reference is wrong:", renamed.Name(), renamed.Target())
+	}
+	if _, err := repo.LookupReference("refs/heads/master"); !IsErrorCode(err, ErrNotFound) {
+		t.Error("old reference should be removed:", err)
+	}
+	head, _ := repo.LookupReference(GitHeadFile)
+	if head.SymbolicTarget() != "refs/heads/master/main" {
+		t.Error("HEAD should follow the renamed branch:", head.SymbolicTarget())
+	}
+	reflog, _ := repo.ReadReflog("refs/heads/master/main")
+	if reflog.EntryCount() != 3 || reflog.EntryByIndex(0).Message != "Branch: renamed refs/heads/master to refs/heads/master/main" {
+		t.Error("reflog should be moved:", reflog.EntryCount())
+	}
+
+	// packed reference can be renamed too
+	packed, _ := repo.LookupReference("refs/heads/packed")
+	if _, err := packed.Rename("refs/heads/unpacked", false, nil, ""); err != nil {
+		t.Error("err should be nil:", err)
+	}
+	if ref, err := repo.LookupReference("refs/heads/unpacked"); err != nil || ref.Target().String() != "41bc8c69075bbdb46c5c6f0566cc8cc5b46e8bd9" {
+		t.Error("packed reference should be renamed:", err)
+	}
+
+	// back to the parent name via the temporary reference
+	if _, err := renamed.Rename("refs/heads/master", false, nil, ""); err != nil {
+		t.Error("err should be nil:", err)
+	}
+	if ref, err := repo.LookupReference("refs/heads/master"); err != nil || !ref.Target().Equal(renamed.Target()) {
+		t.Error("reference should be renamed to the parent name:", err)
+	}
+	if _, err := os.Stat("test_resources/testrepo.git/RENAMED-REF"); !os.IsNotExist(err) {
+		t.Error("temporary reference should be removed")
+	}
+
+	// the new reference is removed if the old one was modified
+	stale, _ := repo.LookupReference("refs/heads/unpacked")
+	current, _ := repo.LookupReference("refs/heads/unpacked")
+	if _, err := current.SetTarget(renamed.Target(), nil, ""); err != nil {
+		t.Error("err should be nil:", err)
+	}
+	if _, err := stale.Rename("refs/heads/moved", false, nil, ""); !IsErrorCode(err, ErrModified) {
+		t.Error("modified reference should not be renamed:", err)
+	}
+	if _, err := repo.LookupReference("refs/heads/moved"); !IsErrorCode(err, ErrNotFound) {
+		t.Error("new reference should be removed:", err)
+	}
+	if _, err := repo.LookupReference("refs/heads/unpacked"); err != nil {
+		t.Error("old reference should be kept:", err)
+	}
+}
+
+func Test_RefDb_PackAll(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	err := repo.NewRefDb().PackAll(false)
+	if err != nil {
+		t.Error("err should be nil:", err)
+	}
+	packed, _ := ioutil.ReadFile("test_resources/testrepo.git/packed-refs")
+	lines := strings.Split(string(packed), "\n")
+	if lines[0] != "# pack-refs with: peeled fully-peeled sorted " {
+		t.Error("header is wrong:", lines[0])
+	}
+	if !strings.Contains(string(packed), "7b4384978d2493e851f9cca7858815fac9b10980 refs/tags/e90810b\n^e90810b8df3e80c413d903f631643c716887138d\n") {
+		t.Error("annotated tag should be peeled:", string(packed))
+	}
+	if !strings.Contains(string(packed), "a65fedf39aefe402d3bb6e24df4d4f5fe4547750 refs/heads/master\n") {
+		t.Error("loose reference should be packed:", string(packed))
+	}
+	var names []string
+	for _, line := range lines[1:] {
+		if line != "" && line[0] != '^' {
+			names = append(names, strings.Split(line, " ")[1])
+		}
+	}
+	for i := 1; i < len(names); i++ {
+		if names[i-1] >= names[i] {
+			t.Error("packed-refs should be sorted:", names[i-1], names[i])
+		}
+	}
+	if _, err := os.Stat("test_resources/testrepo.git/refs/heads/master"); err != nil {
+		t.Error("loose reference should not be removed without prune")
+	}
+}
+
+func Test_RefDb_PackAllPrune(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	before := make(map[string]string)
+	repo.ForEachReference(func(ref *Reference) error {
+		before[ref.Name()] = ref.Target().String() + ref.SymbolicTarget()
+		return nil
+	})
+
+	err := repo.NewRefDb().PackAll(true)
+	if err != nil {
+		t.Error("err should be nil:", err)
+	}
+	if _, err := os.Stat("test_resources/testrepo.git/refs/heads/master"); !os.IsNotExist(err) {
+		t.Error("loose reference should be removed")
+	}
+	if _, err := os.Stat("test_resources/testrepo.git/refs/heads"); err != nil {
+		t.Error("refs/heads should be kept")
+	}
+	after := make(map[string]string)
+	repo.ForEachReference(func(ref *Reference) error {
+		after[ref.Name()] = ref.Target().String() + ref.SymbolicTarget()
+		return nil
+	})
+	if len(before) != len(after) {
+		t.Error("references should be kept:", len(before), len(after))
+	}
+	for name, value := range before {
+		if after[name] != value {
+			t.Error("reference value is changed:", name, value, after[name])
+		}
+	}
+	ref, err := repo.LookupReference("refs/heads/master")
+	if err != nil || ref.Target().String() != "a65fedf39aefe402d3bb6e24df4d4f5fe4547750" {
+		t.Error("packed reference should be found:", err)
+	}
+}
@@ -0,0 +1,281 @@
+package git4go
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	GitReflogDir             = "logs"
+	GitReflogFileMode        = 0666
+	GitReflogDirMode         = 0777
+	gitReflogRenameTemporary = ".tmp-renamed-log"
+)
+
+// Repository methods related to Reflog
+
+func (r *Repository) ReadReflog(name string) (*Reflog, error) {
+	entries, err := r.NewRefDb().backend.ReadReflog(name)
+	if err != nil {
+		return nil, err
+	}
+	return &Reflog{
+		repo:    r,
+		name:    name,
+		entries: entries,
+	}, nil
+}
+
+// RenameReflog moves the reflog of the reference to the new name. It
+// does nothing if the reference doesn't have a reflog.
+func (r *Repository) RenameReflog(oldName, newName string) error {
+	normalized, err := referenceNormalize(newName, false, true)
+	if err != nil {
+		return err
+	}
+	return r.NewRefDb().backend.RenameReflog(oldName, normalized)
+}
+
+// DeleteReflog removes the reflog of the reference.
+func (r *Repository) DeleteReflog(name string) error {
+	return r.NewRefDb().backend.DeleteReflog(name)
+}
+
+// Reflog type and its methods
+
+type Reflog struct {
+	repo    *Repository
+	name    string
+	entries []*ReflogEntry
+}
+
+type ReflogEntry struct {
+	Old       *Oid
+	New       *Oid
+	Committer *Signature
+	Message   string
+}
+
+func (r *Reflog) Name() string {
+	return r.name
+}
+
+func (r *Reflog) EntryCount() uint {
+	return uint(len(r.entries))
+}
+
+// EntryByIndex returns the entry at the given position. Index 0 is the
+// most recent entry, like "<ref>@{0}".
+func (r *Reflog) EntryByIndex(index uint) *ReflogEntry {
+	if index >= uint(len(r.entries)) {
+		return nil
+	}
+	return r.entries[len(r.entries)-1-int(index)]
+}
+
+// Append adds a new entry to the in-memory reflog. Call Write to save it.
+func (r *Reflog) Append(id *Oid, committer *Signature, message string) error {
+	message, err := normalizeReflogMessage(message)
+	if err != nil {
+		return err
+	}
+	if committer == nil {
+		return errors.New("Reflog.Append(): committer is nil")
+	}
+	oldId := new(Oid)
+	if len(r.entries) > 0 {
+		oldId = r.entries[len(r.entries)-1].New.Copy()
+	}
+	r.entries = append(r.entries, &ReflogEntry{
+		Old:       oldId,
+		New:       id.Copy(),
+		Committer: committer,
+		Message:   message,
+	})
+	return nil
+}
+
+// Drop removes the entry at the given index (0 is the most recent entry).
+// If rewritePreviousEntry is true, the old id of the next newer entry is
+// rewritten to keep the chain of the history.
+func (r *Reflog) Drop(index uint, rewritePreviousEntry bool) error {
+	count := uint(len(r.entries))
+	if index >= count {
+		return MakeGitError(fmt.Sprintf("No reflog entry at index %d", index), ErrNotFound)
+	}
+	position := int(count - 1 - index)
+	r.entries = append(r.entries[:position], r.entries[position+1:]...)
+	if !rewritePreviousEntry || index == 0 {
+		return nil
+	}
+	// r.entries[position] is the entry which was newer than the dropped one
+	if position == 0 {
+		r.entries[position].Old = new(Oid)
+	} else {
+		r.entries[position].Old = r.entries[position-1].New.Copy()
+	}
+	return nil
+}
+
+// Write saves the reflog to the disk.
+func (r *Reflog) Write() error {
+	return r.repo.NewRefDb().backend.WriteReflog(r.name, r.entries)
+}
+
+// internal functions
+
+// appendReflogForUpdate is called when the library updates the reference.
+// It writes an entry if core.logAllRefUpdates requires it or the reflog
+// already exists.
+func appendReflogForUpdate(repo *Repository, name string, oldId, newId *Oid, committer *Signature, message string) error {
+	if !shouldLogRefUpdate(repo, name) {
+		return nil
+	}
+	message, err := normalizeReflogMessage(message)
+	if err != nil {
+		return err
+	}
+	if committer == nil {
+		committer = reflogSignature(repo)
+	}
+	if oldId == nil {
+		oldId = new(Oid)
+	}
+	if newId == nil {
+		newId = new(Oid)
+	}
+	return repo.NewRefDb().backend.AppendReflog(name, &ReflogEntry{
+		Old:       oldId,
+		New:       newId,
+		Committer: committer,
+		Message:   message,
+	})
+}
+
+// shouldLogRefUpdate follows git's rule: "always" logs every reference,
+// "true" logs branches, remote-tracking branches, notes and HEAD. If it is
+// not configured, non-bare repositories behave as "true".
+func shouldLogRefUpdate(repo *Repository, name string) bool {
+	if reflogExists(repo, name) {
+		return true
+	}
+	value, err := repo.Config().LookupString("core.logallrefupdates")
+	if err != nil {
+		if repo.IsBare() {
+			return false
+		}
+		value = "true"
+	}
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "always":
+		return true
+	case "true", "yes", "on", "1":
+		return name == GitHeadFile ||
+			strings.HasPrefix(name, GitRefsHeadsDir) ||
+			strings.HasPrefix(name, GitRefsRemotesDir) ||
+			strings.HasPrefix(name, "refs/notes/")
+	}
+	return false
+}
+
+func reflogExists(repo *Repository, name string) bool {
+	return repo.NewRefDb().backend.HasReflog(name)
+}
+
+// reflogSignature returns the identity for reflog entries. Like git, it
+// falls back to "unknown" if user.name or user.email is not configured.
+func reflogSignature(repo *Repository) *Signature {
+	signature, err := repo.DefaultSignature()
+	if err != nil {
+		return &Signature{
+			Name:  "unknown",
+			Email: "unknown",
+			When:  time.Now(),
+		}
+	}
+	return signature
+}
+
+// normalizeReflogMessage removes a trailing newline. A reflog entry can't
+// have a multi-line message.
+func normalizeReflogMessage(message string) (string, error) {
+	message = strings.TrimRight(message, "\n")
+	if strings.ContainsRune(message, '\n') {
+		return "", errors.New("Reflog message cannot contain newline")
+	}
+	return message, nil
+}
+
+func formatReflogEntry(oldId, newId *Oid, committer *Signature, message string) string {
+	line := fmt.Sprintf("%s %s %s", oldId, newId, formatSignature(committer))
+	if message != "" {
+		line += "\t" + message
+	}
+	return line + "\n"
+}
+
+func parseReflog(buffer []byte) ([]*ReflogEntry, error) {
+	var entries []*ReflogEntry
+	offset := 0
+	for offset < len(buffer) {
+		eol := bytes.IndexByte(buffer[offset:], '\n')
+		if eol == -1 {
+			eol = len(buffer)
+		} else {
+			eol += offset
+		}
+		line := buffer[offset:eol]
+		offset = eol + 1
+		if len(line) == 0 {
+			continue
+		}
+		entry, err := parseReflogEntry(line)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func parseReflogEntry(line []byte) (*ReflogEntry, error) {
+	if len(line) < 2*(GitOidHexSize+1) {
+		return nil, errors.New("Reflog entry is too short")
+	}
+	oldId, err := NewOid(string(line[:GitOidHexSize]))
+	if err != nil {
+		return nil, err
+	}
+	if line[GitOidHexSize] != ' ' {
+		return nil, errors.New(fmt.Sprintf("Corrupted reflog entry: '%s'", string(line)))
+	}
+	newId, err := NewOid(string(line[GitOidHexSize+1 : 2*GitOidHexSize+1]))
+	if err != nil {
+		return nil, err
+	}
+	if line[2*GitOidHexSize+1] != ' ' {
+		return nil, errors.New(fmt.Sprintf("Corrupted reflog entry: '%s'", string(line)))
+	}
+	rest := line[2*(GitOidHexSize+1):]
+	message := ""
+	if tab := bytes.IndexByte(rest, '\t'); tab != -1 {
+		message = string(rest[tab+1:])
+		rest = rest[:tab]
+	}
+	signatureLine := make([]byte, len(rest)+1)
+	copy(signatureLine, rest)
+	signatureLine[len(rest)] = '\n'
+	committer, _, err := parseSignature(signatureLine, 0, []byte{})
+	if err != nil {
+		return nil, err
+	}
+	return &ReflogEntry{
+		Old:       oldId,
+		New:       newId,
+		Committer: committer,
+		Message:   message,
+	}, nil
+}
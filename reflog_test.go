@@ -0,0 +1,200 @@
+package git4go
+
+import (
+	"./testutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_ReadReflog(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	reflog, err := repo.ReadReflog("refs/heads/master")
+	if err != nil {
+		t.Error("err should be nil:", err)
+		return
+	}
+	if reflog.EntryCount() != 2 {
+		t.Error("entry count is wrong:", reflog.EntryCount())
+	}
+	entry := reflog.EntryByIndex(0)
+	if entry.Old.String() != "be3563ae3f795b2b4353bcce3a527ad0a4f7f644" {
+		t.Error("old id is wrong:", entry.Old)
+	}
+	if entry.New.String() != "a65fedf39aefe402d3bb6e24df4d4f5fe4547750" {
+		t.Error("new id is wrong:", entry.New)
+	}
+	if entry.Message != "commit: checking in" {
+		t.Error("message is wrong:", entry.Message)
+	}
+	if entry.Committer.Name != "Ben Straub" || entry.Committer.Email != "bstraub@github.com" {
+		t.Error("committer is wrong:", entry.Committer)
+	}
+	if !reflog.EntryByIndex(1).Old.IsZero() {
+		t.Error("the oldest entry should start from zero id:", reflog.EntryByIndex(1).Old)
+	}
+	if reflog.EntryByIndex(2) != nil {
+		t.Error("out of range entry should be nil")
+	}
+}
+
+func Test_ReadReflog_NotExist(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	reflog, err := repo.ReadReflog("refs/heads/not-exist")
+	if err != nil {
+		t.Error("err should be nil:", err)
+	} else if reflog.EntryCount() != 0 {
+		t.Error("reflog should be empty:", reflog.EntryCount())
+	}
+}
+
+func Test_Reflog_AppendAndWrite(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	reflog, _ := repo.ReadReflog("refs/heads/master")
+	id, _ := NewOid("c47800c7266a2be04c571c04d5a6614691ea99bd")
+	committer := &Signature{
+		Name:  "Foo Bar",
+		Email: "foo@example.com",
+		When:  time.Unix(1400000000, 0).In(time.FixedZone("", -(7*3600 + 30*60))),
+	}
+	err := reflog.Append(id, committer, "reset: moving to c47800c\n")
+	if err != nil {
+		t.Error("err should be nil:", err)
+	}
+	err = reflog.Append(id, committer, "multi\nline")
+	if err == nil {
+		t.Error("multi line message should be rejected")
+	}
+	err = reflog.Write()
+	if err != nil {
+		t.Error("err should be nil:", err)
+	}
+
+	reflog, _ = repo.ReadReflog("refs/heads/master")
+	if reflog.EntryCount() != 3 {
+		t.Error("entry count is wrong:", reflog.EntryCount())
+		return
+	}
+	entry := reflog.EntryByIndex(0)
+	if entry.Old.String() != "a65fedf39aefe402d3bb6e24df4d4f5fe4547750" || !entry.New.Equal(id) {
+		t.Error("ids are wrong:", entry.Old, entry.New)
+	}
+	if entry.Message != "reset: moving to c47800c" {
+		t.Error("message is wrong:", entry.Message)
+	}
+	if entry.Committer.Name != "Foo Bar" || entry.Committer.When.Unix() != 1400000000 || entry.Committer.Offset() != -450 {
+		t.Error("committer is wrong:", entry.Committer)
+	}
+	if reflog.EntryByIndex(2).Message != "clone: from /Users/ben/src/libgit2/tests/resources/testrepo.git" {
+		t.Error("existing entry is broken:", reflog.EntryByIndex(2).Message)
+	}
+}
+
+func Test_Reflog_Drop(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	reflog, _ := repo.ReadReflog(GitHeadFile)
+	count := reflog.EntryCount()
+
+	if err := reflog.Drop(count, true); !IsErrorCode(err, ErrNotFound) {
+		t.Error("out of range should be error:", err)
+	}
+
+	// drop "checkout: moving from master to 5b5b025"
+	if err := reflog.Drop(4, true); err != nil {
+		t.Error("err should be nil:", err)
+	}
+	if reflog.EntryCount() != count-1 {
+		t.Error("entry count is wrong:", reflog.EntryCount())
+	}
+	entry := reflog.EntryByIndex(3)
+	if entry.Message != "checkout: moving from 5b5b025 to master" {
+		t.Error("wrong entry:", entry.Message)
+	}
+	if entry.Old.String() != "a65fedf39aefe402d3bb6e24df4d4f5fe4547750" {
+		t.Error("old id should be rewritten:", entry.Old)
+	}
+
+	// drop the oldest entry
+	if err := reflog.Drop(reflog.EntryCount()-1, true); err != nil {
+		t.Error("err should be nil:", err)
+	}
+	if !reflog.EntryByIndex(reflog.EntryCount() - 1).Old.IsZero() {
+		t.Error("old id of the oldest entry should be zero")
+	}
+
+	reflog.Write()
+	reflog, _ = repo.ReadReflog(GitHeadFile)
+	if reflog.EntryCount() != count-2 {
+		t.Error("entry count is wrong after writing:", reflog.EntryCount())
+	}
+}
+
+func Test_Reflog_RenameAndDelete(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	err := repo.RenameReflog("refs/heads/master", "refs/heads/master/nested")
+	if err != nil {
+		t.Error("err should be nil:", err)
+	}
+	reflog, _ := repo.ReadReflog("refs/heads/master/nested")
+	if reflog.EntryCount() != 2 {
+		t.Error("reflog should be moved:", reflog.EntryCount())
+	}
+
+	err = repo.RenameReflog("refs/heads/master/nested", "refs/heads/other")
+	if err != nil {
+		t.Error("err should be nil:", err)
+	}
+	if _, err := os.Stat("test_resources/testrepo.git/logs/refs/heads/master"); !os.IsNotExist(err) {
+		t.Error("empty directory should be removed")
+	}
+	if err := repo.RenameReflog("refs/heads/other", "refs/heads/in valid"); !IsErrorCode(err, ErrInvalidSpec) {
+		t.Error("invalid name should be rejected:", err)
+	}
+
+	err = repo.DeleteReflog("refs/heads/other")
+	if err != nil {
+		t.Error("err should be nil:", err)
+	}
+	reflog, _ = repo.ReadReflog("refs/heads/other")
+	if reflog.EntryCount() != 0 {
+		t.Error("reflog should be deleted:", reflog.EntryCount())
+	}
+}
+
+func Test_Reflog_AutoLogging(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	id, _ := NewOid("c47800c7266a2be04c571c04d5a6614691ea99bd")
+
+	// core.logallrefupdates = true
+	appendReflogForUpdate(repo, "refs/heads/new-branch", nil, id, nil, "branch: Created from c47800c")
+	appendReflogForUpdate(repo, "refs/tags/new-tag", nil, id, nil, "tag")
+
+	reflog, _ := repo.ReadReflog("refs/heads/new-branch")
+	if reflog.EntryCount() != 1 {
+		t.Error("branch update should be logged:", reflog.EntryCount())
+	} else if entry := reflog.EntryByIndex(0); !entry.Old.IsZero() || !entry.New.Equal(id) || entry.Committer == nil {
+		t.Error("entry is wrong:", entry)
+	}
+	reflog, _ = repo.ReadReflog("refs/tags/new-tag")
+	if reflog.EntryCount() != 0 {
+		t.Error("tag update should not be logged:", reflog.EntryCount())
+	}
+}
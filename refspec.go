@@ -0,0 +1,60 @@
+package git4go
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+type refspec struct {
+	force bool
+	src   string
+	dst   string
+}
+
+func parseRefspec(input string) (*refspec, error) {
+	spec := &refspec{}
+	if strings.HasPrefix(input, "+") {
+		spec.force = true
+		input = input[1:]
+	}
+	colon := strings.LastIndex(input, ":")
+	if colon == -1 {
+		spec.src = input
+	} else {
+		spec.src = input[:colon]
+		spec.dst = input[colon+1:]
+	}
+	wildcards := strings.Count(spec.src, "*")
+	if wildcards > 1 || (spec.dst != "" && strings.Count(spec.dst, "*") != wildcards) {
+		return nil, errors.New(fmt.Sprintf("Invalid refspec: '%s'", input))
+	}
+	return spec, nil
+}
+
+func refspecMatch(pattern, name string) bool {
+	star := strings.IndexByte(pattern, '*')
+	if star == -1 {
+		return pattern == name
+	}
+	return len(name) >= len(pattern)-1 &&
+		strings.HasPrefix(name, pattern[:star]) && strings.HasSuffix(name, pattern[star+1:])
+}
+
+func (r *refspec) srcMatches(name string) bool {
+	return refspecMatch(r.src, name)
+}
+
+// transform converts the name that matches the source side of the refspec
+// into the destination side of it.
+func (r *refspec) transform(name string) (string, error) {
+	if !r.srcMatches(name) {
+		return "", errors.New(fmt.Sprintf("The reference '%s' doesn't match the refspec's source", name))
+	}
+	star := strings.IndexByte(r.src, '*')
+	if star == -1 {
+		return r.dst, nil
+	}
+	matched := name[star : len(name)-(len(r.src)-star-1)]
+	return strings.Replace(r.dst, "*", matched, 1), nil
+}
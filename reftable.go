@@ -0,0 +1,1047 @@
+package git4go
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+)
+
+// reftable is git's block based reference storage format. See
+// Documentation/technical/reftable.txt of git for the details.
+
+const (
+	reftableMagic            = "REFT"
+	reftableVersion          = 1
+	reftableHeaderSize       = 24
+	reftableFooterSize       = 68
+	reftableV2HeaderSize     = 28
+	reftableV2FooterSize     = 72
+	reftableDefaultBlockSize = 4096
+	reftableRestartInterval  = 16
+	reftableIndexThreshold   = 4
+	reftableMinObjIdLen      = 2
+
+	reftableBlockTypeRef   byte = 'r'
+	reftableBlockTypeObj   byte = 'o'
+	reftableBlockTypeLog   byte = 'g'
+	reftableBlockTypeIndex byte = 'i'
+
+	reftableRefDeletion byte = 0
+	reftableRefValue    byte = 1
+	reftableRefPeeled   byte = 2
+	reftableRefSymbolic byte = 3
+
+	reftableLogDeletion byte = 0
+	reftableLogUpdate   byte = 1
+)
+
+var errReftableCorrupted = errors.New("Corrupted reftable file")
+
+type reftableRef struct {
+	name        string
+	updateIndex uint64
+	valueType   byte
+	value       *Oid
+	peeled      *Oid
+	target      string
+}
+
+func (r *reftableRef) isDeletion() bool {
+	return r.valueType == reftableRefDeletion
+}
+
+func (r *reftableRef) reference(repo *Repository) *Reference {
+	if r.valueType == reftableRefSymbolic {
+		return &Reference{
+			refType:        ReferenceSymbolic,
+			targetSymbolic: r.target,
+			repo:           repo,
+			name:           r.name,
+		}
+	}
+	return &Reference{
+		refType:   ReferenceOid,
+		targetOid: r.value,
+		repo:      repo,
+		name:      r.name,
+	}
+}
+
+type reftableLog struct {
+	name        string
+	updateIndex uint64
+	deleted     bool
+	old         *Oid
+	new         *Oid
+	committer   *Signature
+	message     string
+}
+
+func (l *reftableLog) key() []byte {
+	key := make([]byte, len(l.name)+9)
+	copy(key, l.name)
+	binary.BigEndian.PutUint64(key[len(l.name)+1:], ^l.updateIndex)
+	return key
+}
+
+func putUint24(buffer []byte, value int) {
+	buffer[0] = byte(value >> 16)
+	buffer[1] = byte(value >> 8)
+	buffer[2] = byte(value)
+}
+
+func getUint24(buffer []byte) int {
+	return int(buffer[0])<<16 | int(buffer[1])<<8 | int(buffer[2])
+}
+
+// Reader
+
+type reftable struct {
+	name           string
+	data           []byte
+	blockSize      int
+	minUpdateIndex uint64
+	maxUpdateIndex uint64
+	headerSize     int
+	footerOffset   int
+	refIndexOffset int
+	objOffset      int
+	objIdLen       int
+	objIndexOffset int
+	logOffset      int
+	logIndexOffset int
+	hasRefs        bool
+	hasLogs        bool
+}
+
+func openReftable(path, name string) (*reftable, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	table, err := parseReftable(data)
+	if err != nil {
+		return nil, err
+	}
+	table.name = name
+	return table, nil
+}
+
+func parseReftable(data []byte) (*reftable, error) {
+	if len(data) < reftableHeaderSize || string(data[:4]) != reftableMagic {
+		return nil, errReftableCorrupted
+	}
+	table := &reftable{data: data}
+	footerSize := reftableFooterSize
+	switch data[4] {
+	case 1:
+		table.headerSize = reftableHeaderSize
+	case 2:
+		table.headerSize = reftableV2HeaderSize
+		footerSize = reftableV2FooterSize
+		if len(data) < reftableV2HeaderSize || string(data[24:28]) != "sha1" {
+			return nil, errors.New("Unsupported hash function of reftable")
+		}
+	default:
+		return nil, errors.New(fmt.Sprintf("Unsupported reftable version %d", data[4]))
+	}
+	if len(data) < table.headerSize+footerSize {
+		return nil, errReftableCorrupted
+	}
+	table.footerOffset = len(data) - footerSize
+	footer := data[table.footerOffset:]
+	if !bytes.Equal(footer[:table.headerSize], data[:table.headerSize]) {
+		return nil, errReftableCorrupted
+	}
+	if crc32.ChecksumIEEE(footer[:footerSize-4]) != binary.BigEndian.Uint32(footer[footerSize-4:]) {
+		return nil, errors.New("Reftable footer checksum mismatch")
+	}
+	table.blockSize = getUint24(data[5:8])
+	table.minUpdateIndex = binary.BigEndian.Uint64(data[8:16])
+	table.maxUpdateIndex = binary.BigEndian.Uint64(data[16:24])
+	fields := footer[table.headerSize:]
+	table.refIndexOffset = int(binary.BigEndian.Uint64(fields[0:8]))
+	objPosition := binary.BigEndian.Uint64(fields[8:16])
+	table.objOffset = int(objPosition >> 5)
+	table.objIdLen = int(objPosition & 31)
+	if table.objOffset > 0 && (table.objIdLen == 0 || table.objIdLen > GitOidRawSize) {
+		return nil, errReftableCorrupted
+	}
+	table.objIndexOffset = int(binary.BigEndian.Uint64(fields[16:24]))
+	table.logOffset = int(binary.BigEndian.Uint64(fields[24:32]))
+	table.logIndexOffset = int(binary.BigEndian.Uint64(fields[32:40]))
+
+	if table.footerOffset > table.headerSize {
+		firstType := data[table.headerSize]
+		table.hasRefs = firstType == reftableBlockTypeRef
+		table.hasLogs = table.logOffset > 0 || firstType == reftableBlockTypeLog
+	}
+	return table, nil
+}
+
+type reftableBlock struct {
+	blockType byte
+	data      []byte
+	start     int
+	end       int
+	next      int
+}
+
+// readBlock reads the block at the offset. Log blocks are inflated. It
+// returns nil at the end of the blocks.
+func (t *reftable) readBlock(offset int) (*reftableBlock, error) {
+	if offset < 0 {
+		return nil, errReftableCorrupted
+	}
+	headerOffset := 0
+	if offset == 0 {
+		headerOffset = t.headerSize
+	}
+	if offset+headerOffset+4 > t.footerOffset {
+		return nil, nil
+	}
+	blockType := t.data[offset+headerOffset]
+	blockLen := getUint24(t.data[offset+headerOffset+1:])
+	block := &reftableBlock{
+		blockType: blockType,
+		start:     headerOffset + 4,
+	}
+	if blockType == reftableBlockTypeLog {
+		reader := bytes.NewReader(t.data[offset+headerOffset+4 : t.footerOffset])
+		inflater, err := zlib.NewReader(reader)
+		if err != nil {
+			return nil, err
+		}
+		inflated, err := ioutil.ReadAll(inflater)
+		if err != nil {
+			return nil, err
+		}
+		block.data = make([]byte, 0, blockLen)
+		block.data = append(block.data, t.data[offset:offset+headerOffset+4]...)
+		block.data = append(block.data, inflated...)
+		block.next = t.footerOffset - reader.Len()
+	} else {
+		if offset+blockLen > t.footerOffset {
+			return nil, errReftableCorrupted
+		}
+		block.data = t.data[offset : offset+blockLen]
+		block.next = offset + blockLen
+	}
+	if len(block.data) != blockLen || blockLen < block.start+2 {
+		return nil, errReftableCorrupted
+	}
+	restartCount := int(binary.BigEndian.Uint16(block.data[blockLen-2:]))
+	block.end = blockLen - 2 - 3*restartCount
+	if block.end < block.start {
+		return nil, errReftableCorrupted
+	}
+	// skip padding
+	for block.next < t.footerOffset && t.data[block.next] == 0 {
+		block.next++
+	}
+	return block, nil
+}
+
+// reftableBlockIter reads the prefix compressed records in the block.
+type reftableBlockIter struct {
+	block  *reftableBlock
+	offset int
+	key    []byte
+}
+
+func newReftableBlockIter(block *reftableBlock) *reftableBlockIter {
+	return &reftableBlockIter{
+		block:  block,
+		offset: block.start,
+	}
+}
+
+// nextKey reads the key and the value type of the next record. The value
+// should be read by the read* methods before calling nextKey again.
+func (it *reftableBlockIter) nextKey() (bool, byte, error) {
+	if it.offset >= it.block.end {
+		return false, 0, nil
+	}
+	data := it.block.data[:it.block.end]
+	prefixLen, offset, ok := getVarint(data, it.offset)
+	if !ok {
+		return false, 0, errReftableCorrupted
+	}
+	suffixAndType, offset, ok := getVarint(data, offset)
+	if !ok {
+		return false, 0, errReftableCorrupted
+	}
+	// compare before the conversion to int not to make them negative
+	if prefixLen > uint64(len(it.key)) || suffixAndType>>3 > uint64(len(data)-offset) {
+		return false, 0, errReftableCorrupted
+	}
+	suffixLen := int(suffixAndType >> 3)
+	key := make([]byte, 0, int(prefixLen)+suffixLen)
+	key = append(key, it.key[:prefixLen]...)
+	key = append(key, data[offset:offset+suffixLen]...)
+	it.key = key
+	it.offset = offset + suffixLen
+	return true, byte(suffixAndType & 7), nil
+}
+
+func (it *reftableBlockIter) readVarint() (uint64, error) {
+	value, offset, ok := getVarint(it.block.data[:it.block.end], it.offset)
+	if !ok {
+		return 0, errReftableCorrupted
+	}
+	it.offset = offset
+	return value, nil
+}
+
+func (it *reftableBlockIter) readBytes(length int) ([]byte, error) {
+	if length < 0 || length > it.block.end-it.offset {
+		return nil, errReftableCorrupted
+	}
+	result := it.block.data[it.offset : it.offset+length]
+	it.offset += length
+	return result, nil
+}
+
+func (it *reftableBlockIter) readString() (string, error) {
+	length, err := it.readVarint()
+	if err != nil {
+		return "", err
+	}
+	if length > uint64(it.block.end-it.offset) {
+		return "", errReftableCorrupted
+	}
+	result, err := it.readBytes(int(length))
+	return string(result), err
+}
+
+func (it *reftableBlockIter) readOid() (*Oid, error) {
+	raw, err := it.readBytes(GitOidRawSize)
+	if err != nil {
+		return nil, err
+	}
+	return NewOidFromBytes(raw), nil
+}
+
+func (t *reftable) readRef(it *reftableBlockIter, valueType byte) (*reftableRef, error) {
+	delta, err := it.readVarint()
+	if err != nil {
+		return nil, err
+	}
+	ref := &reftableRef{
+		name:        string(it.key),
+		updateIndex: t.minUpdateIndex + delta,
+		valueType:   valueType,
+	}
+	switch valueType {
+	case reftableRefDeletion:
+	case reftableRefValue:
+		ref.value, err = it.readOid()
+	case reftableRefPeeled:
+		ref.value, err = it.readOid()
+		if err == nil {
+			ref.peeled, err = it.readOid()
+		}
+	case reftableRefSymbolic:
+		ref.target, err = it.readString()
+	default:
+		err = errReftableCorrupted
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ref, nil
+}
+
+func (t *reftable) readLog(it *reftableBlockIter, valueType byte) (*reftableLog, error) {
+	key := it.key
+	if len(key) < 9 || key[len(key)-9] != 0 {
+		return nil, errReftableCorrupted
+	}
+	log := &reftableLog{
+		name:        string(key[:len(key)-9]),
+		updateIndex: ^binary.BigEndian.Uint64(key[len(key)-8:]),
+		deleted:     valueType == reftableLogDeletion,
+	}
+	if log.deleted {
+		return log, nil
+	} else if valueType != reftableLogUpdate {
+		return nil, errReftableCorrupted
+	}
+	var err error
+	if log.old, err = it.readOid(); err != nil {
+		return nil, err
+	}
+	if log.new, err = it.readOid(); err != nil {
+		return nil, err
+	}
+	name, err := it.readString()
+	if err != nil {
+		return nil, err
+	}
+	email, err := it.readString()
+	if err != nil {
+		return nil, err
+	}
+	seconds, err := it.readVarint()
+	if err != nil {
+		return nil, err
+	}
+	tz, err := it.readBytes(2)
+	if err != nil {
+		return nil, err
+	}
+	message, err := it.readString()
+	if err != nil {
+		return nil, err
+	}
+	// git stores the time zone like "+0900" as the decimal number 900
+	hhmm := int(int16(binary.BigEndian.Uint16(tz)))
+	sign := 1
+	if hhmm < 0 {
+		sign = -1
+		hhmm = -hhmm
+	}
+	offset := sign * ((hhmm/100)*60 + hhmm%100) * 60
+	log.committer = &Signature{
+		Name:  name,
+		Email: email,
+		When:  time.Unix(int64(seconds), 0).In(time.FixedZone("", offset)),
+	}
+	log.message = strings.TrimSuffix(message, "\n")
+	return log, nil
+}
+
+func (t *reftable) readIndexPosition(it *reftableBlockIter) (int, error) {
+	position, err := it.readVarint()
+	return int(position), err
+}
+
+// forEachRef calls the callback for all reference records in the table
+// including deletions.
+func (t *reftable) forEachRef(callback func(*reftableRef) error) error {
+	if !t.hasRefs {
+		return nil
+	}
+	for offset := 0; ; {
+		block, err := t.readBlock(offset)
+		if err != nil {
+			return err
+		}
+		if block == nil || block.blockType != reftableBlockTypeRef {
+			return nil
+		}
+		it := newReftableBlockIter(block)
+		for {
+			ok, valueType, err := it.nextKey()
+			if err != nil {
+				return err
+			} else if !ok {
+				break
+			}
+			ref, err := t.readRef(it, valueType)
+			if err != nil {
+				return err
+			}
+			if err := callback(ref); err != nil {
+				return err
+			}
+		}
+		offset = block.next
+	}
+}
+
+// seekIndex finds the block which may have the key by using the index
+// blocks. It returns -1 if the key is greater than all keys.
+func (t *reftable) seekIndex(indexOffset int, key []byte) (int, error) {
+	for offset := indexOffset; ; {
+		block, err := t.readBlock(offset)
+		if err != nil {
+			return -1, err
+		}
+		if block == nil {
+			return -1, nil
+		}
+		if block.blockType != reftableBlockTypeIndex {
+			return offset, nil
+		}
+		found := -1
+		for scan := block; scan != nil && scan.blockType == reftableBlockTypeIndex && found == -1; {
+			it := newReftableBlockIter(scan)
+			for {
+				ok, _, err := it.nextKey()
+				if err != nil {
+					return -1, err
+				} else if !ok {
+					break
+				}
+				position, err := t.readIndexPosition(it)
+				if err != nil {
+					return -1, err
+				}
+				if bytes.Compare(it.key, key) >= 0 {
+					found = position
+					break
+				}
+			}
+			if found == -1 {
+				if scan, err = t.readBlock(scan.next); err != nil {
+					return -1, err
+				}
+			}
+		}
+		if found == -1 {
+			return -1, nil
+		}
+		// the blocks which the index points to are before the index
+		if found >= offset {
+			return -1, errReftableCorrupted
+		}
+		offset = found
+	}
+}
+
+// lookupRef returns the record of the reference. It returns nil if the
+// table doesn't have the record.
+func (t *reftable) lookupRef(name string) (*reftableRef, error) {
+	if !t.hasRefs {
+		return nil, nil
+	}
+	key := []byte(name)
+	offset := 0
+	if t.refIndexOffset > 0 {
+		var err error
+		offset, err = t.seekIndex(t.refIndexOffset, key)
+		if err != nil || offset == -1 {
+			return nil, err
+		}
+	}
+	for {
+		block, err := t.readBlock(offset)
+		if err != nil {
+			return nil, err
+		}
+		if block == nil || block.blockType != reftableBlockTypeRef {
+			return nil, nil
+		}
+		it := newReftableBlockIter(block)
+		for {
+			ok, valueType, err := it.nextKey()
+			if err != nil {
+				return nil, err
+			} else if !ok {
+				break
+			}
+			ref, err := t.readRef(it, valueType)
+			if err != nil {
+				return nil, err
+			}
+			switch compare := strings.Compare(ref.name, name); {
+			case compare == 0:
+				return ref, nil
+			case compare > 0:
+				return nil, nil
+			}
+		}
+		offset = block.next
+	}
+}
+
+// refsFor returns the references which point to the object. The object
+// index is used if the table has it.
+func (t *reftable) refsFor(id *Oid) ([]*reftableRef, error) {
+	var result []*reftableRef
+	collect := func(ref *reftableRef) error {
+		if (ref.value != nil && ref.value.Equal(id)) || (ref.peeled != nil && ref.peeled.Equal(id)) {
+			result = append(result, ref)
+		}
+		return nil
+	}
+	if t.objOffset == 0 {
+		return result, t.forEachRef(collect)
+	}
+	key := id[:t.objIdLen]
+	for offset := t.objOffset; ; {
+		block, err := t.readBlock(offset)
+		if err != nil {
+			return nil, err
+		}
+		if block == nil || block.blockType != reftableBlockTypeObj {
+			return nil, nil
+		}
+		it := newReftableBlockIter(block)
+		for {
+			ok, count3, err := it.nextKey()
+			if err != nil {
+				return nil, err
+			} else if !ok {
+				break
+			}
+			count := uint64(count3)
+			if count3 == 0 {
+				if count, err = it.readVarint(); err != nil {
+					return nil, err
+				}
+			}
+			// each position needs one byte at least
+			if count > uint64(it.block.end-it.offset) {
+				return nil, errReftableCorrupted
+			}
+			positions := make([]int, count)
+			for i := range positions {
+				delta, err := it.readVarint()
+				if err != nil {
+					return nil, err
+				}
+				positions[i] = int(delta)
+				if i > 0 {
+					positions[i] += positions[i-1]
+				}
+			}
+			switch compare := bytes.Compare(it.key, key); {
+			case compare < 0:
+				continue
+			case compare > 0:
+				return nil, nil
+			}
+			if count == 0 {
+				// too many references to record the positions
+				return result, t.forEachRef(collect)
+			}
+			for _, position := range positions {
+				refBlock, err := t.readBlock(position)
+				if err != nil {
+					return nil, err
+				}
+				if refBlock == nil || refBlock.blockType != reftableBlockTypeRef {
+					return nil, errReftableCorrupted
+				}
+				refIter := newReftableBlockIter(refBlock)
+				for {
+					ok, valueType, err := refIter.nextKey()
+					if err != nil {
+						return nil, err
+					} else if !ok {
+						break
+					}
+					ref, err := t.readRef(refIter, valueType)
+					if err != nil {
+						return nil, err
+					}
+					collect(ref)
+				}
+			}
+			return result, nil
+		}
+		offset = block.next
+	}
+}
+
+// forEachLog calls the callback for all log records in the table including
+// deletions. Records are sorted by name and the newer entry comes first.
+func (t *reftable) forEachLog(callback func(*reftableLog) error) error {
+	if !t.hasLogs {
+		return nil
+	}
+	for offset := t.logOffset; ; {
+		block, err := t.readBlock(offset)
+		if err != nil {
+			return err
+		}
+		if block == nil || block.blockType != reftableBlockTypeLog {
+			return nil
+		}
+		it := newReftableBlockIter(block)
+		for {
+			ok, valueType, err := it.nextKey()
+			if err != nil {
+				return err
+			} else if !ok {
+				break
+			}
+			log, err := t.readLog(it, valueType)
+			if err != nil {
+				return err
+			}
+			if err := callback(log); err != nil {
+				return err
+			}
+		}
+		offset = block.next
+	}
+}
+
+// Writer
+
+type reftableRecord struct {
+	key       []byte
+	valueType byte
+	value     []byte
+}
+
+type reftableBlockWriter struct {
+	blockType    byte
+	start        int
+	headerOffset int
+	buffer       []byte
+	lastKey      []byte
+	restarts     []int
+	entries      int
+	blockSize    int
+}
+
+// add appends the record to the block. It returns false if the block
+// doesn't have enough space.
+func (w *reftableBlockWriter) add(record *reftableRecord) bool {
+	restart := w.entries%reftableRestartInterval == 0
+	prefixLen := 0
+	if !restart {
+		for prefixLen < len(w.lastKey) && prefixLen < len(record.key) && w.lastKey[prefixLen] == record.key[prefixLen] {
+			prefixLen++
+		}
+	}
+	encoded := putVarint(nil, uint64(prefixLen))
+	encoded = putVarint(encoded, uint64(len(record.key)-prefixLen)<<3|uint64(record.valueType))
+	encoded = append(encoded, record.key[prefixLen:]...)
+	encoded = append(encoded, record.value...)
+	restartCount := len(w.restarts)
+	if restart {
+		restartCount++
+	}
+	if len(w.buffer)+len(encoded)+3*restartCount+2 > w.blockSize {
+		return false
+	}
+	if restart {
+		w.restarts = append(w.restarts, len(w.buffer))
+	}
+	w.buffer = append(w.buffer, encoded...)
+	w.lastKey = record.key
+	w.entries++
+	return true
+}
+
+func (w *reftableBlockWriter) finish() ([]byte, error) {
+	for _, restart := range w.restarts {
+		var encoded [3]byte
+		putUint24(encoded[:], restart)
+		w.buffer = append(w.buffer, encoded[:]...)
+	}
+	w.buffer = append(w.buffer, byte(len(w.restarts)>>8), byte(len(w.restarts)))
+	putUint24(w.buffer[w.headerOffset+1:], len(w.buffer))
+	if w.blockType == reftableBlockTypeLog {
+		var compressed bytes.Buffer
+		compressed.Write(w.buffer[:w.headerOffset+4])
+		deflater := zlib.NewWriter(&compressed)
+		if _, err := deflater.Write(w.buffer[w.headerOffset+4:]); err != nil {
+			return nil, err
+		}
+		if err := deflater.Close(); err != nil {
+			return nil, err
+		}
+		return compressed.Bytes(), nil
+	}
+	for len(w.buffer) < w.blockSize {
+		w.buffer = append(w.buffer, 0)
+	}
+	return w.buffer, nil
+}
+
+type reftableWriter struct {
+	blockSize      int
+	minUpdateIndex uint64
+	maxUpdateIndex uint64
+	output         []byte
+	blockCount     int
+}
+
+// writeReftable serializes the records as a reftable file. The update
+// indexes of references should be in the range of min and max.
+func writeReftable(refs []*reftableRef, logs []*reftableLog, minUpdateIndex, maxUpdateIndex uint64) ([]byte, error) {
+	w := &reftableWriter{
+		blockSize:      reftableDefaultBlockSize,
+		minUpdateIndex: minUpdateIndex,
+		maxUpdateIndex: maxUpdateIndex,
+	}
+	w.output = w.header()
+
+	sortedRefs := make([]*reftableRef, len(refs))
+	copy(sortedRefs, refs)
+	sort.Sort(reftableRefsByName(sortedRefs))
+	refRecords := make([]*reftableRecord, len(sortedRefs))
+	for i, ref := range sortedRefs {
+		if i > 0 && sortedRefs[i-1].name == ref.name {
+			return nil, errors.New(fmt.Sprintf("Duplicated reference '%s' in reftable", ref.name))
+		}
+		if ref.updateIndex < minUpdateIndex || ref.updateIndex > maxUpdateIndex {
+			return nil, errors.New("Update index of the reference is out of range")
+		}
+		refRecords[i] = w.refRecord(ref)
+	}
+	refIndexOffset, refPositions, err := w.writeSection(reftableBlockTypeRef, refRecords)
+	if err != nil {
+		return nil, err
+	}
+
+	// like git, the object index is written only for large tables
+	objOffset, objIdLen, objIndexOffset := 0, 0, 0
+	if refIndexOffset > 0 {
+		objOffset = len(w.output)
+		var objRecords []*reftableRecord
+		objRecords, objIdLen = reftableObjRecords(sortedRefs, refPositions)
+		objIndexOffset, _, err = w.writeSection(reftableBlockTypeObj, objRecords)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	logRecords := make([]*reftableRecord, len(logs))
+	for i, log := range logs {
+		logRecords[i] = reftableLogRecord(log)
+	}
+	sort.Sort(reftableRecordsByKey(logRecords))
+	logOffset, logIndexOffset := 0, 0
+	if len(logRecords) > 0 {
+		if w.blockCount > 0 {
+			logOffset = len(w.output)
+		}
+		logIndexOffset, _, err = w.writeSection(reftableBlockTypeLog, logRecords)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	footer := w.header()
+	var fields [40]byte
+	binary.BigEndian.PutUint64(fields[0:], uint64(refIndexOffset))
+	binary.BigEndian.PutUint64(fields[8:], uint64(objOffset)<<5|uint64(objIdLen))
+	binary.BigEndian.PutUint64(fields[16:], uint64(objIndexOffset))
+	binary.BigEndian.PutUint64(fields[24:], uint64(logOffset))
+	binary.BigEndian.PutUint64(fields[32:], uint64(logIndexOffset))
+	footer = append(footer, fields[:]...)
+	var crc [4]byte
+	binary.BigEndian.PutUint32(crc[:], crc32.ChecksumIEEE(footer))
+	footer = append(footer, crc[:]...)
+	return append(w.output, footer...), nil
+}
+
+func (w *reftableWriter) header() []byte {
+	header := make([]byte, reftableHeaderSize)
+	copy(header, reftableMagic)
+	header[4] = reftableVersion
+	putUint24(header[5:], w.blockSize)
+	binary.BigEndian.PutUint64(header[8:], w.minUpdateIndex)
+	binary.BigEndian.PutUint64(header[16:], w.maxUpdateIndex)
+	return header
+}
+
+func (w *reftableWriter) newBlockWriter(blockType byte) *reftableBlockWriter {
+	blockWriter := &reftableBlockWriter{
+		blockType: blockType,
+		start:     len(w.output),
+		blockSize: w.blockSize,
+	}
+	if w.blockCount == 0 {
+		// the first block contains the file header
+		blockWriter.start = 0
+		blockWriter.headerOffset = reftableHeaderSize
+		blockWriter.buffer = append(blockWriter.buffer, w.output...)
+	}
+	blockWriter.buffer = append(blockWriter.buffer, blockType, 0, 0, 0)
+	return blockWriter
+}
+
+func (w *reftableWriter) flushBlock(blockWriter *reftableBlockWriter) error {
+	block, err := blockWriter.finish()
+	if err != nil {
+		return err
+	}
+	if w.blockCount == 0 {
+		w.output = block
+	} else {
+		w.output = append(w.output, block...)
+	}
+	w.blockCount++
+	return nil
+}
+
+// writeSection writes the records into blocks and the index blocks if
+// there are many blocks. It returns the offset of the index and the block
+// offset of each record.
+func (w *reftableWriter) writeSection(blockType byte, records []*reftableRecord) (int, []int, error) {
+	positions := make([]int, len(records))
+	indexOffset := 0
+	for level := 0; len(records) > 0; level++ {
+		var index []*reftableRecord
+		blockWriter := w.newBlockWriter(blockType)
+		flush := func() error {
+			index = append(index, &reftableRecord{
+				key:   blockWriter.lastKey,
+				value: putVarint(nil, uint64(blockWriter.start)),
+			})
+			return w.flushBlock(blockWriter)
+		}
+		for i, record := range records {
+			if !blockWriter.add(record) {
+				if blockWriter.entries == 0 {
+					return 0, nil, errors.New("The record is too large for reftable block")
+				}
+				if err := flush(); err != nil {
+					return 0, nil, err
+				}
+				blockWriter = w.newBlockWriter(blockType)
+				if !blockWriter.add(record) {
+					return 0, nil, errors.New("The record is too large for reftable block")
+				}
+			}
+			if level == 0 {
+				positions[i] = blockWriter.start
+			}
+		}
+		if err := flush(); err != nil {
+			return 0, nil, err
+		}
+		if len(index) < reftableIndexThreshold {
+			break
+		}
+		indexOffset = len(w.output)
+		blockType = reftableBlockTypeIndex
+		records = index
+	}
+	return indexOffset, positions, nil
+}
+
+func (w *reftableWriter) refRecord(ref *reftableRef) *reftableRecord {
+	value := putVarint(nil, ref.updateIndex-w.minUpdateIndex)
+	switch ref.valueType {
+	case reftableRefValue:
+		value = append(value, ref.value[:]...)
+	case reftableRefPeeled:
+		value = append(value, ref.value[:]...)
+		value = append(value, ref.peeled[:]...)
+	case reftableRefSymbolic:
+		value = putVarint(value, uint64(len(ref.target)))
+		value = append(value, ref.target...)
+	}
+	return &reftableRecord{
+		key:       []byte(ref.name),
+		valueType: ref.valueType,
+		value:     value,
+	}
+}
+
+func reftableLogRecord(log *reftableLog) *reftableRecord {
+	if log.deleted {
+		return &reftableRecord{
+			key:       log.key(),
+			valueType: reftableLogDeletion,
+		}
+	}
+	value := make([]byte, 0, 2*GitOidRawSize+64)
+	value = append(value, log.old[:]...)
+	value = append(value, log.new[:]...)
+	value = putVarint(value, uint64(len(log.committer.Name)))
+	value = append(value, log.committer.Name...)
+	value = putVarint(value, uint64(len(log.committer.Email)))
+	value = append(value, log.committer.Email...)
+	value = putVarint(value, uint64(log.committer.When.Unix()))
+	offset := log.committer.Offset()
+	sign := 1
+	if offset < 0 {
+		sign = -1
+		offset = -offset
+	}
+	hhmm := sign * (offset/60*100 + offset%60)
+	value = append(value, byte(uint16(hhmm)>>8), byte(uint16(hhmm)))
+	message := strings.TrimRight(log.message, "\n") + "\n"
+	value = putVarint(value, uint64(len(message)))
+	value = append(value, message...)
+	return &reftableRecord{
+		key:       log.key(),
+		valueType: reftableLogUpdate,
+		value:     value,
+	}
+}
+
+// reftableObjRecords makes the reverse index from object id to the ref
+// blocks. Ids are abbreviated to the shortest unique length.
+func reftableObjRecords(refs []*reftableRef, positions []int) ([]*reftableRecord, int) {
+	blocks := make(map[Oid][]int)
+	add := func(id *Oid, position int) {
+		list := blocks[*id]
+		if len(list) == 0 || list[len(list)-1] != position {
+			blocks[*id] = append(list, position)
+		}
+	}
+	for i, ref := range refs {
+		if ref.value != nil {
+			add(ref.value, positions[i])
+		}
+		if ref.peeled != nil {
+			add(ref.peeled, positions[i])
+		}
+	}
+	ids := make([][]byte, 0, len(blocks))
+	for id := range blocks {
+		copied := id
+		ids = append(ids, copied[:])
+	}
+	sort.Sort(byteSlices(ids))
+	idLen := reftableMinObjIdLen
+	for i := 1; i < len(ids); i++ {
+		common := 0
+		for common < GitOidRawSize && ids[i-1][common] == ids[i][common] {
+			common++
+		}
+		if common+1 > idLen {
+			idLen = common + 1
+		}
+	}
+	records := make([]*reftableRecord, len(ids))
+	for i, id := range ids {
+		var key Oid
+		copy(key[:], id)
+		list := blocks[key]
+		var value []byte
+		count := byte(0)
+		if len(list) < 8 {
+			count = byte(len(list))
+		} else {
+			value = putVarint(value, uint64(len(list)))
+		}
+		previous := 0
+		for _, position := range list {
+			value = putVarint(value, uint64(position-previous))
+			previous = position
+		}
+		records[i] = &reftableRecord{
+			key:       id[:idLen],
+			valueType: count,
+			value:     value,
+		}
+	}
+	return records, idLen
+}
+
+type reftableRefsByName []*reftableRef
+
+func (r reftableRefsByName) Len() int           { return len(r) }
+func (r reftableRefsByName) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+func (r reftableRefsByName) Less(i, j int) bool { return r[i].name < r[j].name }
+
+type reftableRecordsByKey []*reftableRecord
+
+func (r reftableRecordsByKey) Len() int           { return len(r) }
+func (r reftableRecordsByKey) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+func (r reftableRecordsByKey) Less(i, j int) bool { return bytes.Compare(r[i].key, r[j].key) < 0 }
+
+type byteSlices [][]byte
+
+func (b byteSlices) Len() int           { return len(b) }
+func (b byteSlices) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byteSlices) Less(i, j int) bool { return bytes.Compare(b[i], b[j]) < 0 }
@@ -0,0 +1,406 @@
+package git4go
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	GitReftableDir       = "reftable"
+	GitReftableListFile  = "tables.list"
+	reftableCompactRatio = 2
+)
+
+// reftableStack is the list of reftables in "tables.list". Newer tables
+// override older ones and every update adds a new table to the stack.
+// Small tables are merged by auto compaction.
+type reftableStack struct {
+	lock        sync.Mutex
+	dir         string
+	listContent string
+	tables      []*reftable
+}
+
+func newReftableStack(dir string) *reftableStack {
+	return &reftableStack{
+		dir: dir,
+	}
+}
+
+func (s *reftableStack) listPath() string {
+	return filepath.Join(s.dir, GitReftableListFile)
+}
+
+// reload reads tables.list if it was changed.
+func (s *reftableStack) reload() error {
+	content, err := ioutil.ReadFile(s.listPath())
+	if os.IsNotExist(err) {
+		content = nil
+	} else if err != nil {
+		return err
+	}
+	if s.tables != nil && string(content) == s.listContent {
+		return nil
+	}
+	opened := make(map[string]*reftable)
+	for _, table := range s.tables {
+		opened[table.name] = table
+	}
+	tables := []*reftable{}
+	for _, name := range strings.Split(string(content), "\n") {
+		if name == "" {
+			continue
+		}
+		table := opened[name]
+		if table == nil {
+			table, err = openReftable(filepath.Join(s.dir, name), name)
+			if err != nil {
+				return err
+			}
+		}
+		tables = append(tables, table)
+	}
+	s.tables = tables
+	s.listContent = string(content)
+	return nil
+}
+
+func (s *reftableStack) nextUpdateIndex() uint64 {
+	if len(s.tables) == 0 {
+		return 1
+	}
+	return s.tables[len(s.tables)-1].maxUpdateIndex + 1
+}
+
+// lookup returns the latest record of the reference. It returns nil if the
+// reference doesn't exist.
+func (s *reftableStack) lookup(name string) (*reftableRef, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	for i := len(s.tables) - 1; i >= 0; i-- {
+		ref, err := s.tables[i].lookupRef(name)
+		if err != nil {
+			return nil, err
+		}
+		if ref != nil {
+			if ref.isDeletion() {
+				return nil, nil
+			}
+			return ref, nil
+		}
+	}
+	return nil, nil
+}
+
+// refs returns all existing references sorted by name.
+func (s *reftableStack) refs() ([]*reftableRef, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	merged, err := s.mergedRefs(0, len(s.tables), true)
+	if err != nil {
+		return nil, err
+	}
+	return merged, nil
+}
+
+// mergedRefs merges the reference records of the tables in [start, end).
+// Deletions are removed if dropDeletions is true.
+func (s *reftableStack) mergedRefs(start, end int, dropDeletions bool) ([]*reftableRef, error) {
+	latest := make(map[string]*reftableRef)
+	for _, table := range s.tables[start:end] {
+		err := table.forEachRef(func(ref *reftableRef) error {
+			latest[ref.name] = ref
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	var result []*reftableRef
+	for _, ref := range latest {
+		if !dropDeletions || !ref.isDeletion() {
+			result = append(result, ref)
+		}
+	}
+	sort.Sort(reftableRefsByName(result))
+	return result, nil
+}
+
+// mergedLogs merges the log records of the tables in [start, end). If name
+// is not empty, only the logs of the reference are returned.
+func (s *reftableStack) mergedLogs(start, end int, name string, dropDeletions bool) ([]*reftableLog, error) {
+	type logKey struct {
+		name        string
+		updateIndex uint64
+	}
+	latest := make(map[logKey]*reftableLog)
+	for _, table := range s.tables[start:end] {
+		err := table.forEachLog(func(log *reftableLog) error {
+			if name == "" || log.name == name {
+				latest[logKey{log.name, log.updateIndex}] = log
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	var result []*reftableLog
+	for _, log := range latest {
+		if !dropDeletions || !log.deleted {
+			result = append(result, log)
+		}
+	}
+	sort.Sort(reftableLogsByKey(result))
+	return result, nil
+}
+
+// logs returns the reflog entries of the reference. Older entries come
+// first.
+func (s *reftableStack) logs(name string) ([]*reftableLog, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	logs, err := s.mergedLogs(0, len(s.tables), name, true)
+	if err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(logs)-1; i < j; i, j = i+1, j-1 {
+		logs[i], logs[j] = logs[j], logs[i]
+	}
+	return logs, nil
+}
+
+// refsFor returns the references which point to the object directly or
+// via the peeled value.
+func (s *reftableStack) refsFor(id *Oid) ([]*reftableRef, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	var result []*reftableRef
+	for _, table := range s.tables {
+		refs, err := table.refsFor(id)
+		if err != nil {
+			return nil, err
+		}
+		for _, ref := range refs {
+			// the newer table may have overwritten the record
+			latest, err := s.lookupLocked(ref.name)
+			if err != nil {
+				return nil, err
+			}
+			if latest != nil && latest.updateIndex == ref.updateIndex {
+				result = append(result, ref)
+			}
+		}
+	}
+	sort.Sort(reftableRefsByName(result))
+	return result, nil
+}
+
+func (s *reftableStack) lookupLocked(name string) (*reftableRef, error) {
+	for i := len(s.tables) - 1; i >= 0; i-- {
+		ref, err := s.tables[i].lookupRef(name)
+		if err != nil || ref != nil {
+			return ref, err
+		}
+	}
+	return nil, nil
+}
+
+// reftableAddition is the content of the new table. prepare is called
+// while the stack is locked, so it can check the current values safely.
+type reftableAddition func(updateIndex uint64) ([]*reftableRef, []*reftableLog, error)
+
+// add writes a new table to the top of the stack and compacts the stack
+// if needed.
+func (s *reftableStack) add(prepare reftableAddition) error {
+	lock, err := s.lockList()
+	if err != nil {
+		return err
+	}
+	if err := s.addLocked(lock, prepare); err != nil {
+		lock.Rollback()
+		return err
+	}
+	if err := lock.Commit(); err != nil {
+		return err
+	}
+	return s.autoCompact()
+}
+
+// lockList locks tables.list and reloads the stack.
+func (s *reftableStack) lockList() (*lockFile, error) {
+	lock, err := newLockFile(s.listPath(), GitRefFileMode)
+	if err != nil {
+		return nil, err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := s.reload(); err != nil {
+		lock.Rollback()
+		return nil, err
+	}
+	return lock, nil
+}
+
+// addLocked writes the new table and the new tables.list content into the
+// lock. The caller should commit the lock.
+func (s *reftableStack) addLocked(lock *lockFile, prepare reftableAddition) error {
+	updateIndex := s.nextUpdateIndex()
+	refs, logs, err := prepare(updateIndex)
+	if err != nil {
+		return err
+	}
+	if len(refs) == 0 && len(logs) == 0 {
+		_, err := lock.Write([]byte(s.listContent))
+		return err
+	}
+	maxUpdateIndex := updateIndex
+	for _, log := range logs {
+		if log.updateIndex > maxUpdateIndex {
+			maxUpdateIndex = log.updateIndex
+		}
+	}
+	name, err := s.writeTable(refs, logs, updateIndex, maxUpdateIndex)
+	if err != nil {
+		return err
+	}
+	_, err = lock.Write([]byte(s.listContent + name + "\n"))
+	return err
+}
+
+func (s *reftableStack) writeTable(refs []*reftableRef, logs []*reftableLog, minUpdateIndex, maxUpdateIndex uint64) (string, error) {
+	data, err := writeReftable(refs, logs, minUpdateIndex, maxUpdateIndex)
+	if err != nil {
+		return "", err
+	}
+	name := fmt.Sprintf("0x%012x-0x%012x-%08x.ref", minUpdateIndex, maxUpdateIndex, rand.Uint32())
+	path := filepath.Join(s.dir, name)
+	lock, err := newLockFile(path, GitRefFileMode)
+	if err != nil {
+		return "", err
+	}
+	if _, err := lock.Write(data); err != nil {
+		lock.Rollback()
+		return "", err
+	}
+	return name, lock.Commit()
+}
+
+// autoCompact merges the newest tables until each table is at least twice
+// as large as the newer one, like git does. It does nothing if the stack
+// is locked by other process.
+func (s *reftableStack) autoCompact() error {
+	lock, err := s.lockList()
+	if IsErrorCode(err, ErrLocked) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	end := len(s.tables)
+	start := end - 1
+	if start < 0 {
+		lock.Rollback()
+		return nil
+	}
+	total := len(s.tables[start].data)
+	for start > 0 && len(s.tables[start-1].data) < reftableCompactRatio*total {
+		start--
+		total += len(s.tables[start].data)
+	}
+	if end-start < 2 {
+		lock.Rollback()
+		return nil
+	}
+	return s.compact(lock, start, end)
+}
+
+// compactAll merges all tables into one table.
+func (s *reftableStack) compactAll() error {
+	lock, err := s.lockList()
+	if err != nil {
+		return err
+	}
+	if len(s.tables) < 2 {
+		lock.Rollback()
+		return nil
+	}
+	return s.compact(lock, 0, len(s.tables))
+}
+
+// compact replaces the tables in [start, end) with the merged table and
+// commits the lock. Deletions are dropped when the bottom of the stack is
+// compacted because there are no older records to hide.
+func (s *reftableStack) compact(lock *lockFile, start, end int) error {
+	dropDeletions := start == 0
+	refs, err := s.mergedRefs(start, end, dropDeletions)
+	if err != nil {
+		lock.Rollback()
+		return err
+	}
+	logs, err := s.mergedLogs(start, end, "", dropDeletions)
+	if err != nil {
+		lock.Rollback()
+		return err
+	}
+	minUpdateIndex := s.tables[start].minUpdateIndex
+	maxUpdateIndex := s.tables[end-1].maxUpdateIndex
+	var names []string
+	for _, table := range s.tables[:start] {
+		names = append(names, table.name)
+	}
+	if len(refs) > 0 || len(logs) > 0 || !dropDeletions {
+		name, err := s.writeTable(refs, logs, minUpdateIndex, maxUpdateIndex)
+		if err != nil {
+			lock.Rollback()
+			return err
+		}
+		names = append(names, name)
+	}
+	for _, table := range s.tables[end:] {
+		names = append(names, table.name)
+	}
+	content := ""
+	for _, name := range names {
+		content += name + "\n"
+	}
+	if _, err := lock.Write([]byte(content)); err != nil {
+		lock.Rollback()
+		return err
+	}
+	if err := lock.Commit(); err != nil {
+		return err
+	}
+	for _, table := range s.tables[start:end] {
+		os.Remove(filepath.Join(s.dir, table.name))
+	}
+	return nil
+}
+
+type reftableLogsByKey []*reftableLog
+
+func (l reftableLogsByKey) Len() int      { return len(l) }
+func (l reftableLogsByKey) Swap(i, j int) { l[i], l[j] = l[j], l[i] }
+func (l reftableLogsByKey) Less(i, j int) bool {
+	if l[i].name != l[j].name {
+		return l[i].name < l[j].name
+	}
+	return l[i].updateIndex > l[j].updateIndex
+}
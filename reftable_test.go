@@ -0,0 +1,560 @@
+package git4go
+
+import (
+	"./testutil"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// convertToReftable moves all references and reflogs of the repository
+// into one reftable like "git refs migrate --ref-format=reftable".
+func convertToReftable(path string) error {
+	repo, err := OpenRepository(path)
+	if err != nil {
+		return err
+	}
+	refDb := repo.NewRefDb()
+	head, err := refDb.Lookup(GitHeadFile)
+	if err != nil {
+		return err
+	}
+	refs := []*reftableRef{newReftableRef(repo, head, 1)}
+	var logs []*reftableLog
+	maxUpdateIndex := uint64(1)
+	addLogs := func(name string) error {
+		reflog, err := repo.ReadReflog(name)
+		if err != nil {
+			return err
+		}
+		for i := uint(0); i < reflog.EntryCount(); i++ {
+			entry := reflog.EntryByIndex(reflog.EntryCount() - 1 - i)
+			logs = append(logs, &reftableLog{
+				name:        name,
+				updateIndex: uint64(i + 1),
+				old:         entry.Old,
+				new:         entry.New,
+				committer:   entry.Committer,
+				message:     entry.Message,
+			})
+			if uint64(i+1) > maxUpdateIndex {
+				maxUpdateIndex = uint64(i + 1)
+			}
+		}
+		return nil
+	}
+	addLogs(GitHeadFile)
+	err = repo.ForEachReference(func(ref *Reference) error {
+		refs = append(refs, newReftableRef(repo, ref, 1))
+		return addLogs(ref.Name())
+	})
+	if err != nil {
+		return err
+	}
+	data, err := writeReftable(refs, logs, 1, maxUpdateIndex)
+	if err != nil {
+		return err
+	}
+	os.RemoveAll(filepath.Join(path, "refs"))
+	os.RemoveAll(filepath.Join(path, "logs"))
+	os.Remove(filepath.Join(path, GitPackedRefsFile))
+	os.MkdirAll(filepath.Join(path, "refs"), 0777)
+	ioutil.WriteFile(filepath.Join(path, "refs", "heads"), []byte("this repository uses the reftable format\n"), 0666)
+	ioutil.WriteFile(filepath.Join(path, GitHeadFile), []byte("ref: refs/heads/.invalid\n"), 0666)
+	os.MkdirAll(filepath.Join(path, GitReftableDir), 0777)
+	name := fmt.Sprintf("0x%012x-0x%012x-00000000.ref", 1, maxUpdateIndex)
+	ioutil.WriteFile(filepath.Join(path, GitReftableDir, name), data, 0666)
+	ioutil.WriteFile(filepath.Join(path, GitReftableDir, GitReftableListFile), []byte(name+"\n"), 0666)
+	config, err := os.OpenFile(filepath.Join(path, "config"), os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	defer config.Close()
+	_, err = config.WriteString("[extensions]\n\trefStorage = reftable\n")
+	return err
+}
+
+func Test_Reftable_Varint(t *testing.T) {
+	cases := []struct {
+		value   uint64
+		encoded []byte
+	}{
+		{0, []byte{0x00}},
+		{127, []byte{0x7f}},
+		{128, []byte{0x80, 0x00}},
+		{16511, []byte{0xff, 0x7f}},
+		{16512, []byte{0x80, 0x80, 0x00}},
+	}
+	for _, c := range cases {
+		encoded := putVarint(nil, c.value)
+		if !bytes.Equal(encoded, c.encoded) {
+			t.Errorf("encoding %d: %x != %x", c.value, encoded, c.encoded)
+		}
+		decoded, offset, ok := getVarint(c.encoded, 0)
+		if !ok || decoded != c.value || offset != len(c.encoded) {
+			t.Errorf("decoding %x: %d != %d", c.encoded, decoded, c.value)
+		}
+	}
+}
+
+// Test_Reftable_ReadHandmadeTable reads the table which is assembled by
+// hand from the format specification.
+func Test_Reftable_ReadHandmadeTable(t *testing.T) {
+	id, _ := NewOid("a65fedf39aefe402d3bb6e24df4d4f5fe4547750")
+	header := []byte("REFT\x01\x00\x01\x00")
+	header = append(header, 0, 0, 0, 0, 0, 0, 0, 5)
+	header = append(header, 0, 0, 0, 0, 0, 0, 0, 6)
+
+	var records []byte
+	// "HEAD" -> refs/heads/master, update index 5
+	records = append(records, 0x00, 4<<3|3)
+	records = append(records, "HEAD"...)
+	records = append(records, 0x00, 17)
+	records = append(records, "refs/heads/master"...)
+	// "refs/heads/master" at update index 6
+	// (17 << 3 | 1) = 137 needs two bytes of varint
+	records = append(records, 0x00, 0x80, 0x09)
+	records = append(records, "refs/heads/master"...)
+	records = append(records, 0x01)
+	records = append(records, id[:]...)
+	// "refs/heads/trunk" shares "refs/heads/" with the previous key, deleted
+	records = append(records, 11, 5<<3|0)
+	records = append(records, "trunk"...)
+	records = append(records, 0x01)
+
+	blockLen := len(header) + 4 + len(records) + 3 + 2
+	data := append([]byte{}, header...)
+	data = append(data, 'r', 0, 0, byte(blockLen))
+	data = append(data, records...)
+	data = append(data, 0, 0, byte(len(header)+4), 0, 1)
+	footer := append([]byte{}, header...)
+	footer = append(footer, make([]byte, 40)...)
+	var crc [4]byte
+	binary.BigEndian.PutUint32(crc[:], crc32.ChecksumIEEE(footer))
+	data = append(data, footer...)
+	data = append(data, crc[:]...)
+
+	table, err := parseReftable(data)
+	if err != nil {
+		t.Error("err should be nil:", err)
+		return
+	}
+	if table.minUpdateIndex != 5 || table.maxUpdateIndex != 6 || table.blockSize != 256 {
+		t.Error("header is wrong:", table.minUpdateIndex, table.maxUpdateIndex, table.blockSize)
+	}
+	head, _ := table.lookupRef("HEAD")
+	if head == nil || head.valueType != reftableRefSymbolic || head.target != "refs/heads/master" || head.updateIndex != 5 {
+		t.Error("HEAD is wrong:", head)
+	}
+	master, _ := table.lookupRef("refs/heads/master")
+	if master == nil || !master.value.Equal(id) || master.updateIndex != 6 {
+		t.Error("master is wrong:", master)
+	}
+	trunk, _ := table.lookupRef("refs/heads/trunk")
+	if trunk == nil || !trunk.isDeletion() {
+		t.Error("trunk should be a deletion:", trunk)
+	}
+	missing, _ := table.lookupRef("refs/heads/missing")
+	if missing != nil {
+		t.Error("missing reference should not be found")
+	}
+
+	// writer generates the same records
+	written, err := writeReftable([]*reftableRef{head, master, trunk}, nil, 5, 6)
+	if err != nil {
+		t.Error("err should be nil:", err)
+	}
+	if !bytes.Equal(written[len(header):blockLen], data[len(header):blockLen]) {
+		t.Errorf("written block is different:\n%x\n%x", written[:blockLen], data[:blockLen])
+	}
+
+	data[len(data)-1] ^= 0xff
+	if _, err := parseReftable(data); err == nil {
+		t.Error("broken checksum should be detected")
+	}
+}
+
+// handmadeReftable returns the table which has one reference block with
+// the records.
+func handmadeReftable(records []byte) []byte {
+	header := []byte("REFT\x01\x00\x01\x00")
+	header = append(header, 0, 0, 0, 0, 0, 0, 0, 1)
+	header = append(header, 0, 0, 0, 0, 0, 0, 0, 1)
+	blockLen := len(header) + 4 + len(records) + 3 + 2
+	data := append([]byte{}, header...)
+	data = append(data, 'r', 0, byte(blockLen>>8), byte(blockLen))
+	data = append(data, records...)
+	data = append(data, 0, 0, byte(len(header)+4), 0, 1)
+	footer := append([]byte{}, header...)
+	footer = append(footer, make([]byte, 40)...)
+	var crc [4]byte
+	binary.BigEndian.PutUint32(crc[:], crc32.ChecksumIEEE(footer))
+	data = append(data, footer...)
+	return append(data, crc[:]...)
+}
+
+func Test_Reftable_CorruptedRecords(t *testing.T) {
+	id, _ := NewOid("a65fedf39aefe402d3bb6e24df4d4f5fe4547750")
+	huge := putVarint(nil, 1<<63)
+	join := func(parts ...[]byte) []byte {
+		return bytes.Join(parts, nil)
+	}
+	cases := []struct {
+		name    string
+		records []byte
+	}{
+		{"prefix length", join(huge, []byte{4<<3 | 1}, []byte("HEAD"), []byte{0}, id[:])},
+		{"prefix longer than previous key", join([]byte{1, 4<<3 | 1}, []byte("HEAD"), []byte{0}, id[:])},
+		{"suffix length", join([]byte{0}, putVarint(nil, 1<<62|1), []byte("HEAD"))},
+		{"symbolic target length", join([]byte{0, 4<<3 | 3}, []byte("HEAD"), []byte{0}, huge, []byte("refs/heads/master"))},
+		{"truncated object id", join([]byte{0, 4<<3 | 1}, []byte("HEAD"), []byte{0}, id[:10])},
+		{"value type", join([]byte{0, 4<<3 | 7}, []byte("HEAD"), []byte{0})},
+	}
+	for _, c := range cases {
+		table, err := parseReftable(handmadeReftable(c.records))
+		if err != nil {
+			t.Error("err should be nil:", c.name, err)
+			continue
+		}
+		if _, err := table.lookupRef("HEAD"); err != errReftableCorrupted {
+			t.Error("broken record should be detected:", c.name, err)
+		}
+		if err := table.forEachRef(func(*reftableRef) error { return nil }); err != errReftableCorrupted {
+			t.Error("broken record should be detected:", c.name, err)
+		}
+	}
+}
+
+func Test_Reftable_CorruptedTable(t *testing.T) {
+	var refs []*reftableRef
+	for i := 0; i < 3000; i++ {
+		id, _ := NewOid(fmt.Sprintf("%040x", i*7919+1))
+		refs = append(refs, &reftableRef{
+			name:        fmt.Sprintf("refs/tags/v%d.%d", i/100, i%100),
+			updateIndex: 1,
+			valueType:   reftableRefValue,
+			value:       id,
+		})
+	}
+	data, _ := writeReftable(refs, nil, 1, 1)
+	table, _ := parseReftable(data)
+	if table.objOffset == 0 || table.refIndexOffset == 0 {
+		t.Error("table should have indexes:", table.objOffset, table.refIndexOffset)
+		return
+	}
+
+	// truncated tables
+	for _, size := range []int{0, 4, reftableHeaderSize, table.objOffset, len(data) - 1} {
+		if _, err := parseReftable(data[:size]); err == nil {
+			t.Error("truncated table should not be parsed:", size)
+		}
+	}
+
+	// too long abbreviated ids of the object index
+	broken := append([]byte{}, data...)
+	footer := broken[table.footerOffset:]
+	fields := footer[table.headerSize:]
+	binary.BigEndian.PutUint64(fields[8:], uint64(table.objOffset)<<5|31)
+	binary.BigEndian.PutUint32(footer[len(footer)-4:], crc32.ChecksumIEEE(footer[:len(footer)-4]))
+	if _, err := parseReftable(broken); err != errReftableCorrupted {
+		t.Error("object id length should be checked:", err)
+	}
+
+	// broken bytes in the blocks don't make the reader panic
+	for offset := table.headerSize; offset < table.footerOffset; offset += 13 {
+		broken := append([]byte{}, data...)
+		broken[offset] ^= 0xff
+		table, err := parseReftable(broken)
+		if err != nil {
+			continue
+		}
+		table.lookupRef("refs/tags/v12.34")
+		table.refsFor(refs[1234].value)
+	}
+}
+
+func Test_Reftable_WriteAndReadLargeTable(t *testing.T) {
+	var refs []*reftableRef
+	ids := make(map[string]*Oid)
+	for i := 0; i < 3000; i++ {
+		id, _ := NewOid(fmt.Sprintf("%040x", i*7919+1))
+		name := fmt.Sprintf("refs/tags/v%d.%d", i/100, i%100)
+		ids[name] = id
+		refs = append(refs, &reftableRef{
+			name:        name,
+			updateIndex: uint64(1 + i%3),
+			valueType:   reftableRefValue,
+			value:       id,
+		})
+	}
+	committer := &Signature{
+		Name:  "Tester",
+		Email: "tester@example.com",
+		When:  time.Unix(1400000000, 0).In(time.FixedZone("", -(7*60+30)*60)),
+	}
+	var logs []*reftableLog
+	for i := 0; i < 500; i++ {
+		logs = append(logs, &reftableLog{
+			name:        "refs/heads/master",
+			updateIndex: uint64(i + 1),
+			old:         new(Oid),
+			new:         ids["refs/tags/v0.1"],
+			committer:   committer,
+			message:     fmt.Sprintf("commit: change %d", i),
+		})
+	}
+	data, err := writeReftable(refs, logs, 1, 500)
+	if err != nil {
+		t.Error("err should be nil:", err)
+		return
+	}
+	table, err := parseReftable(data)
+	if err != nil {
+		t.Error("err should be nil:", err)
+		return
+	}
+	if table.refIndexOffset == 0 || table.objOffset == 0 || table.logOffset == 0 {
+		t.Error("large table should have indexes:", table.refIndexOffset, table.objOffset, table.logOffset)
+	}
+	for name, id := range ids {
+		ref, err := table.lookupRef(name)
+		if err != nil || ref == nil || !ref.value.Equal(id) {
+			t.Error("reference is not found:", name, err)
+			break
+		}
+	}
+	count := 0
+	previous := ""
+	table.forEachRef(func(ref *reftableRef) error {
+		if ref.name <= previous {
+			t.Error("references should be sorted:", previous, ref.name)
+		}
+		previous = ref.name
+		count++
+		return nil
+	})
+	if count != 3000 {
+		t.Error("all references should be read:", count)
+	}
+	found, err := table.refsFor(ids["refs/tags/v12.34"])
+	if err != nil || len(found) != 1 || found[0].name != "refs/tags/v12.34" {
+		t.Error("object index is wrong:", found, err)
+	}
+
+	var readLogs []*reftableLog
+	table.forEachLog(func(log *reftableLog) error {
+		readLogs = append(readLogs, log)
+		return nil
+	})
+	if len(readLogs) != 500 {
+		t.Error("all logs should be read:", len(readLogs))
+		return
+	}
+	// newer entry comes first
+	latest := readLogs[0]
+	if latest.updateIndex != 500 || latest.message != "commit: change 499" {
+		t.Error("log order is wrong:", latest.updateIndex, latest.message)
+	}
+	if latest.committer.Name != "Tester" || latest.committer.Offset() != -(7*60+30) || latest.committer.When.Unix() != 1400000000 {
+		t.Error("committer is wrong:", latest.committer)
+	}
+}
+
+func Test_Reftable_Repository(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	before := make(map[string]string)
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	repo.ForEachReference(func(ref *Reference) error {
+		before[ref.Name()] = ref.Target().String() + ref.SymbolicTarget()
+		return nil
+	})
+	headLog, _ := repo.ReadReflog(GitHeadFile)
+	if err := convertToReftable("test_resources/testrepo.git"); err != nil {
+		t.Error("err should be nil:", err)
+		return
+	}
+
+	repo, err := OpenRepository("test_resources/testrepo.git")
+	if err != nil {
+		t.Error("err should be nil:", err)
+		return
+	}
+	head, err := repo.Head()
+	if err != nil || head.Name() != "refs/heads/master" || head.Target().String() != "a65fedf39aefe402d3bb6e24df4d4f5fe4547750" {
+		t.Error("HEAD is wrong:", head, err)
+	}
+	after := make(map[string]string)
+	repo.ForEachReference(func(ref *Reference) error {
+		after[ref.Name()] = ref.Target().String() + ref.SymbolicTarget()
+		return nil
+	})
+	if len(before) != len(after) {
+		t.Error("reference count is different:", len(before), len(after))
+	}
+	for name, value := range before {
+		if after[name] != value {
+			t.Error("reference value is different:", name, value, after[name])
+		}
+	}
+	var tags []string
+	repo.ForEachGlobReferenceName("refs/tags/*", func(name string) error {
+		tags = append(tags, name)
+		return nil
+	})
+	if len(tags) == 0 || !strings.HasPrefix(tags[0], "refs/tags/") {
+		t.Error("glob iteration is wrong:", tags)
+	}
+	if _, err := repo.LookupReference("refs/heads/missing"); !IsErrorCode(err, ErrNotFound) {
+		t.Error("missing reference should not be found:", err)
+	}
+	reflog, _ := repo.ReadReflog(GitHeadFile)
+	if reflog.EntryCount() != headLog.EntryCount() || reflog.EntryByIndex(0).Message != headLog.EntryByIndex(0).Message {
+		t.Error("reflog is wrong:", reflog.EntryCount(), headLog.EntryCount())
+	}
+	obj, err := repo.RevparseSingle("HEAD@{1}")
+	if err != nil || obj.Id().String() != headLog.EntryByIndex(1).New.String() {
+		t.Error("revparse with reflog is wrong:", err)
+	}
+}
+
+func Test_Reftable_UpdateReferences(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	convertToReftable("test_resources/testrepo.git")
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	id, _ := NewOid("c47800c7266a2be04c571c04d5a6614691ea99bd")
+	master, _ := NewOid("a65fedf39aefe402d3bb6e24df4d4f5fe4547750")
+
+	ref, err := repo.CreateReference("refs/heads/new-branch", id, false, nil, "branch: Created")
+	if err != nil {
+		t.Error("err should be nil:", err)
+		return
+	}
+	if _, err := repo.CreateReference("refs/heads/new-branch", id, false, nil, ""); !IsErrorCode(err, ErrExists) {
+		t.Error("existing reference should not be overwritten:", err)
+	}
+	if _, err := repo.CreateReference("refs/heads/new-branch/child", id, false, nil, ""); !IsErrorCode(err, ErrExists) {
+		t.Error("conflicting name should be rejected:", err)
+	}
+	reflog, _ := repo.ReadReflog("refs/heads/new-branch")
+	if reflog.EntryCount() != 1 || reflog.EntryByIndex(0).Message != "branch: Created" {
+		t.Error("reflog should be written:", reflog.EntryCount())
+	}
+
+	renamed, err := ref.Rename("refs/heads/renamed", false, nil, "renamed")
+	if err != nil {
+		t.Error("err should be nil:", err)
+		return
+	}
+	if _, err := repo.LookupReference("refs/heads/new-branch"); !IsErrorCode(err, ErrNotFound) {
+		t.Error("old name should be removed:", err)
+	}
+	reflog, _ = repo.ReadReflog("refs/heads/renamed")
+	if reflog.EntryCount() != 2 {
+		t.Error("reflog should be moved:", reflog.EntryCount())
+	}
+	reflog, _ = repo.ReadReflog("refs/heads/new-branch")
+	if reflog.EntryCount() != 0 {
+		t.Error("old reflog should be removed:", reflog.EntryCount())
+	}
+	if err := renamed.Delete(); err != nil {
+		t.Error("err should be nil:", err)
+	}
+	if _, err := repo.LookupReference("refs/heads/renamed"); !IsErrorCode(err, ErrNotFound) {
+		t.Error("reference should be deleted:", err)
+	}
+
+	headRef, _ := repo.LookupReference("refs/heads/master")
+	if _, err := headRef.SetTarget(id, nil, "reset: moving"); err != nil {
+		t.Error("err should be nil:", err)
+	}
+	if _, err := headRef.SetTarget(master, nil, ""); !IsErrorCode(err, ErrModified) {
+		t.Error("stale reference should not be updated:", err)
+	}
+	reflog, _ = repo.ReadReflog(GitHeadFile)
+	if entry := reflog.EntryByIndex(0); entry.Message != "reset: moving" || !entry.Old.Equal(master) || !entry.New.Equal(id) {
+		t.Error("HEAD reflog is wrong:", entry.Message)
+	}
+	reflog.Drop(0, true)
+	reflog.Write()
+	reflog, _ = repo.ReadReflog(GitHeadFile)
+	if reflog.EntryByIndex(0).Message == "reset: moving" {
+		t.Error("dropped entry should be removed")
+	}
+
+	// auto compaction keeps the stack small
+	tables, _ := ioutil.ReadFile("test_resources/testrepo.git/reftable/tables.list")
+	if count := strings.Count(string(tables), "\n"); count > 5 {
+		t.Error("stack should be compacted:", count)
+	}
+	if err := repo.NewRefDb().PackAll(false); err != nil {
+		t.Error("err should be nil:", err)
+	}
+	tables, _ = ioutil.ReadFile("test_resources/testrepo.git/reftable/tables.list")
+	if count := strings.Count(string(tables), "\n"); count != 1 {
+		t.Error("stack should be compacted into one table:", count)
+	}
+	files, _ := ioutil.ReadDir("test_resources/testrepo.git/reftable")
+	if len(files) != 2 {
+		t.Error("old tables should be removed:", len(files))
+	}
+	ref, _ = repo.LookupReference("refs/heads/master")
+	if ref == nil || !ref.Target().Equal(id) {
+		t.Error("master should be kept after compaction")
+	}
+}
+
+func Test_Reftable_Transaction(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	convertToReftable("test_resources/testrepo.git")
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	id, _ := NewOid("c47800c7266a2be04c571c04d5a6614691ea99bd")
+	master, _ := NewOid("a65fedf39aefe402d3bb6e24df4d4f5fe4547750")
+
+	tx := repo.NewRefTransaction()
+	tx.Update("refs/heads/master", id, master, nil, "")
+	tx.Create("refs/queue/done", master, nil, "")
+	tx.Delete("refs/heads/packed", nil)
+	if _, err := repo.CreateReference("refs/heads/other", id, false, nil, ""); !IsErrorCode(err, ErrLocked) {
+		t.Error("stack should be locked during transaction:", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Error("err should be nil:", err)
+	}
+	ref, _ := repo.LookupReference("refs/heads/master")
+	if !ref.Target().Equal(id) {
+		t.Error("master should be updated")
+	}
+	if _, err := repo.LookupReference("refs/queue/done"); err != nil {
+		t.Error("reference should be created:", err)
+	}
+	if _, err := repo.LookupReference("refs/heads/packed"); !IsErrorCode(err, ErrNotFound) {
+		t.Error("reference should be deleted:", err)
+	}
+
+	tx = repo.NewRefTransaction()
+	tx.Update("refs/heads/master", master, nil, nil, "")
+	if err := tx.Update("refs/heads/br2", id, id, nil, ""); !IsErrorCode(err, ErrModified) {
+		t.Error("unmatched value should be rejected:", err)
+	}
+	tx.Rollback()
+	ref, _ = repo.LookupReference("refs/heads/master")
+	if !ref.Target().Equal(id) {
+		t.Error("rolled back transaction should not change anything")
+	}
+	if _, err := repo.CreateReference("refs/heads/other", id, false, nil, ""); err != nil {
+		t.Error("lock should be released:", err)
+	}
+}
@@ -18,6 +18,8 @@ const (
 	GitHeadFile                   string = "HEAD"
 	GitRefsDir                    string = "refs/"
 	GitRefsTagsDir                string = "refs/tags"
+	GitRefsHeadsDir               string = "refs/heads/"
+	GitRefsRemotesDir             string = "refs/remotes/"
 )
 
 // Repository type and its methods
@@ -153,6 +155,9 @@ func findRepo(startPath string, flags uint32, ceilingDirs []string) (repoPath, p
 	if err == nil && (flags&GIT_REPOSITORY_OPEN_BARE) == 0 {
 		if len(repoPath) == 0 {
 			parentPath = ""
+		} else if linkPath != "" {
+			// the working directory has the ".git" file
+			parentPath = filepath.Dir(linkPath) + string(filepath.Separator)
 		} else {
 			parentPath = filepath.Dir(repoPath[:len(repoPath)-1]) + string(filepath.Separator)
 		}
@@ -172,7 +177,11 @@ func readGitFile(path string) (string, error) {
 	if !strings.HasPrefix(content, "gitdir:") {
 		return "", errors.New(".git file shoudl have 'gitdir:' prefix")
 	}
-	return filepath.Clean(filepath.Join(filepath.Dir(path), strings.TrimSpace(content[7:]))), nil
+	gitDir := filepath.FromSlash(strings.TrimSpace(content[7:]))
+	if filepath.IsAbs(gitDir) {
+		return filepath.Clean(gitDir), nil
+	}
+	return filepath.Clean(filepath.Join(filepath.Dir(path), gitDir)), nil
 }
 
 func isContainsFile(dir, fileName string) bool {
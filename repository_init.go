@@ -0,0 +1,343 @@
+package git4go
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	GitDirName            = ".git"
+	GitDescriptionFile    = "description"
+	GitDefaultBranch      = "master"
+	GitDefaultDescription = "Unnamed repository; edit this file 'description' to name the repository.\n"
+
+	// Values of RepositoryInitOptions.Mode. Other values are used as the
+	// permission of the directories (e.g. 0770).
+	RepositoryInitSharedUmask uint32 = 0
+	RepositoryInitSharedGroup uint32 = 02775
+	RepositoryInitSharedAll   uint32 = 02777
+)
+
+type RepositoryInitOptions struct {
+	Bare bool
+	// NoReinit makes it fail with ErrExists if the repository exists.
+	NoReinit bool
+	// InitialHead is the branch which HEAD points to. If it is empty,
+	// init.defaultBranch or "master" is used.
+	InitialHead string
+	// TemplatePath is the directory copied into the new repository. If it
+	// is empty and ExternalTemplate is true, init.templateDir or the
+	// system template directory is used.
+	TemplatePath     string
+	ExternalTemplate bool
+	Description      string
+	// Mode is core.sharedRepository. See RepositoryInitShared* constants.
+	Mode uint32
+	// SeparateGitDir stores the repository in the directory and writes
+	// a ".git" file which points to it into the working directory.
+	SeparateGitDir string
+	// ObjectFormat is "sha1" (default) or "sha256". git4go itself can
+	// read only SHA-1 repositories.
+	ObjectFormat string
+}
+
+// InitRepository creates a new repository like "git init". If bare is
+// false, the repository is created in ".git" under the path.
+func InitRepository(path string, bare bool) (*Repository, error) {
+	return InitRepositoryExtended(path, &RepositoryInitOptions{
+		Bare: bare,
+	})
+}
+
+// InitRepositoryExtended creates a new repository with the options. If the
+// repository exists already, only missing files are created like "git init"
+// does.
+func InitRepositoryExtended(path string, opts *RepositoryInitOptions) (*Repository, error) {
+	if opts == nil {
+		opts = &RepositoryInitOptions{}
+	}
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	objectFormat := strings.ToLower(opts.ObjectFormat)
+	if objectFormat != "" && objectFormat != "sha1" && objectFormat != "sha256" {
+		return nil, MakeGitError(fmt.Sprintf("Unknown object format '%s'", opts.ObjectFormat), ErrInvalidSpec)
+	}
+	head, err := initialHead(opts)
+	if err != nil {
+		return nil, err
+	}
+	gitDir := path
+	if opts.SeparateGitDir != "" {
+		gitDir, err = filepath.Abs(opts.SeparateGitDir)
+		if err != nil {
+			return nil, err
+		}
+	} else if !opts.Bare {
+		gitDir = filepath.Join(path, GitDirName)
+	}
+	if isValidRepositoryPath(gitDir) && opts.NoReinit {
+		return nil, MakeGitError(fmt.Sprintf("Repository '%s' already exists", gitDir), ErrExists)
+	}
+
+	init := &repositoryInit{
+		gitDir:   gitDir,
+		dirMode:  os.FileMode(GitObjectDirMode),
+		fileMode: os.FileMode(GitRefFileMode),
+		shared:   opts.Mode != RepositoryInitSharedUmask,
+	}
+	if init.shared {
+		init.dirMode = os.FileMode(opts.Mode&0777) | os.ModeSetgid
+		init.fileMode = os.FileMode(opts.Mode & 0666)
+	}
+	if err := init.mkdir(gitDir); err != nil {
+		return nil, err
+	}
+	if err := init.copyTemplate(opts); err != nil {
+		return nil, err
+	}
+	for _, dir := range []string{"objects/info", "objects/pack", "refs/heads", "refs/tags"} {
+		if err := init.mkdir(filepath.Join(gitDir, dir)); err != nil {
+			return nil, err
+		}
+	}
+	if err := init.writeConfig(opts, objectFormat); err != nil {
+		return nil, err
+	}
+	description := opts.Description
+	if description != "" && !strings.HasSuffix(description, "\n") {
+		description += "\n"
+	}
+	if err := init.writeFile(GitDescriptionFile, description, GitDefaultDescription); err != nil {
+		return nil, err
+	}
+	// like git, an existing HEAD is kept on reinitialization
+	if err := init.writeFile(GitHeadFile, "", GitSymbolReference+head+"\n"); err != nil {
+		return nil, err
+	}
+	if opts.SeparateGitDir != "" {
+		if err := init.mkdir(path); err != nil {
+			return nil, err
+		}
+		gitFile := filepath.Join(path, GitDirName)
+		content := "gitdir: " + filepath.ToSlash(gitDir) + "\n"
+		if err := ioutil.WriteFile(gitFile, []byte(content), init.fileMode); err != nil {
+			return nil, err
+		}
+		if opts.Bare {
+			return OpenRepository(gitDir)
+		}
+	}
+	return OpenRepository(path)
+}
+
+// internal functions
+
+type repositoryInit struct {
+	gitDir   string
+	dirMode  os.FileMode
+	fileMode os.FileMode
+	shared   bool
+}
+
+// mkdir creates the directory. On a shared repository, the permission is
+// set explicitly because it shouldn't be limited by umask.
+func (i *repositoryInit) mkdir(path string) error {
+	if err := os.MkdirAll(path, i.dirMode); err != nil {
+		return err
+	}
+	if i.shared {
+		return os.Chmod(path, i.dirMode)
+	}
+	return nil
+}
+
+// writeFile writes the file only if it doesn't exist. An empty content
+// means defaultContent, which is used only when the file is created.
+func (i *repositoryInit) writeFile(name, content, defaultContent string) error {
+	path := filepath.Join(i.gitDir, name)
+	if _, err := os.Stat(path); err == nil {
+		if content == "" {
+			return nil
+		}
+	} else if content == "" {
+		content = defaultContent
+	}
+	if err := ioutil.WriteFile(path, []byte(content), i.fileMode); err != nil {
+		return err
+	}
+	if i.shared {
+		return os.Chmod(path, i.fileMode)
+	}
+	return nil
+}
+
+// copyTemplate copies the files in the template directory which don't
+// exist in the repository. "config" in the template is not copied.
+func (i *repositoryInit) copyTemplate(opts *RepositoryInitOptions) error {
+	templatePath := opts.TemplatePath
+	if templatePath == "" {
+		if !opts.ExternalTemplate {
+			return nil
+		}
+		templatePath = findTemplateDir()
+		if templatePath == "" {
+			return nil
+		}
+	}
+	return filepath.Walk(templatePath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(templatePath, path)
+		if err != nil || relPath == "." || relPath == ConfigFileNameInrepo {
+			return err
+		}
+		target := filepath.Join(i.gitDir, relPath)
+		if info.IsDir() {
+			return i.mkdir(target)
+		}
+		if _, err := os.Stat(target); err == nil {
+			return nil
+		}
+		mode := i.fileMode
+		if info.Mode()&0111 != 0 {
+			mode |= 0111 & (mode >> 2)
+		}
+		return copyTemplateFile(path, target, mode, i.shared)
+	})
+}
+
+func copyTemplateFile(source, target string, mode os.FileMode, shared bool) error {
+	reader, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	writer, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(writer, reader)
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && shared {
+		err = os.Chmod(target, mode)
+	}
+	return err
+}
+
+// findTemplateDir returns init.templateDir in the global config or the
+// system template directory. It returns an empty string if neither exists.
+func findTemplateDir() string {
+	config := globalConfig()
+	for _, name := range []string{"init.templateDir", "init.templatedir"} {
+		if value, err := config.LookupString(name); err == nil && value != "" {
+			return value
+		}
+	}
+	path, err := findInDirList("", "template")
+	if err != nil {
+		return ""
+	}
+	return path
+}
+
+// globalConfig returns the config without repository's one.
+func globalConfig() *Config {
+	config, _ := NewConfig()
+	if path, err := ConfigFindGlobal(); err == nil {
+		config.AddFile(path, ConfigLevelGlobal, false)
+	}
+	if path, err := ConfigFindXDG(); err == nil {
+		config.AddFile(path, ConfigLevelXDG, false)
+	}
+	if path, err := ConfigFindSystem(); err == nil {
+		config.AddFile(path, ConfigLevelSystem, false)
+	}
+	return config
+}
+
+// writeConfig writes the core settings if the repository doesn't have
+// config yet.
+func (i *repositoryInit) writeConfig(opts *RepositoryInitOptions, objectFormat string) error {
+	if _, err := os.Stat(filepath.Join(i.gitDir, ConfigFileNameInrepo)); err == nil {
+		return nil
+	}
+	formatVersion := 0
+	if objectFormat == "sha256" {
+		formatVersion = 1
+	}
+	lines := []string{
+		"[core]",
+		fmt.Sprintf("\trepositoryformatversion = %d", formatVersion),
+		fmt.Sprintf("\tfilemode = %t", isFileModeSupported(i.gitDir)),
+		fmt.Sprintf("\tbare = %t", opts.Bare),
+	}
+	if !opts.Bare {
+		lines = append(lines, "\tlogallrefupdates = true")
+	}
+	if i.shared {
+		switch opts.Mode {
+		case RepositoryInitSharedGroup:
+			lines = append(lines, "\tsharedrepository = 1")
+		case RepositoryInitSharedAll:
+			lines = append(lines, "\tsharedrepository = 2")
+		default:
+			lines = append(lines, fmt.Sprintf("\tsharedrepository = 0%o", opts.Mode&0666))
+		}
+		lines = append(lines, "[receive]", "\tdenyNonFastforwards = true")
+	}
+	if formatVersion == 1 {
+		lines = append(lines, "[extensions]", "\tobjectformat = "+objectFormat)
+	}
+	return i.writeFile(ConfigFileNameInrepo, strings.Join(lines, "\n")+"\n", "")
+}
+
+// initialHead returns the reference name of the initial branch.
+func initialHead(opts *RepositoryInitOptions) (string, error) {
+	branch := opts.InitialHead
+	if branch == "" {
+		config := globalConfig()
+		for _, name := range []string{"init.defaultBranch", "init.defaultbranch"} {
+			if value, err := config.LookupString(name); err == nil && value != "" {
+				branch = value
+				break
+			}
+		}
+		if branch == "" {
+			branch = GitDefaultBranch
+		}
+	}
+	if !strings.HasPrefix(branch, GitRefsDir) {
+		branch = GitRefsHeadsDir + branch
+	}
+	return referenceNormalize(branch, false, false)
+}
+
+// isFileModeSupported checks whether the file system keeps the executable
+// bit like git does for core.filemode.
+func isFileModeSupported(dir string) bool {
+	file, err := ioutil.TempFile(dir, "filemode-")
+	if err != nil {
+		return false
+	}
+	path := file.Name()
+	file.Close()
+	defer os.Remove(path)
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	if err := os.Chmod(path, info.Mode()^0100); err != nil {
+		return false
+	}
+	changed, err := os.Stat(path)
+	return err == nil && changed.Mode() != info.Mode()
+}
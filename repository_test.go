@@ -2,7 +2,9 @@ package git4go
 
 import (
 	"./testutil"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -197,3 +199,159 @@ func Test_OpenRepositoryExtended_failure(t *testing.T) {
 		t.Error("it should not be null when loading repository in failure")
 	}
 }
+
+func Test_InitRepository_Standard(t *testing.T) {
+	testutil.PrepareEmptyWorkDir("test_resources/init_repo")
+	defer testutil.CleanupEmptyWorkDir()
+
+	repo, err := InitRepository("test_resources/init_repo/work", false)
+	if err != nil {
+		t.Error("err should be nil:", err)
+		return
+	}
+	if repo.IsBare() || !strings.HasSuffix(repo.Path(), "init_repo/work/.git/") {
+		t.Error("repository is wrong:", repo.Path())
+	}
+	if !strings.HasSuffix(repo.Workdir(), "init_repo/work/") {
+		t.Error("workdir is wrong:", repo.Workdir())
+	}
+	head, _ := ioutil.ReadFile("test_resources/init_repo/work/.git/HEAD")
+	if string(head) != "ref: refs/heads/master\n" {
+		t.Error("HEAD is wrong:", string(head))
+	}
+	for _, dir := range []string{"objects/info", "objects/pack", "refs/heads", "refs/tags"} {
+		if !isContainsDir("test_resources/init_repo/work/.git", dir) {
+			t.Error("directory should be created:", dir)
+		}
+	}
+	if logAll, _ := repo.Config().LookupBool("core.logallrefupdates"); !logAll {
+		t.Error("core.logallrefupdates should be true")
+	}
+	if !isContainsFile("test_resources/init_repo/work/.git", "description") {
+		t.Error("description should be created")
+	}
+
+	if _, err := repo.Odb(); err != nil {
+		t.Error("object database should be opened:", err)
+	}
+	if _, err := repo.CreateSymbolicReference("refs/heads/alias", "refs/heads/master", false, nil, ""); err != nil {
+		t.Error("references should be written:", err)
+	}
+
+	// reinitialization keeps the existing files
+	_, err = InitRepositoryExtended("test_resources/init_repo/work", &RepositoryInitOptions{
+		InitialHead: "main",
+	})
+	if err != nil {
+		t.Error("err should be nil:", err)
+	}
+	head, _ = ioutil.ReadFile("test_resources/init_repo/work/.git/HEAD")
+	if string(head) != "ref: refs/heads/master\n" {
+		t.Error("HEAD should be kept:", string(head))
+	}
+	_, err = InitRepositoryExtended("test_resources/init_repo/work", &RepositoryInitOptions{
+		NoReinit: true,
+	})
+	if !IsErrorCode(err, ErrExists) {
+		t.Error("it should fail if NoReinit is true:", err)
+	}
+}
+
+func Test_InitRepository_Bare(t *testing.T) {
+	testutil.PrepareEmptyWorkDir("test_resources/init_repo")
+	defer testutil.CleanupEmptyWorkDir()
+
+	repo, err := InitRepositoryExtended("test_resources/init_repo/bare.git", &RepositoryInitOptions{
+		Bare:        true,
+		InitialHead: "main",
+		Description: "bare test repository",
+	})
+	if err != nil {
+		t.Error("err should be nil:", err)
+		return
+	}
+	if !repo.IsBare() || !strings.HasSuffix(repo.Path(), "init_repo/bare.git/") {
+		t.Error("repository is wrong:", repo.Path())
+	}
+	head, _ := repo.LookupReference(GitHeadFile)
+	if head.SymbolicTarget() != "refs/heads/main" {
+		t.Error("HEAD is wrong:", head.SymbolicTarget())
+	}
+	description, _ := ioutil.ReadFile("test_resources/init_repo/bare.git/description")
+	if string(description) != "bare test repository\n" {
+		t.Error("description is wrong:", string(description))
+	}
+	if _, err := os.Stat("test_resources/init_repo/bare.git/.git"); !os.IsNotExist(err) {
+		t.Error("bare repository should not have .git")
+	}
+	_, err = InitRepositoryExtended("test_resources/init_repo/invalid", &RepositoryInitOptions{
+		InitialHead: "bad:name",
+	})
+	if !IsErrorCode(err, ErrInvalidSpec) {
+		t.Error("invalid initial head should be rejected:", err)
+	}
+	if _, err := os.Stat("test_resources/init_repo/invalid"); !os.IsNotExist(err) {
+		t.Error("nothing should be created for invalid options")
+	}
+}
+
+func Test_InitRepository_Options(t *testing.T) {
+	testutil.PrepareEmptyWorkDir("test_resources/init_repo")
+	defer testutil.CleanupEmptyWorkDir()
+
+	repo, err := InitRepositoryExtended("test_resources/init_repo/work", &RepositoryInitOptions{
+		TemplatePath:   "test_resources/template",
+		Mode:           RepositoryInitSharedGroup,
+		SeparateGitDir: "test_resources/init_repo/gitdir",
+		ObjectFormat:   "sha256",
+	})
+	if err != nil {
+		t.Error("err should be nil:", err)
+		return
+	}
+	if !strings.HasSuffix(repo.Path(), "init_repo/gitdir") {
+		t.Error("repository path is wrong:", repo.Path())
+	}
+	if !strings.HasSuffix(repo.Workdir(), "init_repo/work/") {
+		t.Error("workdir is wrong:", repo.Workdir())
+	}
+	gitFile, _ := ioutil.ReadFile("test_resources/init_repo/work/.git")
+	if !strings.HasPrefix(string(gitFile), "gitdir: ") {
+		t.Error(".git file is wrong:", string(gitFile))
+	}
+	for _, name := range []string{"hooks/update.sample", "info/exclude", "branches/.gitignore"} {
+		if !isContainsFile("test_resources/init_repo/gitdir", name) {
+			t.Error("template file should be copied:", name)
+		}
+	}
+	template, _ := ioutil.ReadFile("test_resources/template/description")
+	description, _ := ioutil.ReadFile("test_resources/init_repo/gitdir/description")
+	if string(description) != string(template) {
+		t.Error("description should come from the template:", string(description))
+	}
+	config := repo.Config()
+	if value, _ := config.LookupString("core.sharedrepository"); value != "1" {
+		t.Error("core.sharedrepository is wrong:", value)
+	}
+	if value, _ := config.LookupString("extensions.objectformat"); value != "sha256" {
+		t.Error("extensions.objectformat is wrong:", value)
+	}
+	if value, _ := config.LookupInt32("core.repositoryformatversion"); value != 1 {
+		t.Error("core.repositoryformatversion is wrong:", value)
+	}
+	info, _ := os.Stat(filepath.Join("test_resources/init_repo/gitdir", "refs/heads"))
+	if info.Mode()&os.ModeSetgid == 0 || info.Mode().Perm() != 0775 {
+		t.Error("shared directory permission is wrong:", info.Mode())
+	}
+	info, _ = os.Stat(filepath.Join("test_resources/init_repo/gitdir", "HEAD"))
+	if info.Mode().Perm() != 0664 {
+		t.Error("shared file permission is wrong:", info.Mode())
+	}
+
+	_, err = InitRepositoryExtended("test_resources/init_repo/other", &RepositoryInitOptions{
+		ObjectFormat: "md5",
+	})
+	if !IsErrorCode(err, ErrInvalidSpec) {
+		t.Error("unknown object format should be rejected:", err)
+	}
+}
@@ -1,12 +1,11 @@
 package git4go
 
-/*
 import (
-	"errors"
+	"fmt"
 	"regexp"
 	"strconv"
 	"strings"
-	"fmt"
+	"time"
 )
 
 type RevparseFlag int
@@ -41,7 +40,180 @@ func (rs *Revspec) IsSingle() bool {
 }
 
 func (rs *Revspec) IsMergeBase() bool {
-	return rs.flags == RevparseMergeBase
+	return rs.flags&RevparseMergeBase != 0
+}
+
+// Repository methods related to revision parsing
+
+// RevparseExt finds a single object and intermediate reference (if there
+// is one) by a revision string. See `man gitrevisions` for information on
+// the syntax accepted. The reference is nil if the spec does not point
+// to a reference or if the spec walks away from it (e.g. "master^").
+func (r *Repository) RevparseExt(spec string) (Object, *Reference, error) {
+	object, reference, err := revparseExt(r, spec)
+	if err != nil {
+		if IsErrorCode(err, ErrInvalidSpec) {
+			msg := fmt.Sprintf("Failed to parse revision specifier - Invalid pattern '%s'", spec)
+			return nil, nil, MakeGitError(msg, ErrInvalidSpec)
+		}
+		return nil, nil, err
+	}
+	return object, reference, nil
+}
+
+func (r *Repository) RevparseSingle(spec string) (Object, error) {
+	obj, _, err := r.RevparseExt(spec)
+	return obj, err
+}
+
+// Revparse parses a revision string for from, to and intent. It accepts
+// a single revision, "from..to" and "from...to" style ranges.
+func (r *Repository) Revparse(spec string) (*Revspec, error) {
+	revspec := &Revspec{}
+	dotdot := strings.Index(spec, "..")
+	if dotdot == -1 {
+		from, err := r.RevparseSingle(spec)
+		if err != nil {
+			return nil, err
+		}
+		revspec.flags = RevparseSingle
+		revspec.from = from
+		return revspec, nil
+	}
+
+	leftSpec := spec[:dotdot]
+	rightSpec := spec[dotdot+2:]
+	revspec.flags = RevparseRange
+	if strings.HasPrefix(rightSpec, ".") {
+		revspec.flags |= RevparseMergeBase
+		rightSpec = rightSpec[1:]
+	}
+	// an omitted end of a range means HEAD, like "master.." or "...topic"
+	if leftSpec == "" {
+		leftSpec = GitHeadFile
+	}
+	if rightSpec == "" {
+		rightSpec = GitHeadFile
+	}
+	var err error
+	revspec.from, err = r.RevparseSingle(leftSpec)
+	if err != nil {
+		return nil, err
+	}
+	revspec.to, err = r.RevparseSingle(rightSpec)
+	if err != nil {
+		return nil, err
+	}
+	return revspec, nil
+}
+
+// internal functions
+
+func invalidSpecError(message string) error {
+	return MakeGitError(message, ErrInvalidSpec)
+}
+
+func revparseExt(repo *Repository, spec string) (Object, *Reference, error) {
+	pos := 0
+	identifierLength := 0
+	shouldReturnReference := true
+	var reference *Reference
+	var baseRev Object
+	var err error
+
+	for pos < len(spec) {
+		c := spec[pos]
+		switch {
+		case c == '^':
+			shouldReturnReference = false
+			baseRev, reference, err = ensureBaseRevLoaded(baseRev, reference, spec, identifierLength, repo, false)
+			if err != nil {
+				return nil, nil, err
+			}
+			if pos+1 < len(spec) && spec[pos+1] == '{' {
+				var content string
+				content, pos, err = extractCurlyBracesContent(spec, pos)
+				if err != nil {
+					return nil, nil, err
+				}
+				baseRev, err = handleCaretCurlySyntax(baseRev, content)
+			} else {
+				var n int
+				n, pos, err = extractHowMany(spec, pos)
+				if err != nil {
+					return nil, nil, err
+				}
+				baseRev, err = handleCaretParentSyntax(baseRev, n)
+			}
+			if err != nil {
+				return nil, nil, err
+			}
+		case c == '~':
+			shouldReturnReference = false
+			var n int
+			n, pos, err = extractHowMany(spec, pos)
+			if err != nil {
+				return nil, nil, err
+			}
+			baseRev, reference, err = ensureBaseRevLoaded(baseRev, reference, spec, identifierLength, repo, false)
+			if err != nil {
+				return nil, nil, err
+			}
+			baseRev, err = handleLinearSyntax(baseRev, n)
+			if err != nil {
+				return nil, nil, err
+			}
+		case c == ':':
+			shouldReturnReference = false
+			path := spec[pos+1:]
+			pos = len(spec)
+			if anyLeftHandIdentifier(baseRev, reference, identifierLength) {
+				baseRev, reference, err = ensureBaseRevLoaded(baseRev, reference, spec, identifierLength, repo, true)
+				if err != nil {
+					return nil, nil, err
+				}
+				baseRev, err = handleColonSyntax(baseRev, path)
+			} else if strings.HasPrefix(path, "/") {
+				baseRev, err = handleGrepSyntax(repo, nil, path[1:])
+			} else {
+				baseRev, err = handleIndexSyntax(repo, path)
+			}
+			if err != nil {
+				return nil, nil, err
+			}
+		case c == '@' && pos+1 < len(spec) && spec[pos+1] == '{':
+			var content string
+			content, pos, err = extractCurlyBracesContent(spec, pos)
+			if err != nil {
+				return nil, nil, err
+			}
+			if baseRev != nil {
+				return nil, nil, invalidSpecError("The base revision is already resolved")
+			}
+			var object Object
+			object, reference, err = handleAtSyntax(repo, spec[:identifierLength], reference, content)
+			if err != nil {
+				return nil, nil, err
+			}
+			if object != nil {
+				baseRev = object
+			}
+		default:
+			if baseRev != nil || reference != nil {
+				return nil, nil, invalidSpecError("The left hand identifier is already resolved")
+			}
+			pos++
+			identifierLength++
+		}
+	}
+	baseRev, reference, err = ensureBaseRevLoaded(baseRev, reference, spec, identifierLength, repo, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !shouldReturnReference {
+		reference = nil
+	}
+	return baseRev, reference, nil
 }
 
 func objectFromReference(reference *Reference) (Object, error) {
@@ -54,11 +226,11 @@ func objectFromReference(reference *Reference) (Object, error) {
 
 func maybeSha(repo *Repository, spec string) (Object, error) {
 	if len(spec) != GitOidHexSize {
-		return nil, errors.New("not found")
+		return nil, MakeGitError("Spec is not a full object id", ErrNotFound)
 	}
 	oid, err := NewOid(spec)
 	if err != nil {
-		return nil, err
+		return nil, MakeGitError("Spec is not a full object id", ErrNotFound)
 	}
 	return repo.Lookup(oid)
 }
@@ -66,28 +238,26 @@ func maybeSha(repo *Repository, spec string) (Object, error) {
 func maybeAbbrev(repo *Repository, spec string) (Object, error) {
 	oid, err := NewOidFromPrefix(spec)
 	if err != nil {
-		return nil, err
+		return nil, MakeGitError("Spec is not an abbreviated object id", ErrNotFound)
 	}
 	return repo.LookupPrefix(oid, len(spec))
 }
 
-var describeRegexp *regexp.Regexp
+var describeRegexp = regexp.MustCompile("^.+-[0-9]+-g([0-9a-fA-F]+)$")
 
+// maybeDescribe accepts an output of "git describe" like "v1.0-7-gc47800c".
 func maybeDescribe(repo *Repository, spec string) (Object, error) {
-	if describeRegexp == nil {
-		describeRegexp = regexp.MustCompilePOSIX(".+-[0-9]+-g[0-9a-fA-F]+")
-	}
-	index := strings.Index(spec, "-g")
-	if index == -1 {
-		return nil, errors.New("not found")
-	}
-	if !describeRegexp.MatchString(spec) {
-		return nil, errors.New("not found")
+	match := describeRegexp.FindStringSubmatch(spec)
+	if match == nil {
+		return nil, MakeGitError("Spec is not a describe output", ErrNotFound)
 	}
-	return maybeAbbrev(repo, spec[index+2:])
+	return maybeAbbrev(repo, match[1])
 }
 
-func revParseLookupObject(repo *Repository, spec string) (Object, *Reference, error) {
+func revparseLookupObject(repo *Repository, spec string) (Object, *Reference, error) {
+	if spec == "@" {
+		spec = GitHeadFile
+	}
 	object, err := maybeSha(repo, spec)
 	if err == nil {
 		return object, nil, nil
@@ -95,18 +265,24 @@ func revParseLookupObject(repo *Repository, spec string) (Object, *Reference, er
 	ref, err := repo.DwimReference(spec)
 	if err == nil {
 		object, err = repo.Lookup(ref.Target())
-		if err == nil {
-			return object, ref, nil
+		if err != nil {
+			return nil, nil, err
 		}
+		return object, ref, nil
+	} else if !IsErrorCode(err, ErrNotFound) {
+		return nil, nil, err
 	}
 	if len(spec) < GitOidHexSize {
-		object, err := maybeAbbrev(repo, spec)
+		object, err = maybeAbbrev(repo, spec)
 		if err == nil {
 			return object, nil, nil
 		}
 	}
 	object, err = maybeDescribe(repo, spec)
-	return object, nil, err
+	if err == nil {
+		return object, nil, nil
+	}
+	return nil, nil, MakeGitError(fmt.Sprintf("Revspec '%s' not found.", spec), ErrNotFound)
 }
 
 func ensureBaseRevLoaded(object Object, reference *Reference, spec string, identifierLength int, repo *Repository, allowEmptyIdentifier bool) (Object, *Reference, error) {
@@ -121,185 +297,109 @@ func ensureBaseRevLoaded(object Object, reference *Reference, spec string, ident
 		return object, reference, nil
 	}
 	if !allowEmptyIdentifier && identifierLength == 0 {
-		return nil, nil, errors.New("Invalid Spec")
+		return nil, nil, invalidSpecError("Revision spec doesn't have an identifier")
 	}
-	return revParseLookupObject(repo, spec[:identifierLength])
+	return revparseLookupObject(repo, spec[:identifierLength])
 }
 
+func anyLeftHandIdentifier(object Object, reference *Reference, identifierLength int) bool {
+	return object != nil || reference != nil || identifierLength > 0
+}
+
+// extractCurlyBracesContent returns the content of "^{...}" or "@{...}"
+// at pos and the position just after the closing brace.
 func extractCurlyBracesContent(spec string, pos int) (string, int, error) {
-	c := spec[pos]
-	if c != '^' && c != '@' {
-		return "", 0, errors.New("assertion error: extractCurlyBracesContent()")
-	}
 	pos++
 	if pos >= len(spec) || spec[pos] != '{' {
-		return "", 0, errors.New("invalid spec: extractCurlyBracesContent()")
+		return "", 0, invalidSpecError("'{' is expected")
 	}
 	pos++
-	endPos := pos
-	specLength := len(spec)
-	for endPos < specLength {
-		if spec[endPos] == '}' {
-			return spec[pos:endPos], endPos + 1, nil
-		}
-		endPos++
-	}
-	return "", 0, errors.New("invalid spec: extractCurlyBracesContent()")
-}
-
-func deferenceToNonTag(object Object) (Object, error) {
-	if object.Type() == ObjectTag {
-		return object.Peel(ObjectAny)
-	}
-	return object, nil
-}
-
-func handleCaretCurlySyntax(object Object, curlyBracesContent string) (Object, error) {
-	if len(curlyBracesContent) == 0 {
-		return deferenceToNonTag(object)
-	}
-	if curlyBracesContent[0] == '/' {
-		return handleGrepSyntax(object.Owner(), object.Id(), curlyBracesContent[1:])
-	}
-	expectedType := TypeString2Type(curlyBracesContent)
-	if expectedType == ObjectBad {
-		return nil, errors.New("Invalid Spec: handleCaretCurlySyntax()")
-	}
-	return object.Peel(expectedType)
-}
-
-func handleCaretParentSyntax(baseRev Object, n int) (Object, error) {
-	peeled, err := baseRev.Peel(ObjectCommit)
-	if err != nil {
-		return nil, err
-	}
-	if n == 0 {
-		return peeled, nil
+	end := strings.IndexByte(spec[pos:], '}')
+	if end == -1 {
+		return "", 0, invalidSpecError("'}' is expected")
 	}
-	commit := peeled.(*Commit)
-	return commit.Parent(n - 1), nil
+	return spec[pos : pos+end], pos + end + 1, nil
 }
 
 func isDigit(c byte) bool {
-	return 0 <= c && c <= 9
-}
-
-func strtol(str string, start, base int) (int, int, error) {
-	end := start
-	for end < len(str) {
-		if !isDigit(str[end]) {
-			result, err := strconv.ParseInt(str[start:end], base, 64)
-			if err != nil {
-				return 0, 0, err
-			}
-			return int(result), end, nil
-		}
-		end++
-	}
-	return 0, 0, errors.New("invalid format: strtol()")
+	return '0' <= c && c <= '9'
 }
 
+// extractHowMany reads "^", "^<n>", "~", "~<n>" and the repeats like "~~"
+// and returns the accumulated count and the position after them.
 func extractHowMany(spec string, pos int) (int, int, error) {
-	parsed := 0
-	accumulated := 0
 	kind := spec[pos]
-	specLength := len(spec)
-	if kind != '^' && kind != '~' {
-		return 0, 0, errors.New("assertion error: extractHowMany()")
-	}
+	accumulated := 0
 	for {
 		for {
 			pos++
 			accumulated++
-			fmt.Println("@@@", pos, spec, specLength)
-			if pos >= specLength-1 || spec[pos] != kind || kind != '~' {
+			if pos >= len(spec) || spec[pos] != kind || kind != '~' {
 				break
 			}
 		}
-		if pos < specLength && isDigit(spec[pos]) {
-			var err error
-			parsed, pos, err = strtol(spec, pos, 10)
+		if pos < len(spec) && isDigit(spec[pos]) {
+			end := pos
+			for end < len(spec) && isDigit(spec[end]) {
+				end++
+			}
+			parsed, err := strconv.Atoi(spec[pos:end])
 			if err != nil {
-				return 0, 0, err
+				return 0, 0, invalidSpecError("Invalid number: " + spec[pos:end])
 			}
 			accumulated += parsed - 1
+			pos = end
 		}
-		if pos >= specLength-1 || spec[pos] != kind || kind != '~' {
+		if pos >= len(spec) || spec[pos] != kind || kind != '~' {
 			break
 		}
 	}
 	return accumulated, pos, nil
 }
 
-func handlingLinearSyntax(obj Object, n int) (*Commit, error) {
-	peeled, err := obj.Peel(ObjectCommit)
-	if err != nil {
-		return err
-	}
-	commit := peeled.(*Commit)
-	return commit.NthGenAncestor(n)
-}
-
-func extractPath(spec string, pos int) (string, int, error) {
-	if spec[pos] != ':' {
-		return "", 0, errors.New("assertion error: extractPath()")
+func dereferenceToNonTag(object Object) (Object, error) {
+	if object.Type() == ObjectTag {
+		return object.Peel(ObjectAny)
 	}
-	return spec[pos+1:], len(spec), nil
+	return object, nil
 }
 
-func handleAtSyntax(spec string, identifierLength int, repo *Repository, curlyBasedContent string) (Object, *Reference, error) {
-	parsed, err := strconv.ParseInt(curlyBasedContent, 10, 64)
-	isNumeric := (err == nil)
-	if curlyBasedContent[0] == '-' && (!isNumeric || parsed == 0) {
-		return nil, nil, errors.New("invalid spec")
+func handleCaretCurlySyntax(object Object, content string) (Object, error) {
+	if content == "" {
+		return dereferenceToNonTag(object)
 	}
-	if isNumeric {
-		if parsed < 0 {
-			return retrievePreviouslyCheckedOutBranchOrRevision(repo, spec, -int(parsed))
-		} else {
-			return retrieveRevObjectFromRefLog(repo, spec, int(parsed))
-		}
+	if content[0] == '/' {
+		return handleGrepSyntax(object.Owner(), object.Id(), content[1:])
 	}
-
-	if curlyBasedContent == "u" || curlyBasedContent == "upstream" {
-		ref, err := retrieveRemoteTrackingReference(repo, spec)
-		return nil, ref, err
+	expectedType := TypeString2Type(content)
+	if expectedType == ObjectBad {
+		return nil, invalidSpecError(fmt.Sprintf("Unknown object type '%s'", content))
 	}
-	// todo: parse time
-	// return retrieveRevObjectFromRefLog(repo, spec, timestamp)
-	return nil, nil, errors.New("not implemented")
-}
-
-func retrievePreviouslyCheckedOutBranchOrRevision(repo *Repository, identifier string, parsed int) (Object, *Reference, error) {
-
-}
-
-func retrieveRevObjectFromRefLog(repo *Repository, identifier string, parsed int) (Object, *Reference, error) {
-
-}
-
-func retrieveRemoteTrackingReference(repo *Repository, identifier string) (*Reference, error) {
-
-}
-
-func ensureLeftHandIdentifierIsNotKnownYet(baseRev Object, reference *Reference) error {
+	return object.Peel(expectedType)
 }
 
-func ensureBaseRevIsNotKnownYet(object Object) error {
-	if object == nil {
-		return nil
+func handleCaretParentSyntax(object Object, n int) (Object, error) {
+	peeled, err := object.Peel(ObjectCommit)
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return peeled, nil
 	}
-	return errors.New("invalid spec: ensureBaseRevIsNotKnownYet()")
+	commit := peeled.(*Commit)
+	parent := commit.Parent(n - 1)
+	if parent == nil {
+		return nil, MakeGitError(fmt.Sprintf("Commit %s does not have parent %d", commit.Id(), n), ErrNotFound)
+	}
+	return parent, nil
 }
 
-func anyLeftHandIdentifier(object Object, reference *Reference, identifierLength int) bool {
-	if object != nil {
-		return true
-	}
-	if reference != nil {
-		return true
+func handleLinearSyntax(object Object, n int) (Object, error) {
+	peeled, err := object.Peel(ObjectCommit)
+	if err != nil {
+		return nil, err
 	}
-	return identifierLength > 0
+	return peeled.(*Commit).NthGenAncestor(uint(n))
 }
 
 func handleColonSyntax(object Object, path string) (Object, error) {
@@ -308,7 +408,7 @@ func handleColonSyntax(object Object, path string) (Object, error) {
 		return nil, err
 	}
 	if path == "" {
-		return peeled, err
+		return peeled, nil
 	}
 	tree := peeled.(*Tree)
 	entry, err := tree.EntryByPath(path)
@@ -318,10 +418,38 @@ func handleColonSyntax(object Object, path string) (Object, error) {
 	return tree.repo.Lookup(entry.Id)
 }
 
+var stagePathRegexp = regexp.MustCompile("^([0-3]):(.*)$")
+
+// handleIndexSyntax looks up the blob in the index by ":<path>" or
+// ":<stage>:<path>".
+func handleIndexSyntax(repo *Repository, path string) (Object, error) {
+	stage := IndexStage(0)
+	if match := stagePathRegexp.FindStringSubmatch(path); match != nil {
+		n, _ := strconv.Atoi(match[1])
+		stage = IndexStage(n)
+		path = match[2]
+	}
+	if path == "" {
+		return nil, invalidSpecError("Path is empty")
+	}
+	index, err := repo.Index()
+	if err != nil {
+		return nil, err
+	}
+	entry, err := index.EntryByPath(path, stage)
+	if err != nil {
+		return nil, err
+	}
+	return repo.Lookup(entry.Id)
+}
+
 func handleGrepSyntax(repo *Repository, specOid *Oid, pattern string) (Object, error) {
+	if pattern == "" {
+		return nil, invalidSpecError("Empty pattern")
+	}
 	rx, err := regexp.Compile(pattern)
 	if err != nil {
-		return nil, err
+		return nil, invalidSpecError(err.Error())
 	}
 	walk, err := repo.Walk()
 	if err != nil {
@@ -329,7 +457,7 @@ func handleGrepSyntax(repo *Repository, specOid *Oid, pattern string) (Object, e
 	}
 	walk.Sorting(SortTime)
 	if specOid == nil {
-		err = walk.PushRef("refs/*")
+		err = walk.PushGlob("refs/*")
 	} else {
 		err = walk.Push(specOid)
 	}
@@ -351,164 +479,158 @@ func handleGrepSyntax(repo *Repository, specOid *Oid, pattern string) (Object, e
 		}
 	}
 	if IsErrorCode(err, ErrIterOver) {
-		return nil, errors.New("not found")
+		return nil, MakeGitError(fmt.Sprintf("Could not find a commit matching regex '%s'", pattern), ErrNotFound)
 	}
 	return nil, err
 }
 
-func (r *Repository) RevparseExt(spec string) (Object, *Reference, error) {
-	pos := 0
-	identifierLength := 0
-	shouldReturnReference := true
-	var reference *Reference
-	var baseRev Object
-	var err error
+// handleAtSyntax resolves "<identifier>@{<content>}". It returns either
+// the object or the reference that the spec points to.
+func handleAtSyntax(repo *Repository, identifier string, reference *Reference, content string) (Object, *Reference, error) {
+	if content == "" {
+		return nil, nil, invalidSpecError("Empty '@{}'")
+	}
+	parsed, err := strconv.Atoi(content)
+	isNumeric := err == nil
+	if content[0] == '-' && (!isNumeric || parsed == 0) {
+		return nil, nil, invalidSpecError(fmt.Sprintf("Invalid number '%s'", content))
+	}
+	if isNumeric && parsed < reflogTimestampThreshold {
+		if parsed < 0 {
+			return retrievePreviouslyCheckedOutBranchOrRevision(repo, identifier, reference, -parsed)
+		}
+		object, err := retrieveRevObjectFromReflog(repo, identifier, reference, parsed)
+		return object, nil, err
+	}
+	if content == "u" || content == "upstream" {
+		ref, err := retrieveRemoteTrackingReference(repo, identifier, reference)
+		return nil, ref, err
+	}
+	var date time.Time
+	if isNumeric {
+		date = time.Unix(int64(parsed), 0)
+	} else {
+		date, err = approxidate(content, time.Now())
+		if err != nil {
+			return nil, nil, invalidSpecError(err.Error())
+		}
+	}
+	object, err := retrieveRevObjectFromReflogByDate(repo, identifier, reference, date)
+	return object, nil, err
+}
 
-	for pos < len(spec) {
-		switch spec[pos] {
-			case '^':
-			shouldReturnReference = false
-			baseRev, reference, err = ensureBaseRevLoaded(baseRev, reference, spec, identifierLength, r, false)
-			if err != nil {
-				return nil, nil, err
-			}
-			if pos+1<len(spec) && spec[pos+1] == '{' {
-				var buf string
-				buf, pos, err = extractCurlyBracesContent(spec, pos)
-				if err != nil {
-					return nil, nil, err
-				}
-				baseRev, err = handleCaretCurlySyntax(baseRev, buf)
-			} else {
-				var n int
-				n, pos, err = extractHowMany(spec, pos)
-				if err != nil {
-					return nil, nil, err
-				}
-				baseRev, err = handleCaretParentSyntax(baseRev, n)
-				if err != nil {
-					return nil, nil, err
-				}
-			}
-			case '~':
-			var n int
-			shouldReturnReference = false
-			n, pos, err = extractHowMany(spec, pos)
-			if err != nil {
-				return nil, nil, err
-			}
-			baseRev, reference, err = ensureBaseRevLoaded(baseRev, reference, spec, identifierLength, r, false)
-			if err != nil {
-				return nil, nil, err
-			}
-			err = handlingLinearSyntax(baseRev, n)
-			if err != nil {
-				return nil, nil, err
-			}
-			case ':':
-			shouldReturnReference = false
-			var buf string
-			buf, pos, err = extractPath(spec, pos)
-			if err != nil {
-				return nil, nil, err
-			}
-			if anyLeftHandIdentifier(baseRev, reference, identifierLength) {
-				baseRev, reference, err = ensureBaseRevLoaded(baseRev, reference, spec, identifierLength, r, true)
-				if err != nil {
-					return nil, nil, err
-				}
-				baseRev, err = handleColonSyntax(baseRev, buf)
-				if err != nil {
-					return nil, nil, err
-				}
-			} else {
-				if buf[0] == '/' {
-					baseRev, err = handleGrepSyntax(r, nil, buf[1:])
-					if err != nil {
-						return nil, nil, err
-					} else {
-
-					}
-				} else {
-					// todo: support merge-stage path lookup (":2:Makefile")
-					// and plain index blob lookup (:i-am/a/blob)
-					return nil, nil, errors.New("Unimplemented")
-				}
+// Like git, a large number in "@{<n>}" is not a position but a timestamp.
+const reflogTimestampThreshold = 100000000
 
-			}
-			case '@':
-			if pos+1<len(spec) && spec[pos+1] == '{' {
-				var buf string
-				buf, pos, err = extractCurlyBracesContent(spec, pos)
-				if err != nil {
-					return nil, nil, err
-				}
-				err = ensureBaseRevIsNotKnownYet(baseRev)
-				if err != nil {
-					return nil, nil, err
-				}
-				baseRev, reference, err = handleAtSyntax(spec, identifierLength, r, buf)
-				if err != nil {
-					return nil, nil, err
-				}
-			} else {
-				// fall through
-			}
-			default:
-			err = ensureLeftHandIdentifierIsNotKnownYet(baseRev, reference)
-			if err != nil {
-				return nil, nil, err
-			}
-			pos++
-			identifierLength++
-		}
+var checkoutMessageRegexp = regexp.MustCompile("checkout: moving from (.*) to .*")
+
+// retrievePreviouslyCheckedOutBranchOrRevision resolves "@{-<n>}" by
+// searching checkout messages in the reflog of HEAD.
+func retrievePreviouslyCheckedOutBranchOrRevision(repo *Repository, identifier string, reference *Reference, position int) (Object, *Reference, error) {
+	if identifier != "" || reference != nil {
+		return nil, nil, invalidSpecError("'@{-<n>}' can't follow any identifier")
 	}
-	baseRev, reference, err = ensureBaseRevLoaded(baseRev, reference, spec, identifierLength, r, false)
+	reflog, err := repo.ReadReflog(GitHeadFile)
 	if err != nil {
 		return nil, nil, err
 	}
-	if !shouldReturnReference {
-		reference = nil
+	count := 0
+	for i := uint(0); i < reflog.EntryCount(); i++ {
+		match := checkoutMessageRegexp.FindStringSubmatch(reflog.EntryByIndex(i).Message)
+		if match == nil {
+			continue
+		}
+		count++
+		if count < position {
+			continue
+		}
+		ref, err := repo.DwimReference(match[1])
+		if err == nil {
+			return nil, ref, nil
+		}
+		object, err := maybeAbbrev(repo, match[1])
+		return object, nil, err
 	}
-	return baseRev, reference, nil
+	return nil, nil, MakeGitError(fmt.Sprintf("HEAD reflog doesn't have %d checkouts", position), ErrNotFound)
 }
 
-func (r *Repository) RevparseSingle(spec string) (Object, error) {
-	obj, _, err := r.RevparseExt(spec)
-	return obj, err
+// retrieveRevObjectFromReflog resolves "<ref>@{<n>}" by the n-th prior
+// value of the reference.
+func retrieveRevObjectFromReflog(repo *Repository, identifier string, reference *Reference, position int) (Object, error) {
+	reference, err := reflogReference(repo, identifier, reference)
+	if err != nil {
+		return nil, err
+	}
+	if position == 0 {
+		return objectFromReference(reference)
+	}
+	reflog, err := repo.ReadReflog(reference.Name())
+	if err != nil {
+		return nil, err
+	}
+	if reflog.EntryCount() < uint(position)+1 {
+		msg := fmt.Sprintf("Reflog for '%s' has only %d entries, asked for %d", reference.Name(), reflog.EntryCount(), position)
+		return nil, MakeGitError(msg, ErrNotFound)
+	}
+	return repo.Lookup(reflog.EntryByIndex(uint(position)).New)
 }
 
-func (r *Repository) Revparse(spec string) (*Revspec, error) {
-	dotdotPos := strings.Index(spec, "..")
-	revspec := &Revspec{}
+// retrieveRevObjectFromReflogByDate resolves "<ref>@{<date>}" by the value
+// of the reference at the given time. If the date is older than the reflog,
+// the oldest known value is used like git does.
+func retrieveRevObjectFromReflogByDate(repo *Repository, identifier string, reference *Reference, date time.Time) (Object, error) {
+	reference, err := reflogReference(repo, identifier, reference)
+	if err != nil {
+		return nil, err
+	}
+	reflog, err := repo.ReadReflog(reference.Name())
+	if err != nil {
+		return nil, err
+	}
+	count := reflog.EntryCount()
+	if count == 0 {
+		return nil, MakeGitError(fmt.Sprintf("Reflog for '%s' is empty", reference.Name()), ErrNotFound)
+	}
+	for i := uint(0); i < count; i++ {
+		entry := reflog.EntryByIndex(i)
+		if !entry.Committer.When.After(date) {
+			return repo.Lookup(entry.New)
+		}
+	}
+	oldest := reflog.EntryByIndex(count - 1)
+	if oldest.Old.IsZero() {
+		return repo.Lookup(oldest.New)
+	}
+	return repo.Lookup(oldest.Old)
+}
 
-	var err error
+// reflogReference returns the reference whose reflog is used for
+// "<identifier>@{...}". "HEAD" means HEAD itself, not the current branch.
+func reflogReference(repo *Repository, identifier string, reference *Reference) (*Reference, error) {
+	if reference != nil {
+		return reference, nil
+	}
+	if identifier == GitHeadFile {
+		return repo.LookupReference(GitHeadFile)
+	}
+	return repo.DwimReference(identifier)
+}
 
-	if dotdotPos != -1 {
-		leftStr := spec[:dotdotPos]
-		var rightStr string
-		revspec.flags = RevparseRange
-		if spec[dotdotPos+2] == '.' {
-			rightStr = spec[dotdotPos+3:]
-		} else {
-			revspec.flags |= RevparseMergeBase
-			rightStr = spec[dotdotPos+2:]
-		}
-		revspec.from, err = r.RevparseSingle(leftStr)
-		if err != nil {
-			return nil, err
-		}
-		revspec.to, err = r.RevparseSingle(rightStr)
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		revspec.flags = RevparseSingle
-		revspec.from, err = r.RevparseSingle(spec)
+// retrieveRemoteTrackingReference resolves "<branch>@{upstream}".
+func retrieveRemoteTrackingReference(repo *Repository, identifier string, reference *Reference) (*Reference, error) {
+	var err error
+	if reference == nil {
+		reference, err = repo.DwimReference(identifier)
 		if err != nil {
 			return nil, err
 		}
 	}
-	return revspec, err
+	if !reference.IsBranch() {
+		return nil, invalidSpecError(fmt.Sprintf("'%s' is not a local branch", reference.Name()))
+	}
+	upstream, err := branchUpstreamName(repo, reference.Name())
+	if err != nil {
+		return nil, err
+	}
+	return repo.LookupReference(upstream)
 }
-*/
@@ -1,6 +1,5 @@
 package git4go
 
-/*
 import (
 	"./testutil"
 	"testing"
@@ -8,49 +7,63 @@ import (
 
 func checkObjectAndRefInRepo(spec, expectedOid, expectedRefName string, repo *Repository, t *testing.T) {
 	obj, ref, err := repo.RevparseExt(spec)
-	oid, err := NewOid(expectedOid)
 	if err != nil {
-		t.Error("id was wrong:", expectedOid)
-	} else if !obj.Id().Equal(oid) {
+		t.Error("err should be nil:", spec, err)
+		return
+	}
+	oid, _ := NewOid(expectedOid)
+	if !obj.Id().Equal(oid) {
 		t.Error("Ids are not equal:", expectedOid, obj.Id().String())
 	}
-	if ref.Name() != expectedRefName {
+	if expectedRefName == "" {
+		if ref != nil {
+			t.Error("ref should be nil:", spec, ref.Name())
+		}
+	} else if ref == nil {
+		t.Error("ref should not be nil:", spec)
+	} else if ref.Name() != expectedRefName {
 		t.Error("Ref.Name() was wrong:", expectedRefName, ref.Name())
 	}
 }
 
 func checkObjectInRepo(spec, expectedOid string, repo *Repository, t *testing.T) {
 	obj, _, err := repo.RevparseExt(spec)
-	if obj == nil {
-		t.Error("obj should not be nil:", err)
-	} else if expectedOid != "" {
-		oid, err := NewOid(expectedOid)
-		if err != nil {
-			t.Error("err should be nil:", err)
-		} else if !obj.Id().Equal(oid) {
-			t.Error("Ids are not equal:", expectedOid, obj.Id().String())
+	if expectedOid == "" {
+		if err == nil {
+			t.Error("err should be error:", spec)
+		}
+	} else if obj == nil {
+		t.Error("obj should not be nil:", spec, err)
+	} else {
+		oid, _ := NewOid(expectedOid)
+		if !obj.Id().Equal(oid) {
+			t.Error("Ids are not equal:", spec, expectedOid, obj.Id().String())
 		}
-	} else if err == nil {
-		t.Error("err should be error", spec)
 	}
 }
 
 func checkIdInRepo(spec, expectedLeft, expectedRight string, flag RevparseFlag, repo *Repository, t *testing.T) {
 	revSpec, err := repo.Revparse(spec)
-
-	if expectedLeft != "" {
-		oid, _ := NewOid(expectedLeft)
-		if !revSpec.From().Id().Equal(oid) {
-			t.Error("Ids are not equal(From):", expectedLeft, revSpec.From().Id().String())
+	if expectedLeft == "" {
+		if err == nil {
+			t.Error("err should not be nil:", spec)
 		}
-	} else if err == nil {
-		t.Error("err should not be nil:", expectedLeft)
+		return
+	}
+	if err != nil {
+		t.Error("err should be nil:", spec, err)
+		return
+	}
+
+	oid, _ := NewOid(expectedLeft)
+	if !revSpec.From().Id().Equal(oid) {
+		t.Error("Ids are not equal(From):", expectedLeft, revSpec.From().Id().String())
 	}
 
 	if expectedRight != "" {
 		oid, _ := NewOid(expectedRight)
 		if !revSpec.To().Id().Equal(oid) {
-			t.Error("Ids are not equal(To):", expectedLeft, revSpec.From().Id().String())
+			t.Error("Ids are not equal(To):", expectedRight, revSpec.To().Id().String())
 		}
 	}
 
@@ -61,8 +74,8 @@ func checkIdInRepo(spec, expectedLeft, expectedRight string, flag RevparseFlag,
 
 func checkInvalidSingleSpec(invalidSpec string, repo *Repository, t *testing.T) {
 	_, err := repo.RevparseSingle(invalidSpec)
-	if err != nil {
-		t.Error("Id should not be found: ", invalidSpec)
+	if !IsErrorCode(err, ErrInvalidSpec) {
+		t.Error("spec should be invalid: ", invalidSpec, err)
 	}
 }
 
@@ -183,4 +196,216 @@ func Test_Revparse_NotTag(t *testing.T) {
 	checkObjectInRepo("tags/e90810b^{}", "e90810b8df3e80c413d903f631643c716887138d", repo, t)
 	checkObjectInRepo("e908^{}", "e90810b8df3e80c413d903f631643c716887138d", repo, t)
 }
-*/
+
+func Test_Revparse_LinearHistory(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+
+	checkInvalidSingleSpec("~", repo, t)
+	checkObjectInRepo("foo~bar", "", repo, t)
+	checkInvalidSingleSpec("master~bar", repo, t)
+	checkInvalidSingleSpec("master~-1", repo, t)
+	checkInvalidSingleSpec("master~0bar", repo, t)
+	checkInvalidSingleSpec("this doesn't make sense~2", repo, t)
+	checkInvalidSingleSpec("be3563a^{tree}~", repo, t)
+	checkInvalidSingleSpec("point_to_blob^{blob}~", repo, t)
+
+	checkObjectInRepo("master~0", "a65fedf39aefe402d3bb6e24df4d4f5fe4547750", repo, t)
+	checkObjectInRepo("master~1", "be3563ae3f795b2b4353bcce3a527ad0a4f7f644", repo, t)
+	checkObjectInRepo("master~2", "9fd738e8f7967c078dceed8190330fc8648ee56a", repo, t)
+	checkObjectInRepo("master~1~1", "9fd738e8f7967c078dceed8190330fc8648ee56a", repo, t)
+	checkObjectInRepo("master~~", "9fd738e8f7967c078dceed8190330fc8648ee56a", repo, t)
+}
+
+func Test_Revparse_ChainingLinearAndParent(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+
+	checkObjectInRepo("master~1^1", "9fd738e8f7967c078dceed8190330fc8648ee56a", repo, t)
+	checkObjectInRepo("master~1^2", "c47800c7266a2be04c571c04d5a6614691ea99bd", repo, t)
+	checkObjectInRepo("master^1^2~1", "5b5b025afb0b4c913b4c338a42934a3863bf3644", repo, t)
+	checkObjectInRepo("master^^2^", "5b5b025afb0b4c913b4c338a42934a3863bf3644", repo, t)
+	checkObjectInRepo("master^1^1^1^1^1", "8496071c1b46c854b31185ea97743be6a8774479", repo, t)
+	checkObjectInRepo("master^^1^2^1", "", repo, t)
+}
+
+func Test_Revparse_ReflogPosition(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+
+	checkInvalidSingleSpec("@{-0}", repo, t)
+	checkInvalidSingleSpec("@{-xyz}", repo, t)
+	checkInvalidSingleSpec("@{-1b}", repo, t)
+	checkInvalidSingleSpec("master@{-1}", repo, t)
+
+	checkObjectInRepo("@{-42}", "", repo, t)
+	checkObjectInRepo("@{-1}", "a4a7dce85cf63874e984719f4fdd239f5145052f", repo, t)
+	checkObjectInRepo("@{-2}", "a65fedf39aefe402d3bb6e24df4d4f5fe4547750", repo, t)
+	checkObjectInRepo("@{-3}", "5b5b025afb0b4c913b4c338a42934a3863bf3644", repo, t)
+	checkObjectInRepo("@{-4}@{1}", "be3563ae3f795b2b4353bcce3a527ad0a4f7f644", repo, t)
+
+	checkObjectAndRefInRepo("@{-1}", "a4a7dce85cf63874e984719f4fdd239f5145052f", "refs/heads/br2", repo, t)
+	checkObjectAndRefInRepo("@{-3}", "5b5b025afb0b4c913b4c338a42934a3863bf3644", "", repo, t)
+
+	checkObjectInRepo("@{0}", "a65fedf39aefe402d3bb6e24df4d4f5fe4547750", repo, t)
+	checkObjectInRepo("@{1}", "be3563ae3f795b2b4353bcce3a527ad0a4f7f644", repo, t)
+	checkObjectInRepo("master@{0}", "a65fedf39aefe402d3bb6e24df4d4f5fe4547750", repo, t)
+	checkObjectInRepo("master@{1}", "be3563ae3f795b2b4353bcce3a527ad0a4f7f644", repo, t)
+	checkObjectInRepo("heads/master@{1}", "be3563ae3f795b2b4353bcce3a527ad0a4f7f644", repo, t)
+	checkObjectInRepo("refs/heads/master@{1}", "be3563ae3f795b2b4353bcce3a527ad0a4f7f644", repo, t)
+	checkObjectInRepo("master@{42}", "", repo, t)
+	checkInvalidSingleSpec("master@{1}@{1}", repo, t)
+}
+
+func Test_Revparse_Upstream(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+
+	checkInvalidSingleSpec("e90810b@{u}", repo, t)
+	checkInvalidSingleSpec("refs/tags/e90810b@{u}", repo, t)
+	checkObjectInRepo("refs/heads/e90810b@{u}", "", repo, t)
+
+	checkObjectInRepo("master@{upstream}", "be3563ae3f795b2b4353bcce3a527ad0a4f7f644", repo, t)
+	checkObjectInRepo("@{u}", "be3563ae3f795b2b4353bcce3a527ad0a4f7f644", repo, t)
+	checkObjectInRepo("master@{u}", "be3563ae3f795b2b4353bcce3a527ad0a4f7f644", repo, t)
+	checkObjectInRepo("heads/master@{u}", "be3563ae3f795b2b4353bcce3a527ad0a4f7f644", repo, t)
+	checkObjectInRepo("refs/heads/master@{u}", "be3563ae3f795b2b4353bcce3a527ad0a4f7f644", repo, t)
+	checkObjectAndRefInRepo("master@{u}", "be3563ae3f795b2b4353bcce3a527ad0a4f7f644", "refs/remotes/test/master", repo, t)
+}
+
+func Test_Revparse_Colon(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+
+	checkInvalidSingleSpec(":/", repo, t)
+	checkInvalidSingleSpec("point_to_blob:readme.txt", repo, t)
+
+	checkObjectInRepo(":/not found in any commit", "", repo, t)
+	checkObjectInRepo("subtrees:ab/42.txt", "", repo, t)
+	checkObjectInRepo("subtrees:ab/4.txt/nope", "", repo, t)
+	checkObjectInRepo("subtrees:nope", "", repo, t)
+	checkObjectInRepo("test/master^1:branch_file.txt", "", repo, t)
+
+	// From tags
+	checkObjectInRepo("test:readme.txt", "0266163a49e280c4f5ed1e08facd36a2bd716bcf", repo, t)
+	checkObjectInRepo("tags/test:readme.txt", "0266163a49e280c4f5ed1e08facd36a2bd716bcf", repo, t)
+	checkObjectInRepo("e90810b:readme.txt", "0266163a49e280c4f5ed1e08facd36a2bd716bcf", repo, t)
+	checkObjectInRepo("tags/e90810b:readme.txt", "0266163a49e280c4f5ed1e08facd36a2bd716bcf", repo, t)
+
+	// From commits
+	checkObjectInRepo("a65f:README", "a8233120f6ad708f843d861ce2b7228ec4e3dec6", repo, t)
+
+	// From trees
+	checkObjectInRepo("a65f^{tree}:README", "a8233120f6ad708f843d861ce2b7228ec4e3dec6", repo, t)
+	checkObjectInRepo("944c:README", "a8233120f6ad708f843d861ce2b7228ec4e3dec6", repo, t)
+
+	// Retrieving trees
+	checkObjectInRepo("master:", "944c0f6e4dfa41595e6eb3ceecdb14f50fe18162", repo, t)
+	checkObjectInRepo("subtrees:", "ae90f12eea699729ed24555e40b9fd669da12a12", repo, t)
+	checkObjectInRepo("subtrees:ab", "f1425cef211cc08caa31e7b545ffb232acb098c3", repo, t)
+	checkObjectInRepo("subtrees:ab/", "f1425cef211cc08caa31e7b545ffb232acb098c3", repo, t)
+
+	// Retrieving blobs
+	checkObjectInRepo("subtrees:ab/4.txt", "d6c93164c249c8000205dd4ec5cbca1b516d487f", repo, t)
+	checkObjectInRepo("subtrees:ab/de/fgh/1.txt", "1f67fc4386b2d171e0d21be1c447e12660561f9b", repo, t)
+	checkObjectInRepo("master:README", "a8233120f6ad708f843d861ce2b7228ec4e3dec6", repo, t)
+	checkObjectInRepo("master:new.txt", "a71586c1dfe8a71c6cbf6c129f404c5642ff31bd", repo, t)
+	checkObjectInRepo(":/Merge", "a4a7dce85cf63874e984719f4fdd239f5145052f", repo, t)
+	checkObjectInRepo(":/one", "c47800c7266a2be04c571c04d5a6614691ea99bd", repo, t)
+	checkObjectInRepo(":/packed commit t", "41bc8c69075bbdb46c5c6f0566cc8cc5b46e8bd9", repo, t)
+	checkObjectInRepo("test/master^2:branch_file.txt", "45b983be36b73c0788dc9cbcb76cbb80fc7bb057", repo, t)
+	checkObjectInRepo("test/master@{1}:branch_file.txt", "3697d64be941a53d4ae8f6a271e4e3fa56b022cc", repo, t)
+}
+
+func Test_Revparse_Grep(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+
+	checkInvalidSingleSpec("master^{/}", repo, t)
+	checkObjectInRepo("master^{/not found in any commit}", "", repo, t)
+	checkObjectInRepo("master^{/anoth}", "5b5b025afb0b4c913b4c338a42934a3863bf3644", repo, t)
+	checkObjectInRepo("master^{/Merge}", "be3563ae3f795b2b4353bcce3a527ad0a4f7f644", repo, t)
+	checkObjectInRepo("br2^{/Merge}", "a4a7dce85cf63874e984719f4fdd239f5145052f", repo, t)
+	checkObjectInRepo("master^{/fo.rth}", "9fd738e8f7967c078dceed8190330fc8648ee56a", repo, t)
+}
+
+func Test_Revparse_Disambiguation(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+
+	checkObjectInRepo("e90810b", "7b4384978d2493e851f9cca7858815fac9b10980", repo, t)
+	checkObjectInRepo("e90810", "e90810b8df3e80c413d903f631643c716887138d", repo, t)
+}
+
+func Test_Revparse_ReturnsReference(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+
+	checkObjectAndRefInRepo("HEAD", "a65fedf39aefe402d3bb6e24df4d4f5fe4547750", "refs/heads/master", repo, t)
+	checkObjectAndRefInRepo("master", "a65fedf39aefe402d3bb6e24df4d4f5fe4547750", "refs/heads/master", repo, t)
+	checkObjectAndRefInRepo("br2", "a4a7dce85cf63874e984719f4fdd239f5145052f", "refs/heads/br2", repo, t)
+	checkObjectAndRefInRepo("master^", "be3563ae3f795b2b4353bcce3a527ad0a4f7f644", "", repo, t)
+	checkObjectAndRefInRepo("a65fedf", "a65fedf39aefe402d3bb6e24df4d4f5fe4547750", "", repo, t)
+}
+
+func Test_Revparse_Range(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+
+	checkIdInRepo("be3563a^1..be3563a", "9fd738e8f7967c078dceed8190330fc8648ee56a", "be3563ae3f795b2b4353bcce3a527ad0a4f7f644", RevparseRange, repo, t)
+	checkIdInRepo("be3563a^1...be3563a", "9fd738e8f7967c078dceed8190330fc8648ee56a", "be3563ae3f795b2b4353bcce3a527ad0a4f7f644", RevparseRange|RevparseMergeBase, repo, t)
+	checkIdInRepo("be3563a^1.be3563a", "", "", RevparseNone, repo, t)
+	checkIdInRepo("HEAD~1..", "be3563ae3f795b2b4353bcce3a527ad0a4f7f644", "a65fedf39aefe402d3bb6e24df4d4f5fe4547750", RevparseRange, repo, t)
+	checkIdInRepo("a65fedf", "a65fedf39aefe402d3bb6e24df4d4f5fe4547750", "", RevparseSingle, repo, t)
+}
+
+func Test_Revparse_ReflogDate(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+
+	/*
+	 * $ git reflog master --date=raw
+	 * a65fedf master@{1335806603 -0800}: commit: checking in
+	 * be3563a master@{1335806563 -0800}: clone: from /Users/ben/src/libgit2/tests/resources/testrepo.git
+	 */
+	checkInvalidSingleSpec("master@{not a date}", repo, t)
+	checkObjectInRepo("not-exist@{yesterday}", "", repo, t)
+
+	checkObjectInRepo("master@{1335806603}", "a65fedf39aefe402d3bb6e24df4d4f5fe4547750", repo, t)
+	checkObjectInRepo("master@{1335806602}", "be3563ae3f795b2b4353bcce3a527ad0a4f7f644", repo, t)
+	checkObjectInRepo("master@{2012-04-30 17:22:43 +0000}", "be3563ae3f795b2b4353bcce3a527ad0a4f7f644", repo, t)
+	checkObjectInRepo("master@{2012-04-30 09:22:43 -0800}", "be3563ae3f795b2b4353bcce3a527ad0a4f7f644", repo, t)
+	checkObjectInRepo("master@{2012-4-30 09:23:23 -0800}", "a65fedf39aefe402d3bb6e24df4d4f5fe4547750", repo, t)
+	checkObjectInRepo("master@{Mon, 30 Apr 2012 17:23:23 +0000}", "a65fedf39aefe402d3bb6e24df4d4f5fe4547750", repo, t)
+	checkObjectInRepo("master@{2012-05-03 18:33:13 +0000}", "a65fedf39aefe402d3bb6e24df4d4f5fe4547750", repo, t)
+	checkObjectInRepo("master@{1 year ago}", "a65fedf39aefe402d3bb6e24df4d4f5fe4547750", repo, t)
+	checkObjectInRepo("@{1 year ago}", "a65fedf39aefe402d3bb6e24df4d4f5fe4547750", repo, t)
+
+	// older than the reflog: the oldest value is used
+	checkObjectInRepo("master@{2012-04-30 17:22:42 +0000}", "be3563ae3f795b2b4353bcce3a527ad0a4f7f644", repo, t)
+
+	// HEAD@{<date>} uses the reflog of HEAD, not of the current branch
+	checkObjectInRepo("HEAD@{1335806608}", "c47800c7266a2be04c571c04d5a6614691ea99bd", repo, t)
+	checkObjectInRepo("HEAD@{1335806604}", "5b5b025afb0b4c913b4c338a42934a3863bf3644", repo, t)
+	checkObjectInRepo("master@{1335806604}", "a65fedf39aefe402d3bb6e24df4d4f5fe4547750", repo, t)
+}
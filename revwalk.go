@@ -2,6 +2,7 @@ package git4go
 
 import (
 	"errors"
+	"fmt"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -85,8 +86,43 @@ func (v *RevWalk) PushGlob(glob string) error {
 	return v.pushGlob(glob, false)
 }
 
+// PushRange pushes and hides the commits by a range like "from..to". A
+// symmetric range "from...to" pushes the both and hides their merge bases.
 func (v *RevWalk) PushRange(r string) error {
-	return nil
+	revspec, err := v.repo.Revparse(r)
+	if err != nil {
+		return err
+	}
+	if revspec.Flags()&RevparseRange == 0 {
+		return MakeGitError(fmt.Sprintf("'%s' is not a valid range", r), ErrInvalidSpec)
+	}
+	if revspec.IsMergeBase() {
+		from, err := revspec.From().Peel(ObjectCommit)
+		if err != nil {
+			return err
+		}
+		to, err := revspec.To().Peel(ObjectCommit)
+		if err != nil {
+			return err
+		}
+		bases, err := v.repo.MergeBases(from.Id(), to.Id())
+		if err != nil && !IsErrorCode(err, ErrNotFound) {
+			return err
+		}
+		for _, base := range bases {
+			if err := v.pushCommit(base, true, false); err != nil {
+				return err
+			}
+		}
+		if err := v.pushCommit(from.Id(), false, false); err != nil {
+			return err
+		}
+		return v.pushCommit(to.Id(), false, false)
+	}
+	if err := v.pushCommit(revspec.From().Id(), true, false); err != nil {
+		return err
+	}
+	return v.pushCommit(revspec.To().Id(), false, false)
 }
 
 func (v *RevWalk) PushRef(r string) error {
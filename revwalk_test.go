@@ -164,6 +164,50 @@ func Test_RevWalk_Basic_PushHead(t *testing.T) {
 	}
 }
 
+func Test_RevWalk_Basic_PushRange(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	walk, _ := repo.Walk()
+	walk.Sorting(SortTime)
+	err := walk.PushRange("9fd738e~2..9fd738e")
+	if err != nil {
+		t.Error("err should be nil:", err)
+	}
+	if !checkWalkOnly(walk, commitSortingSegment, t) {
+		t.Error("walk result error")
+	}
+}
+
+func Test_RevWalk_Basic_PushRangeSymmetric(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	walk, _ := repo.Walk()
+	walk.Sorting(SortTime)
+	err := walk.PushRange("9fd738e...c47800c")
+	if err != nil {
+		t.Error("err should be nil:", err)
+	}
+	if !checkWalkOnly(walk, [][]int{{3, 1, 2, -1, -1, -1}}, t) {
+		t.Error("walk result error")
+	}
+}
+
+func Test_RevWalk_Basic_PushRangeNotRange(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	walk, _ := repo.Walk()
+	err := walk.PushRange("9fd738e")
+	if !IsErrorCode(err, ErrInvalidSpec) {
+		t.Error("single revision should be rejected:", err)
+	}
+}
+
 func Test_RevWalk_Basic_PushHeadHideRef(t *testing.T) {
 	testutil.PrepareWorkspace("test_resources/testrepo.git")
 	defer testutil.CleanupWorkspace()
@@ -3,6 +3,7 @@ package git4go
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"strconv"
 	"strings"
 	"time"
@@ -43,6 +44,18 @@ func (v *Signature) Offset() int {
 	return offset / 60
 }
 
+// formatSignature returns the signature in the format of commit, tag and
+// reflog like "Name <email> 1225475778 -0700".
+func formatSignature(sig *Signature) string {
+	offset := sig.Offset()
+	sign := '+'
+	if offset < 0 {
+		sign = '-'
+		offset = -offset
+	}
+	return fmt.Sprintf("%s <%s> %d %c%02d%02d", sig.Name, sig.Email, sig.When.Unix(), sign, offset/60, offset%60)
+}
+
 func parseSignature(data []byte, offset int, prefix []byte) (*Signature, int, error) {
 	linePrefix := offset + len(prefix)
 	lineEnd := offset
@@ -93,10 +106,9 @@ func parseSignature(data []byte, offset int, prefix []byte) (*Signature, int, er
 	timezone, err := strconv.ParseInt(string(bytes.TrimSpace(line[timeEnd:])), 10, 64)
 	hour := timezone / 100
 	min := timezone % 100
-	if hour < 14 && min < 59 {
+	if err == nil && -14 < hour && hour < 14 && -60 < min && min < 60 {
+		// the epoch is in UTC, so only the location is changed
 		second := int(hour*3600 + min*60)
-		_, localTimezone := timestamp.Zone()
-		timestamp = timestamp.Add(time.Second * time.Duration(localTimezone-second))
 		timestamp = timestamp.In(time.FixedZone(" ", second))
 	}
 	sig.When = timestamp
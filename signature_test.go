@@ -74,7 +74,7 @@ func Test_parseSignature(t *testing.T) {
 		if signature.When.Year() != 2008 {
 			t.Error("parse error: when", signature.When.String())
 		}
-		if signature.When.Hour() != 2 {
+		if signature.When.Hour() != 10 {
 			t.Error("parse error: when", signature.When.String())
 		}
 		_, diff := signature.When.Zone()
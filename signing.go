@@ -0,0 +1,198 @@
+package git4go
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type SignatureFormat int
+
+const (
+	SignatureFormatOpenPGP SignatureFormat = iota
+	SignatureFormatSSH
+)
+
+// SignatureStatus is the result of the verification like "%G?" of
+// "git log".
+type SignatureStatus int
+
+const (
+	// the signature is valid. See the trust to know the signer is allowed
+	SignatureGood SignatureStatus = iota
+	// the signature doesn't match the content
+	SignatureBad
+	// the key which made the signature is not available
+	SignatureMissingKey
+	SignatureExpiredSignature
+	SignatureExpiredKey
+	SignatureRevokedKey
+)
+
+// SignatureTrust is the trust level of the key like "%GT" of "git log".
+type SignatureTrust int
+
+const (
+	SignatureTrustUndefined SignatureTrust = iota
+	SignatureTrustNever
+	SignatureTrustMarginal
+	SignatureTrustFully
+	SignatureTrustUltimate
+)
+
+// SignatureVerification is the result of Commit.VerifySignature and
+// Tag.VerifySignature.
+type SignatureVerification struct {
+	Format SignatureFormat
+	Status SignatureStatus
+	Trust  SignatureTrust
+	// the principal of the allowed signers file for SSH, or the user ID of
+	// the key for OpenPGP. It is empty if the signer is unknown
+	Signer string
+	// the fingerprint of the key which made the signature
+	Fingerprint string
+	// the fingerprint of the primary key if a subkey made the signature
+	// (OpenPGP only)
+	PrimaryKeyFingerprint string
+}
+
+// IsTrusted returns true if the signature is good and made by a key which
+// is trusted at least the given level.
+func (v *SignatureVerification) IsTrusted(level SignatureTrust) bool {
+	return v.Status == SignatureGood && v.Trust >= level
+}
+
+// SignatureVerifyOptions has the keys to verify signatures.
+type SignatureVerifyOptions struct {
+	// the allowed signers file for SSH signatures. gpg.ssh.allowedSignersFile
+	// is used if it is empty
+	AllowedSignersFile string
+	// the public keys for OpenPGP signatures. All keys in it are trusted
+	// fully
+	Keyring *OpenPGPKeyring
+}
+
+// Signer makes the signatures of commits and tags. Sign receives the
+// object without the signature and returns the armored signature, which is
+// written in the "gpgsig" header of commits or appended to tags.
+type Signer interface {
+	Sign(payload []byte) (string, error)
+}
+
+const (
+	sshSignatureBegin     = "-----BEGIN SSH SIGNATURE-----"
+	openPGPSignatureBegin = "-----BEGIN PGP SIGNATURE-----"
+	openPGPMessageBegin   = "-----BEGIN PGP MESSAGE-----"
+	x509SignatureBegin    = "-----BEGIN SIGNED MESSAGE-----"
+)
+
+// VerifySignature verifies the signature in the "gpgsig" header like "git
+// verify-commit". The validity period of the SSH keys is checked at the
+// committer's time. It fails with ErrNotFound if the commit is not signed.
+func (c *Commit) VerifySignature(opts *SignatureVerifyOptions) (*SignatureVerification, error) {
+	signature, signedData, err := c.ExtractSignature()
+	if err != nil {
+		return nil, err
+	}
+	return c.repo.verifySignature(signature, []byte(signedData), c.committer.When, opts)
+}
+
+// ExtractSignature returns the signature at the end of the message and the
+// signed data, which is the tag object without the signature. It fails with
+// ErrNotFound if the tag is not signed.
+func (t *Tag) ExtractSignature() (signature, signedData string, err error) {
+	odb, err := t.repo.Odb()
+	if err != nil {
+		return "", "", err
+	}
+	obj, err := odb.Read(t.oid)
+	if err != nil {
+		return "", "", err
+	}
+	if obj.Type != ObjectTag {
+		return "", "", MakeGitError("the requested type does not match the type in ODB", ErrInvalidSpec)
+	}
+	body := bytes.Index(obj.Data, []byte("\n\n"))
+	if body == -1 {
+		return "", "", MakeGitError("the tag is not signed", ErrNotFound)
+	}
+	// the last line which starts a signature like git
+	start := -1
+	for offset := body + 2; offset < len(obj.Data); {
+		if isSignatureBegin(obj.Data[offset:]) {
+			start = offset
+		}
+		eol := bytes.IndexByte(obj.Data[offset:], '\n')
+		if eol == -1 {
+			break
+		}
+		offset += eol + 1
+	}
+	if start == -1 {
+		return "", "", MakeGitError("the tag is not signed", ErrNotFound)
+	}
+	return string(obj.Data[start:]), string(obj.Data[:start]), nil
+}
+
+// VerifySignature verifies the signature at the end of the message like
+// "git verify-tag". The validity period of the SSH keys is checked at the
+// tagger's time. It fails with ErrNotFound if the tag is not signed.
+func (t *Tag) VerifySignature(opts *SignatureVerifyOptions) (*SignatureVerification, error) {
+	signature, signedData, err := t.ExtractSignature()
+	if err != nil {
+		return nil, err
+	}
+	when := time.Now()
+	if t.tagger != nil {
+		when = t.tagger.When
+	}
+	return t.repo.verifySignature(signature, []byte(signedData), when, opts)
+}
+
+func isSignatureBegin(line []byte) bool {
+	for _, begin := range []string{openPGPSignatureBegin, openPGPMessageBegin, sshSignatureBegin, x509SignatureBegin} {
+		if bytes.HasPrefix(line, []byte(begin)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Repository) verifySignature(signature string, signedData []byte, when time.Time, opts *SignatureVerifyOptions) (*SignatureVerification, error) {
+	if opts == nil {
+		opts = &SignatureVerifyOptions{}
+	}
+	switch {
+	case strings.HasPrefix(signature, sshSignatureBegin):
+		path := opts.AllowedSignersFile
+		if path == "" {
+			path = findAllowedSignersFile(r.Config())
+		}
+		if path == "" {
+			return nil, errors.New("gpg.ssh.allowedSignersFile needs to be configured and exist for ssh signature verification")
+		}
+		signers, err := readAllowedSignersFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return verifySSHSignature(signature, signedData, signers, when)
+	case strings.HasPrefix(signature, openPGPSignatureBegin), strings.HasPrefix(signature, openPGPMessageBegin):
+		return opts.Keyring.verify(signature, signedData)
+	}
+	return nil, errors.New("unsupported signature format")
+}
+
+func findAllowedSignersFile(config *Config) string {
+	for _, name := range []string{"gpg.ssh.allowedSignersFile", "gpg.ssh.allowedsignersfile"} {
+		if value, err := config.LookupString(name); err == nil && value != "" {
+			if strings.HasPrefix(value, "~/") {
+				value = filepath.Join(os.Getenv("HOME"), value[2:])
+			}
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,824 @@
+package git4go
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// the packet tags of OpenPGP (RFC 4880)
+const (
+	openPGPTagSignature     = 2
+	openPGPTagPublicKey     = 6
+	openPGPTagUserId        = 13
+	openPGPTagPublicSubkey  = 14
+	openPGPTagUserAttribute = 17
+)
+
+// the public key algorithms
+const (
+	openPGPAlgorithmRSA         = 1
+	openPGPAlgorithmRSASignOnly = 3
+	openPGPAlgorithmECDSA       = 19
+	openPGPAlgorithmEdDSA       = 22
+	openPGPAlgorithmEd25519     = 27
+)
+
+// the signature types
+const (
+	openPGPSignatureBinary           = 0x00
+	openPGPSignatureText             = 0x01
+	openPGPSignatureSubkeyBinding    = 0x18
+	openPGPSignaturePrimaryBinding   = 0x19
+	openPGPSignatureKeyRevocation    = 0x20
+	openPGPSignatureSubkeyRevocation = 0x28
+	openPGPSignatureCertRevocation   = 0x30
+)
+
+// the signature subpacket types
+const (
+	openPGPSubpacketCreationTime      = 2
+	openPGPSubpacketExpirationTime    = 3
+	openPGPSubpacketKeyExpirationTime = 9
+	openPGPSubpacketIssuer            = 16
+	openPGPSubpacketPrimaryUserId     = 25
+	openPGPSubpacketKeyFlags          = 27
+	openPGPSubpacketEmbeddedSignature = 32
+	openPGPSubpacketIssuerFingerprint = 33
+)
+
+const openPGPKeyFlagSign = 0x02
+
+var openPGPCurves = map[string]elliptic.Curve{
+	"\x2a\x86\x48\xce\x3d\x03\x01\x07": elliptic.P256(),
+	"\x2b\x81\x04\x00\x22":             elliptic.P384(),
+	"\x2b\x81\x04\x00\x23":             elliptic.P521(),
+}
+
+// the OID of Ed25519 for EdDSA
+const openPGPEd25519Oid = "\x2b\x06\x01\x04\x01\xda\x47\x0f\x01"
+
+var openPGPHashes = map[byte]crypto.Hash{
+	2:  crypto.SHA1,
+	8:  crypto.SHA256,
+	9:  crypto.SHA384,
+	10: crypto.SHA512,
+	11: crypto.SHA224,
+}
+
+// OpenPGPKeyring has the public keys to verify OpenPGP signatures. Only
+// the version 4 keys of RSA, ECDSA (NIST curves) and EdDSA (Ed25519) are
+// supported.
+type OpenPGPKeyring struct {
+	entities []*openPGPEntity
+}
+
+// openPGPEntity is a primary key with its user IDs and subkeys.
+type openPGPEntity struct {
+	primary    *openPGPKey
+	identities []*openPGPIdentity
+	subkeys    []*openPGPKey
+	// the signatures directly on the primary key like revocations
+	signatures []*openPGPSignature
+	// the user ID of the primary identity
+	name string
+}
+
+type openPGPIdentity struct {
+	name       string
+	signatures []*openPGPSignature
+}
+
+type openPGPKey struct {
+	entity      *openPGPEntity
+	body        []byte
+	fingerprint []byte
+	keyId       uint64
+	created     time.Time
+	algorithm   byte
+	publicKey   crypto.PublicKey
+	// the signatures which bind the subkey
+	signatures []*openPGPSignature
+	// the properties given by the valid self signature
+	expiration  time.Time
+	flags       byte
+	hasFlags    bool
+	revoked     bool
+	certified   bool
+	signCapable bool
+}
+
+type openPGPSignature struct {
+	signatureType byte
+	algorithm     byte
+	hash          crypto.Hash
+	hashedPart    []byte
+	left16        []byte
+	values        [][]byte
+	created       time.Time
+	// zero means never
+	expiration        time.Duration
+	keyExpiration     time.Duration
+	issuer            uint64
+	issuerFingerprint []byte
+	flags             byte
+	hasFlags          bool
+	primaryUserId     bool
+	embedded          *openPGPSignature
+}
+
+// ReadOpenPGPKeyring reads the public keys exported by "gpg --export". The
+// data can be armored or binary, and can have several keys.
+func ReadOpenPGPKeyring(data []byte) (*OpenPGPKeyring, error) {
+	if bytes.Contains(data, []byte("-----BEGIN PGP PUBLIC KEY BLOCK-----")) {
+		blocks, err := decodeOpenPGPArmor(string(data), "PGP PUBLIC KEY BLOCK")
+		if err != nil {
+			return nil, err
+		}
+		data = bytes.Join(blocks, nil)
+	}
+	packets, err := readOpenPGPPackets(data)
+	if err != nil {
+		return nil, err
+	}
+	keyring := &OpenPGPKeyring{}
+	var entity *openPGPEntity
+	// the last packet which the following signatures are on
+	var identity *openPGPIdentity
+	var subkey *openPGPKey
+	skipSignatures := false
+	for _, packet := range packets {
+		switch packet.tag {
+		case openPGPTagPublicKey:
+			entity, identity, subkey, skipSignatures = nil, nil, nil, false
+			key, err := parseOpenPGPKey(packet.body)
+			if err != nil {
+				// the unsupported key versions are skipped
+				continue
+			}
+			entity = &openPGPEntity{primary: key}
+			key.entity = entity
+			keyring.entities = append(keyring.entities, entity)
+		case openPGPTagUserId:
+			if entity == nil {
+				continue
+			}
+			identity, subkey, skipSignatures = &openPGPIdentity{name: string(packet.body)}, nil, false
+			entity.identities = append(entity.identities, identity)
+		case openPGPTagUserAttribute:
+			identity, subkey, skipSignatures = nil, nil, true
+		case openPGPTagPublicSubkey:
+			if entity == nil {
+				continue
+			}
+			identity, subkey, skipSignatures = nil, nil, true
+			key, err := parseOpenPGPKey(packet.body)
+			if err != nil {
+				continue
+			}
+			key.entity = entity
+			subkey, skipSignatures = key, false
+			entity.subkeys = append(entity.subkeys, key)
+		case openPGPTagSignature:
+			if entity == nil || skipSignatures {
+				continue
+			}
+			signature, err := parseOpenPGPSignature(packet.body)
+			if err != nil {
+				continue
+			}
+			switch {
+			case subkey != nil:
+				subkey.signatures = append(subkey.signatures, signature)
+			case identity != nil:
+				identity.signatures = append(identity.signatures, signature)
+			default:
+				entity.signatures = append(entity.signatures, signature)
+			}
+		}
+	}
+	for _, entity := range keyring.entities {
+		entity.resolve()
+	}
+	return keyring, nil
+}
+
+// resolve applies the self signatures to the keys.
+func (e *openPGPEntity) resolve() {
+	primary := e.primary
+	for _, signature := range e.signatures {
+		if signature.signatureType == openPGPSignatureKeyRevocation && signature.isIssuedBy(primary) &&
+			primary.verifyKeySignature(signature, primary, nil, nil) {
+			primary.revoked = true
+		}
+	}
+	var selfSignature *openPGPSignature
+	for _, identity := range e.identities {
+		var latest *openPGPSignature
+		revoked := false
+		for _, signature := range identity.signatures {
+			if !signature.isIssuedBy(primary) {
+				continue
+			}
+			switch {
+			case signature.signatureType >= 0x10 && signature.signatureType <= 0x13:
+				if (latest == nil || signature.created.After(latest.created)) &&
+					primary.verifyKeySignature(signature, primary, nil, []byte(identity.name)) {
+					latest = signature
+				}
+			case signature.signatureType == openPGPSignatureCertRevocation:
+				if primary.verifyKeySignature(signature, primary, nil, []byte(identity.name)) {
+					revoked = true
+				}
+			}
+		}
+		if latest == nil || revoked {
+			continue
+		}
+		if selfSignature == nil || latest.primaryUserId && !selfSignature.primaryUserId {
+			selfSignature = latest
+			e.name = identity.name
+		}
+	}
+	if selfSignature != nil {
+		primary.certified = true
+		primary.applySelfSignature(selfSignature)
+		primary.signCapable = !primary.hasFlags || primary.flags&openPGPKeyFlagSign != 0
+	}
+
+	for _, subkey := range e.subkeys {
+		var binding *openPGPSignature
+		for _, signature := range subkey.signatures {
+			if !signature.isIssuedBy(primary) || !primary.verifyKeySignature(signature, primary, subkey, nil) {
+				continue
+			}
+			switch signature.signatureType {
+			case openPGPSignatureSubkeyBinding:
+				if binding == nil || signature.created.After(binding.created) {
+					binding = signature
+				}
+			case openPGPSignatureSubkeyRevocation:
+				subkey.revoked = true
+			}
+		}
+		if binding == nil {
+			continue
+		}
+		subkey.certified = true
+		subkey.applySelfSignature(binding)
+		// the signing subkey should sign the primary key to prove that it
+		// belongs to the primary key
+		if subkey.hasFlags && subkey.flags&openPGPKeyFlagSign != 0 {
+			back := binding.embedded
+			subkey.signCapable = back != nil && back.signatureType == openPGPSignaturePrimaryBinding &&
+				subkey.verifyKeySignature(back, primary, subkey, nil)
+		}
+	}
+}
+
+func (k *openPGPKey) applySelfSignature(signature *openPGPSignature) {
+	if signature.keyExpiration != 0 {
+		k.expiration = k.created.Add(signature.keyExpiration)
+	}
+	k.flags = signature.flags
+	k.hasFlags = signature.hasFlags
+}
+
+// findSigningKey returns the key which made the signature. Subkeys which
+// are not bound to the primary key or can't sign are ignored.
+func (r *OpenPGPKeyring) findSigningKey(signature *openPGPSignature) *openPGPKey {
+	if r == nil {
+		return nil
+	}
+	for _, entity := range r.entities {
+		if entity.primary.certified && entity.primary.signCapable && signature.isIssuedBy(entity.primary) {
+			return entity.primary
+		}
+		for _, subkey := range entity.subkeys {
+			if subkey.certified && subkey.signCapable && signature.isIssuedBy(subkey) {
+				return subkey
+			}
+		}
+	}
+	return nil
+}
+
+// verify verifies the detached signature like "gpg --verify". The keys in
+// the keyring are trusted fully. The expiration and the revocation of the
+// key are checked at the current time like gpg.
+func (r *OpenPGPKeyring) verify(armored string, data []byte) (*SignatureVerification, error) {
+	blocks, err := decodeOpenPGPArmor(armored, "")
+	if err != nil {
+		return nil, err
+	}
+	packets, err := readOpenPGPPackets(bytes.Join(blocks, nil))
+	if err != nil {
+		return nil, err
+	}
+	var signature *openPGPSignature
+	for _, packet := range packets {
+		if packet.tag == openPGPTagSignature {
+			if signature, err = parseOpenPGPSignature(packet.body); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+	if signature == nil {
+		return nil, errors.New("no OpenPGP signature packet")
+	}
+	result := &SignatureVerification{
+		Format: SignatureFormatOpenPGP,
+		Status: SignatureMissingKey,
+	}
+	if signature.issuerFingerprint != nil {
+		result.Fingerprint = strings.ToUpper(hex.EncodeToString(signature.issuerFingerprint))
+	}
+	key := r.findSigningKey(signature)
+	if key == nil {
+		return result, nil
+	}
+	result.Signer = key.entity.name
+	result.Fingerprint = strings.ToUpper(hex.EncodeToString(key.fingerprint))
+	result.PrimaryKeyFingerprint = strings.ToUpper(hex.EncodeToString(key.entity.primary.fingerprint))
+	result.Status = SignatureBad
+	switch signature.signatureType {
+	case openPGPSignatureBinary:
+	case openPGPSignatureText:
+		data = canonicalizeOpenPGPText(data)
+	default:
+		return result, nil
+	}
+	if !key.verify(signature, data) || signature.created.Before(key.created) {
+		return result, nil
+	}
+
+	now := time.Now()
+	primary := key.entity.primary
+	switch {
+	case signature.expiration != 0 && now.After(signature.created.Add(signature.expiration)):
+		result.Status = SignatureExpiredSignature
+	case primary.revoked || key.revoked:
+		result.Status = SignatureRevokedKey
+	case primary.isExpired(now) || key.isExpired(now):
+		result.Status = SignatureExpiredKey
+	default:
+		result.Status = SignatureGood
+		result.Trust = SignatureTrustFully
+	}
+	return result, nil
+}
+
+func (k *openPGPKey) isExpired(now time.Time) bool {
+	return !k.expiration.IsZero() && now.After(k.expiration)
+}
+
+// canonicalizeOpenPGPText converts the line endings into CRLF for the text
+// signatures.
+func canonicalizeOpenPGPText(data []byte) []byte {
+	data = bytes.Replace(data, []byte("\r\n"), []byte("\n"), -1)
+	return bytes.Replace(data, []byte("\n"), []byte("\r\n"), -1)
+}
+
+func (s *openPGPSignature) isIssuedBy(key *openPGPKey) bool {
+	if s.issuerFingerprint != nil {
+		return bytes.Equal(s.issuerFingerprint, key.fingerprint)
+	}
+	return s.issuer == key.keyId
+}
+
+// verifyKeySignature verifies the signature on the primary key with the
+// subkey or the user ID.
+func (k *openPGPKey) verifyKeySignature(signature *openPGPSignature, primary, subkey *openPGPKey, userId []byte) bool {
+	var data bytes.Buffer
+	primary.writeForHash(&data)
+	if subkey != nil {
+		subkey.writeForHash(&data)
+	}
+	if userId != nil {
+		var header [5]byte
+		header[0] = 0xb4
+		binary.BigEndian.PutUint32(header[1:], uint32(len(userId)))
+		data.Write(header[:])
+		data.Write(userId)
+	}
+	return k.verify(signature, data.Bytes())
+}
+
+func (k *openPGPKey) writeForHash(buffer *bytes.Buffer) {
+	buffer.Write([]byte{0x99, byte(len(k.body) >> 8), byte(len(k.body))})
+	buffer.Write(k.body)
+}
+
+// verify checks the signature of the data with the key.
+func (k *openPGPKey) verify(signature *openPGPSignature, data []byte) bool {
+	if k.publicKey == nil || signature.hash == 0 || !signature.hash.Available() {
+		return false
+	}
+	h := signature.hash.New()
+	h.Write(data)
+	h.Write(signature.hashedPart)
+	var trailer [6]byte
+	trailer[0] = 4
+	trailer[1] = 0xff
+	binary.BigEndian.PutUint32(trailer[2:], uint32(len(signature.hashedPart)))
+	h.Write(trailer[:])
+	digest := h.Sum(nil)
+	if !bytes.Equal(digest[:2], signature.left16) {
+		return false
+	}
+	switch publicKey := k.publicKey.(type) {
+	case *rsa.PublicKey:
+		if signature.algorithm != openPGPAlgorithmRSA && signature.algorithm != openPGPAlgorithmRSASignOnly || len(signature.values) != 1 {
+			return false
+		}
+		value := signature.values[0]
+		size := (publicKey.N.BitLen() + 7) / 8
+		if len(value) > size {
+			return false
+		}
+		padded := make([]byte, size)
+		copy(padded[size-len(value):], value)
+		return rsa.VerifyPKCS1v15(publicKey, signature.hash, digest, padded) == nil
+	case *ecdsa.PublicKey:
+		if signature.algorithm != openPGPAlgorithmECDSA || len(signature.values) != 2 {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature.values[0])
+		s := new(big.Int).SetBytes(signature.values[1])
+		return ecdsa.Verify(publicKey, digest, r, s)
+	case ed25519.PublicKey:
+		var value []byte
+		switch {
+		case signature.algorithm == openPGPAlgorithmEdDSA && len(signature.values) == 2:
+			// the leading zeros of R and S can be stripped
+			r, s := signature.values[0], signature.values[1]
+			if len(r) > 32 || len(s) > 32 {
+				return false
+			}
+			value = make([]byte, ed25519.SignatureSize)
+			copy(value[32-len(r):32], r)
+			copy(value[64-len(s):], s)
+		case signature.algorithm == openPGPAlgorithmEd25519 && len(signature.values) == 1:
+			value = signature.values[0]
+		default:
+			return false
+		}
+		return ed25519.Verify(publicKey, digest, value)
+	}
+	return false
+}
+
+// parseOpenPGPKey parses the public key packet. It fails if the version is
+// not 4. The unsupported algorithms are parsed without the public key.
+func parseOpenPGPKey(body []byte) (*openPGPKey, error) {
+	if len(body) < 6 || body[0] != 4 {
+		return nil, errors.New("unsupported OpenPGP key version")
+	}
+	sum := sha1.New()
+	sum.Write([]byte{0x99, byte(len(body) >> 8), byte(len(body))})
+	sum.Write(body)
+	fingerprint := sum.Sum(nil)
+	key := &openPGPKey{
+		body:        body,
+		fingerprint: fingerprint,
+		keyId:       binary.BigEndian.Uint64(fingerprint[12:]),
+		created:     time.Unix(int64(binary.BigEndian.Uint32(body[1:5])), 0),
+		algorithm:   body[5],
+	}
+	material := openPGPBuffer(body[6:])
+	switch key.algorithm {
+	case openPGPAlgorithmRSA, openPGPAlgorithmRSASignOnly:
+		n, ok1 := material.readMPI()
+		e, ok2 := material.readMPI()
+		if !ok1 || !ok2 || len(e) > 4 {
+			return nil, errors.New("invalid OpenPGP RSA key")
+		}
+		exponent := 0
+		for _, b := range e {
+			exponent = exponent<<8 | int(b)
+		}
+		key.publicKey = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exponent}
+	case openPGPAlgorithmECDSA:
+		oid, ok1 := material.readOid()
+		point, ok2 := material.readMPI()
+		if !ok1 || !ok2 {
+			return nil, errors.New("invalid OpenPGP ECDSA key")
+		}
+		if curve, ok := openPGPCurves[string(oid)]; ok {
+			if x, y := elliptic.Unmarshal(curve, point); x != nil {
+				key.publicKey = &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+			}
+		}
+	case openPGPAlgorithmEdDSA:
+		oid, ok1 := material.readOid()
+		point, ok2 := material.readMPI()
+		if !ok1 || !ok2 {
+			return nil, errors.New("invalid OpenPGP EdDSA key")
+		}
+		if string(oid) == openPGPEd25519Oid && len(point) == ed25519.PublicKeySize+1 && point[0] == 0x40 {
+			key.publicKey = ed25519.PublicKey(point[1:])
+		}
+	case openPGPAlgorithmEd25519:
+		if len(material) == ed25519.PublicKeySize {
+			key.publicKey = ed25519.PublicKey(material)
+		}
+	}
+	return key, nil
+}
+
+// parseOpenPGPSignature parses the version 4 signature packet.
+func parseOpenPGPSignature(body []byte) (*openPGPSignature, error) {
+	if len(body) < 6 || body[0] != 4 {
+		return nil, errors.New("unsupported OpenPGP signature version")
+	}
+	signature := &openPGPSignature{
+		signatureType: body[1],
+		algorithm:     body[2],
+		hash:          openPGPHashes[body[3]],
+	}
+	hashedLength := int(binary.BigEndian.Uint16(body[4:6]))
+	if len(body) < 6+hashedLength+2 {
+		return nil, errors.New("invalid OpenPGP signature")
+	}
+	signature.hashedPart = body[:6+hashedLength]
+	if err := signature.parseSubpackets(body[6:6+hashedLength], true); err != nil {
+		return nil, err
+	}
+	rest := body[6+hashedLength:]
+	unhashedLength := int(binary.BigEndian.Uint16(rest))
+	if len(rest) < 2+unhashedLength+2 {
+		return nil, errors.New("invalid OpenPGP signature")
+	}
+	if err := signature.parseSubpackets(rest[2:2+unhashedLength], false); err != nil {
+		return nil, err
+	}
+	rest = rest[2+unhashedLength:]
+	signature.left16 = rest[:2]
+	values := openPGPBuffer(rest[2:])
+	if signature.algorithm == openPGPAlgorithmEd25519 {
+		signature.values = [][]byte{values}
+		return signature, nil
+	}
+	for len(values) > 0 {
+		value, ok := values.readMPI()
+		if !ok {
+			return nil, errors.New("invalid OpenPGP signature")
+		}
+		signature.values = append(signature.values, value)
+	}
+	return signature, nil
+}
+
+// parseSubpackets reads the subpackets. The unhashed ones are only used to
+// find the issuer, which is verified by the signature itself.
+func (s *openPGPSignature) parseSubpackets(data []byte, hashed bool) error {
+	for len(data) > 0 {
+		var length int
+		switch {
+		case data[0] < 192:
+			length, data = int(data[0]), data[1:]
+		case data[0] < 255:
+			if len(data) < 2 {
+				return errors.New("invalid OpenPGP subpacket")
+			}
+			length, data = (int(data[0])-192)<<8+int(data[1])+192, data[2:]
+		default:
+			if len(data) < 5 {
+				return errors.New("invalid OpenPGP subpacket")
+			}
+			length, data = int(binary.BigEndian.Uint32(data[1:5])), data[5:]
+		}
+		if length == 0 || length > len(data) {
+			return errors.New("invalid OpenPGP subpacket")
+		}
+		packetType, content := data[0]&0x7f, data[1:length]
+		data = data[length:]
+		switch packetType {
+		case openPGPSubpacketIssuer:
+			if len(content) == 8 {
+				s.issuer = binary.BigEndian.Uint64(content)
+			}
+			continue
+		case openPGPSubpacketIssuerFingerprint:
+			if len(content) == 21 && content[0] == 4 {
+				s.issuerFingerprint = content[1:]
+			}
+			continue
+		case openPGPSubpacketEmbeddedSignature:
+			if embedded, err := parseOpenPGPSignature(content); err == nil {
+				s.embedded = embedded
+			}
+			continue
+		}
+		if !hashed {
+			continue
+		}
+		switch packetType {
+		case openPGPSubpacketCreationTime:
+			if len(content) == 4 {
+				s.created = time.Unix(int64(binary.BigEndian.Uint32(content)), 0)
+			}
+		case openPGPSubpacketExpirationTime:
+			if len(content) == 4 {
+				s.expiration = time.Duration(binary.BigEndian.Uint32(content)) * time.Second
+			}
+		case openPGPSubpacketKeyExpirationTime:
+			if len(content) == 4 {
+				s.keyExpiration = time.Duration(binary.BigEndian.Uint32(content)) * time.Second
+			}
+		case openPGPSubpacketKeyFlags:
+			if len(content) > 0 {
+				s.flags = content[0]
+				s.hasFlags = true
+			}
+		case openPGPSubpacketPrimaryUserId:
+			s.primaryUserId = len(content) > 0 && content[0] != 0
+		}
+	}
+	return nil
+}
+
+type openPGPPacket struct {
+	tag  int
+	body []byte
+}
+
+// readOpenPGPPackets splits the data into the packets. The partial body
+// lengths are not supported because keys and signatures don't use them.
+func readOpenPGPPackets(data []byte) ([]openPGPPacket, error) {
+	var packets []openPGPPacket
+	for len(data) > 0 {
+		header := data[0]
+		if header&0x80 == 0 {
+			return nil, errors.New("invalid OpenPGP packet")
+		}
+		var tag, length int
+		data = data[1:]
+		if header&0x40 == 0 {
+			// old format
+			tag = int(header>>2) & 0x0f
+			switch header & 3 {
+			case 0:
+				if len(data) < 1 {
+					return nil, errors.New("invalid OpenPGP packet")
+				}
+				length, data = int(data[0]), data[1:]
+			case 1:
+				if len(data) < 2 {
+					return nil, errors.New("invalid OpenPGP packet")
+				}
+				length, data = int(binary.BigEndian.Uint16(data)), data[2:]
+			case 2:
+				if len(data) < 4 {
+					return nil, errors.New("invalid OpenPGP packet")
+				}
+				length, data = int(binary.BigEndian.Uint32(data)), data[4:]
+			default:
+				length = len(data)
+			}
+		} else {
+			tag = int(header & 0x3f)
+			if len(data) < 1 {
+				return nil, errors.New("invalid OpenPGP packet")
+			}
+			switch {
+			case data[0] < 192:
+				length, data = int(data[0]), data[1:]
+			case data[0] < 224:
+				if len(data) < 2 {
+					return nil, errors.New("invalid OpenPGP packet")
+				}
+				length, data = (int(data[0])-192)<<8+int(data[1])+192, data[2:]
+			case data[0] == 255:
+				if len(data) < 5 {
+					return nil, errors.New("invalid OpenPGP packet")
+				}
+				length, data = int(binary.BigEndian.Uint32(data[1:5])), data[5:]
+			default:
+				return nil, errors.New("partial body length of OpenPGP packet is not supported")
+			}
+		}
+		if length < 0 || length > len(data) {
+			return nil, errors.New("invalid OpenPGP packet")
+		}
+		packets = append(packets, openPGPPacket{tag: tag, body: data[:length]})
+		data = data[length:]
+	}
+	return packets, nil
+}
+
+// decodeOpenPGPArmor decodes all the armored blocks of the kind like "PGP
+// SIGNATURE". An empty kind accepts any blocks.
+func decodeOpenPGPArmor(text, kind string) ([][]byte, error) {
+	var blocks [][]byte
+	lines := strings.Split(strings.Replace(text, "\r\n", "\n", -1), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if !strings.HasPrefix(line, "-----BEGIN ") || !strings.HasSuffix(line, "-----") {
+			continue
+		}
+		if kind != "" && line != "-----BEGIN "+kind+"-----" {
+			continue
+		}
+		end := "-----END " + line[len("-----BEGIN "):]
+		// the headers end with a blank line
+		i++
+		for j := i; j < len(lines); j++ {
+			header := strings.TrimSpace(lines[j])
+			if header == "" {
+				i = j + 1
+				break
+			}
+			if !strings.Contains(header, ": ") {
+				break
+			}
+		}
+		var encoded strings.Builder
+		var checksum string
+		closed := false
+		for ; i < len(lines); i++ {
+			line := strings.TrimSpace(lines[i])
+			if line == end {
+				closed = true
+				break
+			}
+			if strings.HasPrefix(line, "=") {
+				checksum = line[1:]
+				continue
+			}
+			encoded.WriteString(line)
+		}
+		if !closed {
+			return nil, errors.New("OpenPGP armor is not closed")
+		}
+		block, err := base64.StdEncoding.DecodeString(encoded.String())
+		if err != nil {
+			return nil, errors.New("invalid OpenPGP armor: " + err.Error())
+		}
+		if checksum != "" {
+			expected, err := base64.StdEncoding.DecodeString(checksum)
+			crc := crc24(block)
+			if err != nil || len(expected) != 3 || !bytes.Equal(expected, []byte{byte(crc >> 16), byte(crc >> 8), byte(crc)}) {
+				return nil, errors.New("OpenPGP armor checksum mismatch")
+			}
+		}
+		blocks = append(blocks, block)
+	}
+	if len(blocks) == 0 {
+		return nil, fmt.Errorf("no OpenPGP armor")
+	}
+	return blocks, nil
+}
+
+func crc24(data []byte) uint32 {
+	crc := uint32(0xb704ce)
+	for _, b := range data {
+		crc ^= uint32(b) << 16
+		for i := 0; i < 8; i++ {
+			crc <<= 1
+			if crc&0x1000000 != 0 {
+				crc ^= 0x1864cfb
+			}
+		}
+	}
+	return crc & 0xffffff
+}
+
+// openPGPBuffer reads the data types of OpenPGP.
+type openPGPBuffer []byte
+
+func (b *openPGPBuffer) readMPI() ([]byte, bool) {
+	if len(*b) < 2 {
+		return nil, false
+	}
+	length := (int(binary.BigEndian.Uint16(*b)) + 7) / 8
+	if len(*b) < 2+length {
+		return nil, false
+	}
+	value := (*b)[2 : 2+length]
+	*b = (*b)[2+length:]
+	return value, true
+}
+
+func (b *openPGPBuffer) readOid() ([]byte, bool) {
+	if len(*b) < 1 || (*b)[0] == 0 || (*b)[0] == 0xff || len(*b) < 1+int((*b)[0]) {
+		return nil, false
+	}
+	length := int((*b)[0])
+	value := (*b)[1 : 1+length]
+	*b = (*b)[1+length:]
+	return value, true
+}
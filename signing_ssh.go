@@ -0,0 +1,676 @@
+package git4go
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// the namespace of SSH signatures made by git
+const sshSignatureNamespace = "git"
+
+// sshSignature is the content of the armored signature made by "ssh-keygen
+// -Y sign" (PROTOCOL.sshsig of OpenSSH).
+type sshSignature struct {
+	publicKey     []byte
+	namespace     string
+	hashAlgorithm string
+	signature     []byte
+}
+
+func parseSSHSignature(armored string) (*sshSignature, error) {
+	var encoded strings.Builder
+	for _, line := range strings.Split(armored, "\n") {
+		line = strings.TrimSpace(line)
+		if line == sshSignatureBegin {
+			continue
+		}
+		if line == "-----END SSH SIGNATURE-----" {
+			break
+		}
+		encoded.WriteString(line)
+	}
+	blob, err := base64.StdEncoding.DecodeString(encoded.String())
+	if err != nil {
+		return nil, errors.New("invalid SSH signature: " + err.Error())
+	}
+	if !bytes.HasPrefix(blob, []byte("SSHSIG")) {
+		return nil, errors.New("invalid SSH signature: wrong magic")
+	}
+	buffer := sshBuffer(blob[6:])
+	version, ok := buffer.readUint32()
+	if !ok || version != 1 {
+		return nil, errors.New("unsupported SSH signature version")
+	}
+	fields := make([][]byte, 5)
+	for i := range fields {
+		if fields[i], ok = buffer.readString(); !ok {
+			return nil, errors.New("invalid SSH signature: too short")
+		}
+	}
+	return &sshSignature{
+		publicKey:     fields[0],
+		namespace:     string(fields[1]),
+		hashAlgorithm: string(fields[3]),
+		signature:     fields[4],
+	}, nil
+}
+
+// verify checks the signature of the data.
+func (s *sshSignature) verify(data []byte) (bool, error) {
+	var digest []byte
+	switch s.hashAlgorithm {
+	case "sha256":
+		sum := sha256.Sum256(data)
+		digest = sum[:]
+	case "sha512":
+		sum := sha512.Sum512(data)
+		digest = sum[:]
+	default:
+		return false, fmt.Errorf("unsupported hash algorithm of SSH signature: %s", s.hashAlgorithm)
+	}
+	signed := sshSignedData(s.namespace, s.hashAlgorithm, digest)
+
+	key := sshBuffer(s.publicKey)
+	keyType, ok := key.readString()
+	if !ok {
+		return false, errors.New("invalid SSH public key")
+	}
+	signature := sshBuffer(s.signature)
+	signatureType, ok1 := signature.readString()
+	blob, ok2 := signature.readString()
+	if !ok1 || !ok2 {
+		return false, errors.New("invalid SSH signature: too short")
+	}
+	switch string(keyType) {
+	case "ssh-ed25519":
+		publicKey, ok := key.readString()
+		if !ok || len(publicKey) != ed25519.PublicKeySize {
+			return false, errors.New("invalid SSH public key")
+		}
+		if string(signatureType) != "ssh-ed25519" {
+			return false, nil
+		}
+		return ed25519.Verify(ed25519.PublicKey(publicKey), signed, blob), nil
+	case "ecdsa-sha2-nistp256", "ecdsa-sha2-nistp384", "ecdsa-sha2-nistp521":
+		curve, hash := sshCurveByName(string(keyType[len("ecdsa-sha2-"):]))
+		_, ok1 := key.readString()
+		point, ok2 := key.readString()
+		if !ok1 || !ok2 {
+			return false, errors.New("invalid SSH public key")
+		}
+		x, y := elliptic.Unmarshal(curve, point)
+		if x == nil {
+			return false, errors.New("invalid SSH public key")
+		}
+		if !bytes.Equal(signatureType, keyType) {
+			return false, nil
+		}
+		values := sshBuffer(blob)
+		r, ok1 := values.readMPInt()
+		s, ok2 := values.readMPInt()
+		if !ok1 || !ok2 {
+			return false, nil
+		}
+		h := hash.New()
+		h.Write(signed)
+		return ecdsa.Verify(&ecdsa.PublicKey{Curve: curve, X: x, Y: y}, h.Sum(nil), r, s), nil
+	case "ssh-rsa":
+		e, ok1 := key.readMPInt()
+		n, ok2 := key.readMPInt()
+		if !ok1 || !ok2 || !e.IsInt64() {
+			return false, errors.New("invalid SSH public key")
+		}
+		var hash crypto.Hash
+		switch string(signatureType) {
+		case "rsa-sha2-256":
+			hash = crypto.SHA256
+		case "rsa-sha2-512":
+			hash = crypto.SHA512
+		default:
+			// SHA-1 is not allowed for SSH signatures
+			return false, nil
+		}
+		h := hash.New()
+		h.Write(signed)
+		publicKey := &rsa.PublicKey{N: n, E: int(e.Int64())}
+		return rsa.VerifyPKCS1v15(publicKey, hash, h.Sum(nil), blob) == nil, nil
+	}
+	return false, fmt.Errorf("unsupported SSH key type: %s", keyType)
+}
+
+// sshSignedData returns the data which is signed by the key. It has the
+// digest of the message instead of the message itself.
+func sshSignedData(namespace, hashAlgorithm string, digest []byte) []byte {
+	var signed bytes.Buffer
+	signed.WriteString("SSHSIG")
+	for _, field := range []string{namespace, "", hashAlgorithm, string(digest)} {
+		writeSSHString(&signed, []byte(field))
+	}
+	return signed.Bytes()
+}
+
+// the curves of ECDSA keys and the hash functions for them
+var sshCurves = []struct {
+	name  string
+	curve elliptic.Curve
+	hash  crypto.Hash
+}{
+	{"nistp256", elliptic.P256(), crypto.SHA256},
+	{"nistp384", elliptic.P384(), crypto.SHA384},
+	{"nistp521", elliptic.P521(), crypto.SHA512},
+}
+
+func sshCurveByName(name string) (elliptic.Curve, crypto.Hash) {
+	for _, c := range sshCurves {
+		if c.name == name {
+			return c.curve, c.hash
+		}
+	}
+	return nil, 0
+}
+
+// sshFingerprint returns the fingerprint like "ssh-keygen -l".
+func sshFingerprint(publicKey []byte) string {
+	sum := sha256.Sum256(publicKey)
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
+// allowedSigner is a line of the allowed signers file. See "ALLOWED
+// SIGNERS" of ssh-keygen(1).
+type allowedSigner struct {
+	principals  []string
+	namespaces  []string
+	validAfter  time.Time
+	validBefore time.Time
+	publicKey   []byte
+}
+
+// readAllowedSignersFile reads the allowed signers. The malformed lines and
+// the certificate authorities, which are not supported, are skipped.
+func readAllowedSignersFile(filePath string) ([]*allowedSigner, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var signers []*allowedSigner
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		if signer := parseAllowedSigner(scanner.Text()); signer != nil {
+			signers = append(signers, signer)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return signers, nil
+}
+
+func parseAllowedSigner(line string) *allowedSigner {
+	line = strings.TrimSpace(line)
+	if line == "" || line[0] == '#' {
+		return nil
+	}
+	fields := splitUnquoted(line, ' ')
+	if len(fields) < 3 {
+		return nil
+	}
+	signer := &allowedSigner{
+		principals: splitUnquoted(unquote(fields[0]), ','),
+	}
+	fields = fields[1:]
+	if !isSSHKeyType(fields[0]) {
+		for _, option := range splitUnquoted(fields[0], ',') {
+			name, value := option, ""
+			if i := strings.IndexByte(option, '='); i != -1 {
+				name, value = option[:i], unquote(option[i+1:])
+			}
+			var err error
+			switch strings.ToLower(name) {
+			case "cert-authority":
+				return nil
+			case "namespaces":
+				signer.namespaces = strings.Split(value, ",")
+			case "valid-after":
+				signer.validAfter, err = parseAllowedSignerTime(value)
+			case "valid-before":
+				signer.validBefore, err = parseAllowedSignerTime(value)
+			default:
+				return nil
+			}
+			if err != nil {
+				return nil
+			}
+		}
+		fields = fields[1:]
+	}
+	if len(fields) < 2 {
+		return nil
+	}
+	publicKey, err := base64.StdEncoding.DecodeString(fields[1])
+	if err != nil {
+		return nil
+	}
+	buffer := sshBuffer(publicKey)
+	if keyType, ok := buffer.readString(); !ok || string(keyType) != fields[0] {
+		return nil
+	}
+	signer.publicKey = publicKey
+	return signer
+}
+
+func isSSHKeyType(field string) bool {
+	return strings.HasPrefix(field, "ssh-") || strings.HasPrefix(field, "ecdsa-") || strings.HasPrefix(field, "sk-")
+}
+
+// parseAllowedSignerTime parses YYYYMMDD[HHMM[SS]][Z]. The time is local
+// unless it ends with "Z".
+func parseAllowedSignerTime(value string) (time.Time, error) {
+	location := time.Local
+	if strings.HasSuffix(value, "Z") || strings.HasSuffix(value, "z") {
+		location = time.UTC
+		value = value[:len(value)-1]
+	}
+	layouts := map[int]string{8: "20060102", 12: "200601021504", 14: "20060102150405"}
+	layout, ok := layouts[len(value)]
+	if !ok {
+		return time.Time{}, fmt.Errorf("invalid time: %s", value)
+	}
+	return time.ParseInLocation(layout, value, location)
+}
+
+func (s *allowedSigner) matches(publicKey []byte, namespace string, when time.Time) (matched, valid bool) {
+	if !bytes.Equal(s.publicKey, publicKey) {
+		return false, false
+	}
+	if s.namespaces != nil {
+		matched := false
+		for _, pattern := range s.namespaces {
+			if ok, _ := path.Match(strings.TrimSpace(pattern), namespace); ok {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false, false
+		}
+	}
+	if !s.validAfter.IsZero() && when.Before(s.validAfter) {
+		return true, false
+	}
+	if !s.validBefore.IsZero() && when.After(s.validBefore) {
+		return true, false
+	}
+	return true, true
+}
+
+// verifySSHSignature verifies the signature like "ssh-keygen -Y verify". The
+// signature made by a key which is not in the allowed signers is good, but
+// the trust is undefined like git.
+func verifySSHSignature(armored string, data []byte, signers []*allowedSigner, when time.Time) (*SignatureVerification, error) {
+	signature, err := parseSSHSignature(armored)
+	if err != nil {
+		return nil, err
+	}
+	result := &SignatureVerification{
+		Format:      SignatureFormatSSH,
+		Status:      SignatureBad,
+		Fingerprint: sshFingerprint(signature.publicKey),
+	}
+	if signature.namespace != sshSignatureNamespace {
+		return result, nil
+	}
+	ok, err := signature.verify(data)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return result, nil
+	}
+	result.Status = SignatureGood
+	for _, signer := range signers {
+		matched, valid := signer.matches(signature.publicKey, signature.namespace, when)
+		if !matched {
+			continue
+		}
+		result.Signer = signer.principals[0]
+		if valid {
+			result.Status = SignatureGood
+			result.Trust = SignatureTrustFully
+			break
+		}
+		result.Status = SignatureExpiredKey
+	}
+	return result, nil
+}
+
+// SSHSigner signs commits and tags with an SSH key like "gpg.format=ssh"
+// of git, but without ssh-keygen.
+type SSHSigner struct {
+	key       crypto.Signer
+	publicKey []byte
+}
+
+// NewSSHSigner returns the signer with the private key. Ed25519, ECDSA (NIST
+// curves) and RSA keys are supported. The key can be a hardware key which
+// implements crypto.Signer.
+func NewSSHSigner(key crypto.Signer) (*SSHSigner, error) {
+	var publicKey bytes.Buffer
+	switch public := key.Public().(type) {
+	case ed25519.PublicKey:
+		writeSSHString(&publicKey, []byte("ssh-ed25519"))
+		writeSSHString(&publicKey, public)
+	case *ecdsa.PublicKey:
+		name := sshCurveName(public.Curve)
+		if name == "" {
+			return nil, errors.New("unsupported curve of ECDSA key")
+		}
+		writeSSHString(&publicKey, []byte("ecdsa-sha2-"+name))
+		writeSSHString(&publicKey, []byte(name))
+		writeSSHString(&publicKey, elliptic.Marshal(public.Curve, public.X, public.Y))
+	case *rsa.PublicKey:
+		writeSSHString(&publicKey, []byte("ssh-rsa"))
+		writeSSHMPInt(&publicKey, big.NewInt(int64(public.E)))
+		writeSSHMPInt(&publicKey, public.N)
+	default:
+		return nil, fmt.Errorf("unsupported key type: %T", public)
+	}
+	return &SSHSigner{
+		key:       key,
+		publicKey: publicKey.Bytes(),
+	}, nil
+}
+
+// PublicKey returns the public key in the format of authorized_keys and the
+// allowed signers file like "ssh-ed25519 AAAA...".
+func (s *SSHSigner) PublicKey() string {
+	buffer := sshBuffer(s.publicKey)
+	keyType, _ := buffer.readString()
+	return string(keyType) + " " + base64.StdEncoding.EncodeToString(s.publicKey)
+}
+
+// Fingerprint returns the fingerprint of the public key like "SHA256:...".
+func (s *SSHSigner) Fingerprint() string {
+	return sshFingerprint(s.publicKey)
+}
+
+// Sign returns the armored signature like "ssh-keygen -Y sign -n git".
+func (s *SSHSigner) Sign(payload []byte) (string, error) {
+	digest := sha512.Sum512(payload)
+	signed := sshSignedData(sshSignatureNamespace, "sha512", digest[:])
+	signature, err := s.sign(signed)
+	if err != nil {
+		return "", err
+	}
+	var blob bytes.Buffer
+	blob.WriteString("SSHSIG")
+	binary.Write(&blob, binary.BigEndian, uint32(1))
+	for _, field := range [][]byte{s.publicKey, []byte(sshSignatureNamespace), nil, []byte("sha512"), signature} {
+		writeSSHString(&blob, field)
+	}
+	encoded := base64.StdEncoding.EncodeToString(blob.Bytes())
+	var armored strings.Builder
+	armored.WriteString(sshSignatureBegin + "\n")
+	for len(encoded) > 70 {
+		armored.WriteString(encoded[:70] + "\n")
+		encoded = encoded[70:]
+	}
+	armored.WriteString(encoded + "\n")
+	armored.WriteString("-----END SSH SIGNATURE-----\n")
+	return armored.String(), nil
+}
+
+// sign returns the signature in the SSH format, which has the signature
+// type and the signature blob.
+func (s *SSHSigner) sign(data []byte) ([]byte, error) {
+	var signatureType string
+	var blob []byte
+	switch public := s.key.Public().(type) {
+	case ed25519.PublicKey:
+		signature, err := s.key.Sign(rand.Reader, data, crypto.Hash(0))
+		if err != nil {
+			return nil, err
+		}
+		signatureType, blob = "ssh-ed25519", signature
+	case *ecdsa.PublicKey:
+		name := sshCurveName(public.Curve)
+		_, hash := sshCurveByName(name)
+		h := hash.New()
+		h.Write(data)
+		signature, err := s.key.Sign(rand.Reader, h.Sum(nil), hash)
+		if err != nil {
+			return nil, err
+		}
+		var values struct {
+			R, S *big.Int
+		}
+		if _, err := asn1.Unmarshal(signature, &values); err != nil {
+			return nil, err
+		}
+		var buffer bytes.Buffer
+		writeSSHMPInt(&buffer, values.R)
+		writeSSHMPInt(&buffer, values.S)
+		signatureType, blob = "ecdsa-sha2-"+name, buffer.Bytes()
+	case *rsa.PublicKey:
+		digest := sha512.Sum512(data)
+		signature, err := s.key.Sign(rand.Reader, digest[:], crypto.SHA512)
+		if err != nil {
+			return nil, err
+		}
+		signatureType, blob = "rsa-sha2-512", signature
+	}
+	var signature bytes.Buffer
+	writeSSHString(&signature, []byte(signatureType))
+	writeSSHString(&signature, blob)
+	return signature.Bytes(), nil
+}
+
+func sshCurveName(curve elliptic.Curve) string {
+	for _, c := range sshCurves {
+		if c.curve == curve {
+			return c.name
+		}
+	}
+	return ""
+}
+
+// ParseSSHPrivateKey parses the private key file like "~/.ssh/id_ed25519".
+// The OpenSSH format and PEM (PKCS #1, PKCS #8 and SEC 1) are supported.
+// The encrypted keys are not supported.
+func ParseSSHPrivateKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no private key is found")
+	}
+	if _, ok := block.Headers["Proc-Type"]; ok {
+		return nil, errors.New("encrypted private key is not supported")
+	}
+	switch block.Type {
+	case "OPENSSH PRIVATE KEY":
+		return parseOpenSSHPrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		if signer, ok := key.(crypto.Signer); ok {
+			return signer, nil
+		}
+	}
+	return nil, fmt.Errorf("unsupported private key: %s", block.Type)
+}
+
+// parseOpenSSHPrivateKey parses the key in PROTOCOL.key of OpenSSH.
+func parseOpenSSHPrivateKey(data []byte) (crypto.Signer, error) {
+	const magic = "openssh-key-v1\x00"
+	if !bytes.HasPrefix(data, []byte(magic)) {
+		return nil, errors.New("invalid OpenSSH private key")
+	}
+	buffer := sshBuffer(data[len(magic):])
+	cipherName, ok1 := buffer.readString()
+	_, ok2 := buffer.readString()
+	_, ok3 := buffer.readString()
+	count, ok4 := buffer.readUint32()
+	_, ok5 := buffer.readString()
+	privateKeys, ok6 := buffer.readString()
+	if !ok1 || !ok2 || !ok3 || !ok4 || !ok5 || !ok6 {
+		return nil, errors.New("invalid OpenSSH private key")
+	}
+	if string(cipherName) != "none" {
+		return nil, errors.New("encrypted private key is not supported")
+	}
+	if count != 1 {
+		return nil, errors.New("multiple keys in a file are not supported")
+	}
+	buffer = sshBuffer(privateKeys)
+	check1, ok1 := buffer.readUint32()
+	check2, ok2 := buffer.readUint32()
+	keyType, ok3 := buffer.readString()
+	if !ok1 || !ok2 || !ok3 || check1 != check2 {
+		return nil, errors.New("invalid OpenSSH private key")
+	}
+	switch string(keyType) {
+	case "ssh-ed25519":
+		_, ok1 := buffer.readString()
+		privateKey, ok2 := buffer.readString()
+		if !ok1 || !ok2 || len(privateKey) != ed25519.PrivateKeySize {
+			return nil, errors.New("invalid OpenSSH private key")
+		}
+		return ed25519.PrivateKey(privateKey), nil
+	case "ecdsa-sha2-nistp256", "ecdsa-sha2-nistp384", "ecdsa-sha2-nistp521":
+		curveName, ok1 := buffer.readString()
+		point, ok2 := buffer.readString()
+		d, ok3 := buffer.readMPInt()
+		curve, _ := sshCurveByName(string(curveName))
+		if !ok1 || !ok2 || !ok3 || curve == nil {
+			return nil, errors.New("invalid OpenSSH private key")
+		}
+		x, y := elliptic.Unmarshal(curve, point)
+		if x == nil {
+			return nil, errors.New("invalid OpenSSH private key")
+		}
+		return &ecdsa.PrivateKey{
+			PublicKey: ecdsa.PublicKey{Curve: curve, X: x, Y: y},
+			D:         d,
+		}, nil
+	case "ssh-rsa":
+		values := make([]*big.Int, 6)
+		for i := range values {
+			var ok bool
+			if values[i], ok = buffer.readMPInt(); !ok {
+				return nil, errors.New("invalid OpenSSH private key")
+			}
+		}
+		// n, e, d, iqmp, p, q
+		if !values[1].IsInt64() {
+			return nil, errors.New("invalid OpenSSH private key")
+		}
+		key := &rsa.PrivateKey{
+			PublicKey: rsa.PublicKey{N: values[0], E: int(values[1].Int64())},
+			D:         values[2],
+			Primes:    []*big.Int{values[4], values[5]},
+		}
+		if err := key.Validate(); err != nil {
+			return nil, err
+		}
+		key.Precompute()
+		return key, nil
+	}
+	return nil, fmt.Errorf("unsupported key type: %s", keyType)
+}
+
+// sshBuffer reads the data types of SSH protocol (RFC 4251).
+type sshBuffer []byte
+
+func (b *sshBuffer) readUint32() (uint32, bool) {
+	if len(*b) < 4 {
+		return 0, false
+	}
+	value := binary.BigEndian.Uint32(*b)
+	*b = (*b)[4:]
+	return value, true
+}
+
+func (b *sshBuffer) readString() ([]byte, bool) {
+	length, ok := b.readUint32()
+	if !ok || uint32(len(*b)) < length {
+		return nil, false
+	}
+	value := (*b)[:length]
+	*b = (*b)[length:]
+	return value, true
+}
+
+func (b *sshBuffer) readMPInt() (*big.Int, bool) {
+	value, ok := b.readString()
+	if !ok || len(value) > 0 && value[0]&0x80 != 0 {
+		return nil, false
+	}
+	return new(big.Int).SetBytes(value), true
+}
+
+func writeSSHString(buffer *bytes.Buffer, value []byte) {
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(value)))
+	buffer.Write(length[:])
+	buffer.Write(value)
+}
+
+func writeSSHMPInt(buffer *bytes.Buffer, value *big.Int) {
+	data := value.Bytes()
+	if len(data) > 0 && data[0]&0x80 != 0 {
+		data = append([]byte{0}, data...)
+	}
+	writeSSHString(buffer, data)
+}
+
+// splitUnquoted splits the text by the separator out of double quotes.
+func splitUnquoted(text string, separator byte) []string {
+	var fields []string
+	start := 0
+	quoted := false
+	for i := 0; i <= len(text); i++ {
+		if i < len(text) && text[i] == '"' {
+			quoted = !quoted
+		}
+		if i == len(text) || !quoted && (text[i] == separator || separator == ' ' && text[i] == '\t') {
+			if i > start {
+				fields = append(fields, text[start:i])
+			}
+			start = i + 1
+		}
+	}
+	return fields
+}
+
+func unquote(text string) string {
+	if len(text) >= 2 && text[0] == '"' && text[len(text)-1] == '"' {
+		return text[1 : len(text)-1]
+	}
+	return text
+}
@@ -0,0 +1,322 @@
+package git4go
+
+import (
+	"./testutil"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// the signed commits and tags are made by "git commit -S" and "git tag -s"
+// with the keys in keyring.asc and allowed_signers
+const signingResources = "test_resources/signing"
+
+func writeSignedObject(repo *Repository, name string, objectType ObjectType, replace ...string) *Oid {
+	data, _ := ioutil.ReadFile(filepath.Join(signingResources, name))
+	for i := 0; i+1 < len(replace); i += 2 {
+		data = bytes.Replace(data, []byte(replace[i]), []byte(replace[i+1]), 1)
+	}
+	odb, _ := repo.Odb()
+	oid, _ := odb.Write(data, objectType)
+	return oid
+}
+
+func Test_Commit_VerifySignature_SSH(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	opts := &SignatureVerifyOptions{
+		AllowedSignersFile: filepath.Join(signingResources, "allowed_signers"),
+	}
+	testcases := []struct {
+		name        string
+		status      SignatureStatus
+		trust       SignatureTrust
+		signer      string
+		fingerprint string
+	}{
+		{"ssh_ed25519.commit", SignatureGood, SignatureTrustFully, "ed25519@example.com", "SHA256:yaq7nuzp9cveIuHE+QpDOdzmDaAzfxWM102+RQEbiR4"},
+		{"ssh_ecdsa.commit", SignatureGood, SignatureTrustFully, "ecdsa@example.com", "SHA256:kUdHuGKEjQrP648g6RaVz81cxmJ73qbRR6egW/29/04"},
+		// the key is valid before 2020
+		{"ssh_rsa.commit", SignatureExpiredKey, SignatureTrustUndefined, "rsa@example.com", "SHA256:ARffspZW12Pf8kKIviKFEKoiCEw3/1GOf4NjU5aRGxI"},
+		// the key is not in allowed_signers
+		{"ssh_unknown.commit", SignatureGood, SignatureTrustUndefined, "", "SHA256:pG79mG1fsV5h+qhf6F0EBbiaxAyjQf7UsE4/ZKMOg4A"},
+	}
+	for _, c := range testcases {
+		commit, _ := repo.LookupCommit(writeSignedObject(repo, c.name, ObjectCommit))
+		result, err := commit.VerifySignature(opts)
+		if err != nil {
+			t.Error("err should be nil:", c.name, err)
+			continue
+		}
+		if result.Format != SignatureFormatSSH || result.Status != c.status || result.Trust != c.trust ||
+			result.Signer != c.signer || result.Fingerprint != c.fingerprint {
+			t.Error("result is wrong:", c.name, result)
+		}
+	}
+
+	// the message is changed after signing
+	commit, _ := repo.LookupCommit(writeSignedObject(repo, "ssh_ed25519.commit", ObjectCommit, "ssh_ed25519", "changed"))
+	if result, err := commit.VerifySignature(opts); err != nil || result.Status != SignatureBad || result.IsTrusted(SignatureTrustUndefined) {
+		t.Error("changed commit should be bad:", result, err)
+	}
+
+	// gpg.ssh.allowedSignersFile is required
+	commit, _ = repo.LookupCommit(writeSignedObject(repo, "ssh_ed25519.commit", ObjectCommit))
+	if _, err := commit.VerifySignature(nil); err == nil {
+		t.Error("allowed signers file should be required")
+	}
+	path, _ := filepath.Abs(opts.AllowedSignersFile)
+	config, _ := ioutil.ReadFile("test_resources/testrepo.git/config")
+	config = append(config, "[gpg \"ssh\"]\n\tallowedSignersFile = "+path+"\n"...)
+	ioutil.WriteFile("test_resources/testrepo.git/config", config, 0644)
+	repo, _ = OpenRepository("test_resources/testrepo.git")
+	commit, _ = repo.LookupCommit(commit.Id())
+	if result, err := commit.VerifySignature(nil); err != nil || !result.IsTrusted(SignatureTrustFully) {
+		t.Error("gpg.ssh.allowedSignersFile should be used:", result, err)
+	}
+
+	oid, _ := NewOid("a65fedf39aefe402d3bb6e24df4d4f5fe4547750")
+	unsigned, _ := repo.LookupCommit(oid)
+	if _, err := unsigned.VerifySignature(opts); !IsErrorCode(err, ErrNotFound) {
+		t.Error("unsigned commit should not be verified:", err)
+	}
+}
+
+func Test_Commit_VerifySignature_OpenPGP(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	data, _ := ioutil.ReadFile(filepath.Join(signingResources, "keyring.asc"))
+	keyring, err := ReadOpenPGPKeyring(data)
+	if err != nil {
+		t.Error("err should be nil:", err)
+		return
+	}
+	opts := &SignatureVerifyOptions{Keyring: keyring}
+	testcases := []struct {
+		name        string
+		status      SignatureStatus
+		signer      string
+		fingerprint string
+		primary     string
+	}{
+		// Ed25519
+		{"pgp_alice.commit", SignatureGood, "Alice <alice@example.com>", "E4C3EC707960C17CFAD2132CEB8AE87885DA3484", "E4C3EC707960C17CFAD2132CEB8AE87885DA3484"},
+		// RSA signing subkey
+		{"pgp_bob.commit", SignatureGood, "Bob <bob@example.com>", "249794C2801E1783ECC493BAA616C1851E540F9A", "74F1FA83FF6896EE3A8DAE64998002139EE9E24D"},
+		// NIST P-256
+		{"pgp_carol.commit", SignatureGood, "Carol <carol@example.com>", "7572587673A717CA9874CCB336EF11D4B868FB09", "7572587673A717CA9874CCB336EF11D4B868FB09"},
+		{"pgp_dave.commit", SignatureExpiredKey, "Dave <dave@example.com>", "4E41B35CDD591DB0EEE92256483A711DC14A8F59", "4E41B35CDD591DB0EEE92256483A711DC14A8F59"},
+		{"pgp_eve.commit", SignatureRevokedKey, "Eve <eve@example.com>", "3A2969191DC912943701DF4CC2F5080474304AA5", "3A2969191DC912943701DF4CC2F5080474304AA5"},
+	}
+	for _, c := range testcases {
+		commit, _ := repo.LookupCommit(writeSignedObject(repo, c.name, ObjectCommit))
+		result, err := commit.VerifySignature(opts)
+		if err != nil {
+			t.Error("err should be nil:", c.name, err)
+			continue
+		}
+		if result.Format != SignatureFormatOpenPGP || result.Status != c.status || result.Signer != c.signer ||
+			result.Fingerprint != c.fingerprint || result.PrimaryKeyFingerprint != c.primary {
+			t.Error("result is wrong:", c.name, result)
+		}
+		if (c.status == SignatureGood) != result.IsTrusted(SignatureTrustFully) {
+			t.Error("trust is wrong:", c.name, result.Trust)
+		}
+	}
+
+	commit, _ := repo.LookupCommit(writeSignedObject(repo, "pgp_alice.commit", ObjectCommit, "pgp_alice", "changed"))
+	if result, err := commit.VerifySignature(opts); err != nil || result.Status != SignatureBad {
+		t.Error("changed commit should be bad:", result, err)
+	}
+	commit, _ = repo.LookupCommit(writeSignedObject(repo, "pgp_alice.commit", ObjectCommit))
+	if result, err := commit.VerifySignature(nil); err != nil || result.Status != SignatureMissingKey ||
+		result.Fingerprint != "E4C3EC707960C17CFAD2132CEB8AE87885DA3484" {
+		t.Error("key should be missing:", result, err)
+	}
+}
+
+func Test_Tag_VerifySignature(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	data, _ := ioutil.ReadFile(filepath.Join(signingResources, "keyring.asc"))
+	keyring, _ := ReadOpenPGPKeyring(data)
+	opts := &SignatureVerifyOptions{
+		AllowedSignersFile: filepath.Join(signingResources, "allowed_signers"),
+		Keyring:            keyring,
+	}
+	testcases := []struct {
+		name   string
+		format SignatureFormat
+		signer string
+	}{
+		{"ssh_tag.tag", SignatureFormatSSH, "ed25519@example.com"},
+		{"pgp_tag.tag", SignatureFormatOpenPGP, "Alice <alice@example.com>"},
+	}
+	for _, c := range testcases {
+		tag, _ := repo.LookupTag(writeSignedObject(repo, c.name, ObjectTag))
+		signature, signedData, err := tag.ExtractSignature()
+		if err != nil || !isSignatureBegin([]byte(signature)) || !bytes.HasSuffix([]byte(signedData), []byte(" tag\n")) {
+			t.Error("signature is not extracted:", c.name, signature, signedData, err)
+		}
+		result, err := tag.VerifySignature(opts)
+		if err != nil || result.Format != c.format || !result.IsTrusted(SignatureTrustFully) || result.Signer != c.signer {
+			t.Error("result is wrong:", c.name, result, err)
+		}
+		tag, _ = repo.LookupTag(writeSignedObject(repo, c.name, ObjectTag, " tag\n", " changed tag\n"))
+		if result, err := tag.VerifySignature(opts); err != nil || result.Status != SignatureBad {
+			t.Error("changed tag should be bad:", c.name, result, err)
+		}
+	}
+
+	oid, _ := NewOid("7b4384978d2493e851f9cca7858815fac9b10980")
+	unsigned, _ := repo.LookupTag(oid)
+	if _, err := unsigned.VerifySignature(opts); !IsErrorCode(err, ErrNotFound) {
+		t.Error("unsigned tag should not be verified:", err)
+	}
+}
+
+func readTestSSHSigner(name string) (*SSHSigner, error) {
+	data, _ := ioutil.ReadFile(filepath.Join(signingResources, name))
+	key, err := ParseSSHPrivateKey(data)
+	if err != nil {
+		return nil, err
+	}
+	return NewSSHSigner(key)
+}
+
+func Test_SSHSigner(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	signer, err := readTestSSHSigner("id_ed25519")
+	if err != nil {
+		t.Error("err should be nil:", err)
+		return
+	}
+	if signer.Fingerprint() != "SHA256:yaq7nuzp9cveIuHE+QpDOdzmDaAzfxWM102+RQEbiR4" {
+		t.Error("fingerprint is wrong:", signer.Fingerprint())
+	}
+	allowedSigners, _ := ioutil.ReadFile(filepath.Join(signingResources, "allowed_signers"))
+	if !bytes.Contains(allowedSigners, []byte(signer.PublicKey())) {
+		t.Error("public key is wrong:", signer.PublicKey())
+	}
+	// Ed25519 signatures are same as ssh-keygen's
+	commit, _ := repo.LookupCommit(writeSignedObject(repo, "ssh_ed25519.commit", ObjectCommit))
+	expected, payload, _ := commit.ExtractSignature()
+	if signature, err := signer.Sign([]byte(payload)); err != nil || signature != expected+"\n" {
+		t.Error("signature is wrong:", signature, err)
+	}
+
+	// ECDSA and RSA signatures are random
+	for _, name := range []string{"id_ecdsa", "id_rsa"} {
+		signer, err := readTestSSHSigner(name)
+		if err != nil {
+			t.Error("err should be nil:", name, err)
+			continue
+		}
+		signature, err := signer.Sign([]byte(payload))
+		if err != nil {
+			t.Error("err should be nil:", name, err)
+			continue
+		}
+		result, err := verifySSHSignature(signature, []byte(payload), nil, time.Now())
+		if err != nil || result.Status != SignatureGood || result.Fingerprint != signer.Fingerprint() {
+			t.Error("signature is wrong:", name, result, err)
+		}
+	}
+
+	// PKCS #8
+	key, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	der, _ := x509.MarshalPKCS8PrivateKey(key)
+	parsed, err := ParseSSHPrivateKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	if err != nil {
+		t.Error("err should be nil:", err)
+	} else if signer, err := NewSSHSigner(parsed); err != nil || !strings.HasPrefix(signer.PublicKey(), "ecdsa-sha2-nistp384 ") {
+		t.Error("signer is wrong:", err)
+	}
+}
+
+func Test_CreateSignedCommit(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	signer, _ := readTestSSHSigner("id_ecdsa")
+	oid, _ := NewOid("a65fedf39aefe402d3bb6e24df4d4f5fe4547750")
+	parent, _ := repo.LookupCommit(oid)
+	tree, _ := parent.Tree()
+	sig := &Signature{Name: "a", Email: "a@b", When: time.Now()}
+	oid, err := repo.CreateSignedCommit("refs/heads/not-good", sig, sig, "", "signed\n", tree, signer, parent)
+	if err != nil {
+		t.Error("err should be nil:", err)
+		return
+	}
+	commit, _ := repo.LookupCommit(oid)
+	if commit.Message() != "signed\n" || commit.ParentCount() != 1 {
+		t.Error("commit is wrong:", commit.RawHeader())
+	}
+	if ref, _ := repo.LookupReference("refs/heads/not-good"); ref == nil || !ref.Target().Equal(oid) {
+		t.Error("reference should be created:", ref)
+	}
+	opts := &SignatureVerifyOptions{
+		AllowedSignersFile: filepath.Join(signingResources, "allowed_signers"),
+	}
+	if result, err := commit.VerifySignature(opts); err != nil || !result.IsTrusted(SignatureTrustFully) || result.Signer != "ecdsa@example.com" {
+		t.Error("signature is wrong:", result, err)
+	}
+	if _, err := repo.CreateSignedCommit("", sig, sig, "", "signed\n", tree, nil, parent); err == nil {
+		t.Error("signer should be required")
+	}
+}
+
+func Test_CreateSignedTag(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	signer, _ := readTestSSHSigner("id_ed25519")
+	oid, _ := NewOid("a65fedf39aefe402d3bb6e24df4d4f5fe4547750")
+	target, _ := repo.LookupCommit(oid)
+	sig := &Signature{Name: "a", Email: "a@b", When: time.Now()}
+	oid, err := repo.CreateSignedTag("v1.0.0", target, sig, "release", signer, false)
+	if err != nil {
+		t.Error("err should be nil:", err)
+		return
+	}
+	tag, _ := repo.LookupTag(oid)
+	if tag.Name() != "v1.0.0" || !tag.TargetId().Equal(target.Id()) || !strings.HasPrefix(tag.Message(), "release\n"+sshSignatureBegin) {
+		t.Error("tag is wrong:", tag.Name(), tag.Message())
+	}
+	opts := &SignatureVerifyOptions{
+		AllowedSignersFile: filepath.Join(signingResources, "allowed_signers"),
+	}
+	if result, err := tag.VerifySignature(opts); err != nil || !result.IsTrusted(SignatureTrustFully) {
+		t.Error("signature is wrong:", result, err)
+	}
+	if ref, _ := repo.LookupReference("refs/tags/v1.0.0"); ref == nil || !ref.Target().Equal(oid) {
+		t.Error("reference should be created:", ref)
+	}
+
+	if _, err := repo.CreateSignedTag("v1.0.0", target, sig, "again\n", signer, false); !IsErrorCode(err, ErrExists) {
+		t.Error("existing tag should not be overwritten:", err)
+	}
+	oid, err = repo.CreateSignedTag("v1.0.0", target, sig, "again\n", signer, true)
+	if ref, _ := repo.LookupReference("refs/tags/v1.0.0"); err != nil || ref == nil || !ref.Target().Equal(oid) {
+		t.Error("tag should be overwritten:", err)
+	}
+}
@@ -0,0 +1,304 @@
+package git4go
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const (
+	GitSharedIndexPrefix = "sharedindex."
+
+	// default value of splitIndex.maxPercentChange
+	SplitIndexMaxPercentChange = 20
+)
+
+// splitIndex keeps the shared index of "core.splitIndex". The index file
+// has only the differences from the shared index file
+// ("sharedindex.<sha1>") in the "link" extension:
+//
+//   - the entries of the shared index at the set bits of the replace
+//     bitmap are replaced by the entries of the index file in order.
+//     They are written without paths.
+//   - the entries of the shared index at the set bits of the delete
+//     bitmap are removed.
+//   - the rest entries of the index file are added.
+type splitIndex struct {
+	// baseId is the checksum of the shared index. It is nil if the
+	// shared index is not written yet.
+	baseId *Oid
+	// base is the entries of the shared index in the order on disk. They
+	// are not modified to find the differences when the index is written.
+	base []*IndexEntry
+
+	deleteBitmap  *ewahBitmap
+	replaceBitmap *ewahBitmap
+}
+
+// SetSplitIndex enables or disables the split index. The shared index is
+// created by the next Write() when the split index is enabled.
+func (v *Index) SetSplitIndex(enable bool) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if !enable {
+		v.split = nil
+	} else if v.split == nil {
+		v.split = &splitIndex{}
+	}
+}
+
+// IsSplitIndex returns true if the index is written as a split index.
+func (v *Index) IsSplitIndex() bool {
+	return v.split != nil
+}
+
+func readLink(buffer []byte) (*splitIndex, error) {
+	if len(buffer) < GitOidRawSize {
+		return nil, errors.New("corrupt link extension (too short)")
+	}
+	split := &splitIndex{
+		baseId: NewOidFromBytes(buffer[:GitOidRawSize]),
+	}
+	if len(buffer) == GitOidRawSize {
+		return split, nil
+	}
+	var offset int
+	var err error
+	split.deleteBitmap, offset, err = readEwahBitmap(buffer, GitOidRawSize)
+	if err != nil {
+		return nil, err
+	}
+	split.replaceBitmap, offset, err = readEwahBitmap(buffer, offset)
+	if err != nil {
+		return nil, err
+	}
+	if offset != len(buffer) {
+		return nil, errors.New("garbage at the end of link extension")
+	}
+	return split, nil
+}
+
+// mergeSharedIndex reads the shared index in the directory and applies
+// the entries of the index file to it.
+func (v *Index) mergeSharedIndex(dir string) error {
+	split := v.split
+	if split.baseId.IsZero() {
+		split.baseId = nil
+		return nil
+	}
+	sharedPath := filepath.Join(dir, GitSharedIndexPrefix+split.baseId.String())
+	buffer, err := ioutil.ReadFile(sharedPath)
+	if err != nil {
+		return err
+	}
+	if len(buffer) < IndexFooterSize || !split.baseId.Equal(NewOidFromBytes(buffer[len(buffer)-IndexFooterSize:])) {
+		return fmt.Errorf("broken index, expect %s in %s", split.baseId, sharedPath)
+	}
+	shared := &Index{}
+	shared.Clear()
+	if err := shared.parse(buffer); err != nil {
+		return err
+	}
+	if shared.split != nil {
+		return fmt.Errorf("shared index %s should not have link extension", sharedPath)
+	}
+	split.base = shared.Entries
+
+	entries := make([]*IndexEntry, len(split.base))
+	for i, entry := range split.base {
+		copied := *entry
+		entries[i] = &copied
+	}
+	delta := v.Entries
+	replaced := 0
+	if split.replaceBitmap != nil {
+		err := split.replaceBitmap.eachBit(func(pos int) error {
+			if pos >= len(entries) || replaced >= len(delta) {
+				return errors.New("corrupt link extension (replace bitmap is out of range)")
+			}
+			entry := delta[replaced]
+			if entry.Path != "" {
+				return fmt.Errorf("corrupt link extension, entry %d should have zero length name", replaced)
+			}
+			entry.Path = entries[pos].Path
+			entries[pos] = entry
+			replaced++
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if split.deleteBitmap != nil {
+		err := split.deleteBitmap.eachBit(func(pos int) error {
+			if pos >= len(entries) {
+				return errors.New("corrupt link extension (delete bitmap is out of range)")
+			}
+			entries[pos] = nil
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	var result indexEntriesCaseSensitive = make([]*IndexEntry, 0, len(entries)+len(delta)-replaced)
+	positions := make(map[indexEntryKey]int)
+	for _, entry := range entries {
+		if entry != nil {
+			positions[keyOfIndexEntry(entry)] = len(result)
+			result = append(result, entry)
+		}
+	}
+	for i, entry := range delta[replaced:] {
+		if entry.Path == "" {
+			return fmt.Errorf("corrupt link extension, entry %d should have a name", replaced+i)
+		}
+		if pos, ok := positions[keyOfIndexEntry(entry)]; ok {
+			result[pos] = entry
+		} else {
+			result = append(result, entry)
+		}
+	}
+	sort.Sort(result)
+	v.Entries = result
+	split.deleteBitmap = nil
+	split.replaceBitmap = nil
+	return nil
+}
+
+type indexEntryKey struct {
+	path  string
+	stage IndexStage
+}
+
+func keyOfIndexEntry(entry *IndexEntry) indexEntryKey {
+	return indexEntryKey{entry.Path, entry.Stage()}
+}
+
+// sameIndexEntry compares all fields stored in the index file except
+// the path.
+func sameIndexEntry(a, b *IndexEntry) bool {
+	return a.Ctime.Equal(b.Ctime) && a.Mtime.Equal(b.Mtime) &&
+		a.Dev == b.Dev && a.Ino == b.Ino && a.Mode == b.Mode &&
+		a.Uid == b.Uid && a.Gid == b.Gid && a.Size == b.Size &&
+		a.Id.Equal(b.Id) &&
+		a.flags&^uint16(IndexEntryNameMask) == b.flags&^uint16(IndexEntryNameMask) &&
+		a.flagsExtended == b.flagsExtended
+}
+
+// delta returns the entries written in the index file and the content of
+// the link extension. The replacing entries come first in the order of
+// the shared index and the added entries follow them.
+func (s *splitIndex) delta(entries []*IndexEntry) ([]*IndexEntry, []byte) {
+	positions := make(map[indexEntryKey]int, len(s.base))
+	for i, entry := range s.base {
+		positions[keyOfIndexEntry(entry)] = i
+	}
+	matched := make([]*IndexEntry, len(s.base))
+	var added []*IndexEntry
+	for _, entry := range entries {
+		if pos, ok := positions[keyOfIndexEntry(entry)]; ok {
+			matched[pos] = entry
+		} else {
+			added = append(added, entry)
+		}
+	}
+	deleteBitmap := newEwahBitmap()
+	replaceBitmap := newEwahBitmap()
+	var result []*IndexEntry
+	for i, base := range s.base {
+		entry := matched[i]
+		if entry == nil {
+			deleteBitmap.set(i)
+		} else if !sameIndexEntry(base, entry) {
+			replaceBitmap.set(i)
+			stripped := *entry
+			stripped.Path = ""
+			result = append(result, &stripped)
+		}
+	}
+	result = append(result, added...)
+
+	var link bytes.Buffer
+	link.Write(s.baseId[:])
+	deleteBitmap.write(&link)
+	replaceBitmap.write(&link)
+	return result, link.Bytes()
+}
+
+// tooManyChanges decides to create a new shared index like git. The
+// percentage of the entries which are not in the shared index is compared
+// with splitIndex.maxPercentChange.
+func (s *splitIndex) tooManyChanges(entries []*IndexEntry, maxPercent int) bool {
+	if maxPercent == 0 {
+		return true
+	}
+	positions := make(map[indexEntryKey]bool, len(s.base))
+	for _, entry := range s.base {
+		positions[keyOfIndexEntry(entry)] = true
+	}
+	notShared := 0
+	for _, entry := range entries {
+		if !positions[keyOfIndexEntry(entry)] {
+			notShared++
+		}
+	}
+	return len(entries)*maxPercent < notShared*100
+}
+
+// updateSharedIndex writes a new shared index if it doesn't exist yet or
+// the index file would have too many entries. It returns the path of the
+// shared index if it is created by this call.
+func (v *Index) updateSharedIndex() (string, error) {
+	entries, err := v.sortedEntries()
+	if err != nil {
+		return "", err
+	}
+	if v.split.baseId != nil && !v.split.tooManyChanges(entries, v.maxPercentSplitChange()) {
+		return "", nil
+	}
+	buffer, _ := serializeEntries(entries, v.versionFor(entries), 0)
+	checksum := calcHash(buffer.Bytes())
+	buffer.Write(checksum[:])
+
+	createdPath := ""
+	sharedPath := filepath.Join(filepath.Dir(v.filePath), GitSharedIndexPrefix+checksum.String())
+	if _, err := os.Stat(sharedPath); os.IsNotExist(err) {
+		lock, err := newLockFile(sharedPath, GitIndexFileMode)
+		if err != nil {
+			return "", err
+		}
+		if _, err := lock.Write(buffer.Bytes()); err != nil {
+			lock.Rollback()
+			return "", err
+		}
+		if err := lock.Commit(); err != nil {
+			return "", err
+		}
+		createdPath = sharedPath
+	}
+	base := make([]*IndexEntry, len(entries))
+	for i, entry := range entries {
+		copied := *entry
+		base[i] = &copied
+	}
+	v.split.baseId = checksum
+	v.split.base = base
+	return createdPath, nil
+}
+
+func (v *Index) maxPercentSplitChange() int {
+	if v.repo != nil {
+		for _, name := range []string{"splitIndex.maxPercentChange", "splitindex.maxpercentchange"} {
+			if value, err := v.repo.Config().LookupInt32(name); err == nil && value >= 0 && value <= 100 {
+				return int(value)
+			}
+		}
+	}
+	return SplitIndexMaxPercentChange
+}
@@ -0,0 +1,152 @@
+package git4go
+
+import (
+	"./testutil"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// test_resources/split_index was made by "git update-index --split-index"
+// with 8 files. After that, file2.txt was changed, file5.txt was removed
+// and new.txt was added.
+func Test_IndexSplit_Read(t *testing.T) {
+	index, err := OpenIndex("test_resources/split_index/index")
+	if err != nil {
+		t.Error("err should be nil:", err)
+		return
+	}
+	if !index.IsSplitIndex() {
+		t.Error("index should be split")
+	}
+	expected := "file1.txt file2.txt file3.txt file4.txt file6.txt file7.txt file8.txt new.txt"
+	if paths := indexEntryPaths(index); paths != expected {
+		t.Error("entries are wrong:", paths)
+	}
+	entry, _ := index.EntryByPath("file2.txt", 0)
+	if entry == nil || entry.Id.String() != "5ea2ed416fbd4a4cbe227b75fe255dd7fa6bd4d6" {
+		t.Error("changed entry should be read from the split index:", entry)
+	}
+	entry, _ = index.EntryByPath("file3.txt", 0)
+	if entry == nil || entry.Id.String() != "7c8ac2f8d82a1eb5f6aaece6629ff11015f91eb4" {
+		t.Error("entry should be read from the shared index:", entry)
+	}
+}
+
+func Test_IndexSplit_WriteWithSharedIndex(t *testing.T) {
+	testutil.PrepareEmptyWorkDir("test-index")
+	defer testutil.CleanupEmptyWorkDir()
+
+	files, _ := filepath.Glob("test_resources/split_index/*")
+	for _, file := range files {
+		content, _ := ioutil.ReadFile(file)
+		ioutil.WriteFile(filepath.Join("test-index", filepath.Base(file)), content, 0644)
+	}
+	index, _ := OpenIndex("test-index/index")
+	if err := index.Write(); err != nil {
+		t.Error("err should be nil:", err)
+	}
+	if shared, _ := filepath.Glob("test-index/sharedindex.*"); len(shared) != 1 {
+		t.Error("shared index should be reused:", shared)
+	}
+	reread, err := OpenIndex("test-index/index")
+	if err != nil {
+		t.Error("err should be nil:", err)
+		return
+	}
+	if paths := indexEntryPaths(reread); paths != indexEntryPaths(index) {
+		t.Error("entries should be same:", paths)
+	}
+	for i, entry := range reread.Entries {
+		if !sameIndexEntry(entry, index.Entries[i]) {
+			t.Error("entry should be same:", entry.Path)
+		}
+	}
+}
+
+func Test_IndexSplit_WriteFailed(t *testing.T) {
+	testutil.PrepareEmptyWorkDir("test-index")
+	defer testutil.CleanupEmptyWorkDir()
+
+	original, _ := ioutil.ReadFile("test_resources/gitgit.index")
+	ioutil.WriteFile("test-index/index", original, 0644)
+	index, _ := OpenIndex("test-index/index")
+	index.SetSplitIndex(true)
+
+	ioutil.WriteFile("test-index/index.lock", []byte{}, 0644)
+	if err := index.Write(); !IsErrorCode(err, ErrLocked) {
+		t.Error("locked index should not be written:", err)
+	}
+	if shared, _ := filepath.Glob("test-index/sharedindex.*"); len(shared) != 0 {
+		t.Error("shared index should not be written without the lock:", shared)
+	}
+	os.Remove("test-index/index.lock")
+
+	// the index can't be replaced with the lock file
+	os.Remove("test-index/index")
+	os.MkdirAll("test-index/index/dir", 0755)
+	if err := index.Write(); err == nil {
+		t.Error("err should not be nil")
+	}
+	if shared, _ := filepath.Glob("test-index/sharedindex.*"); len(shared) != 0 {
+		t.Error("new shared index should be removed:", shared)
+	}
+}
+
+func Test_IndexSplit_Write(t *testing.T) {
+	testutil.PrepareEmptyWorkDir("test-index")
+	defer testutil.CleanupEmptyWorkDir()
+
+	original, _ := ioutil.ReadFile("test_resources/gitgit.index")
+	ioutil.WriteFile("test-index/index", original, 0644)
+	index, _ := OpenIndex("test-index/index")
+	index.SetSplitIndex(true)
+	if err := index.Write(); err != nil {
+		t.Error("err should be nil:", err)
+		return
+	}
+	shared, _ := filepath.Glob("test-index/sharedindex.*")
+	if len(shared) != 1 {
+		t.Error("shared index should be written:", shared)
+		return
+	}
+
+	changed := *index.Entries[10]
+	changed.Size++
+	index.Add(&changed)
+	index.Remove(index.Entries[20].Path, 0)
+	added := *index.Entries[30]
+	added.Path = "added.txt"
+	index.Add(&added)
+	if err := index.Write(); err != nil {
+		t.Error("err should be nil:", err)
+		return
+	}
+	if shared2, _ := filepath.Glob("test-index/sharedindex.*"); len(shared2) != 1 {
+		t.Error("shared index should be reused:", shared2)
+	}
+	// the tree cache is still in the index file
+	written, _ := ioutil.ReadFile("test-index/index")
+	if len(written) > len(original)/10 {
+		t.Error("index file should have only changed entries:", len(written))
+	}
+
+	reread, err := OpenIndex("test-index/index")
+	if err != nil {
+		t.Error("err should be nil:", err)
+		return
+	}
+	if len(reread.Entries) != len(index.Entries) {
+		t.Error("entry count should be same:", len(reread.Entries), len(index.Entries))
+	}
+	for _, entry := range reread.Entries {
+		expected, _ := index.EntryByPath(entry.Path, entry.Stage())
+		if expected == nil || !sameIndexEntry(entry, expected) {
+			t.Error("entry should be same:", entry.Path)
+		}
+	}
+	if _, err := reread.EntryByPath("added.txt", 0); err != nil {
+		t.Error("added entry should be read:", err)
+	}
+}
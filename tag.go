@@ -3,6 +3,7 @@ package git4go
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"sort"
 	"strings"
 )
@@ -24,10 +25,19 @@ func (r *Repository) LookupPrefixTag(oid *Oid, length int) (*Tag, error) {
 }
 
 func (r *Repository) ListTag() ([]string, error) {
+	return r.ListTagMatch("")
+}
+
+// ListTagMatch returns the names of the tags which match the pattern like
+// "v1.*". An empty pattern matches all tags.
+func (r *Repository) ListTagMatch(pattern string) ([]string, error) {
 	var tags []string
 	err := r.ForEachReferenceName(func(path string) error {
-		if strings.HasPrefix(path, GitRefsTagsDir) {
-			tags = append(tags, path[len(GitRefsTagsDir)+1:])
+		if strings.HasPrefix(path, GitRefsTagsDir+"/") {
+			name := path[len(GitRefsTagsDir)+1:]
+			if pattern == "" || fnMatch(pattern, name, 0) {
+				tags = append(tags, name)
+			}
 		}
 		return nil
 	})
@@ -38,6 +48,143 @@ func (r *Repository) ListTag() ([]string, error) {
 	return tags, nil
 }
 
+// CreateTag creates an annotated tag of the target object and returns the
+// id of the tag object. An existing tag is replaced only if force is true,
+// otherwise it fails with ErrExists.
+func (r *Repository) CreateTag(name string, target Object, tagger *Signature, message string, force bool) (*Oid, error) {
+	return r.createTag(name, target, tagger, message, nil, force)
+}
+
+// CreateLightweightTag creates a tag which points to the target object
+// directly and returns the id of the target.
+func (r *Repository) CreateLightweightTag(name string, target Object, force bool) (*Oid, error) {
+	if target == nil {
+		return nil, errors.New("Repository.CreateLightweightTag(): target is required")
+	}
+	refname, err := r.tagReferenceName(name, force)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.CreateReference(refname, target.Id(), force, nil, ""); err != nil {
+		return nil, err
+	}
+	return target.Id(), nil
+}
+
+// CreateTagFromBuffer writes the raw tag object and creates the tag of its
+// name. The target should exist and have the type written in the tag.
+func (r *Repository) CreateTagFromBuffer(buffer []byte, force bool) (*Oid, error) {
+	tag, err := newTag(r, nil, buffer)
+	if err != nil {
+		return nil, err
+	}
+	odb, err := r.Odb()
+	if err != nil {
+		return nil, err
+	}
+	target, err := odb.Read(tag.targetId)
+	if err != nil {
+		return nil, err
+	}
+	if target.Type != tag.targetType {
+		return nil, errors.New("The type for the given target is invalid")
+	}
+	refname, err := r.tagReferenceName(tag.name, force)
+	if err != nil {
+		return nil, err
+	}
+	if refname[len(GitRefsTagsDir)+1:] != tag.name {
+		return nil, MakeGitError(fmt.Sprintf("The tag name '%s' is not normalized", tag.name), ErrInvalidSpec)
+	}
+	oid, err := odb.Write(buffer, ObjectTag)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.CreateReference(refname, oid, force, tag.tagger, ""); err != nil {
+		return nil, err
+	}
+	return oid, nil
+}
+
+// DeleteTag removes the tag. It fails with ErrNotFound if the tag doesn't
+// exist.
+func (r *Repository) DeleteTag(name string) error {
+	refname, err := referenceNormalizeForWrite(r, GitRefsTagsDir+"/"+name)
+	if err != nil {
+		return err
+	}
+	ref, err := r.NewRefDb().Lookup(refname)
+	if err != nil {
+		return err
+	}
+	return ref.Delete()
+}
+
+// CreateSignedTag creates an annotated tag signed by the signer like "git
+// tag -s". The signature is appended to the message. An existing tag is
+// replaced only if force is true, otherwise it fails with ErrExists.
+func (r *Repository) CreateSignedTag(name string, target Object, tagger *Signature, message string, signer Signer, force bool) (*Oid, error) {
+	if signer == nil {
+		return nil, errors.New("Repository.CreateSignedTag(): signer is required")
+	}
+	return r.createTag(name, target, tagger, message, signer, force)
+}
+
+func (r *Repository) createTag(name string, target Object, tagger *Signature, message string, signer Signer, force bool) (*Oid, error) {
+	if target == nil || tagger == nil {
+		return nil, errors.New("Repository.CreateTag(): target and tagger are required")
+	}
+	odb, err := r.Odb()
+	if err != nil {
+		return nil, err
+	}
+	if !odb.Exists(target.Id()) {
+		return nil, MakeGitError(fmt.Sprintf("Target object %s doesn't exist on the repository", target.Id()), ErrNotFound)
+	}
+	refname, err := r.tagReferenceName(name, force)
+	if err != nil {
+		return nil, err
+	}
+	var buffer bytes.Buffer
+	// the object has the normalized name like the reference
+	name = refname[len(GitRefsTagsDir)+1:]
+	fmt.Fprintf(&buffer, "object %s\ntype %s\ntag %s\ntagger %s\n\n%s", target.Id(), target.Type(), name, formatSignature(tagger), message)
+	if signer != nil {
+		// the signature should start at the beginning of a line
+		if message != "" && !strings.HasSuffix(message, "\n") {
+			buffer.WriteByte('\n')
+		}
+		signature, err := signer.Sign(buffer.Bytes())
+		if err != nil {
+			return nil, err
+		}
+		buffer.WriteString(signature)
+	}
+	oid, err := odb.Write(buffer.Bytes(), ObjectTag)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.CreateReference(refname, oid, force, tagger, ""); err != nil {
+		return nil, err
+	}
+	return oid, nil
+}
+
+// tagReferenceName returns the reference name of the tag. It fails with
+// ErrExists if the tag exists and force is false.
+func (r *Repository) tagReferenceName(name string, force bool) (string, error) {
+	refname, err := referenceNormalizeForWrite(r, GitRefsTagsDir+"/"+name)
+	if err != nil {
+		return "", err
+	}
+	if !force {
+		if _, err := r.NewRefDb().Lookup(refname); err == nil {
+			return "", MakeGitError(fmt.Sprintf("Tag '%s' already exists", name), ErrExists)
+		}
+	}
+	return refname, nil
+}
+
 type Tag struct {
 	gitObject
 	targetType ObjectType
@@ -4,6 +4,7 @@ import (
 	"./testutil"
 	"strings"
 	"testing"
+	"time"
 )
 
 func Test_LookupTag(t *testing.T) {
@@ -90,3 +91,141 @@ func Test_ReadTagTaggerLess(t *testing.T) {
 	}
 
 }
+
+func Test_CreateTag(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	oid, _ := NewOid("e90810b8df3e80c413d903f631643c716887138d")
+	target, _ := repo.LookupCommit(oid)
+	tagger := &Signature{Name: "Vicent Marti", Email: "tanoku@gmail.com", When: time.Unix(1288280880, 0).In(time.FixedZone("", 2*60*60))}
+	oid, err := repo.CreateTag("the-tag", target, tagger, "My message.\n\nIt is a tag.\n", false)
+	if err != nil {
+		t.Error("err should be nil:", err)
+		return
+	}
+	tag, _ := repo.LookupTag(oid)
+	if tag.Name() != "the-tag" || !tag.TargetId().Equal(target.Id()) || tag.TargetType() != ObjectCommit ||
+		tag.Message() != "My message.\n\nIt is a tag.\n" || tag.Tagger().Email != "tanoku@gmail.com" {
+		t.Error("tag is wrong:", tag.Name(), tag.Message())
+	}
+	if ref, _ := repo.LookupReference("refs/tags/the-tag"); ref == nil || !ref.Target().Equal(oid) {
+		t.Error("reference should be created:", ref)
+	}
+
+	// the existing tag
+	if _, err := repo.CreateTag("e90810b", target, tagger, "message\n", false); !IsErrorCode(err, ErrExists) {
+		t.Error("existing tag should not be overwritten:", err)
+	}
+	oid, err = repo.CreateTag("e90810b", target, tagger, "message\n", true)
+	if ref, _ := repo.LookupReference("refs/tags/e90810b"); err != nil || ref == nil || !ref.Target().Equal(oid) {
+		t.Error("tag should be overwritten:", err)
+	}
+	if _, err := repo.CreateTag("bad:name", target, tagger, "message\n", false); !IsErrorCode(err, ErrInvalidSpec) {
+		t.Error("invalid name should be refused:", err)
+	}
+	// the object has the same name as the reference
+	oid, err = repo.CreateTag("v1//x", target, tagger, "message\n", false)
+	if tag, _ := repo.LookupTag(oid); err != nil || tag == nil || tag.Name() != "v1/x" {
+		t.Error("tag name should be normalized:", err)
+	}
+	if ref, _ := repo.LookupReference("refs/tags/v1/x"); ref == nil || !ref.Target().Equal(oid) {
+		t.Error("reference should be created:", ref)
+	}
+}
+
+func Test_CreateLightweightTag(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	oid, _ := NewOid("e90810b8df3e80c413d903f631643c716887138d")
+	target, _ := repo.LookupCommit(oid)
+	oid, err := repo.CreateLightweightTag("light", target, false)
+	if err != nil || !oid.Equal(target.Id()) {
+		t.Error("err should be nil:", oid, err)
+	}
+	if ref, _ := repo.LookupReference("refs/tags/light"); ref == nil || !ref.Target().Equal(target.Id()) {
+		t.Error("reference should point to the target:", ref)
+	}
+	other, _ := NewOid("a65fedf39aefe402d3bb6e24df4d4f5fe4547750")
+	otherCommit, _ := repo.LookupCommit(other)
+	if _, err := repo.CreateLightweightTag("light", otherCommit, false); !IsErrorCode(err, ErrExists) {
+		t.Error("existing tag should not be overwritten:", err)
+	}
+	if _, err := repo.CreateLightweightTag("light", otherCommit, true); err != nil {
+		t.Error("err should be nil:", err)
+	}
+	if ref, _ := repo.LookupReference("refs/tags/light"); ref == nil || !ref.Target().Equal(other) {
+		t.Error("tag should be overwritten:", ref)
+	}
+}
+
+func Test_CreateTagFromBuffer(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	buffer := "object e90810b8df3e80c413d903f631643c716887138d\n" +
+		"type commit\n" +
+		"tag from-buffer\n" +
+		"tagger a <a@b> 1700000000 +0900\n" +
+		"\n" +
+		"message\n"
+	oid, err := repo.CreateTagFromBuffer([]byte(buffer), false)
+	if err != nil {
+		t.Error("err should be nil:", err)
+		return
+	}
+	if tag, _ := repo.LookupTag(oid); tag == nil || tag.Name() != "from-buffer" || tag.Message() != "message\n" {
+		t.Error("tag is wrong:", tag)
+	}
+	if ref, _ := repo.LookupReference("refs/tags/from-buffer"); ref == nil || !ref.Target().Equal(oid) {
+		t.Error("reference should be created:", ref)
+	}
+	if _, err := repo.CreateTagFromBuffer([]byte(buffer), false); !IsErrorCode(err, ErrExists) {
+		t.Error("existing tag should not be overwritten:", err)
+	}
+	if _, err := repo.CreateTagFromBuffer([]byte(strings.Replace(buffer, "type commit", "type tree", 1)), true); err == nil {
+		t.Error("wrong type should be refused")
+	}
+	if _, err := repo.CreateTagFromBuffer([]byte(strings.Replace(buffer, "tag from-buffer", "tag v1//x", 1)), false); !IsErrorCode(err, ErrInvalidSpec) {
+		t.Error("name which is not normalized should be refused:", err)
+	}
+}
+
+func Test_DeleteTag(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	if err := repo.DeleteTag("e90810b"); err != nil {
+		t.Error("err should be nil:", err)
+	}
+	if _, err := repo.LookupReference("refs/tags/e90810b"); !IsErrorCode(err, ErrNotFound) {
+		t.Error("tag should be deleted:", err)
+	}
+	if err := repo.DeleteTag("e90810b"); !IsErrorCode(err, ErrNotFound) {
+		t.Error("missing tag should not be deleted:", err)
+	}
+}
+
+func Test_ListTagMatch(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	tags, err := repo.ListTagMatch("t*")
+	if err != nil || strings.Join(tags, " ") != "taggerless test" {
+		t.Error("tags are wrong:", tags, err)
+	}
+	tags, _ = repo.ListTagMatch("*_tag*")
+	if strings.Join(tags, " ") != "annotated_tag_to_blob hard_tag wrapped_tag" {
+		t.Error("tags are wrong:", tags)
+	}
+	all, _ := repo.ListTag()
+	if tags, _ := repo.ListTagMatch(""); len(tags) != len(all) {
+		t.Error("empty pattern should match all tags:", tags)
+	}
+}
@@ -0,0 +1,182 @@
+package git4go
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// RefTransaction updates several references atomically. Each queued
+// operation locks its reference immediately and checks the expected old
+// value. Nothing is changed until Commit() is called, and Rollback()
+// releases all locks without any change. How references are locked
+// depends on the backend: on reftable, the whole stack is locked and all
+// operations are written into one table.
+type RefTransaction struct {
+	repo    *Repository
+	refDb   *RefDb
+	backend RefDbTransaction
+	updates []*refUpdate
+	done    bool
+}
+
+type refUpdate struct {
+	name    string
+	ref     *Reference // nil means deletion
+	current *Reference
+	sig     *Signature
+	msg     string
+}
+
+func (r *Repository) NewRefTransaction() *RefTransaction {
+	refDb := r.NewRefDb()
+	return &RefTransaction{
+		repo:    r,
+		refDb:   refDb,
+		backend: refDb.backend.NewTransaction(),
+	}
+}
+
+// Create queues creation of the direct reference. It fails if the
+// reference already exists.
+func (t *RefTransaction) Create(name string, id *Oid, sig *Signature, msg string) error {
+	return t.Update(name, id, new(Oid), sig, msg)
+}
+
+// Update queues update of the direct reference. If oldId is not nil, the
+// current value should match it (a zero oldId means the reference should
+// not exist yet).
+func (t *RefTransaction) Update(name string, id, oldId *Oid, sig *Signature, msg string) error {
+	odb, err := t.repo.Odb()
+	if err != nil {
+		return err
+	}
+	if !odb.Exists(id) {
+		return MakeGitError(fmt.Sprintf("Target OID %s for the reference doesn't exist on the repository", id), ErrNotFound)
+	}
+	ref := &Reference{
+		refType:   ReferenceOid,
+		repo:      t.repo,
+		targetOid: id.Copy(),
+	}
+	return t.queue(name, ref, oldId, "", sig, msg)
+}
+
+// UpdateSymbolic queues update of the symbolic reference. If oldTarget is
+// not empty, the current target should match it.
+func (t *RefTransaction) UpdateSymbolic(name, target, oldTarget string, sig *Signature, msg string) error {
+	normalizedTarget, err := referenceNormalizeForWrite(t.repo, target)
+	if err != nil {
+		return err
+	}
+	ref := &Reference{
+		refType:        ReferenceSymbolic,
+		repo:           t.repo,
+		targetSymbolic: normalizedTarget,
+	}
+	return t.queue(name, ref, nil, oldTarget, sig, msg)
+}
+
+// Delete queues deletion of the reference. If oldId is not nil, the
+// current value should match it.
+func (t *RefTransaction) Delete(name string, oldId *Oid) error {
+	return t.queue(name, nil, oldId, "", nil, "")
+}
+
+func (t *RefTransaction) queue(name string, ref *Reference, oldId *Oid, oldTarget string, sig *Signature, msg string) error {
+	if t.done {
+		return errors.New("The transaction has already been finished")
+	}
+	normalized, err := referenceNormalizeForWrite(t.repo, name)
+	if err != nil {
+		return err
+	}
+	for _, update := range t.updates {
+		if update.name == normalized {
+			return MakeGitError(fmt.Sprintf("Multiple updates for reference '%s' are not allowed", normalized), ErrExists)
+		}
+		if ref != nil && update.ref != nil && (strings.HasPrefix(update.name, normalized+"/") || strings.HasPrefix(normalized, update.name+"/")) {
+			msg := fmt.Sprintf("The reference '%s' conflicts with the reference '%s' in the same transaction", normalized, update.name)
+			return MakeGitError(msg, ErrExists)
+		}
+	}
+	if ref != nil {
+		ref.name = normalized
+		if err := t.refDb.checkNameAvailable(normalized, ""); err != nil {
+			return err
+		}
+	}
+	if err := t.backend.Lock(normalized); err != nil {
+		return err
+	}
+	current, err := t.refDb.Lookup(normalized)
+	if err == nil {
+		err = checkReferenceValue(normalized, current, oldId, oldTarget)
+	} else if IsErrorCode(err, ErrNotFound) && ref != nil {
+		err = checkReferenceValue(normalized, nil, oldId, oldTarget)
+	}
+	if err != nil {
+		t.backend.Unlock(normalized)
+		return err
+	}
+	t.updates = append(t.updates, &refUpdate{
+		name:    normalized,
+		ref:     ref,
+		current: current,
+		sig:     sig,
+		msg:     msg,
+	})
+	return nil
+}
+
+// Commit applies all queued operations. If preparing any of them fails,
+// no reference is changed.
+func (t *RefTransaction) Commit() error {
+	if t.done {
+		return errors.New("The transaction has already been finished")
+	}
+	t.done = true
+	updates := make([]*RefUpdate, len(t.updates))
+	for i, update := range t.updates {
+		updates[i] = &RefUpdate{
+			Name:      update.name,
+			Reference: update.ref,
+		}
+	}
+	if err := t.backend.Commit(updates); err != nil {
+		return err
+	}
+	return t.writeReflogs()
+}
+
+func (t *RefTransaction) writeReflogs() error {
+	for _, update := range t.updates {
+		if err := t.writeReflog(update); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *RefTransaction) writeReflog(update *refUpdate) error {
+	if update.ref == nil {
+		return t.repo.DeleteReflog(update.name)
+	}
+	if update.ref.refType == ReferenceOid {
+		return logReferenceUpdate(t.repo, update.name, resolvedTarget(update.current), update.ref.targetOid, update.sig, update.msg)
+	}
+	if newId := resolvedTarget(update.ref); newId != nil {
+		return appendReflogForUpdate(t.repo, update.name, resolvedTarget(update.current), newId, update.sig, update.msg)
+	}
+	return nil
+}
+
+// Rollback releases all locks without any change. It does nothing after
+// the transaction has been committed.
+func (t *RefTransaction) Rollback() {
+	if t.done {
+		return
+	}
+	t.done = true
+	t.backend.Rollback()
+}
@@ -0,0 +1,152 @@
+package git4go
+
+import (
+	"./testutil"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func Test_RefTransaction_Commit(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	id, _ := NewOid("c47800c7266a2be04c571c04d5a6614691ea99bd")
+	master, _ := NewOid("a65fedf39aefe402d3bb6e24df4d4f5fe4547750")
+
+	tx := repo.NewRefTransaction()
+	if err := tx.Update("refs/heads/master", id, master, nil, "merge queue"); err != nil {
+		t.Error("err should be nil:", err)
+	}
+	if err := tx.Create("refs/queue/done", master, nil, "merge queue"); err != nil {
+		t.Error("err should be nil:", err)
+	}
+	if err := tx.Delete("refs/heads/packed-test", nil); err != nil {
+		t.Error("err should be nil:", err)
+	}
+	if err := tx.UpdateSymbolic("refs/queue/current", "refs/heads/br2", "", nil, ""); err != nil {
+		t.Error("err should be nil:", err)
+	}
+	if _, err := os.Stat("test_resources/testrepo.git/refs/heads/master.lock"); err != nil {
+		t.Error("reference should be locked")
+	}
+	if err := tx.Commit(); err != nil {
+		t.Error("err should be nil:", err)
+		return
+	}
+
+	ref, _ := repo.LookupReference("refs/heads/master")
+	if !ref.Target().Equal(id) {
+		t.Error("master should be updated:", ref.Target())
+	}
+	ref, _ = repo.LookupReference("refs/queue/done")
+	if ref == nil || !ref.Target().Equal(master) {
+		t.Error("reference should be created")
+	}
+	ref, _ = repo.LookupReference("refs/queue/current")
+	if ref == nil || ref.SymbolicTarget() != "refs/heads/br2" {
+		t.Error("symbolic reference should be created")
+	}
+	if _, err := repo.LookupReference("refs/heads/packed-test"); !IsErrorCode(err, ErrNotFound) {
+		t.Error("reference should be deleted:", err)
+	}
+	packed, _ := ioutil.ReadFile("test_resources/testrepo.git/packed-refs")
+	if strings.Contains(string(packed), "refs/heads/packed-test") || !strings.Contains(string(packed), "refs/heads/packed\n") {
+		t.Error("packed-refs is wrong:", string(packed))
+	}
+	if _, err := os.Stat("test_resources/testrepo.git/refs/heads/master.lock"); !os.IsNotExist(err) {
+		t.Error("lock should be released")
+	}
+	reflog, _ := repo.ReadReflog(GitHeadFile)
+	if reflog.EntryByIndex(0).Message != "merge queue" {
+		t.Error("reflog of HEAD should be written:", reflog.EntryByIndex(0).Message)
+	}
+	if err := tx.Commit(); err == nil {
+		t.Error("finished transaction can't be committed again")
+	}
+}
+
+func Test_RefTransaction_Failure(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	id, _ := NewOid("c47800c7266a2be04c571c04d5a6614691ea99bd")
+	wrong, _ := NewOid("a4a7dce85cf63874e984719f4fdd239f5145052f")
+
+	tx := repo.NewRefTransaction()
+	if err := tx.Update("refs/heads/master", id, nil, nil, ""); err != nil {
+		t.Error("err should be nil:", err)
+	}
+	if err := tx.Update("refs/heads/master", id, nil, nil, ""); !IsErrorCode(err, ErrExists) {
+		t.Error("same reference can't be updated twice:", err)
+	}
+	if err := tx.Update("refs/heads/test", id, wrong, nil, ""); !IsErrorCode(err, ErrModified) {
+		t.Error("unmatched value should be rejected:", err)
+	}
+	if err := tx.Create("refs/heads/br2", id, nil, ""); !IsErrorCode(err, ErrModified) {
+		t.Error("existing reference can't be created:", err)
+	}
+	if err := tx.Delete("refs/heads/not-exist", nil); !IsErrorCode(err, ErrNotFound) {
+		t.Error("missing reference can't be deleted:", err)
+	}
+	if err := tx.Create("refs/heads/new/a", id, nil, ""); err != nil {
+		t.Error("err should be nil:", err)
+	}
+	if err := tx.Create("refs/heads/new", id, nil, ""); !IsErrorCode(err, ErrExists) {
+		t.Error("conflicting name should be rejected:", err)
+	}
+
+	// other writers can't touch the locked reference
+	ref, _ := repo.LookupReference("refs/heads/master")
+	if _, err := ref.SetTarget(wrong, nil, ""); !IsErrorCode(err, ErrLocked) {
+		t.Error("locked reference can't be updated:", err)
+	}
+	other := repo.NewRefTransaction()
+	if err := other.Delete("refs/heads/master", nil); !IsErrorCode(err, ErrLocked) {
+		t.Error("locked reference can't be deleted:", err)
+	}
+	other.Rollback()
+
+	tx.Rollback()
+	ref, _ = repo.LookupReference("refs/heads/master")
+	if ref.Target().String() != "a65fedf39aefe402d3bb6e24df4d4f5fe4547750" {
+		t.Error("master should not be changed:", ref.Target())
+	}
+	if _, err := os.Stat("test_resources/testrepo.git/refs/heads/new"); !os.IsNotExist(err) {
+		t.Error("directory created for lock should be removed")
+	}
+	if err := tx.Commit(); err == nil {
+		t.Error("finished transaction can't be committed")
+	}
+}
+
+func Test_RefTransaction_PackedRefsLocked(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	id, _ := NewOid("c47800c7266a2be04c571c04d5a6614691ea99bd")
+
+	tx := repo.NewRefTransaction()
+	tx.Update("refs/heads/master", id, nil, nil, "")
+	tx.Delete("refs/heads/packed", nil)
+
+	lock, _ := os.Create("test_resources/testrepo.git/packed-refs.lock")
+	lock.Close()
+	if err := tx.Commit(); !IsErrorCode(err, ErrLocked) {
+		t.Error("commit should fail when packed-refs is locked:", err)
+	}
+	os.Remove("test_resources/testrepo.git/packed-refs.lock")
+
+	// nothing is changed
+	ref, _ := repo.LookupReference("refs/heads/master")
+	if ref.Target().String() != "a65fedf39aefe402d3bb6e24df4d4f5fe4547750" {
+		t.Error("master should not be changed:", ref.Target())
+	}
+	if _, err := repo.LookupReference("refs/heads/packed"); err != nil {
+		t.Error("packed reference should not be deleted:", err)
+	}
+}
@@ -2,7 +2,9 @@ package git4go
 
 import (
 	"errors"
+	"fmt"
 	"path/filepath"
+	"strings"
 )
 
 type Filemode uint32
@@ -54,7 +56,34 @@ func (t *Tree) EntryByName(filename string) *TreeEntry {
 }
 
 func (t *Tree) EntryByPath(path string) (*TreeEntry, error) {
-	return nil, nil
+	if path == "" {
+		return nil, errors.New("Tree.EntryByPath(): path is empty")
+	}
+	notFound := MakeGitError(fmt.Sprintf("The path '%s' does not exist in the given tree", path), ErrNotFound)
+	// a trailing slash means the entry should be a tree
+	fragments := strings.Split(strings.TrimSuffix(path, "/"), "/")
+	tree := t
+	for i, fragment := range fragments {
+		entry := tree.EntryByName(fragment)
+		if entry == nil {
+			return nil, notFound
+		}
+		if i == len(fragments)-1 {
+			if strings.HasSuffix(path, "/") && entry.Type != ObjectTree {
+				return nil, notFound
+			}
+			return entry, nil
+		}
+		if entry.Type != ObjectTree {
+			return nil, notFound
+		}
+		subTree, err := t.repo.LookupTree(entry.Id)
+		if err != nil {
+			return nil, err
+		}
+		tree = subTree
+	}
+	return nil, notFound
 }
 
 func (t *Tree) EntryByIndex(index int) *TreeEntry {
@@ -27,8 +27,18 @@ func (p TreeEntries) Len() int {
 func (p TreeEntries) Swap(i, j int) {
 	p[i], p[j] = p[j], p[i]
 }
+
+// Less compares names like git. Trees are compared as if they have a
+// trailing slash.
 func (p TreeEntries) Less(i, j int) bool {
-	return p[i].Name < p[j].Name
+	return treeEntrySortName(p[i]) < treeEntrySortName(p[j])
+}
+
+func treeEntrySortName(entry *TreeEntry) string {
+	if entry.Filemode == FilemodeTree {
+		return entry.Name + "/"
+	}
+	return entry.Name
 }
 
 func (b *TreeBuilder) Insert(filename string, oid *Oid, filemode Filemode) error {
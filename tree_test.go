@@ -54,6 +54,32 @@ func Test_LookupTree(t *testing.T) {
 	}
 }
 
+func Test_TreeEntryByPath(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	oid, _ := NewOid("ae90f12eea699729ed24555e40b9fd669da12a12")
+	tree, _ := repo.LookupTree(oid)
+
+	entry, err := tree.EntryByPath("ab/de/fgh/1.txt")
+	if err != nil || entry.Id.String() != "1f67fc4386b2d171e0d21be1c447e12660561f9b" {
+		t.Error("entry ab/de/fgh/1.txt is invalid", entry, err)
+	}
+	entry, err = tree.EntryByPath("ab/")
+	if err != nil || entry.Type != ObjectTree {
+		t.Error("entry ab/ is invalid", entry, err)
+	}
+	_, err = tree.EntryByPath("ab/4.txt/")
+	if !IsErrorCode(err, ErrNotFound) {
+		t.Error("blob with trailing slash should not be found", err)
+	}
+	_, err = tree.EntryByPath("ab/42.txt")
+	if !IsErrorCode(err, ErrNotFound) {
+		t.Error("entry ab/42.txt should not be found", err)
+	}
+}
+
 func Test_TreeWalk(t *testing.T) {
 	testutil.PrepareWorkspace("test_resources/testrepo")
 	defer testutil.CleanupWorkspace()
@@ -4,9 +4,16 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"sort"
 	"strings"
 )
 
+// the id of the tree without entries
+var emptyTreeId, _ = NewOid("4b825dc642cb6eb9a060e54bf8d69288fbee4904")
+
+// the smallest TREE extension entry is an invalidated one like "\x00-1 0\n"
+const treeCacheMinimumEntrySize = 6
+
 type TreeCache struct {
 	children []*TreeCache
 
@@ -18,8 +25,10 @@ type TreeCache struct {
 func (v *TreeCache) write(buffer *bytes.Buffer) {
 	buffer.WriteString(v.name)
 	buffer.WriteByte(0)
-	fmt.Fprintf(buffer, "%d %d\n", v.entryCount, len(v.children))
-	if v.entryCount != -1 {
+	if v.entryCount < 0 || v.oid == nil {
+		fmt.Fprintf(buffer, "-1 %d\n", len(v.children))
+	} else {
+		fmt.Fprintf(buffer, "%d %d\n", v.entryCount, len(v.children))
 		buffer.Write(v.oid[:])
 	}
 	for _, child := range v.children {
@@ -33,61 +42,78 @@ func (v *TreeCache) get(path string) *TreeCache {
 		if pathFragment == "" {
 			continue
 		}
-		for _, child := range current.children {
-			if child.name == pathFragment {
-				current = child
-				continue
-			}
+		current = current.child(pathFragment)
+		if current == nil {
+			return nil
 		}
-		return nil
 	}
 	return current
 }
 
+func (v *TreeCache) child(name string) *TreeCache {
+	for _, child := range v.children {
+		if child.name == name {
+			return child
+		}
+	}
+	return nil
+}
+
+// invalidatePath marks the trees which contain the path as invalid.
+// Invalid trees are written with entry count -1 and without object id.
 func (v *TreeCache) invalidatePath(path string) {
-	v.entryCount--
+	if v == nil {
+		return
+	}
+	v.entryCount = -1
 	current := v
 	for _, pathFragment := range strings.Split(path, "/") {
 		if pathFragment == "" {
 			continue
 		}
-		for _, child := range current.children {
-			if child.name == pathFragment {
-				current = child
-				current.entryCount--
-				continue
-			}
+		current = current.child(pathFragment)
+		if current == nil {
+			return /* we don't have that tree */
 		}
-		return /* we don't have that tree */
+		current.entryCount = -1
 	}
 }
 
 func readTreeInternal(buffer []byte, offset, bufferEnd int) (*TreeCache, int, error) {
 	nameEnd := findChar(buffer, 0, offset, bufferEnd)
-	if nameEnd == -1 || bufferEnd-nameEnd < 8 {
+	if nameEnd == -1 || bufferEnd-nameEnd < 4 {
 		return nil, offset, errors.New("Corrupted TREE extension in index")
 	}
 	name := string(buffer[offset:nameEnd])
 	offset = nameEnd + 1
 	entryCount, newOffset := strtol32(buffer, offset, bufferEnd, 10)
-	childCount, newOffset := strtol32(buffer, newOffset, bufferEnd, 10)
-	if entryCount == -1 || childCount == -1 {
+	if newOffset == offset || buffer[newOffset-1] != ' ' || entryCount < -1 {
 		return nil, offset, errors.New("Corrupted TREE extension in index")
 	}
-
-	cache := &TreeCache{
-		name:       name,
-		children:   make([]*TreeCache, childCount),
-		entryCount: int(entryCount),
+	offset = newOffset
+	childCount, newOffset := strtol32(buffer, offset, bufferEnd, 10)
+	if newOffset == offset || buffer[newOffset-1] != '\n' || childCount < 0 {
+		return nil, offset, errors.New("Corrupted TREE extension in index")
 	}
 	offset = newOffset
-	if entryCount > 0 {
+	var oid *Oid
+	if entryCount >= 0 {
 		if offset+GitOidRawSize > bufferEnd {
 			return nil, offset, errors.New("Corrupted TREE extension in index")
 		}
-		cache.oid = NewOidFromBytes(buffer[offset : offset+GitOidRawSize])
+		oid = NewOidFromBytes(buffer[offset : offset+GitOidRawSize])
 		offset += GitOidRawSize
 	}
+	// check the count before the allocation
+	if int(childCount) > (bufferEnd-offset)/treeCacheMinimumEntrySize {
+		return nil, offset, errors.New("Corrupted TREE extension in index")
+	}
+	cache := &TreeCache{
+		name:       name,
+		children:   make([]*TreeCache, childCount),
+		entryCount: int(entryCount),
+		oid:        oid,
+	}
 	for i := 0; i < int(childCount); i++ {
 		child, newOffset, err := readTreeInternal(buffer, offset, bufferEnd)
 		if err != nil {
@@ -96,7 +122,7 @@ func readTreeInternal(buffer []byte, offset, bufferEnd int) (*TreeCache, int, er
 		offset = newOffset
 		cache.children[i] = child
 	}
-	return nil, offset, errors.New("Corrupted TREE extension in index")
+	return cache, offset, nil
 }
 
 func readTreeCache(buffer []byte, offset, extensionSize int) (*TreeCache, error) {
@@ -112,17 +138,11 @@ func readTreeCache(buffer []byte, offset, extensionSize int) (*TreeCache, error)
 
 func readTreeCacheFromTreeRecursive(tree *Tree, cache *TreeCache) error {
 	cache.oid = tree.Id()
-	treeCount := 0
+	cache.entryCount = 0
+	cache.children = nil
 	for _, entry := range tree.Entries {
-		if entry.Filemode == FilemodeTree {
-			treeCount++
-		}
-	}
-	cache.children = make([]*TreeCache, treeCount)
-	entryCount := 0
-	for i, entry := range tree.Entries {
 		if entry.Filemode != FilemodeTree {
-			entryCount++
+			cache.entryCount++
 			continue
 		}
 		childCache := &TreeCache{
@@ -137,12 +157,105 @@ func readTreeCacheFromTreeRecursive(tree *Tree, cache *TreeCache) error {
 			return err
 		}
 		cache.entryCount += childCache.entryCount
-		cache.children[i] = childCache
+		cache.children = append(cache.children, childCache)
 	}
+	sort.Sort(treeCacheChildren(cache.children))
 	return nil
 }
 
-func createTreeCacheFromTree(tree *Tree) error {
+func createTreeCacheFromTree(tree *Tree) (*TreeCache, error) {
 	cache := &TreeCache{}
-	return readTreeCacheFromTreeRecursive(tree, cache)
+	if err := readTreeCacheFromTreeRecursive(tree, cache); err != nil {
+		return nil, err
+	}
+	return cache, nil
+}
+
+// writeTreeCache writes the trees of the sorted entries under the base
+// directory and returns the tree cache of them. Valid nodes in the old
+// cache are reused without writing the trees. Intent to add entries are
+// not written and the trees which contain them are kept invalid like git.
+func writeTreeCache(repo *Repository, entries []*IndexEntry, name, base string, old *TreeCache) (*TreeCache, error) {
+	if old != nil && old.entryCount == len(entries) && old.oid != nil {
+		return old, nil
+	}
+	builder, err := repo.TreeBuilder()
+	if err != nil {
+		return nil, err
+	}
+	cache := &TreeCache{
+		name:       name,
+		entryCount: len(entries),
+	}
+	containsIntentToAdd := false
+	for i := 0; i < len(entries); {
+		entry := entries[i]
+		relPath := entry.Path[len(base):]
+		slash := strings.IndexByte(relPath, '/')
+		if slash == -1 {
+			if entry.IsIntentToAdd() {
+				containsIntentToAdd = true
+				i++
+				continue
+			}
+			if err := builder.Insert(relPath, entry.Id, entry.Mode); err != nil {
+				return nil, err
+			}
+			i++
+			continue
+		}
+		// entries in the same directory are contiguous in the index
+		dirName := relPath[:slash]
+		prefix := base + dirName + "/"
+		j := i + 1
+		for j < len(entries) && strings.HasPrefix(entries[j].Path, prefix) {
+			j++
+		}
+		var oldChild *TreeCache
+		if old != nil {
+			oldChild = old.child(dirName)
+		}
+		child, err := writeTreeCache(repo, entries[i:j], dirName, prefix, oldChild)
+		if err != nil {
+			return nil, err
+		}
+		cache.children = append(cache.children, child)
+		i = j
+		if child.entryCount < 0 {
+			containsIntentToAdd = true
+			// the tree which has only intent to add entries is empty
+			if child.oid.Equal(emptyTreeId) {
+				continue
+			}
+		}
+		if err := builder.Insert(dirName, child.oid, FilemodeTree); err != nil {
+			return nil, err
+		}
+	}
+	oid, err := builder.Write()
+	if err != nil {
+		return nil, err
+	}
+	cache.oid = oid
+	if containsIntentToAdd {
+		cache.entryCount = -1
+	}
+	sort.Sort(treeCacheChildren(cache.children))
+	return cache, nil
+}
+
+// treeCacheChildren sorts subtrees by the length and the name like git.
+type treeCacheChildren []*TreeCache
+
+func (c treeCacheChildren) Len() int {
+	return len(c)
+}
+func (c treeCacheChildren) Swap(i, j int) {
+	c[i], c[j] = c[j], c[i]
+}
+func (c treeCacheChildren) Less(i, j int) bool {
+	if len(c[i].name) != len(c[j].name) {
+		return len(c[i].name) < len(c[j].name)
+	}
+	return c[i].name < c[j].name
 }
@@ -0,0 +1,788 @@
+package git4go
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/shibukawa/extstat"
+)
+
+const (
+	// dir_flags of the untracked cache. git4go uses the flags of
+	// "git status --untracked-files=normal".
+	UntrackedShowOtherDirectories uint32 = 1 << 1
+	UntrackedHideEmptyDirectories uint32 = 1 << 2
+
+	statDataSize = 36
+)
+
+var errUntrackedCacheCorrupted = errors.New("corrupt untracked cache extension")
+
+// system names in the ident of the untracked cache like uname(2)
+var untrackedCacheSystemNames = map[string]string{
+	"darwin":    "Darwin",
+	"dragonfly": "DragonFly",
+	"freebsd":   "FreeBSD",
+	"linux":     "Linux",
+	"netbsd":    "NetBSD",
+	"openbsd":   "OpenBSD",
+	"solaris":   "SunOS",
+	"windows":   "Windows",
+}
+
+// statData is the stat information stored in the untracked cache.
+type statData struct {
+	ctimeSec, ctimeNsec uint32
+	mtimeSec, mtimeNsec uint32
+	dev, ino, uid, gid  uint32
+	size                uint32
+}
+
+func newStatData(info os.FileInfo) statData {
+	var result statData
+	result.ctimeSec, result.ctimeNsec = indexTime(extstat.New(info).ChangeTime)
+	result.mtimeSec, result.mtimeNsec = indexTime(info.ModTime())
+	result.dev, result.ino, result.uid, result.gid = statIds(info)
+	result.size = uint32(info.Size())
+	return result
+}
+
+func readStatData(buffer []byte, offset int) statData {
+	return statData{
+		ctimeSec:  ntohlFromBytes(buffer, offset),
+		ctimeNsec: ntohlFromBytes(buffer, offset+4),
+		mtimeSec:  ntohlFromBytes(buffer, offset+8),
+		mtimeNsec: ntohlFromBytes(buffer, offset+12),
+		dev:       ntohlFromBytes(buffer, offset+16),
+		ino:       ntohlFromBytes(buffer, offset+20),
+		uid:       ntohlFromBytes(buffer, offset+24),
+		gid:       ntohlFromBytes(buffer, offset+28),
+		size:      ntohlFromBytes(buffer, offset+32),
+	}
+}
+
+func (s *statData) write(buffer *bytes.Buffer) {
+	binary.Write(buffer, binary.BigEndian, []uint32{
+		s.ctimeSec, s.ctimeNsec, s.mtimeSec, s.mtimeNsec,
+		s.dev, s.ino, s.uid, s.gid, s.size,
+	})
+}
+
+// oidStat is the stat and the hash of a global ignore file. id is nil if
+// the file doesn't exist.
+type oidStat struct {
+	stat statData
+	id   *Oid
+}
+
+// newOidStat hashes the ignore file like git. The content is hashed with
+// an extra new line unless the file is empty.
+func newOidStat(path string) oidStat {
+	info, err := os.Stat(path)
+	if err != nil {
+		return oidStat{}
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return oidStat{}
+	}
+	if len(content) > 0 {
+		content = append(content, '\n')
+	}
+	id, _ := hash(content, ObjectBlob)
+	return oidStat{stat: newStatData(info), id: id}
+}
+
+// untrackedCache is the UNTR extension of the index. It keeps the
+// untracked files of each directory with the stat data of the directory
+// to skip reading directories which are not changed.
+type untrackedCache struct {
+	// NUL terminated strings of the locations which use the cache
+	ident         []byte
+	infoExclude   oidStat
+	excludesFile  oidStat
+	dirFlags      uint32
+	excludePerDir string
+	root          *untrackedCacheDir
+}
+
+type untrackedCacheDir struct {
+	name string
+	// untracked files and directories (with a trailing slash)
+	untracked []string
+	// sorted by name
+	dirs []*untrackedCacheDir
+	stat statData
+	// hash of .gitignore in the directory. It is nil if it doesn't exist.
+	excludeId *Oid
+	valid     bool
+	// checkOnly is true if the directory is untracked and it was read
+	// only until the first untracked file was found.
+	checkOnly bool
+	// recurse is false if the directory is not visited since the parent
+	// was read. Such directories are dropped when the cache is written.
+	recurse bool
+}
+
+func newUntrackedCache() *untrackedCache {
+	return &untrackedCache{
+		dirFlags:      UntrackedShowOtherDirectories | UntrackedHideEmptyDirectories,
+		excludePerDir: GitIgnoreFile,
+	}
+}
+
+// SetUntrackedCache enables or disables the untracked cache. The cache is
+// filled by UntrackedFiles() and saved by Write().
+func (v *Index) SetUntrackedCache(enable bool) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if !enable {
+		v.untracked = nil
+	} else if v.untracked == nil {
+		v.untracked = newUntrackedCache()
+	}
+}
+
+// HasUntrackedCache returns true if the untracked cache is enabled.
+func (v *Index) HasUntrackedCache() bool {
+	return v.untracked != nil
+}
+
+func untrackedCacheIdent(workDir string) string {
+	if absPath, err := filepath.Abs(workDir); err == nil {
+		workDir = absPath
+	}
+	system, ok := untrackedCacheSystemNames[runtime.GOOS]
+	if !ok {
+		system = runtime.GOOS
+	}
+	return fmt.Sprintf("Location %s, system %s", filepath.ToSlash(workDir), system)
+}
+
+func (c *untrackedCache) hasIdent(ident string) bool {
+	for _, entry := range strings.Split(string(c.ident), "\x00") {
+		if entry == ident {
+			return true
+		}
+	}
+	return false
+}
+
+// invalidatePath marks the directories of the added or removed path as
+// changed. Ancestor directories are also invalidated because they may
+// have the path as an untracked directory.
+func (c *untrackedCache) invalidatePath(path string) {
+	if c == nil || c.root == nil {
+		return
+	}
+	dir := c.root
+	components := strings.Split(path, "/")
+	for i := 0; ; i++ {
+		if c.dirFlags&UntrackedShowOtherDirectories != 0 || i == len(components)-1 {
+			dir.valid = false
+			dir.untracked = nil
+		}
+		if i == len(components)-1 {
+			return
+		}
+		if dir = dir.find(components[i]); dir == nil {
+			return
+		}
+	}
+}
+
+func (c *untrackedCache) invalidateAll() {
+	if c != nil && c.root != nil {
+		c.root.invalidateAll()
+	}
+}
+
+func (d *untrackedCacheDir) find(name string) *untrackedCacheDir {
+	i := sort.Search(len(d.dirs), func(i int) bool {
+		return d.dirs[i].name >= name
+	})
+	if i < len(d.dirs) && d.dirs[i].name == name {
+		return d.dirs[i]
+	}
+	return nil
+}
+
+// lookup returns the sub directory. It is created if it doesn't exist.
+func (d *untrackedCacheDir) lookup(name string) *untrackedCacheDir {
+	i := sort.Search(len(d.dirs), func(i int) bool {
+		return d.dirs[i].name >= name
+	})
+	if i < len(d.dirs) && d.dirs[i].name == name {
+		return d.dirs[i]
+	}
+	dir := &untrackedCacheDir{name: name}
+	d.dirs = append(d.dirs, nil)
+	copy(d.dirs[i+1:], d.dirs[i:])
+	d.dirs[i] = dir
+	return dir
+}
+
+// invalidate is called before the directory is read again.
+func (d *untrackedCacheDir) invalidate() {
+	d.valid = false
+	d.untracked = nil
+	for _, dir := range d.dirs {
+		dir.recurse = false
+	}
+}
+
+// invalidateAll invalidates the directory and all sub directories. It is
+// used when ignore rules are changed.
+func (d *untrackedCacheDir) invalidateAll() {
+	d.valid = false
+	d.untracked = nil
+	for _, dir := range d.dirs {
+		dir.invalidateAll()
+	}
+}
+
+func readNullableOid(buffer []byte, offset int) *Oid {
+	oid := NewOidFromBytes(buffer[offset : offset+GitOidRawSize])
+	if oid.IsZero() {
+		return nil
+	}
+	return oid
+}
+
+func writeNullableOid(buffer *bytes.Buffer, oid *Oid) {
+	if oid == nil {
+		buffer.Write(make([]byte, GitOidRawSize))
+	} else {
+		buffer.Write(oid[:])
+	}
+}
+
+func readUntrackedCache(buffer []byte) (*untrackedCache, error) {
+	if len(buffer) <= 1 || buffer[len(buffer)-1] != 0 {
+		return nil, errUntrackedCacheCorrupted
+	}
+	buffer = buffer[:len(buffer)-1]
+	identLength, offset, ok := getVarint(buffer, 0)
+	if !ok || uint64(len(buffer)-offset) < identLength {
+		return nil, errUntrackedCacheCorrupted
+	}
+	cache := &untrackedCache{
+		ident: append([]byte{}, buffer[offset:offset+int(identLength)]...),
+	}
+	offset += int(identLength)
+	if offset+statDataSize*2+4+GitOidRawSize*2 > len(buffer) {
+		return nil, errUntrackedCacheCorrupted
+	}
+	cache.infoExclude.stat = readStatData(buffer, offset)
+	cache.excludesFile.stat = readStatData(buffer, offset+statDataSize)
+	cache.dirFlags = ntohlFromBytes(buffer, offset+statDataSize*2)
+	offset += statDataSize*2 + 4
+	cache.infoExclude.id = readNullableOid(buffer, offset)
+	cache.excludesFile.id = readNullableOid(buffer, offset+GitOidRawSize)
+	offset += GitOidRawSize * 2
+	end := bytes.IndexByte(buffer[offset:], 0)
+	if end == -1 {
+		return nil, errUntrackedCacheCorrupted
+	}
+	cache.excludePerDir = string(buffer[offset : offset+end])
+	offset += end + 1
+	if offset >= len(buffer) {
+		return cache, nil
+	}
+	dirCount, offset, ok := getVarint(buffer, offset)
+	if !ok || dirCount > uint64(len(buffer)) {
+		return nil, errUntrackedCacheCorrupted
+	}
+	if dirCount == 0 {
+		return cache, nil
+	}
+
+	dirs := make([]*untrackedCacheDir, 0, dirCount)
+	root, offset, err := readUntrackedCacheDir(buffer, offset, &dirs)
+	if err != nil || len(dirs) != int(dirCount) {
+		return nil, errUntrackedCacheCorrupted
+	}
+	var bitmaps [3]*ewahBitmap
+	for i := range bitmaps {
+		bitmaps[i], offset, err = readEwahBitmap(buffer, offset)
+		if err != nil {
+			return nil, err
+		}
+	}
+	valid, checkOnly, hasExcludeId := bitmaps[0], bitmaps[1], bitmaps[2]
+	err = checkOnly.eachBit(func(pos int) error {
+		if pos >= len(dirs) {
+			return errUntrackedCacheCorrupted
+		}
+		dirs[pos].checkOnly = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = valid.eachBit(func(pos int) error {
+		if pos >= len(dirs) || offset+statDataSize > len(buffer) {
+			return errUntrackedCacheCorrupted
+		}
+		dirs[pos].valid = true
+		dirs[pos].stat = readStatData(buffer, offset)
+		offset += statDataSize
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = hasExcludeId.eachBit(func(pos int) error {
+		if pos >= len(dirs) || offset+GitOidRawSize > len(buffer) {
+			return errUntrackedCacheCorrupted
+		}
+		dirs[pos].excludeId = NewOidFromBytes(buffer[offset : offset+GitOidRawSize])
+		offset += GitOidRawSize
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if offset != len(buffer) {
+		return nil, errUntrackedCacheCorrupted
+	}
+	cache.root = root
+	return cache, nil
+}
+
+// readUntrackedCacheDir reads directories in pre-order.
+func readUntrackedCacheDir(buffer []byte, offset int, dirs *[]*untrackedCacheDir) (*untrackedCacheDir, int, error) {
+	untrackedCount, offset, ok := getVarint(buffer, offset)
+	if !ok || untrackedCount > uint64(len(buffer)) {
+		return nil, 0, errUntrackedCacheCorrupted
+	}
+	dirCount, offset, ok := getVarint(buffer, offset)
+	if !ok || dirCount > uint64(len(buffer)) || len(*dirs) == cap(*dirs) {
+		return nil, 0, errUntrackedCacheCorrupted
+	}
+	readString := func() (string, bool) {
+		end := bytes.IndexByte(buffer[offset:], 0)
+		if end == -1 {
+			return "", false
+		}
+		result := string(buffer[offset : offset+end])
+		offset += end + 1
+		return result, true
+	}
+	name, ok := readString()
+	if !ok {
+		return nil, 0, errUntrackedCacheCorrupted
+	}
+	dir := &untrackedCacheDir{
+		name:    name,
+		recurse: true,
+	}
+	*dirs = append(*dirs, dir)
+	for i := uint64(0); i < untrackedCount; i++ {
+		path, ok := readString()
+		if !ok {
+			return nil, 0, errUntrackedCacheCorrupted
+		}
+		dir.untracked = append(dir.untracked, path)
+	}
+	for i := uint64(0); i < dirCount; i++ {
+		var child *untrackedCacheDir
+		var err error
+		child, offset, err = readUntrackedCacheDir(buffer, offset, dirs)
+		if err != nil {
+			return nil, 0, err
+		}
+		dir.dirs = append(dir.dirs, child)
+	}
+	return dir, offset, nil
+}
+
+func (c *untrackedCache) write() []byte {
+	var buffer bytes.Buffer
+	buffer.Write(putVarint(nil, uint64(len(c.ident))))
+	buffer.Write(c.ident)
+	c.infoExclude.stat.write(&buffer)
+	c.excludesFile.stat.write(&buffer)
+	binary.Write(&buffer, binary.BigEndian, c.dirFlags)
+	writeNullableOid(&buffer, c.infoExclude.id)
+	writeNullableOid(&buffer, c.excludesFile.id)
+	buffer.WriteString(c.excludePerDir)
+	buffer.WriteByte(0)
+	if c.root == nil {
+		buffer.Write(putVarint(nil, 0))
+		return buffer.Bytes()
+	}
+	w := &untrackedCacheWriter{
+		valid:        newEwahBitmap(),
+		checkOnly:    newEwahBitmap(),
+		hasExcludeId: newEwahBitmap(),
+	}
+	w.writeDir(c.root)
+	buffer.Write(putVarint(nil, uint64(w.index)))
+	buffer.Write(w.dirs.Bytes())
+	w.valid.write(&buffer)
+	w.checkOnly.write(&buffer)
+	w.hasExcludeId.write(&buffer)
+	buffer.Write(w.stats.Bytes())
+	buffer.Write(w.excludeIds.Bytes())
+	buffer.WriteByte(0)
+	return buffer.Bytes()
+}
+
+type untrackedCacheWriter struct {
+	index        int
+	dirs         bytes.Buffer
+	stats        bytes.Buffer
+	excludeIds   bytes.Buffer
+	valid        *ewahBitmap
+	checkOnly    *ewahBitmap
+	hasExcludeId *ewahBitmap
+}
+
+func (w *untrackedCacheWriter) writeDir(dir *untrackedCacheDir) {
+	i := w.index
+	w.index++
+	var untracked []string
+	if dir.valid {
+		untracked = dir.untracked
+		if dir.checkOnly {
+			w.checkOnly.set(i)
+		}
+		w.valid.set(i)
+		dir.stat.write(&w.stats)
+	}
+	if dir.excludeId != nil {
+		w.hasExcludeId.set(i)
+		w.excludeIds.Write(dir.excludeId[:])
+	}
+	var children []*untrackedCacheDir
+	for _, child := range dir.dirs {
+		if child.recurse {
+			children = append(children, child)
+		}
+	}
+	w.dirs.Write(putVarint(nil, uint64(len(untracked))))
+	w.dirs.Write(putVarint(nil, uint64(len(children))))
+	w.dirs.WriteString(dir.name)
+	w.dirs.WriteByte(0)
+	for _, path := range untracked {
+		w.dirs.WriteString(path)
+		w.dirs.WriteByte(0)
+	}
+	for _, child := range children {
+		w.writeDir(child)
+	}
+}
+
+// UntrackedFiles returns the untracked files in the working directory in
+// the order of paths like "git status --untracked-files=normal". Untracked
+// directories are returned with a trailing slash instead of the files in
+// them. Ignored files and empty directories are not returned.
+//
+// If the untracked cache is enabled, directories which are not changed
+// since the last call are not read. The updated cache is saved by Write().
+func (v *Index) UntrackedFiles() ([]string, error) {
+	if v.repo == nil {
+		return nil, errors.New("Could not find untracked files. Index is not backed up by an existing repository.")
+	}
+	if v.repo.IsBare() {
+		return nil, MakeGitError("Could not find untracked files in bare repository", ErrBareRepository)
+	}
+	w := &untrackedWalker{
+		index:   v,
+		workDir: v.repo.Workdir(),
+		tracked: newIndexPaths(v.entriesSnapshot(), v.ignoreCase),
+		ignores: newIgnores(v.repo, v.ignoreCase),
+	}
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	var root *untrackedCacheDir
+	if v.untracked != nil {
+		root = w.validateCache(v.repo, v.untracked)
+	}
+	if _, err := w.readDirectory("", root, false); err != nil {
+		return nil, err
+	}
+	// a cached untracked directory is found twice
+	sort.Strings(w.result)
+	result := w.result[:0]
+	for i, path := range w.result {
+		if i == 0 || path != w.result[i-1] {
+			result = append(result, path)
+		}
+	}
+	return result, nil
+}
+
+type pathTreatment int
+
+const (
+	pathNone pathTreatment = iota
+	pathRecurse
+	pathExcluded
+	pathUntracked
+)
+
+// untrackedWalker finds untracked files like git's read_directory().
+type untrackedWalker struct {
+	index   *Index
+	workDir string
+	tracked *indexPaths
+	ignores *ignores
+	result  []string
+}
+
+// validateCache returns the root directory of the cache if it can be
+// used. The cache is cleared if it is made in other location and all
+// directories are invalidated if the global ignore files are changed.
+func (w *untrackedWalker) validateCache(repo *Repository, cache *untrackedCache) *untrackedCacheDir {
+	ident := untrackedCacheIdent(repo.Workdir())
+	if !cache.hasIdent(ident) {
+		*cache = *newUntrackedCache()
+		cache.ident = []byte(ident + "\x00")
+	}
+	if cache.dirFlags != UntrackedShowOtherDirectories|UntrackedHideEmptyDirectories || cache.excludePerDir != GitIgnoreFile {
+		return nil
+	}
+	if cache.root == nil {
+		cache.root = &untrackedCacheDir{}
+	}
+	infoExclude := newOidStat(filepath.Join(repo.Path(), GitInfoExcludeFile))
+	var excludesFile oidStat
+	if path := findExcludesFile(repo.Config()); path != "" {
+		excludesFile = newOidStat(path)
+	}
+	if !sameOid(infoExclude.id, cache.infoExclude.id) || !sameOid(excludesFile.id, cache.excludesFile.id) {
+		cache.root.invalidateAll()
+	}
+	cache.infoExclude = infoExclude
+	cache.excludesFile = excludesFile
+	cache.root.recurse = true
+	return cache.root
+}
+
+func sameOid(a, b *Oid) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(b)
+}
+
+// readDirectory reads the directory ("" or "dir/") and returns the most
+// significant treatment of the paths in it. If checkOnly is true, it stops
+// at the first untracked file.
+func (w *untrackedWalker) readDirectory(dir string, node *untrackedCacheDir, checkOnly bool) (pathTreatment, error) {
+	cached, names, err := w.openDirectory(dir, node, checkOnly)
+	if err != nil {
+		return pathNone, err
+	}
+	if node != nil {
+		node.checkOnly = checkOnly
+	}
+	dirState := pathNone
+	// handle returns true to stop reading the directory
+	handle := func(name string, state pathTreatment, fromDisk bool) (bool, error) {
+		if state > dirState {
+			dirState = state
+		}
+		if state == pathRecurse {
+			var child *untrackedCacheDir
+			if node != nil {
+				child = node.lookup(strings.TrimSuffix(name, "/"))
+			}
+			subState, err := w.readDirectory(dir+name, child, checkOnly)
+			if err != nil {
+				return true, err
+			}
+			if subState > dirState {
+				dirState = subState
+			}
+		}
+		if checkOnly {
+			if dirState == pathUntracked {
+				if fromDisk && node != nil {
+					node.untracked = append(node.untracked, name)
+				}
+				return true, nil
+			}
+			return false, nil
+		}
+		if state == pathUntracked {
+			w.result = append(w.result, dir+name)
+			if fromDisk && node != nil {
+				node.untracked = append(node.untracked, name)
+			}
+		}
+		return false, nil
+	}
+
+	if cached {
+		for _, child := range append([]*untrackedCacheDir{}, node.dirs...) {
+			if !child.recurse {
+				continue
+			}
+			state := pathRecurse
+			if child.checkOnly {
+				// untracked directories are checked again
+				if state, err = w.readDirectory(dir+child.name+"/", child, true); err != nil {
+					return pathNone, err
+				}
+			}
+			if stop, err := handle(child.name+"/", state, false); stop || err != nil {
+				if node != nil {
+					node.valid, node.recurse = true, true
+				}
+				return dirState, err
+			}
+		}
+		for _, name := range append([]string{}, node.untracked...) {
+			if stop, err := handle(name, pathUntracked, false); stop || err != nil {
+				return dirState, err
+			}
+		}
+		return dirState, nil
+	}
+
+	for _, name := range names {
+		state, isDir, err := w.treatPath(dir, name, node)
+		if err != nil {
+			return pathNone, err
+		}
+		if isDir {
+			name += "/"
+		}
+		stop, err := handle(name, state, true)
+		if err != nil {
+			return pathNone, err
+		}
+		if stop {
+			break
+		}
+	}
+	if node != nil {
+		node.valid, node.recurse = true, true
+	}
+	return dirState, nil
+}
+
+// openDirectory returns true if the cached result of the directory can be
+// used. Otherwise it returns the names in the directory.
+func (w *untrackedWalker) openDirectory(dir string, node *untrackedCacheDir, checkOnly bool) (bool, []string, error) {
+	fullPath := filepath.Join(w.workDir, dir)
+	if node != nil {
+		info, err := os.Lstat(fullPath)
+		if err != nil {
+			node.stat = statData{}
+		} else if !node.valid || !w.sameStat(node.stat, info) {
+			node.stat = newStatData(info)
+		} else if node.checkOnly == checkOnly {
+			w.checkExcludeFile(dir, node)
+			if node.valid {
+				return true, nil, nil
+			}
+		}
+		node.invalidate()
+		w.checkExcludeFile(dir, node)
+	}
+	file, err := os.Open(fullPath)
+	if err != nil {
+		// the directory was removed or can't be read
+		return false, nil, nil
+	}
+	defer file.Close()
+	names, err := file.Readdirnames(-1)
+	return false, names, err
+}
+
+// sameStat compares the stat data of the directory. The directory which
+// is modified in the same second as the index file might be modified
+// again after the cache was written.
+func (w *untrackedWalker) sameStat(stat statData, info os.FileInfo) bool {
+	if w.index.isRacyStat(stat.mtimeSec, stat.mtimeNsec) {
+		return false
+	}
+	return stat == newStatData(info)
+}
+
+// checkExcludeFile invalidates the directory and the sub directories if
+// .gitignore in the directory is changed.
+func (w *untrackedWalker) checkExcludeFile(dir string, node *untrackedCacheDir) {
+	// .gitignore can't be created without changing the directory
+	if node.valid && node.excludeId == nil {
+		return
+	}
+	id := w.excludeFileId(dir + GitIgnoreFile)
+	if !sameOid(id, node.excludeId) {
+		node.invalidateAll()
+		node.excludeId = id
+	}
+}
+
+// excludeFileId returns the hash of .gitignore like git. The id in the
+// index is used if the file is not modified.
+func (w *untrackedWalker) excludeFileId(path string) *Oid {
+	fullPath := filepath.Join(w.workDir, path)
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return nil
+	}
+	if entry, _ := w.tracked.lookup(path); entry != nil && w.index.isUnchanged(entry, fullPath, info) {
+		return entry.Id
+	}
+	return newOidStat(fullPath).id
+}
+
+// treatPath decides how the path in the directory is treated. Untracked
+// directories are read to find untracked files in them.
+func (w *untrackedWalker) treatPath(dir, name string, node *untrackedCacheDir) (pathTreatment, bool, error) {
+	if name == GitDirName || (w.index.ignoreCase && strings.EqualFold(name, GitDirName)) {
+		return pathNone, false, nil
+	}
+	path := dir + name
+	info, err := os.Lstat(filepath.Join(w.workDir, path))
+	if err != nil {
+		return pathNone, false, nil
+	}
+	if !info.IsDir() {
+		if !info.Mode().IsRegular() && info.Mode()&os.ModeSymlink == 0 {
+			return pathNone, false, nil
+		}
+		if _, tracked := w.tracked.lookup(path); tracked {
+			return pathNone, false, nil
+		}
+		if w.ignores.match(path, false) {
+			return pathExcluded, false, nil
+		}
+		return pathUntracked, false, nil
+	}
+	// submodules
+	if _, tracked := w.tracked.lookup(path); tracked {
+		return pathNone, true, nil
+	}
+	if w.tracked.hasDir(path) {
+		return pathRecurse, true, nil
+	}
+	if w.ignores.match(path, true) {
+		return pathExcluded, true, nil
+	}
+	// nested repositories are shown without reading them
+	if _, err := os.Lstat(filepath.Join(w.workDir, path, GitDirName)); err == nil {
+		return pathUntracked, true, nil
+	}
+	var child *untrackedCacheDir
+	if node != nil {
+		child = node.lookup(name)
+	}
+	state, err := w.readDirectory(path+"/", child, true)
+	return state, true, err
+}
@@ -0,0 +1,135 @@
+package git4go
+
+import (
+	"./testutil"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// test_resources/untracked_cache.index was written by "git status" with
+// core.untrackedCache. "c" has only an ignored file and "tracked" has a
+// tracked file.
+func Test_IndexUntrackedCache_ReadAndWrite(t *testing.T) {
+	index, err := OpenIndex("test_resources/untracked_cache.index")
+	if err != nil {
+		t.Error("err should be nil:", err)
+		return
+	}
+	if !index.HasUntrackedCache() {
+		t.Error("index should have untracked cache")
+		return
+	}
+	cache := index.untracked
+	if string(cache.ident) != "Location /tmp/ut, system Linux\x00" {
+		t.Error("ident is wrong:", string(cache.ident))
+	}
+	if cache.dirFlags != UntrackedShowOtherDirectories|UntrackedHideEmptyDirectories || cache.excludePerDir != ".gitignore" {
+		t.Error("flags are wrong:", cache.dirFlags, cache.excludePerDir)
+	}
+	if cache.infoExclude.id == nil || cache.excludesFile.id != nil {
+		t.Error("ids of global exclude files are wrong:", cache.infoExclude.id, cache.excludesFile.id)
+	}
+	root := cache.root
+	if strings.Join(root.untracked, " ") != "top.txt a/ d/" || root.excludeId == nil {
+		t.Error("root is wrong:", root.untracked, root.excludeId)
+	}
+	if c := root.find("c"); c == nil || !c.valid || !c.checkOnly || len(c.untracked) != 0 {
+		t.Error("directory which has only ignored files is wrong:", c)
+	}
+	if tracked := root.find("tracked"); tracked == nil || tracked.checkOnly || strings.Join(tracked.untracked, " ") != "u" {
+		t.Error("tracked directory is wrong:", tracked)
+	}
+
+	expected, _ := ioutil.ReadFile("test_resources/untracked_cache.index")
+	buffer, err := index.serialize()
+	if err != nil {
+		t.Error("err should be nil:", err)
+	}
+	if !bytes.Equal(buffer, expected) {
+		t.Error("index should be written as same as git")
+	}
+}
+
+func Test_IndexUntrackedFiles(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/status")
+	defer testutil.CleanupWorkspace()
+
+	os.MkdirAll("test_resources/status/newdir/sub", 0777)
+	ioutil.WriteFile("test_resources/status/newdir/sub/file", []byte("new\n"), 0644)
+	os.MkdirAll("test_resources/status/ignoreddir", 0777)
+	ioutil.WriteFile("test_resources/status/ignoreddir/ignored_file", []byte("ignored\n"), 0644)
+	os.MkdirAll("test_resources/status/emptydir", 0777)
+
+	repo, _ := OpenRepository("test_resources/status")
+	index, _ := repo.Index()
+	files, err := index.UntrackedFiles()
+	if err != nil {
+		t.Error("err should be nil:", err)
+	}
+	expected := "new_file newdir/ staged_delete_modified_file subdir/new_file 这"
+	if strings.Join(files, " ") != expected {
+		t.Error("untracked files are wrong:", files)
+	}
+}
+
+func Test_IndexUntrackedCache_Reuse(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/status")
+	defer testutil.CleanupWorkspace()
+
+	past := time.Now().Add(-time.Hour)
+	for _, dir := range []string{"test_resources/status", "test_resources/status/subdir"} {
+		os.Chtimes(dir, past, past)
+	}
+	repo, _ := OpenRepository("test_resources/status")
+	index, _ := repo.Index()
+	index.SetUntrackedCache(true)
+	if _, err := index.UntrackedFiles(); err != nil {
+		t.Error("err should be nil:", err)
+	}
+	if err := index.Write(); err != nil {
+		t.Error("err should be nil:", err)
+	}
+
+	// unchanged directories are not read again
+	repo, _ = OpenRepository("test_resources/status")
+	index, _ = repo.Index()
+	if !index.HasUntrackedCache() {
+		t.Error("untracked cache should be read")
+		return
+	}
+	index.untracked.root.untracked = append(index.untracked.root.untracked, "cached_file")
+	files, _ := index.UntrackedFiles()
+	expected := "cached_file new_file staged_delete_modified_file subdir/new_file 这"
+	if strings.Join(files, " ") != expected {
+		t.Error("cached result should be used:", files)
+	}
+
+	// the changed directory is read again
+	ioutil.WriteFile("test_resources/status/subdir/another_file", []byte("another\n"), 0644)
+	files, _ = index.UntrackedFiles()
+	expected = "cached_file new_file staged_delete_modified_file subdir/another_file subdir/new_file 这"
+	if strings.Join(files, " ") != expected {
+		t.Error("changed directory should be read:", files)
+	}
+
+	// changing the index invalidates the directory
+	index.AddByPath("subdir/another_file")
+	files, _ = index.UntrackedFiles()
+	expected = "new_file staged_delete_modified_file subdir/new_file 这"
+	if strings.Join(files, " ") != expected {
+		t.Error("added file should not be untracked:", files)
+	}
+
+	// changing the exclude file invalidates all directories
+	index.untracked.root.untracked = append(index.untracked.root.untracked, "cached_file")
+	ioutil.WriteFile("test_resources/status/.git/info/exclude", []byte("ignored*\nnew_file\n"), 0644)
+	files, _ = index.UntrackedFiles()
+	expected = "staged_delete_modified_file 这"
+	if strings.Join(files, " ") != expected {
+		t.Error("exclude rules should be applied again:", files)
+	}
+}
@@ -55,8 +55,14 @@ func readString(input []byte, offset, bound int) (string, int) {
 func strtol32(buffer []byte, startOffset, bound, base int) (int64, int) {
 	for offset := startOffset; offset < bound; offset++ {
 		c := buffer[offset]
+		if c == '-' && offset == startOffset {
+			continue
+		}
 		if c < '0' || '9' < c {
-			attr, _ := strconv.ParseInt(string(buffer[startOffset:offset]), base, 64)
+			attr, err := strconv.ParseInt(string(buffer[startOffset:offset]), base, 64)
+			if err != nil {
+				return -1, startOffset
+			}
 			return attr, offset + 1
 		}
 	}
@@ -71,3 +77,36 @@ func calcHash(buffer []byte) *Oid {
 	copy(oid[:], sha1Hash[:])
 	return oid
 }
+
+// putVarint appends the value in the offset encoding of OFS_DELTA. Index
+// v4 and reftable use it too.
+func putVarint(buffer []byte, value uint64) []byte {
+	var varint [10]byte
+	pos := len(varint) - 1
+	varint[pos] = byte(value & 127)
+	for value >>= 7; value != 0; value >>= 7 {
+		value--
+		pos--
+		varint[pos] = 128 | byte(value&127)
+	}
+	return append(buffer, varint[pos:]...)
+}
+
+// getVarint returns false if the buffer is truncated.
+func getVarint(buffer []byte, offset int) (uint64, int, bool) {
+	if offset >= len(buffer) {
+		return 0, 0, false
+	}
+	c := buffer[offset]
+	offset++
+	value := uint64(c & 127)
+	for c&128 != 0 {
+		if offset >= len(buffer) {
+			return 0, 0, false
+		}
+		c = buffer[offset]
+		offset++
+		value = ((value + 1) << 7) | uint64(c&127)
+	}
+	return value, offset, true
+}